@@ -0,0 +1,367 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	EventCheckpointSaved  = "checkpoint_saved"
+	EventCheckpointFailed = "checkpoint_failed"
+)
+
+var ErrNoAcker = fmt.Errorf("item does not embed an Acker")
+
+/*
+	An AckableTraceable is an item whose source needs to hear back once the
+	pipeline is done with it, to commit its offset or redeliver it, for
+	at-least-once delivery. It is acked when it reaches the end of the
+	pipeline: the output of a Runner, a sink which wrote it, or a filter
+	dropping it. It is nacked when it is sent to the dead letter handlers,
+	with the error that failed it. Items abandoned by a cancelled pipeline
+	are neither.
+
+	Items sent to several branches are acked or nacked once per branch, so
+	Ack and Nack should only act the first time either is called, as those
+	of an Acker do.
+*/
+type AckableTraceable interface {
+	Traceable
+	Ack()
+	Nack(err error)
+}
+
+// Ack acks m when it is an AckableTraceable
+func Ack[E Traceable](m E) {
+	if a, ok := any(m).(AckableTraceable); ok && !isNil(m) {
+		a.Ack()
+	}
+}
+
+// Nack nacks m, failed by err, when it is an AckableTraceable
+func Nack[E Traceable](m E, err error) {
+	if a, ok := any(m).(AckableTraceable); ok && !isNil(m) {
+		a.Nack(err)
+	}
+}
+
+/*
+	An Acker is embedded in items to make them AckableTraceable: it calls
+	onAck or onNack, either of which may be nil, the first time the item is
+	acked or nacked, and ignores the calls after it.
+*/
+type Acker struct {
+	once   sync.Once
+	onAck  func()
+	onNack func(err error)
+}
+
+func NewAcker(onAck func(), onNack func(err error)) *Acker {
+	return &Acker{onAck: onAck, onNack: onNack}
+}
+
+func (a *Acker) Ack() {
+	a.once.Do(func() {
+		if a.onAck != nil {
+			a.onAck()
+		}
+	})
+}
+
+func (a *Acker) Nack(err error) {
+	a.once.Do(func() {
+		if a.onNack != nil {
+			a.onNack(err)
+		}
+	})
+}
+
+/*
+	SetAcker sets the *Acker embedded in item, a pointer to a struct, for
+	sources making items of types they do not know, and tells whether item
+	embeds one.
+*/
+func SetAcker(item interface{}, acker *Acker) bool {
+	v, ok := structValue(item)
+	if !ok {
+		return false
+	}
+
+	for i := 0; i < v.NumField(); i++ {
+		if field := v.Type().Field(i); field.Anonymous && field.Type == reflect.TypeOf(acker) {
+			v.Field(i).Set(reflect.ValueOf(acker))
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+	A CheckpointStore keeps the committed offset of each partition of a
+	source.
+*/
+type CheckpointStore interface {
+	SaveCheckpoint(ctx context.Context, offsets map[string]int64) error
+	LoadCheckpoint(ctx context.Context) (map[string]int64, error)
+}
+
+/*
+	A Checkpointer tracks the progress of a source partitioned like Kafka,
+	whose items each have an offset, increasing within their partition. The
+	source hands every item the Acker returned by Track, and the committed
+	offset of a partition is the highest offset at which it and every offset
+	tracked before it were acked. Run saves the committed offsets to Store
+	every Interval, and a last time when its context is done, and a source
+	restarting resumes after the offsets from Load.
+
+	Nacked offsets hold back the commit of their partition, so that they are
+	delivered again after a restart, unless SkipNacked is set: they then
+	count as done, their items being kept by the dead letter handlers.
+*/
+type Checkpointer[E Traceable] struct {
+	Root       Processor[E]
+	Store      CheckpointStore
+	Interval   time.Duration
+	SkipNacked bool
+
+	lock       sync.Mutex
+	partitions map[string]*partitionProgress
+	saved      map[string]int64
+}
+
+type partitionProgress struct {
+	pending   []int64
+	done      map[int64]bool
+	committed int64
+	known     bool
+}
+
+// Load returns the offsets committed by Store, which become those of c
+func (c *Checkpointer[E]) Load(ctx context.Context) (map[string]int64, error) {
+	offsets, err := c.Store.LoadCheckpoint(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for partition, offset := range offsets {
+		progress := c.partition(partition)
+		progress.committed, progress.known = offset, true
+	}
+
+	c.saved = offsets
+
+	return offsets, nil
+}
+
+// Track starts tracking offset in partition, and returns the Acker of its item
+func (c *Checkpointer[E]) Track(partition string, offset int64) *Acker {
+	c.lock.Lock()
+	progress := c.partition(partition)
+	progress.pending = append(progress.pending, offset)
+	c.lock.Unlock()
+
+	return NewAcker(func() {
+		c.complete(partition, offset)
+	}, func(err error) {
+		if c.SkipNacked {
+			c.complete(partition, offset)
+		}
+	})
+}
+
+func (c *Checkpointer[E]) partition(name string) *partitionProgress {
+	if c.partitions == nil {
+		c.partitions = make(map[string]*partitionProgress)
+	}
+
+	progress, ok := c.partitions[name]
+	if !ok {
+		progress = &partitionProgress{done: make(map[int64]bool)}
+		c.partitions[name] = progress
+	}
+
+	return progress
+}
+
+func (c *Checkpointer[E]) complete(partition string, offset int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	progress := c.partition(partition)
+	progress.done[offset] = true
+
+	for len(progress.pending) > 0 && progress.done[progress.pending[0]] {
+		delete(progress.done, progress.pending[0])
+		progress.committed, progress.known = progress.pending[0], true
+		progress.pending = progress.pending[1:]
+	}
+}
+
+// Offsets returns the committed offset of every partition with one
+func (c *Checkpointer[E]) Offsets() map[string]int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	offsets := make(map[string]int64)
+
+	for partition, progress := range c.partitions {
+		if progress.known {
+			offsets[partition] = progress.committed
+		}
+	}
+
+	return offsets
+}
+
+// Pending returns how many tracked offsets were not acked yet
+func (c *Checkpointer[E]) Pending() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	pending := 0
+	for _, progress := range c.partitions {
+		pending += len(progress.pending)
+	}
+
+	return pending
+}
+
+func (c *Checkpointer[E]) Run(ctx context.Context) {
+	interval := c.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.Save(ctx)
+		case <-ctx.Done():
+			c.Save(context.WithoutCancel(ctx))
+			return
+		}
+	}
+}
+
+// Save saves the committed offsets to Store, when they moved since the last
+// save
+func (c *Checkpointer[E]) Save(ctx context.Context) error {
+	offsets := c.Offsets()
+
+	c.lock.Lock()
+	unchanged := sameOffsets(offsets, c.saved)
+	c.lock.Unlock()
+
+	if unchanged {
+		return nil
+	}
+
+	if err := c.Store.SaveCheckpoint(ctx, offsets); err != nil {
+		Emit(ctx, c.Root, EventCheckpointFailed, "saving checkpoint: %s", err)
+		return err
+	}
+
+	c.lock.Lock()
+	c.saved = offsets
+	c.lock.Unlock()
+
+	Emit(ctx, c.Root, EventCheckpointSaved, "saved checkpoint: %s", formatOffsets(offsets))
+
+	return nil
+}
+
+func sameOffsets(a, b map[string]int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for partition, offset := range a {
+		if other, ok := b[partition]; !ok || other != offset {
+			return false
+		}
+	}
+
+	return true
+}
+
+func formatOffsets(offsets map[string]int64) string {
+	partitions := make([]string, 0, len(offsets))
+	for partition := range offsets {
+		partitions = append(partitions, partition)
+	}
+
+	sort.Strings(partitions)
+
+	s := ""
+	for i, partition := range partitions {
+		if i > 0 {
+			s += ", "
+		}
+
+		s += fmt.Sprintf("%s@%d", partition, offsets[partition])
+	}
+
+	return s
+}
+
+/*
+	FileCheckpointStore keeps offsets in a JSON file, replaced atomically on
+	save
+*/
+type FileCheckpointStore struct {
+	Path string
+}
+
+func (fs *FileCheckpointStore) SaveCheckpoint(ctx context.Context, offsets map[string]int64) error {
+	data, err := json.Marshal(offsets)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(fs.Path), filepath.Base(fs.Path)+".*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), fs.Path)
+}
+
+func (fs *FileCheckpointStore) LoadCheckpoint(ctx context.Context) (map[string]int64, error) {
+	data, err := os.ReadFile(fs.Path)
+	if os.IsNotExist(err) {
+		return map[string]int64{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make(map[string]int64)
+	err = json.Unmarshal(data, &offsets)
+
+	return offsets, err
+}
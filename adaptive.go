@@ -0,0 +1,108 @@
+package pipeline
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+	A BatchController chooses the size and linger of the batches of a sink, and
+	is told the outcome of every batch written.
+*/
+type BatchController interface {
+	BatchConfigurer
+	Observe(items int, latency time.Duration, err error)
+}
+
+/*
+	The AdaptiveBatchController tunes batch sizes with additive increase and
+	multiplicative decrease: the size grows by Step after every batch written
+	within TargetLatency, and is halved after a failure or a slower write.
+
+	Linger follows the size, from MinLinger for MinSize batches to MaxLinger
+	for MaxSize ones, so small batches are not held back waiting for items.
+*/
+type AdaptiveBatchController struct {
+	MinSize int
+	MaxSize int
+	Step    int
+
+	MinLinger time.Duration
+	MaxLinger time.Duration
+
+	TargetLatency time.Duration
+
+	lock sync.Mutex
+	size int
+}
+
+func NewAdaptiveBatchController(minSize, maxSize int, targetLatency time.Duration) *AdaptiveBatchController {
+	return &AdaptiveBatchController{
+		MinSize:       minSize,
+		MaxSize:       maxSize,
+		Step:          (maxSize-minSize)/20 + 1,
+		MinLinger:     DefaultBatchLinger,
+		MaxLinger:     10 * DefaultBatchLinger,
+		TargetLatency: targetLatency,
+		size:          minSize,
+	}
+}
+
+func (c *AdaptiveBatchController) BatchSize() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.current()
+}
+
+func (c *AdaptiveBatchController) BatchLinger() time.Duration {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.MaxSize <= c.MinSize {
+		return c.MinLinger
+	}
+
+	ratio := float64(c.current()-c.MinSize) / float64(c.MaxSize-c.MinSize)
+
+	return c.MinLinger + time.Duration(ratio*float64(c.MaxLinger-c.MinLinger))
+}
+
+func (c *AdaptiveBatchController) Observe(items int, latency time.Duration, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	size := c.current()
+
+	if err != nil || (c.TargetLatency > 0 && latency > c.TargetLatency) {
+		size /= 2
+	} else if items >= size {
+		// only grow when batches fill up, otherwise the input is the limit
+		if c.Step > 0 {
+			size += c.Step
+		} else {
+			size++
+		}
+	}
+
+	c.size = size
+	c.size = c.current()
+}
+
+func (c *AdaptiveBatchController) current() int {
+	size := c.size
+
+	if size < c.MinSize {
+		size = c.MinSize
+	}
+
+	if c.MaxSize > 0 && size > c.MaxSize {
+		size = c.MaxSize
+	}
+
+	if size <= 0 {
+		size = 1
+	}
+
+	return size
+}
@@ -0,0 +1,385 @@
+/*
+	Package admin serves the introspection endpoints of a running pipeline
+	over HTTP, for operators and dashboards.
+*/
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ca0s/pipeline"
+)
+
+// health checks of the processors taking longer are reported as failed
+const DefaultCheckTimeout = 5 * time.Second
+
+const (
+	StatusIdle      = "idle"
+	StatusRunning   = "running"
+	StatusFinished  = "finished"
+	StatusUnhealthy = "unhealthy"
+)
+
+/*
+	A Server serves the introspection endpoints of a pipeline:
+
+		/stats      the StatDB, as serialized, or its processors having the
+		            tags given as tag=key:value parameters
+		/snapshot   the typed snapshot of the StatDB, with rates and queues
+		/graph      the mermaid graph of the pipeline, or its dot or html
+		            rendering with format=dot or format=html
+		/config     the serialized definition of the pipeline
+		/healthz    the health of every processor, failing with 503 when
+		            one is unhealthy or Health is not live
+		/readyz     the readiness of Health, when it is set
+		/admission  the state of Admission, when it is set, failing with
+		            429 while it rejects items
+		/bluegreen/split     the split of BlueGreen, when it is set, as
+		                     {"split": ratio}, changed with a PUT of it
+		/bluegreen/cutover   POST cuts BlueGreen over to green
+		/bluegreen/rollback  POST drains green and keeps blue
+
+	Pipeline returns the current pipeline, so pipelines replaced by reloads
+	are served as they change.
+*/
+type Server[E pipeline.Traceable] struct {
+	Pipeline func() pipeline.Processor[E]
+	Stats    *pipeline.StatDB[E]
+	Health   *pipeline.Health
+
+	Admission *pipeline.AdmissionController[E]
+	BlueGreen BlueGreenControl
+
+	CheckTimeout time.Duration
+}
+
+/*
+	A BlueGreenControl runs a green pipeline next to the blue one, such as
+	a pipeline.PipelineManager once StartGreen was called.
+*/
+type BlueGreenControl interface {
+	Split() float64
+	SetSplit(ratio float64)
+	Cutover() error
+	Rollback() error
+}
+
+// SplitStatus is the body of /bluegreen/split
+type SplitStatus struct {
+	Split float64 `json:"split"`
+}
+
+// New returns a server for root, whose stats are kept in stats
+func New[E pipeline.Traceable](root pipeline.Processor[E], stats *pipeline.StatDB[E]) *Server[E] {
+	return &Server[E]{
+		Pipeline: func() pipeline.Processor[E] { return root },
+		Stats:    stats,
+	}
+}
+
+/*
+	A ProcessorHealth is the state of a processor: idle until it is started
+	or receives items, running until it finishes, and unhealthy when its
+	HealthCheck fails.
+*/
+type ProcessorHealth struct {
+	Name       string    `json:"name"`
+	Status     string    `json:"status"`
+	Err        string    `json:"error,omitempty"`
+	Started    time.Time `json:"started,omitempty"`
+	Finished   time.Time `json:"finished,omitempty"`
+	LastInput  time.Time `json:"last_input,omitempty"`
+	LastOutput time.Time `json:"last_output,omitempty"`
+}
+
+func (s *Server[E]) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/stats", s.serveStats)
+	mux.HandleFunc("/snapshot", s.serveSnapshot)
+	mux.HandleFunc("/graph", s.serveGraph)
+	mux.HandleFunc("/config", s.serveConfig)
+	mux.HandleFunc("/healthz", s.serveHealth)
+
+	if s.Health != nil {
+		mux.Handle("/readyz", s.Health.Handler())
+	}
+
+	if s.Admission != nil {
+		mux.HandleFunc("/admission", s.serveAdmission)
+	}
+
+	if s.BlueGreen != nil {
+		mux.HandleFunc("/bluegreen/split", s.serveSplit)
+		mux.HandleFunc("/bluegreen/cutover", s.serveCutover(s.BlueGreen.Cutover))
+		mux.HandleFunc("/bluegreen/rollback", s.serveCutover(s.BlueGreen.Rollback))
+	}
+
+	return mux
+}
+
+// ListenAndServe serves the endpoints on addr until ctx is done
+func (s *Server[E]) ListenAndServe(ctx context.Context, addr string) error {
+	server := &http.Server{
+		Addr:        addr,
+		Handler:     s.Handler(),
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		server.Shutdown(shutdownCtx)
+	}()
+
+	err := server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+func (s *Server[E]) root() (pipeline.Processor[E], bool) {
+	if s.Pipeline == nil {
+		return nil, false
+	}
+
+	root := s.Pipeline()
+	return root, root != nil
+}
+
+func (s *Server[E]) serveStats(w http.ResponseWriter, r *http.Request) {
+	if s.Stats == nil {
+		http.Error(w, "no stats", http.StatusNotFound)
+		return
+	}
+
+	selector, ok := tagSelector(r)
+	if !ok {
+		http.Error(w, "tags are selected as tag=key:value", http.StatusBadRequest)
+		return
+	}
+
+	if len(selector) == 0 {
+		writeJSON(w, s.Stats)
+		return
+	}
+
+	writeJSON(w, s.Stats.Select(selector))
+}
+
+func tagSelector(r *http.Request) (map[string]string, bool) {
+	selector := make(map[string]string)
+
+	for _, tag := range r.URL.Query()["tag"] {
+		key, value, ok := strings.Cut(tag, ":")
+		if !ok || key == "" {
+			return nil, false
+		}
+
+		selector[key] = value
+	}
+
+	return selector, true
+}
+
+func (s *Server[E]) serveSnapshot(w http.ResponseWriter, r *http.Request) {
+	if s.Stats == nil {
+		http.Error(w, "no stats", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, s.Stats.Snapshot())
+}
+
+func (s *Server[E]) serveGraph(w http.ResponseWriter, r *http.Request) {
+	root, ok := s.root()
+	if !ok {
+		http.Error(w, "no pipeline", http.StatusNotFound)
+		return
+	}
+
+	graph := pipeline.NewProcessorGraphContext(r.Context(), root)
+
+	switch r.URL.Query().Get("format") {
+	case "", "mermaid":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		graph.Write(w)
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		graph.WriteDOT(w)
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		graph.WriteHTML(w)
+	default:
+		http.Error(w, "unknown graph format", http.StatusBadRequest)
+	}
+}
+
+func (s *Server[E]) serveConfig(w http.ResponseWriter, r *http.Request) {
+	root, ok := s.root()
+	if !ok {
+		http.Error(w, "no pipeline", http.StatusNotFound)
+		return
+	}
+
+	enc, err := pipeline.MarshalPipeline(root)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(enc)
+}
+
+func (s *Server[E]) serveHealth(w http.ResponseWriter, r *http.Request) {
+	root, ok := s.root()
+	if !ok {
+		http.Error(w, "no pipeline", http.StatusNotFound)
+		return
+	}
+
+	timeout := s.CheckTimeout
+	if timeout <= 0 {
+		timeout = DefaultCheckTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	processors := s.processorHealth(ctx, root)
+
+	status := http.StatusOK
+	if s.Health != nil && !s.Health.Live() {
+		status = http.StatusServiceUnavailable
+	}
+
+	for _, health := range processors {
+		if health.Status == StatusUnhealthy {
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(processors)
+}
+
+func (s *Server[E]) serveAdmission(w http.ResponseWriter, r *http.Request) {
+	status := s.Admission.Status()
+
+	code := http.StatusOK
+	if status.State == pipeline.AdmissionRejecting {
+		code = http.StatusTooManyRequests
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
+
+func (s *Server[E]) serveSplit(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var status SplitStatus
+		if err := json.NewDecoder(r.Body).Decode(&status); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if status.Split < 0 || status.Split > 1 {
+			http.Error(w, "split must be between 0 and 1", http.StatusBadRequest)
+			return
+		}
+
+		s.BlueGreen.SetSplit(status.Split)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, SplitStatus{Split: s.BlueGreen.Split()})
+}
+
+// serveCutover serves the POSTs applying change, answering Conflict when
+// there is no green pipeline
+func (s *Server[E]) serveCutover(change func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		err := change()
+
+		switch {
+		case errors.Is(err, pipeline.ErrNoGreen):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// processorHealth returns the health of the processors of root, by Walk path
+func (s *Server[E]) processorHealth(ctx context.Context, root pipeline.Processor[E]) map[string]ProcessorHealth {
+	processors := make(map[string]ProcessorHealth)
+
+	pipeline.Walk(root, func(path string, p pipeline.Processor[E]) {
+		health := ProcessorHealth{Name: p.Name(), Status: StatusIdle}
+
+		if s.Stats != nil {
+			if stats, ok := s.Stats.StatsOf(p); ok {
+				health.Started = stats.Started
+				health.Finished = stats.Finished
+				health.LastInput = stats.LastInput
+				health.LastOutput = stats.LastOutput
+
+				switch {
+				case !stats.Finished.IsZero() && !stats.Finished.Before(stats.Started):
+					health.Status = StatusFinished
+				case !stats.Started.IsZero() || !stats.LastInput.IsZero():
+					health.Status = StatusRunning
+				}
+			}
+		}
+
+		if checker, ok := p.(pipeline.HealthChecker); ok {
+			if err := checker.HealthCheck(ctx); err != nil {
+				health.Status = StatusUnhealthy
+				health.Err = err.Error()
+			}
+		}
+
+		processors[path] = health
+	})
+
+	return processors
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	enc, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(enc)
+}
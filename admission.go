@@ -0,0 +1,272 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const EventAdmissionChanged = "admission_changed"
+
+const (
+	DefaultAdmissionDegraded   = 0.5
+	DefaultAdmissionRejecting  = 0.9
+	DefaultAdmissionHysteresis = 0.1
+	DefaultAdmissionInterval   = 100 * time.Millisecond
+	DefaultAdmissionRetryAfter = time.Second
+)
+
+// An AdmissionState tells the sources of a pipeline whether to send it items
+type AdmissionState int
+
+const (
+	// The pipeline keeps up with its input
+	AdmissionAccepting AdmissionState = iota
+	// The queues of the pipeline are filling up: sources should slow down
+	AdmissionDegraded
+	// The queues of the pipeline are full: sources should stop sending
+	AdmissionRejecting
+)
+
+func (s AdmissionState) String() string {
+	switch s {
+	case AdmissionAccepting:
+		return "accepting"
+	case AdmissionDegraded:
+		return "degraded"
+	case AdmissionRejecting:
+		return "rejecting"
+	default:
+		return fmt.Sprintf("AdmissionState(%d)", int(s))
+	}
+}
+
+func (s AdmissionState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+/*
+	An AdmissionController derives whether a pipeline takes more input from
+	the depths of the queues between the stages of its composites, so that
+	its sources push back, answering 429 or pausing their consumption,
+	instead of buffering without bound in front of it.
+
+	The fill of the pipeline is that of its fullest queue, from 0 when it is
+	empty to 1 when it is full. The pipeline is degraded from a fill of
+	Degraded, 0.5 by default, and rejecting from Rejecting, 0.9 by default.
+	A state is left once the fill is back under its threshold by
+	Hysteresis, 0.1 by default, so it does not flap. Run updates the state
+	every Interval, 100ms by default, and emits EventAdmissionChanged when
+	it changes.
+*/
+type AdmissionController[E Traceable] struct {
+	Root       Processor[E]
+	Degraded   float64
+	Rejecting  float64
+	Hysteresis float64
+	Interval   time.Duration
+
+	// RetryAfter is told to the clients rejected by Handler, one second by
+	// default
+	RetryAfter time.Duration
+
+	lock    sync.Mutex
+	status  AdmissionStatus
+	changed chan struct{}
+}
+
+/*
+	An AdmissionStatus is the state of admission into a pipeline, the fill it
+	was derived from, the queue which is the fullest, and since when the
+	state holds.
+*/
+type AdmissionStatus struct {
+	State AdmissionState `json:"state"`
+	Fill  float64        `json:"fill"`
+	Queue string         `json:"queue,omitempty"`
+	Since time.Time      `json:"since"`
+}
+
+func (a *AdmissionController[E]) degraded() float64 {
+	if a.Degraded > 0 {
+		return a.Degraded
+	}
+
+	return DefaultAdmissionDegraded
+}
+
+func (a *AdmissionController[E]) rejecting() float64 {
+	if a.Rejecting > 0 {
+		return a.Rejecting
+	}
+
+	return DefaultAdmissionRejecting
+}
+
+func (a *AdmissionController[E]) hysteresis() float64 {
+	if a.Hysteresis > 0 {
+		return a.Hysteresis
+	}
+
+	return DefaultAdmissionHysteresis
+}
+
+func (a *AdmissionController[E]) retryAfter() time.Duration {
+	if a.RetryAfter > 0 {
+		return a.RetryAfter
+	}
+
+	return DefaultAdmissionRetryAfter
+}
+
+func (a *AdmissionController[E]) Run(ctx context.Context) {
+	interval := a.Interval
+	if interval <= 0 {
+		interval = DefaultAdmissionInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		a.Update(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Update derives the state of admission from the current queue depths
+func (a *AdmissionController[E]) Update(ctx context.Context) AdmissionStatus {
+	fill, fullest := a.fill()
+
+	a.lock.Lock()
+
+	previous := a.status.State
+	state := previous
+
+	switch {
+	case fill >= a.rejecting():
+		state = AdmissionRejecting
+	case fill >= a.degraded() && state < AdmissionDegraded:
+		state = AdmissionDegraded
+	case state == AdmissionRejecting && fill < a.rejecting()-a.hysteresis():
+		state = AdmissionDegraded
+	}
+
+	if state == AdmissionDegraded && fill < a.degraded()-a.hysteresis() {
+		state = AdmissionAccepting
+	}
+
+	a.status.Fill, a.status.Queue = fill, fullest
+
+	if state != previous || a.status.Since.IsZero() {
+		a.status.State, a.status.Since = state, time.Now()
+
+		if a.changed != nil {
+			close(a.changed)
+			a.changed = nil
+		}
+	}
+
+	status := a.status
+	a.lock.Unlock()
+
+	if state != previous {
+		Emit(ctx, a.Root, EventAdmissionChanged, "%s, from %s: %s at %.0f%%", state, previous, fullest, fill*100)
+	}
+
+	return status
+}
+
+// fill returns the fill of the fullest queue of the tree, and its name
+func (a *AdmissionController[E]) fill() (float64, string) {
+	var fill float64
+	var fullest string
+
+	if a.Root == nil {
+		return fill, fullest
+	}
+
+	Walk(a.Root, func(path string, p Processor[E]) {
+		for _, queue := range stageQueues(p) {
+			if cap(queue.c) == 0 {
+				continue
+			}
+
+			if f := float64(len(queue.c)) / float64(cap(queue.c)); f > fill || fullest == "" {
+				fill, fullest = f, fmt.Sprintf("%s %s", path, queue.name)
+			}
+		}
+	})
+
+	return fill, fullest
+}
+
+// Status returns the state of admission as of the last Update
+func (a *AdmissionController[E]) Status() AdmissionStatus {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.status
+}
+
+func (a *AdmissionController[E]) State() AdmissionState {
+	return a.Status().State
+}
+
+// Admit tells whether the pipeline takes items, even degraded
+func (a *AdmissionController[E]) Admit() bool {
+	return a.State() != AdmissionRejecting
+}
+
+// Wait returns once the pipeline is not rejecting items, or ctx is done
+func (a *AdmissionController[E]) Wait(ctx context.Context) error {
+	for {
+		a.lock.Lock()
+		if a.status.State != AdmissionRejecting {
+			a.lock.Unlock()
+			return nil
+		}
+
+		if a.changed == nil {
+			a.changed = make(chan struct{})
+		}
+		changed := a.changed
+		a.lock.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+/*
+	Handler rejects the requests made to next with 429 Too Many Requests,
+	telling clients to retry after RetryAfter, while the pipeline rejects
+	items. The state of admission is in the X-Admission-State header of
+	every response.
+*/
+func (a *AdmissionController[E]) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := a.State()
+
+		w.Header().Set("X-Admission-State", state.String())
+
+		if state == AdmissionRejecting {
+			w.Header().Set("Retry-After", strconv.Itoa(int((a.retryAfter()+time.Second-1)/time.Second)))
+			http.Error(w, "pipeline is rejecting items", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
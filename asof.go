@@ -0,0 +1,81 @@
+package pipeline
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+	EventTimer is implemented by items knowing when the event they carry
+	originally happened, as opposed to when it is being processed.
+*/
+type EventTimer interface {
+	EventTime() time.Time
+}
+
+/*
+	VersionedConfig keeps every version of a configuration (rules, thresholds,
+	lookup tables...) along with the time it became effective, so items being
+	replayed are processed with the version that was in effect when they
+	originally occurred.
+*/
+type VersionedConfig[C any] struct {
+	lock     sync.RWMutex
+	versions []configVersion[C]
+}
+
+type configVersion[C any] struct {
+	effective time.Time
+	config    C
+}
+
+// Register adds a version effective from the given time onwards
+func (vc *VersionedConfig[C]) Register(effective time.Time, config C) {
+	vc.lock.Lock()
+	defer vc.lock.Unlock()
+
+	i := sort.Search(len(vc.versions), func(i int) bool {
+		return vc.versions[i].effective.After(effective)
+	})
+
+	if i > 0 && vc.versions[i-1].effective.Equal(effective) {
+		vc.versions[i-1].config = config
+		return
+	}
+
+	vc.versions = append(vc.versions, configVersion[C]{})
+	copy(vc.versions[i+1:], vc.versions[i:])
+	vc.versions[i] = configVersion[C]{effective: effective, config: config}
+}
+
+// At returns the version in effect at t, if any
+func (vc *VersionedConfig[C]) At(t time.Time) (C, bool) {
+	vc.lock.RLock()
+	defer vc.lock.RUnlock()
+
+	i := sort.Search(len(vc.versions), func(i int) bool {
+		return vc.versions[i].effective.After(t)
+	})
+
+	if i == 0 {
+		var zero C
+		return zero, false
+	}
+
+	return vc.versions[i-1].config, true
+}
+
+// Current returns the latest version in effect
+func (vc *VersionedConfig[C]) Current() (C, bool) {
+	return vc.At(time.Now())
+}
+
+/*
+	For returns the version in effect when the item occurred, as given by
+	EventTime. Items without event time get the current version.
+*/
+func (vc *VersionedConfig[C]) For(ctx context.Context, item Traceable) (C, bool) {
+	return vc.At(EventTimeOrNow(ctx, item))
+}
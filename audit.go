@@ -0,0 +1,150 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var PipelineAudit PipelineContextKey = "pipeline_audit"
+
+/*
+	FieldMapper is implemented by items exposing a map view of their fields.
+	FieldMap must return a new map on every call.
+*/
+type FieldMapper interface {
+	FieldMap() map[string]interface{}
+}
+
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+/*
+	Auditable items receive the changes made to them by every stage. Items only
+	implementing Traceable get a trace describing the changed fields instead.
+*/
+type Auditable interface {
+	AddAudit(processor string, changes []FieldChange)
+}
+
+/*
+	WithAudit records which fields each leaf stage changes on the items going
+	through it, by comparing the FieldMap of items before and after the stage.
+
+	Items are matched by identity, so only pointer items can be audited.
+	Auditing adds a hop before and after each leaf stage and copies the field
+	map of every item, it is meant for debugging sessions.
+*/
+func WithAudit(ctx context.Context) context.Context {
+	return context.WithValue(ctx, PipelineAudit, true)
+}
+
+func hasAudit(ctx context.Context) bool {
+	return ctx.Value(PipelineAudit) == true
+}
+
+func executeAudited[E Traceable](ctx context.Context, p Processor[E], input chan E, output chan E, execute func(ctx context.Context, input chan E, output chan E)) {
+	var lock sync.Mutex
+	before := make(map[interface{}]map[string]interface{})
+
+	procInput := make(chan E)
+	procOutput := make(chan E)
+
+	wg := sync.WaitGroup{}
+
+	wg.Add(1)
+	go func() {
+		for m := range input {
+			if key, fields, ok := auditSnapshot(m); ok {
+				lock.Lock()
+				before[key] = fields
+				lock.Unlock()
+			}
+
+			procInput <- m
+		}
+
+		close(procInput)
+		wg.Done()
+	}()
+
+	wg.Add(1)
+	go func() {
+		for m := range procOutput {
+			if key, fields, ok := auditSnapshot(m); ok {
+				lock.Lock()
+				old, found := before[key]
+				delete(before, key)
+				lock.Unlock()
+
+				if found {
+					recordChanges(p, m, diffFields(old, fields))
+				}
+			}
+
+			output <- m
+		}
+
+		close(output)
+		wg.Done()
+	}()
+
+	execute(ctx, procInput, procOutput)
+	wg.Wait()
+}
+
+func auditSnapshot(item interface{}) (interface{}, map[string]interface{}, bool) {
+	fm, ok := item.(FieldMapper)
+	if !ok || reflect.TypeOf(item).Kind() != reflect.Pointer {
+		return nil, nil, false
+	}
+
+	return item, fm.FieldMap(), true
+}
+
+func diffFields(old, new map[string]interface{}) []FieldChange {
+	var changes []FieldChange
+
+	for field, newValue := range new {
+		oldValue, found := old[field]
+		if !found || !reflect.DeepEqual(oldValue, newValue) {
+			changes = append(changes, FieldChange{Field: field, Old: oldValue, New: newValue})
+		}
+	}
+
+	for field, oldValue := range old {
+		if _, found := new[field]; !found {
+			changes = append(changes, FieldChange{Field: field, Old: oldValue})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+
+	return changes
+}
+
+func recordChanges[E Traceable](p Processor[E], item E, changes []FieldChange) {
+	if len(changes) == 0 {
+		return
+	}
+
+	if a, ok := any(item).(Auditable); ok {
+		a.AddAudit(p.Name(), changes)
+		return
+	}
+
+	fields := make([]string, len(changes))
+	for i, c := range changes {
+		fields[i] = c.Field
+	}
+
+	item.AddTrace(fmt.Sprintf("%s changed %s", p.Name(), strings.Join(fields, ",")))
+}
@@ -0,0 +1,292 @@
+/*
+	Package avro writes the files of a pipeline.FileSink as Avro object
+	container files.
+*/
+package avro
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/ca0s/pipeline"
+	"github.com/linkedin/goavro/v2"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+var durationType = reflect.TypeOf(time.Duration(0))
+
+/*
+	AvroFormat writes Avro object container files.
+
+	The schema is Schema when set, or derived from the struct fields of the
+	first item of every file, named after their `avro` tag: pointers become
+	nullable unions, time.Time a timestamp-micros long, and nested structs,
+	slices and string keyed maps records, arrays and maps. Items
+	implementing pipeline.FieldMapper are written from their FieldMap, which
+	requires Schema.
+
+	Compression is one of the Avro codecs, "null" (the default), "deflate" or
+	"snappy".
+*/
+type AvroFormat struct {
+	Schema      string
+	Compression string
+}
+
+func (f *AvroFormat) Extension() string {
+	return ".avro"
+}
+
+func (f *AvroFormat) NewEncoder(w io.Writer, sample interface{}) (pipeline.FileEncoder, error) {
+	schema := f.Schema
+	if schema == "" {
+		if _, ok := sample.(pipeline.FieldMapper); ok {
+			return nil, fmt.Errorf("%T: field maps need an explicit avro schema: %w", sample, pipeline.ErrUnsupportedField)
+		}
+
+		derived, err := AvroSchemaOf(sample)
+		if err != nil {
+			return nil, err
+		}
+
+		schema = derived
+	}
+
+	ocf, err := goavro.NewOCFWriter(goavro.OCFConfig{
+		W:               w,
+		Schema:          schema,
+		CompressionName: f.Compression,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &avroEncoder{ocf: ocf}, nil
+}
+
+type avroEncoder struct {
+	ocf *goavro.OCFWriter
+}
+
+func (e *avroEncoder) Encode(items []interface{}) error {
+	records := make([]interface{}, len(items))
+
+	for i, item := range items {
+		if mapper, ok := item.(pipeline.FieldMapper); ok {
+			records[i] = mapper.FieldMap()
+			continue
+		}
+
+		v, ok := pipeline.StructValue(item)
+		if !ok {
+			return fmt.Errorf("%T: %w", item, pipeline.ErrUnsupportedField)
+		}
+
+		records[i] = avroNative(v)
+	}
+
+	return e.ocf.Append(records)
+}
+
+func (e *avroEncoder) Close() error {
+	return nil
+}
+
+// AvroSchemaOf derives the Avro schema of the struct type of v
+func AvroSchemaOf(v interface{}) (string, error) {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t == nil || t.Kind() != reflect.Struct {
+		return "", fmt.Errorf("%T: %w", v, pipeline.ErrUnsupportedField)
+	}
+
+	schema, err := avroType(t, map[reflect.Type]bool{})
+	if err != nil {
+		return "", err
+	}
+
+	encoded, err := json.Marshal(schema)
+	return string(encoded), err
+}
+
+func avroType(t reflect.Type, defined map[reflect.Type]bool) (interface{}, error) {
+	switch {
+	case t == timeType:
+		return map[string]string{"type": "long", "logicalType": "timestamp-micros"}, nil
+	case t == durationType:
+		return "long", nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return "string", nil
+	case reflect.Bool:
+		return "boolean", nil
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return "int", nil
+	case reflect.Int, reflect.Int64, reflect.Uint32:
+		return "long", nil
+	case reflect.Float32:
+		return "float", nil
+	case reflect.Float64:
+		return "double", nil
+
+	case reflect.Pointer:
+		elem, err := avroType(t.Elem(), defined)
+		if err != nil {
+			return nil, err
+		}
+
+		return []interface{}{"null", elem}, nil
+
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "bytes", nil
+		}
+
+		items, err := avroType(t.Elem(), defined)
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]interface{}{"type": "array", "items": items}, nil
+
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			break
+		}
+
+		values, err := avroType(t.Elem(), defined)
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]interface{}{"type": "map", "values": values}, nil
+
+	case reflect.Struct:
+		if defined[t] {
+			return t.Name(), nil
+		}
+
+		defined[t] = true
+
+		fields := []interface{}{}
+		for _, f := range pipeline.StructFields(t, "avro") {
+			fieldType, err := avroType(f.Type, defined)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", t.Name(), f.Name, err)
+			}
+
+			field := map[string]interface{}{"name": f.Name, "type": fieldType}
+			if f.Type.Kind() == reflect.Pointer {
+				field["default"] = nil
+			}
+
+			fields = append(fields, field)
+		}
+
+		return map[string]interface{}{"type": "record", "name": t.Name(), "fields": fields}, nil
+	}
+
+	return nil, fmt.Errorf("%s: %w", t, pipeline.ErrUnsupportedField)
+}
+
+// avroNative converts a value to the representation goavro expects for the
+// schema derived by avroType
+func avroNative(v reflect.Value) interface{} {
+	switch {
+	case v.Type() == timeType:
+		return v.Interface().(time.Time)
+	case v.Type() == durationType:
+		return v.Int()
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return int32(v.Int())
+	case reflect.Uint8, reflect.Uint16:
+		return int32(v.Uint())
+	case reflect.Int, reflect.Int64:
+		return v.Int()
+	case reflect.Uint32:
+		return int64(v.Uint())
+	case reflect.Float32:
+		return float32(v.Float())
+	case reflect.Float64:
+		return v.Float()
+
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+
+		elem := v.Elem()
+		schema, _ := avroType(elem.Type(), map[reflect.Type]bool{})
+
+		return goavro.Union(avroUnionName(schema), avroNative(elem))
+
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if v.Kind() == reflect.Array {
+				b := make([]byte, v.Len())
+				reflect.Copy(reflect.ValueOf(b), v)
+				return b
+			}
+
+			return v.Bytes()
+		}
+
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = avroNative(v.Index(i))
+		}
+
+		return items
+
+	case reflect.Map:
+		values := make(map[string]interface{}, v.Len())
+		for _, key := range v.MapKeys() {
+			values[key.String()] = avroNative(v.MapIndex(key))
+		}
+
+		return values
+
+	case reflect.Struct:
+		record := make(map[string]interface{})
+		for _, f := range pipeline.StructFields(v.Type(), "avro") {
+			record[f.Name] = avroNative(v.FieldByIndex(f.Index))
+		}
+
+		return record
+	}
+
+	return v.Interface()
+}
+
+// avroUnionName returns the name goavro uses for a member of a union
+func avroUnionName(schema interface{}) string {
+	switch s := schema.(type) {
+	case string:
+		return s
+	case map[string]string:
+		return s["type"] + "." + s["logicalType"]
+	case map[string]interface{}:
+		if s["type"] == "record" {
+			return s["name"].(string)
+		}
+
+		return s["type"].(string)
+	}
+
+	return ""
+}
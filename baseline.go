@@ -0,0 +1,329 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	EventPerformanceRegressed = "performance_regressed"
+	EventPerformanceRecovered = "performance_recovered"
+)
+
+const (
+	DefaultBaselineWindow   = time.Minute
+	DefaultBaselineMinItems = 100
+)
+
+/*
+	A Baseline is the performance of every stage of a pipeline during a
+	calibration run, by Walk path: the latency quantiles of its items, and
+	the items per second it sent on over the run. It is saved to a file with
+	SaveBaseline, for the later runs of the pipeline to be compared against
+	it by a BaselineComparator.
+*/
+type Baseline struct {
+	Taken  time.Time                `json:"taken"`
+	Stages map[string]StageBaseline `json:"stages"`
+}
+
+type StageBaseline struct {
+	Name       string        `json:"name"`
+	Output     int64         `json:"output"`
+	LatencyP50 time.Duration `json:"latency_p50"`
+	LatencyP99 time.Duration `json:"latency_p99"`
+	Throughput float64       `json:"throughput"`
+}
+
+// NewBaseline returns the baseline of the stages of root, from their stats
+// in statDB
+func NewBaseline[E Traceable](statDB *StatDB[E], root Processor[E]) *Baseline {
+	now := time.Now()
+
+	baseline := &Baseline{
+		Taken:  now,
+		Stages: make(map[string]StageBaseline),
+	}
+
+	if statDB == nil || root == nil {
+		return baseline
+	}
+
+	Walk(root, func(path string, p Processor[E]) {
+		stats, ok := statDB.statsOf(p)
+		if !ok {
+			return
+		}
+
+		stage := StageBaseline{
+			Name:       stats.Name,
+			Output:     stats.Output.Load(),
+			LatencyP50: stats.LatencyQuantile(0.5),
+			LatencyP99: stats.LatencyQuantile(0.99),
+		}
+
+		if elapsed := stageElapsed(stats, now); elapsed > 0 {
+			stage.Throughput = float64(stage.Output) / elapsed.Seconds()
+		}
+
+		baseline.Stages[path] = stage
+	})
+
+	return baseline
+}
+
+// stageElapsed returns how long a stage ran for, from when it started, or
+// its stats were created for leaves not tracking it, to its last output
+func stageElapsed(stats *Stats, now time.Time) time.Duration {
+	start := stats.Started
+	if start.IsZero() && stats.outputRate != nil {
+		start = stats.outputRate.created
+	}
+
+	end := now
+	if !stats.Finished.IsZero() && stats.Finished.After(start) {
+		end = stats.Finished
+	} else if !stats.LastOutput.IsZero() {
+		end = stats.LastOutput
+	}
+
+	if start.IsZero() {
+		return 0
+	}
+
+	return end.Sub(start)
+}
+
+// SaveBaseline writes baseline to the JSON file at path, replaced atomically
+func SaveBaseline(path string, baseline *Baseline) error {
+	data, err := json.MarshalIndent(baseline, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func LoadBaseline(path string) (*Baseline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	baseline := &Baseline{}
+	if err := json.Unmarshal(data, baseline); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return baseline, nil
+}
+
+/*
+	A BaselineComparator compares the stages of a running pipeline against
+	their Baseline. The latency of a stage regressed when one of its
+	quantiles over the run is more than LatencyDeviation above the baseline,
+	0.5 allowing items to take half as long again, and its throughput when
+	the items per second it sent on over the last Window, one minute by
+	default, are more than ThroughputDeviation below it. Deviations left at
+	zero are not compared.
+
+	Stages which are not in the baseline, or which sent on fewer than
+	MinItems items in the run so far, 100 by default, are not compared
+	either, so the first items do not raise false alarms.
+*/
+type BaselineComparator struct {
+	Baseline *Baseline
+
+	LatencyDeviation    float64
+	ThroughputDeviation float64
+
+	Window   time.Duration
+	MinItems int64
+}
+
+/*
+	A Regression is a metric of a stage deviating from its baseline beyond
+	the threshold of the comparator. Deviation is the fraction of the
+	baseline it is worse by.
+*/
+type Regression struct {
+	Path      string  `json:"path"`
+	Metric    string  `json:"metric"`
+	Baseline  float64 `json:"baseline"`
+	Current   float64 `json:"current"`
+	Deviation float64 `json:"deviation"`
+}
+
+func (r Regression) key() string {
+	return r.Path + " " + r.Metric
+}
+
+func (r Regression) String() string {
+	return fmt.Sprintf("%s %s %.1f%% worse than its baseline: %s, was %s", r.Path, r.Metric, r.Deviation*100, r.format(r.Current), r.format(r.Baseline))
+}
+
+func (r Regression) format(value float64) string {
+	if r.Metric == "throughput" {
+		return fmt.Sprintf("%.1f/s", value)
+	}
+
+	return time.Duration(value).String()
+}
+
+func (c *BaselineComparator) window() time.Duration {
+	if c.Window > 0 {
+		return c.Window
+	}
+
+	return DefaultBaselineWindow
+}
+
+func (c *BaselineComparator) minItems() int64 {
+	if c.MinItems > 0 {
+		return c.MinItems
+	}
+
+	return DefaultBaselineMinItems
+}
+
+// CompareBaseline returns the stages of root regressing against the
+// baseline of c, by path and metric
+func CompareBaseline[E Traceable](c *BaselineComparator, statDB *StatDB[E], root Processor[E]) []Regression {
+	var regressions []Regression
+
+	if c == nil || c.Baseline == nil || statDB == nil || root == nil {
+		return regressions
+	}
+
+	now := time.Now()
+
+	Walk(root, func(path string, p Processor[E]) {
+		baseline, ok := c.Baseline.Stages[path]
+		if !ok {
+			return
+		}
+
+		stats, ok := statDB.statsOf(p)
+		if !ok || stats.Output.Load() < c.minItems() {
+			return
+		}
+
+		if c.LatencyDeviation > 0 {
+			latencies := []struct {
+				metric   string
+				baseline time.Duration
+				current  time.Duration
+			}{
+				{"latency_p50", baseline.LatencyP50, stats.LatencyQuantile(0.5)},
+				{"latency_p99", baseline.LatencyP99, stats.LatencyQuantile(0.99)},
+			}
+
+			for _, l := range latencies {
+				if l.baseline <= 0 {
+					continue
+				}
+
+				deviation := float64(l.current-l.baseline) / float64(l.baseline)
+				if deviation > c.LatencyDeviation {
+					regressions = append(regressions, Regression{
+						Path:      path,
+						Metric:    l.metric,
+						Baseline:  float64(l.baseline),
+						Current:   float64(l.current),
+						Deviation: deviation,
+					})
+				}
+			}
+		}
+
+		if c.ThroughputDeviation > 0 && baseline.Throughput > 0 && stats.outputRate != nil {
+			end := now
+			if !stats.Finished.IsZero() && stats.Finished.After(stats.Started) {
+				end = stats.Finished
+			}
+
+			current := stats.outputRate.rate(end, c.window())
+
+			deviation := (baseline.Throughput - current) / baseline.Throughput
+			if deviation > c.ThroughputDeviation {
+				regressions = append(regressions, Regression{
+					Path:      path,
+					Metric:    "throughput",
+					Baseline:  baseline.Throughput,
+					Current:   current,
+					Deviation: deviation,
+				})
+			}
+		}
+	})
+
+	sort.Slice(regressions, func(i, j int) bool {
+		return regressions[i].key() < regressions[j].key()
+	})
+
+	return regressions
+}
+
+// TakeBaseline returns the baseline of the current run, or of the last one,
+// to be saved once a calibration run is over
+func (r *Runner[E]) TakeBaseline() *Baseline {
+	r.lock.Lock()
+	statDB := r.statDB
+	r.lock.Unlock()
+
+	return NewBaseline(statDB, r.Pipeline)
+}
+
+func (r *Runner[E]) watchBaseline(ctx context.Context, statDB *StatDB[E]) {
+	ticker := time.NewTicker(failureCheckInterval)
+	defer ticker.Stop()
+
+	regressed := make(map[string]Regression)
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		current := make(map[string]Regression)
+
+		for _, regression := range CompareBaseline(r.Baseline, statDB, r.Pipeline) {
+			current[regression.key()] = regression
+
+			if _, ok := regressed[regression.key()]; !ok {
+				Emit[E](ctx, r.Pipeline, EventPerformanceRegressed, "%s", regression)
+			}
+		}
+
+		for key, regression := range regressed {
+			if _, ok := current[key]; !ok {
+				Emit[E](ctx, r.Pipeline, EventPerformanceRecovered, "%s %s back within its baseline", regression.Path, regression.Metric)
+			}
+		}
+
+		regressed = current
+	}
+}
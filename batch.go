@@ -0,0 +1,274 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultBatchSize   = 100
+	DefaultBatchLinger = 10 * time.Millisecond
+)
+
+/*
+	A BatchProcessor handles slices of items instead of single items. Composites
+	detect it and call ProcessBatch directly, with items grouped from the input
+	channel, instead of running its Execute.
+
+	When several batch processors follow each other in a Sequential, batches
+	are handed from one to the next without going back to per-item channels.
+
+	ProcessBatch may modify and return the slice it receives, but must not keep
+	it after returning: batch slices are pooled.
+*/
+type BatchProcessor[E Traceable] interface {
+	Processor[E]
+	ProcessBatch(ctx context.Context, items []E) []E
+}
+
+/*
+	BatchConfigurer can be implemented by a BatchProcessor to choose how items
+	are grouped: a batch is handed over when it reaches BatchSize items, or
+	BatchLinger after its first item arrived.
+*/
+type BatchConfigurer interface {
+	BatchSize() int
+	BatchLinger() time.Duration
+}
+
+/*
+	BatchFunc adapts a function working on slices to a BatchProcessor
+*/
+type BatchFunc[E Traceable] struct {
+	ProcName string
+	Size     int
+	Linger   time.Duration
+
+	Fn func(ctx context.Context, items []E) []E
+}
+
+func NewBatchFunc[E Traceable](name string, size int, fn func(ctx context.Context, items []E) []E) *BatchFunc[E] {
+	return &BatchFunc[E]{
+		ProcName: name,
+		Size:     size,
+		Fn:       fn,
+	}
+}
+
+func (bf *BatchFunc[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	executeBatched[E](ctx, []BatchProcessor[E]{bf}, input, output)
+}
+
+func (bf *BatchFunc[E]) Name() string {
+	return bf.ProcName
+}
+
+func (bf *BatchFunc[E]) ProcessBatch(ctx context.Context, items []E) []E {
+	return bf.Fn(ctx, items)
+}
+
+func (bf *BatchFunc[E]) BatchSize() int {
+	return bf.Size
+}
+
+func (bf *BatchFunc[E]) BatchLinger() time.Duration {
+	return bf.Linger
+}
+
+/*
+	fusedBatch runs consecutive batch processors of a Sequential as a single
+	stage, so batches flow between them without per-item hops.
+*/
+type fusedBatch[E Traceable] struct {
+	stages []BatchProcessor[E]
+}
+
+func (f *fusedBatch[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	executeBatched[E](ctx, f.stages, input, output)
+}
+
+func (f *fusedBatch[E]) Name() string {
+	names := make([]string, len(f.stages))
+	for i, s := range f.stages {
+		names[i] = s.Name()
+	}
+
+	return fmt.Sprintf("Batch/%s", strings.Join(names, "+"))
+}
+
+// fuseBatchStages groups runs of consecutive batch processors
+func fuseBatchStages[E Traceable](processors []Processor[E]) []Processor[E] {
+	stages := make([]Processor[E], 0, len(processors))
+
+	var run []BatchProcessor[E]
+
+	flush := func() {
+		switch len(run) {
+		case 0:
+		case 1:
+			stages = append(stages, run[0])
+		default:
+			stages = append(stages, &fusedBatch[E]{stages: run})
+		}
+
+		run = nil
+	}
+
+	for _, p := range processors {
+		if bp, ok := p.(BatchProcessor[E]); ok {
+			run = append(run, bp)
+			continue
+		}
+
+		flush()
+		stages = append(stages, p)
+	}
+
+	flush()
+
+	return stages
+}
+
+func batchSettings[E Traceable](stages []BatchProcessor[E]) (int, time.Duration) {
+	size := DefaultBatchSize
+	linger := DefaultBatchLinger
+
+	if bc, ok := stages[0].(BatchConfigurer); ok {
+		if bc.BatchSize() > 0 {
+			size = bc.BatchSize()
+		}
+
+		if bc.BatchLinger() > 0 {
+			linger = bc.BatchLinger()
+		}
+	}
+
+	return size, linger
+}
+
+/*
+	executeBatched adapts per-item channels to batch processors: items are
+	grouped from the input, handed through every stage, and the result is sent
+	to the output one item at a time. Every stage is tracked under its own
+	stats, as if it ran on its own.
+*/
+func executeBatched[E Traceable](ctx context.Context, stages []BatchProcessor[E], input chan E, output chan E) {
+	size, linger := batchSettings(stages)
+
+	limits := func() (int, time.Duration) {
+		return size, linger
+	}
+
+	statDB, tracked := ctx.Value(PipelineStatDB).(*StatDB[E])
+
+	for _, stage := range stages {
+		if tracked {
+			statDB.register(stage)
+		}
+
+		TrackStarted[E](ctx, stage)
+	}
+
+	collectBatches(input, limits, func(items []E) {
+		for _, stage := range stages {
+			for _, m := range items {
+				TrackItemInput[E](ctx, stage, m)
+			}
+
+			items = stage.ProcessBatch(ctx, items)
+
+			for _, m := range items {
+				TrackOutput[E](ctx, stage, m)
+			}
+		}
+
+		for i, m := range items {
+			if !send(ctx, output, m) {
+				for _, rest := range items[i+1:] {
+					abandon(ctx, rest)
+				}
+
+				break
+			}
+		}
+	})
+
+	for _, stage := range stages {
+		TrackFinished[E](ctx, stage)
+	}
+
+	close(output)
+}
+
+/*
+	collectBatches groups the items of input and calls flush with every batch
+	of size items, or those received within linger of the first one, until the
+	input is closed. limits is called to get both before every batch. Batches
+	are pooled, shared by every stage handling items of type E, so flush must
+	not keep them.
+*/
+func collectBatches[E Traceable](input chan E, limits func() (int, time.Duration), flush func(items []E)) {
+	size, linger := limits()
+	pool := batchPool[E]()
+
+	batch := pool.Get()
+
+	handOver := func() {
+		if batch.Len() == 0 {
+			return
+		}
+
+		flush(batch.Items)
+
+		pool.Put(batch)
+		batch = pool.Get()
+
+		size, linger = limits()
+	}
+
+	timer := time.NewTimer(linger)
+	stopTimer(timer)
+
+	for running := true; running; {
+		select {
+		case m, ok := <-input:
+			if !ok {
+				running = false
+				break
+			}
+
+			if batch.Len() == 0 {
+				stopTimer(timer)
+				timer.Reset(linger)
+			}
+
+			batch.Items = append(batch.Items, m)
+
+			if batch.Len() >= size {
+				stopTimer(timer)
+				handOver()
+			}
+
+		case <-timer.C:
+			handOver()
+		}
+	}
+
+	timer.Stop()
+	handOver()
+
+	pool.Put(batch)
+}
+
+// stopTimer stops timer, and drains a tick it fired before, which would
+// otherwise hand over the next batch as soon as the timer is reset
+func stopTimer(timer *time.Timer) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package pipeline
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestStopTimerDrainsFiredTick(t *testing.T) {
+	timer := time.NewTimer(time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+
+	stopTimer(timer)
+	timer.Reset(time.Hour)
+
+	select {
+	case <-timer.C:
+		t.Fatal("tick fired before the reset received")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// stopping a timer already drained does not block
+	stopTimer(timer)
+	stopTimer(timer)
+}
+
+func TestCollectBatchesBySize(t *testing.T) {
+	input := make(chan *testItem)
+
+	go func() {
+		defer close(input)
+
+		for _, item := range newItems("a", "b", "c", "d", "e") {
+			input <- item
+		}
+	}()
+
+	var sizes []int
+
+	collectBatches(input, func() (int, time.Duration) {
+		return 2, time.Hour
+	}, func(items []*testItem) {
+		sizes = append(sizes, len(items))
+	})
+
+	if !slices.Equal(sizes, []int{2, 2, 1}) {
+		t.Fatalf("batches of %v, want 2 2 1", sizes)
+	}
+}
+
+func TestCollectBatchesByLinger(t *testing.T) {
+	input := make(chan *testItem)
+
+	go func() {
+		defer close(input)
+
+		input <- &testItem{Value: "a"}
+		time.Sleep(50 * time.Millisecond)
+		input <- &testItem{Value: "b"}
+	}()
+
+	var sizes []int
+
+	collectBatches(input, func() (int, time.Duration) {
+		return 10, 5 * time.Millisecond
+	}, func(items []*testItem) {
+		sizes = append(sizes, len(items))
+	})
+
+	if !slices.Equal(sizes, []int{1, 1}) {
+		t.Fatalf("batches of %v, want 1 1", sizes)
+	}
+}
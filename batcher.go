@@ -0,0 +1,86 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+var ErrNoCombiner = fmt.Errorf("batches can not be sent as items")
+
+/*
+	The Batcher processor groups its input into batches of up to Size items,
+	DefaultBatchSize by default, handed over once full or Linger after their
+	first item arrived, DefaultBatchLinger by default, so stages feeding bulk
+	APIs receive one item per batch.
+
+	Every batch is sent on as the item returned by Combine. Without Combine,
+	it is sent as a *Batch[E], which is only possible when E is an interface
+	type Batch implements, such as Traceable; otherwise the Batcher reports a
+	fatal error and discards its input.
+*/
+type Batcher[E Traceable] struct {
+	ChainName string
+	Size      int
+	Linger    time.Duration
+
+	Combine func(items []E) E `json:"-"`
+}
+
+func NewBatcher[E Traceable](name string, size int, linger time.Duration, combine func(items []E) E) *Batcher[E] {
+	return &Batcher[E]{
+		ChainName: name,
+		Size:      size,
+		Linger:    linger,
+		Combine:   combine,
+	}
+}
+
+func (b *Batcher[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	combine := b.Combine
+	if combine == nil {
+		if _, ok := any(&Batch[E]{}).(E); !ok {
+			ReportError(ctx, b, fmt.Errorf("%w: %w", ErrNoCombiner, ErrFatal))
+			drain(input)
+			close(output)
+			return
+		}
+
+		combine = batchItem[E]
+	}
+
+	size, linger := b.Size, b.Linger
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+
+	if linger <= 0 {
+		linger = DefaultBatchLinger
+	}
+
+	limits := func() (int, time.Duration) {
+		return size, linger
+	}
+
+	collectBatches(input, limits, func(batch []E) {
+		for _, m := range batch {
+			TrackItemInput[E](ctx, b, m)
+		}
+
+		// batches are pooled by collectBatches
+		m := combine(append([]E(nil), batch...))
+
+		TrackOutput[E](ctx, b, m)
+		output <- m
+	})
+
+	close(output)
+}
+
+func (b *Batcher[E]) Name() string {
+	return fmt.Sprintf("Batcher/%s", b.ChainName)
+}
+
+func batchItem[E Traceable](items []E) E {
+	return any(&Batch[E]{Items: items}).(E)
+}
@@ -0,0 +1,273 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+
+	"go.uber.org/atomic"
+)
+
+/*
+	The BlueGreen processor has:
+
+	- One input
+	- A blue (current) processor
+	- A green (new) processor
+	- One output
+
+	Both versions run concurrently. Each input item is sent to exactly one of
+	them according to the split ratio, the fraction of traffic routed to green.
+	The output of both is collected and forwarded to the BlueGreen output.
+
+	The split can be changed at any time with SetSplit. Cutover routes all
+	traffic to green and closes the blue input, so the old version drains
+	whatever it has in flight and finishes. Drained is closed once it has,
+	and replaced by a new channel when the BlueGreen is executed again.
+	Rollback instead routes all traffic to blue, and drains green.
+
+	Like the PipelineManager, the BlueGreen is an admin.BlueGreenControl.
+*/
+type BlueGreen[E Traceable] struct {
+	ChainName string
+	Display   string
+	Tags      map[string]string
+
+	Blue  Processor[E]
+	Green Processor[E]
+
+	split atomic.Float64
+
+	initOnce sync.Once
+	cutover  chan struct{}
+	rollback chan struct{}
+
+	lock       sync.Mutex
+	drained    chan struct{}
+	cutOver    bool
+	rolledBack bool
+
+	executions executions
+}
+
+func (bg *BlueGreen[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	bg.executions.begin()
+	defer bg.executions.end()
+
+	Log[E](ctx, bg, "starting")
+	TrackStarted[E](ctx, bg)
+	ctx = withErrorScope[E](ctx, bg)
+	bg.init()
+
+	drained := bg.execution()
+
+	if bg.Green == nil {
+		close(drained)
+		close(output)
+		return
+	}
+
+	wg := sync.WaitGroup{}
+
+	forward := func(p Processor[E], procInput chan E, done func()) {
+		procOutput := make(chan E)
+
+		wg.Add(1)
+		go func() {
+			runProcessor[E](ctx, p, procInput, procOutput)
+			wg.Done()
+		}()
+
+		wg.Add(1)
+		go func() {
+			for m := range procOutput {
+				if ctx.Err() != nil {
+					abandon(ctx, m)
+					continue
+				}
+
+				TrackOutput[E](ctx, bg, m)
+				send(ctx, output, m)
+			}
+
+			if done != nil {
+				done()
+			}
+
+			wg.Done()
+		}()
+	}
+
+	greenInput := make(chan E)
+	forward(bg.Green, greenInput, nil)
+
+	var blueInput chan E
+
+	if bg.Blue != nil {
+		blueInput = make(chan E)
+		forward(bg.Blue, blueInput, func() {
+			close(drained)
+		})
+	} else {
+		close(drained)
+	}
+
+	cutover, rollback := bg.cutover, bg.rollback
+
+	for running := true; running; {
+		select {
+		case <-cutover:
+			cutover = nil
+
+			if blueInput != nil {
+				Log[E](ctx, bg, "cutover to green, draining blue")
+				close(blueInput)
+				blueInput = nil
+			}
+
+		case <-rollback:
+			rollback = nil
+
+			if blueInput != nil {
+				Log[E](ctx, bg, "rolling back to blue, draining green")
+				close(greenInput)
+				greenInput = nil
+			}
+
+		case <-ctx.Done():
+			running = false
+
+		case msg, ok := <-input:
+			if !ok {
+				running = false
+				break
+			}
+
+			TrackItemInput[E](ctx, bg, msg)
+
+			if blueInput == nil || greenInput != nil && bg.toGreen() {
+				send(ctx, greenInput, msg)
+			} else {
+				send(ctx, blueInput, msg)
+			}
+		}
+	}
+
+	inputClosed[E](ctx, bg)
+
+	if greenInput != nil {
+		close(greenInput)
+	}
+
+	if blueInput != nil {
+		close(blueInput)
+	}
+
+	wg.Wait()
+
+	TrackFinished[E](ctx, bg)
+	close(output)
+}
+
+func (bg *BlueGreen[E]) Name() string {
+	return fmt.Sprintf("BlueGreen/%s", bg.ChainName)
+}
+
+// Split returns the fraction of the traffic currently routed to green
+func (bg *BlueGreen[E]) Split() float64 {
+	return bg.split.Load()
+}
+
+// SetSplit changes the fraction of the traffic routed to green. Values are
+// clamped to [0, 1].
+func (bg *BlueGreen[E]) SetSplit(ratio float64) {
+	bg.split.Store(min(max(ratio, 0), 1))
+}
+
+// Cutover routes all the traffic to green and drains blue, or fails with
+// ErrNoGreen without green, or once rolled back
+func (bg *BlueGreen[E]) Cutover() error {
+	bg.init()
+
+	bg.lock.Lock()
+	defer bg.lock.Unlock()
+
+	if bg.Green == nil || bg.rolledBack {
+		return ErrNoGreen
+	}
+
+	bg.SetSplit(1)
+
+	if !bg.cutOver {
+		bg.cutOver = true
+		close(bg.cutover)
+	}
+
+	return nil
+}
+
+// Rollback routes all the traffic to blue and drains green, or fails with
+// ErrNoGreen without blue and green, or once cut over
+func (bg *BlueGreen[E]) Rollback() error {
+	bg.init()
+
+	bg.lock.Lock()
+	defer bg.lock.Unlock()
+
+	if bg.Blue == nil || bg.Green == nil || bg.cutOver {
+		return ErrNoGreen
+	}
+
+	bg.SetSplit(0)
+
+	if !bg.rolledBack {
+		bg.rolledBack = true
+		close(bg.rollback)
+	}
+
+	return nil
+}
+
+// Drained is closed when blue has finished after a cutover, or when the
+// processor finished if the cutover never happened.
+func (bg *BlueGreen[E]) Drained() <-chan struct{} {
+	bg.init()
+
+	bg.lock.Lock()
+	defer bg.lock.Unlock()
+
+	return bg.drained
+}
+
+// execution returns the Drained channel of a new execution, replacing the
+// one of the previous execution when it was closed
+func (bg *BlueGreen[E]) execution() chan struct{} {
+	bg.lock.Lock()
+	defer bg.lock.Unlock()
+
+	select {
+	case <-bg.drained:
+		bg.drained = make(chan struct{})
+	default:
+	}
+
+	return bg.drained
+}
+
+func (bg *BlueGreen[E]) init() {
+	bg.initOnce.Do(func() {
+		bg.cutover = make(chan struct{})
+		bg.rollback = make(chan struct{})
+		bg.drained = make(chan struct{})
+	})
+}
+
+func (bg *BlueGreen[E]) toGreen() bool {
+	split := bg.split.Load()
+	if split >= 1 {
+		return true
+	}
+
+	return rand.Float64() < split
+}
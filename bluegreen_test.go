@@ -0,0 +1,75 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// blueGreenControl is admin.BlueGreenControl, which this package can not
+// import
+type blueGreenControl interface {
+	Split() float64
+	SetSplit(ratio float64)
+	Cutover() error
+	Rollback() error
+}
+
+var _ blueGreenControl = &BlueGreen[*testItem]{}
+var _ blueGreenControl = &PipelineManager[*testItem]{}
+
+func TestBlueGreenSplitIsClamped(t *testing.T) {
+	bg := &BlueGreen[*testItem]{}
+	m := &PipelineManager[*testItem]{}
+
+	for _, ratio := range []float64{-1, 0, 0.3, 1, 2} {
+		bg.SetSplit(ratio)
+		m.SetSplit(ratio)
+
+		if bg.Split() != m.Split() || bg.Split() < 0 || bg.Split() > 1 {
+			t.Fatalf("split %v: BlueGreen has %v, PipelineManager %v", ratio, bg.Split(), m.Split())
+		}
+	}
+}
+
+func TestBlueGreenRollback(t *testing.T) {
+	bg := &BlueGreen[*testItem]{ChainName: "bg", Blue: upper("blue"), Green: &Noop[*testItem]{ChainName: "green"}}
+	bg.SetSplit(1)
+
+	if err := bg.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bg.Cutover(); !errors.Is(err, ErrNoGreen) {
+		t.Fatalf("Cutover after Rollback: got %v, want ErrNoGreen", err)
+	}
+
+	got := itemValues(runItems(t, context.Background(), bg, newItems("a", "b")))
+	if !slices.Equal(got, []string{"A", "B"}) {
+		t.Fatalf("got %v, want every item through blue", got)
+	}
+}
+
+func TestBlueGreenCutover(t *testing.T) {
+	bg := &BlueGreen[*testItem]{ChainName: "bg", Blue: &Noop[*testItem]{ChainName: "blue"}, Green: upper("green")}
+
+	if err := bg.Cutover(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bg.Rollback(); !errors.Is(err, ErrNoGreen) {
+		t.Fatalf("Rollback after Cutover: got %v, want ErrNoGreen", err)
+	}
+
+	got := itemValues(runItems(t, context.Background(), bg, newItems("a", "b")))
+	if !slices.Equal(got, []string{"A", "B"}) {
+		t.Fatalf("got %v, want every item through green", got)
+	}
+
+	<-bg.Drained()
+
+	if err := (&BlueGreen[*testItem]{}).Cutover(); !errors.Is(err, ErrNoGreen) {
+		t.Fatalf("Cutover without green: got %v, want ErrNoGreen", err)
+	}
+}
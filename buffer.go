@@ -0,0 +1,349 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+)
+
+const EventBufferOverflow = "buffer_overflow"
+
+/*
+	A BufferPolicy is what the buffers in front of the stages of a composite
+	do with the items coming once they are full, because the stage reading
+	them stalls. Dropped and spilled items are counted under the "overflow"
+	label dimension of the stats of the composite, and EventBufferOverflow
+	is emitted when a buffer starts overflowing.
+
+	Spilled items are written as JSON to a temporary file in the SpillDir of
+	the composite, or the default directory for temporary files, so they
+	must survive a round trip through encoding/json.
+*/
+type BufferPolicy int
+
+const (
+	// Full buffers hold the items back, and with them the whole pipeline
+	BufferBlock BufferPolicy = iota
+	// Items coming to a full buffer are discarded
+	BufferDropNewest
+	// The oldest items of a full buffer are discarded to make room
+	BufferDropOldest
+	// Items coming to a full buffer are written to a file, and read back in
+	// order once there is room
+	BufferSpillToDisk
+)
+
+// bufferPolicy parses the names of the buffer policies in definitions
+func bufferPolicy(name string) (BufferPolicy, bool) {
+	switch strings.ToLower(name) {
+	case "", "block":
+		return BufferBlock, true
+	case "drop_newest":
+		return BufferDropNewest, true
+	case "drop_oldest":
+		return BufferDropOldest, true
+	case "spill":
+		return BufferSpillToDisk, true
+	}
+
+	return BufferBlock, false
+}
+
+func (p BufferPolicy) String() string {
+	switch p {
+	case BufferDropNewest:
+		return "drop_newest"
+	case BufferDropOldest:
+		return "drop_oldest"
+	case BufferSpillToDisk:
+		return "spill"
+	}
+
+	return "block"
+}
+
+// newPolicyBuffer returns a stage buffer applying policy once it holds size
+// items, spilling to a file in dir removed once the buffer is closed and empty
+func newPolicyBuffer[E Traceable](ctx context.Context, owner Processor[E], size int, policy BufferPolicy, dir string) *stageBuffer[E] {
+	buf := newStageBuffer[E](ctx, owner, size)
+	buf.policy = policy
+
+	if policy == BufferSpillToDisk {
+		buf.spill = &spillFile{dir: dir, wake: make(chan struct{}, 1)}
+		go buf.unspill()
+	}
+
+	return buf
+}
+
+// relay sends the items of upstream to the buffer, and closes it once
+// upstream is closed
+func (buf *stageBuffer[E]) relay(upstream chan E) {
+	for m := range upstream {
+		buf.send(m)
+	}
+
+	buf.close()
+}
+
+func (buf *stageBuffer[E]) overflow(m E) {
+	switch buf.policy {
+	case BufferDropNewest:
+		if !buf.trySend(m) {
+			buf.dropped()
+			return
+		}
+
+	case BufferDropOldest:
+		for !buf.trySend(m) {
+			select {
+			case oldest, ok := <-buf.input:
+				if !ok {
+					return
+				}
+
+				if buf.budget != nil {
+					buf.budget.release(buf.budget.sizeOf(oldest), buf.pressure)
+				}
+
+				buf.dropped()
+			default:
+				buf.dropped()
+				return
+			}
+		}
+
+	case BufferSpillToDisk:
+		if err := buf.spillItem(m); err != nil {
+			Log[E](buf.ctx, buf.owner, "spilling failed: %s", err)
+			TrackFailure[E](buf.ctx, buf.owner)
+			ReportError(buf.ctx, buf.owner, err)
+			SendToDLQ(buf.ctx, buf.owner, m, err)
+		}
+
+		return
+	}
+
+	if len(buf.input) <= cap(buf.input)/2 {
+		buf.overflowing(false)
+	}
+}
+
+// dropped counts an item discarded by the policy of the buffer
+func (buf *stageBuffer[E]) dropped() {
+	TrackLabel[E](buf.ctx, buf.owner, "overflow", "dropped")
+	buf.overflowing(true)
+}
+
+// overflowing emits EventBufferOverflow when the buffer starts overflowing,
+// again once it was back under half full
+func (buf *stageBuffer[E]) overflowing(on bool) {
+	buf.overflowLock.Lock()
+	changed := buf.full != on
+	buf.full = on
+	buf.overflowLock.Unlock()
+
+	if changed && on {
+		Emit[E](buf.ctx, buf.owner, EventBufferOverflow, "buffer full, %s", buf.policy)
+	}
+}
+
+// spillItem sends m to the buffer when nothing was spilled before it, and
+// writes it to the spill file otherwise
+func (buf *stageBuffer[E]) spillItem(m E) error {
+	s := buf.spill
+
+	s.lock.Lock()
+
+	if s.pending == 0 && buf.trySend(m) {
+		s.lock.Unlock()
+
+		if len(buf.input) <= cap(buf.input)/2 {
+			buf.overflowing(false)
+		}
+
+		return nil
+	}
+
+	data, err := json.Marshal(m)
+	if err == nil {
+		err = s.append(data)
+	}
+
+	s.lock.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	TrackLabel[E](buf.ctx, buf.owner, "overflow", "spilled")
+	buf.overflowing(true)
+	s.notify()
+
+	return nil
+}
+
+// unspill reads the spilled items back into the buffer, in order, and closes
+// it once it is closed and nothing is left in the spill file
+func (buf *stageBuffer[E]) unspill() {
+	s := buf.spill
+
+	for {
+		s.lock.Lock()
+
+		if s.pending == 0 {
+			closed := s.closed
+			s.lock.Unlock()
+
+			if closed {
+				break
+			}
+
+			<-s.wake
+			continue
+		}
+
+		data, err := s.next()
+		if err != nil {
+			lost := s.pending
+			s.reset()
+			s.lock.Unlock()
+
+			Log[E](buf.ctx, buf.owner, "reading spilled items failed, %d lost: %s", lost, err)
+			ReportError(buf.ctx, buf.owner, err)
+			continue
+		}
+
+		s.lock.Unlock()
+
+		var item E
+		if err := json.Unmarshal(data, &item); err != nil {
+			Log[E](buf.ctx, buf.owner, "decoding spilled item failed: %s", err)
+			TrackFailure[E](buf.ctx, buf.owner)
+			ReportError(buf.ctx, buf.owner, err)
+		} else {
+			buf.push(item)
+		}
+
+		s.lock.Lock()
+		s.done()
+		s.lock.Unlock()
+	}
+
+	s.remove()
+	close(buf.input)
+}
+
+/*
+	spillFile is a queue of records on disk, each its length and its data,
+	appended at write and read at read. The file is truncated every time it
+	has no pending records left, so it only grows while the buffer is full.
+*/
+type spillFile struct {
+	dir string
+
+	lock    sync.Mutex
+	file    *os.File
+	read    int64
+	write   int64
+	pending int
+	closed  bool
+
+	wake chan struct{}
+}
+
+func (s *spillFile) append(data []byte) error {
+	if s.file == nil {
+		file, err := os.CreateTemp(s.dir, "pipeline-spill-*")
+		if err != nil {
+			return err
+		}
+
+		s.file = file
+	}
+
+	record := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(record, uint32(len(data)))
+	copy(record[4:], data)
+
+	if _, err := s.file.WriteAt(record, s.write); err != nil {
+		return err
+	}
+
+	s.write += int64(len(record))
+	s.pending++
+
+	return nil
+}
+
+// next returns the oldest pending record, which stays pending until done
+func (s *spillFile) next() ([]byte, error) {
+	header := make([]byte, 4)
+	if err := s.readAt(header, s.read); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, binary.BigEndian.Uint32(header))
+	if err := s.readAt(data, s.read+4); err != nil {
+		return nil, err
+	}
+
+	s.read += int64(4 + len(data))
+
+	return data, nil
+}
+
+// readAt fills b from offset, which may end the file
+func (s *spillFile) readAt(b []byte, offset int64) error {
+	n, err := s.file.ReadAt(b, offset)
+	if n == len(b) {
+		return nil
+	}
+
+	return err
+}
+
+func (s *spillFile) done() {
+	s.pending--
+
+	if s.pending == 0 {
+		s.reset()
+	}
+}
+
+func (s *spillFile) reset() {
+	s.pending, s.read, s.write = 0, 0, 0
+
+	if s.file != nil {
+		s.file.Truncate(0)
+	}
+}
+
+func (s *spillFile) close() {
+	s.lock.Lock()
+	s.closed = true
+	s.lock.Unlock()
+
+	s.notify()
+}
+
+func (s *spillFile) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *spillFile) remove() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file != nil {
+		s.file.Close()
+		os.Remove(s.file.Name())
+		s.file = nil
+	}
+}
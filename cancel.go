@@ -0,0 +1,133 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+)
+
+/*
+	send and receive are the channel operations composites use between their
+	caller and their children. They give up once ctx is done, so cancelling
+	the context stops a pipeline even if its input is never closed or its
+	output never read. Items send gives up on are kept by the Recovery of
+	the run, when it has one.
+*/
+func send[E Traceable](ctx context.Context, ch chan E, m E) bool {
+	select {
+	case ch <- m:
+		return true
+	case <-ctx.Done():
+		abandon(ctx, m)
+		return false
+	}
+}
+
+func receive[E Traceable](ctx context.Context, ch chan E) (E, bool) {
+	select {
+	case m, ok := <-ch:
+		return m, ok
+	case <-ctx.Done():
+		var zero E
+		return zero, false
+	}
+}
+
+/*
+	executions tracks the calls to Execute of a composite in progress, for
+	WaitDone. Its zero value is ready to use.
+*/
+type executions struct {
+	lock  sync.Mutex
+	count int
+	idle  chan struct{}
+}
+
+func (e *executions) begin() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.count == 0 {
+		e.idle = make(chan struct{})
+	}
+
+	e.count++
+}
+
+func (e *executions) end() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.count--
+
+	if e.count == 0 {
+		close(e.idle)
+	}
+}
+
+func (e *executions) wait() {
+	e.lock.Lock()
+	idle := e.idle
+	running := e.count > 0
+	e.lock.Unlock()
+
+	if running {
+		<-idle
+	}
+}
+
+/*
+	WaitDone blocks until every call to Execute in progress has returned, and
+	with it every child processor but the branches abandoned by a Fanout. It
+	returns immediately when the processor is not running.
+
+	Once the context of a pipeline is cancelled, composites stop reading their
+	input, close the input of their children and discard what they still
+	produce, so WaitDone returns as soon as the leaves have finished.
+*/
+func (fanout *Fanout[E]) WaitDone() {
+	fanout.executions.wait()
+}
+
+func (sequential *Sequential[E]) WaitDone() {
+	sequential.executions.wait()
+}
+
+func (parallel *Parallel[E]) WaitDone() {
+	parallel.executions.wait()
+}
+
+func (shadow *Shadow[E]) WaitDone() {
+	shadow.executions.wait()
+}
+
+func (bg *BlueGreen[E]) WaitDone() {
+	bg.executions.wait()
+}
+
+func (flagged *Flagged[E]) WaitDone() {
+	flagged.executions.wait()
+}
+
+func (pool *Pool[E]) WaitDone() {
+	pool.executions.wait()
+}
+
+func (pool *TenantPool[E]) WaitDone() {
+	pool.executions.wait()
+}
+
+func (router *Router[E]) WaitDone() {
+	router.executions.wait()
+}
+
+func (retry *Retry[E]) WaitDone() {
+	retry.executions.wait()
+}
+
+func (deadline *Deadline[E]) WaitDone() {
+	deadline.executions.wait()
+}
+
+func (p *Pipeline[E]) WaitDone() {
+	p.executions.wait()
+}
@@ -0,0 +1,40 @@
+package pipeline
+
+/*
+	Clone returns a new tree built from the definition of root, as
+	MarshalPipeline serializes it, rather than by copying its processors, so
+	it shares no buffers, stats or state with root, or with any other clone:
+	the same definition can run several times in one process, for A/B
+	experiments, shadow runs or one tree per tenant.
+
+	Leaves of type "processor" are built by the processor registered under
+	their name, or else by factory, and cloning fails with
+	ErrNoProcessorFactory when there is neither. Whatever a definition does
+	not hold, such as the functions set on the fields of a processor, is not
+	cloned.
+*/
+func Clone[E Traceable](root Processor[E], factory ProcessorFactory[E]) (Processor[E], error) {
+	data, err := MarshalPipeline(root)
+	if err != nil {
+		return nil, err
+	}
+
+	return cloneDefinition(data, factory, nil)
+}
+
+// cloneDefinition builds the serialized definition data, once edit changed
+// it when set
+func cloneDefinition[E Traceable](data []byte, factory ProcessorFactory[E], edit func(sp *SerializedPipeline[E])) (Processor[E], error) {
+	sp, err := unmarshalDefinition[E](data)
+	if err != nil {
+		return nil, err
+	}
+
+	if edit != nil {
+		edit(sp)
+	}
+
+	sp.SetProcessorFactory(factory)
+
+	return sp.Pipeline()
+}
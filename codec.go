@@ -0,0 +1,191 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var ErrUnknownCodec = fmt.Errorf("unknown codec")
+var ErrNotRaw = fmt.Errorf("item can not carry raw data")
+
+/*
+	A Codec converts values to and from their wire representation
+*/
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+var codecsLock sync.RWMutex
+var codecs = map[string]Codec{
+	"json": JSONCodec{},
+}
+
+// RegisterCodec makes a codec available by name to Decode and Encode
+func RegisterCodec(name string, codec Codec) {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+
+	codecs[name] = codec
+}
+
+func LookupCodec(name string) (Codec, bool) {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+
+	codec, ok := codecs[name]
+	return codec, ok
+}
+
+func lookupCodec(name string) (Codec, error) {
+	if name == "" {
+		name = "json"
+	}
+
+	codec, ok := LookupCodec(name)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, ErrUnknownCodec)
+	}
+
+	return codec, nil
+}
+
+/*
+	RawCarrier is implemented by items carrying undecoded data, as produced by
+	byte oriented sources. Items carrying a string can return it as bytes.
+*/
+type RawCarrier interface {
+	RawBytes() []byte
+}
+
+/*
+	RawSetter is implemented by items able to carry encoded data, as consumed
+	by byte oriented sinks.
+*/
+type RawSetter interface {
+	SetRawBytes(data []byte)
+}
+
+/*
+	NewItem returns a new item of the pipeline type, which must be a pointer,
+	for sources declared in definitions to decode what they read into.
+*/
+func NewItem[E Traceable]() (E, error) {
+	var item E
+
+	t := reflect.TypeOf((*E)(nil)).Elem()
+	if t.Kind() != reflect.Pointer {
+		return item, fmt.Errorf("%s: %w", t, ErrUnsupportedField)
+	}
+
+	return reflect.New(t.Elem()).Interface().(E), nil
+}
+
+/*
+	The Decode processor decodes the raw data of RawCarrier items with the
+	named codec, "json" by default.
+
+	New returns the typed item the data is decoded into, given the raw one. If
+	it is nil, the data is decoded into the raw item itself.
+*/
+type Decode[E Traceable] struct {
+	ChainName string
+	Codec     string
+	New       func(raw E) E
+}
+
+func (d *Decode[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	codec, err := lookupCodec(d.Codec)
+	if err != nil {
+		Log[E](ctx, d, "%s", err)
+		ReportError(ctx, d, fmt.Errorf("%w: %w", err, ErrFatal))
+	}
+
+	executeItems[E](ctx, d, input, output, func(item E) (E, error) {
+		if codec == nil {
+			return item, err
+		}
+
+		raw, ok := Traceable(item).(RawCarrier)
+		if !ok {
+			return item, ErrNotRaw
+		}
+
+		target := item
+		if d.New != nil {
+			target = d.New(item)
+		}
+
+		return target, codec.Unmarshal(raw.RawBytes(), target)
+	})
+}
+
+func (d *Decode[E]) Name() string {
+	return fmt.Sprintf("Decode/%s", d.ChainName)
+}
+
+/*
+	The Encode processor encodes items with the named codec, "json" by default.
+
+	Wrap returns the item carrying the encoded data to be sent on. If it is
+	nil, items must implement RawSetter and carry their own encoding.
+*/
+type Encode[E Traceable] struct {
+	ChainName string
+	Codec     string
+	Wrap      func(item E, data []byte) E
+}
+
+func (enc *Encode[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	codec, err := lookupCodec(enc.Codec)
+	if err != nil {
+		Log[E](ctx, enc, "%s", err)
+		ReportError(ctx, enc, fmt.Errorf("%w: %w", err, ErrFatal))
+	}
+
+	executeItems[E](ctx, enc, input, output, func(item E) (E, error) {
+		if codec == nil {
+			return item, err
+		}
+
+		data, err := codec.Marshal(item)
+		if err != nil {
+			return item, err
+		}
+
+		return withRaw(item, data, enc.Wrap)
+	})
+}
+
+func (enc *Encode[E]) Name() string {
+	return fmt.Sprintf("Encode/%s", enc.ChainName)
+}
+
+// withRaw returns the item carrying data, built by wrap if set
+func withRaw[E Traceable](item E, data []byte, wrap func(item E, data []byte) E) (E, error) {
+	if wrap != nil {
+		return wrap(item, data), nil
+	}
+
+	setter, ok := Traceable(item).(RawSetter)
+	if !ok {
+		return item, fmt.Errorf("%T: %w", item, ErrNotRaw)
+	}
+
+	setter.SetRawBytes(data)
+
+	return item, nil
+}
@@ -0,0 +1,393 @@
+package pipeline
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"runtime"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+/*
+	A BenchmarkResult is the performance of a topology over a dataset: its
+	throughput, the end to end latency percentiles of the items, the memory
+	allocated while it ran, and the items handled by every stage.
+
+	Latency is measured for items of comparable types, such as pointers,
+	coming out of the pipeline as the value they were fed as.
+*/
+type BenchmarkResult struct {
+	Name       string        `json:"name"`
+	Duration   time.Duration `json:"duration"`
+	Input      int64         `json:"input"`
+	Output     int64         `json:"output"`
+	Failed     int64         `json:"failed"`
+	Throughput float64       `json:"throughput"`
+
+	P50 time.Duration `json:"p50"`
+	P90 time.Duration `json:"p90"`
+	P99 time.Duration `json:"p99"`
+	Max time.Duration `json:"max"`
+
+	Allocs     uint64 `json:"allocs"`
+	AllocBytes uint64 `json:"alloc_bytes"`
+
+	Stages []BenchmarkStage `json:"stages"`
+}
+
+type BenchmarkStage struct {
+	Path   string `json:"path"`
+	Input  int64  `json:"input"`
+	Output int64  `json:"output"`
+	Failed int64  `json:"failed"`
+}
+
+type Comparison struct {
+	A BenchmarkResult `json:"a"`
+	B BenchmarkResult `json:"b"`
+}
+
+/*
+	Benchmark runs root over the items of dataset, as fast as it takes them.
+	dataset is called for every run, so every run gets items of its own.
+*/
+func Benchmark[E Traceable](ctx context.Context, name string, root Processor[E], dataset func() ([]E, error)) (*BenchmarkResult, error) {
+	items, err := dataset()
+	if err != nil {
+		return nil, err
+	}
+
+	statDB := NewStatDB[E]()
+	statDB.NewGeneration(root)
+	ctx = WithStats(ctx, statDB)
+
+	result := &BenchmarkResult{Name: name, Input: int64(len(items))}
+	latencies := NewExponentialHistogram(0)
+
+	var lock sync.Mutex
+	fed := make(map[interface{}]time.Time)
+
+	input := make(chan E)
+	output := make(chan E)
+
+	runtime.GC()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	start := time.Now()
+
+	go runProcessor[E](ctx, root, input, output)
+
+	go func() {
+		for _, m := range items {
+			if hashable(m) {
+				lock.Lock()
+				fed[m] = time.Now()
+				lock.Unlock()
+			}
+
+			input <- m
+		}
+
+		close(input)
+	}()
+
+	for m := range output {
+		result.Output++
+
+		if !hashable(m) {
+			continue
+		}
+
+		lock.Lock()
+		at, ok := fed[m]
+		delete(fed, m)
+		lock.Unlock()
+
+		if ok {
+			latencies.RecordDuration(time.Since(at))
+		}
+	}
+
+	result.Duration = time.Since(start)
+
+	runtime.ReadMemStats(&after)
+	result.Allocs = after.Mallocs - before.Mallocs
+	result.AllocBytes = after.TotalAlloc - before.TotalAlloc
+
+	if result.Duration > 0 {
+		result.Throughput = float64(result.Output) / result.Duration.Seconds()
+	}
+
+	if latencies.Count() > 0 {
+		seconds := func(s float64) time.Duration {
+			return time.Duration(s * float64(time.Second))
+		}
+
+		result.P50 = seconds(latencies.Quantile(0.5))
+		result.P90 = seconds(latencies.Quantile(0.9))
+		result.P99 = seconds(latencies.Quantile(0.99))
+		result.Max = seconds(latencies.Snapshot().Max)
+	}
+
+	Walk(root, func(path string, p Processor[E]) {
+		stage := BenchmarkStage{Path: path}
+
+		if stats, ok := statDB.statsOf(p); ok {
+			stage.Input = stats.Input.Load()
+			stage.Output = stats.Output.Load()
+			stage.Failed = stats.Failed.Load()
+		}
+
+		// composites count the failures of their children again
+		if _, ok := p.(Composite[E]); !ok {
+			result.Failed += stage.Failed
+		}
+
+		result.Stages = append(result.Stages, stage)
+	})
+
+	return result, nil
+}
+
+func hashable(item interface{}) bool {
+	t := reflect.TypeOf(item)
+	return t != nil && t.Comparable()
+}
+
+/*
+	Compare benchmarks a and b one after the other over the same dataset
+*/
+func Compare[E Traceable](ctx context.Context, a, b Processor[E], dataset func() ([]E, error)) (*Comparison, error) {
+	resultA, err := Benchmark(ctx, a.Name(), a, dataset)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", a.Name(), err)
+	}
+
+	resultB, err := Benchmark(ctx, b.Name(), b, dataset)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+
+	return &Comparison{A: *resultA, B: *resultB}, nil
+}
+
+/*
+	WriteTable writes the comparison side by side: the totals of both runs,
+	then the stages of both, matched by path.
+*/
+func (c *Comparison) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	delta := func(a, b float64) string {
+		if a == 0 {
+			return "-"
+		}
+
+		return fmt.Sprintf("%+.1f%%", (b-a)/a*100)
+	}
+
+	fmt.Fprintf(tw, "\t%s\t%s\tdelta\n", c.A.Name, c.B.Name)
+
+	rows := []struct {
+		name string
+		a, b float64
+		text func(v float64) string
+	}{
+		{"duration", float64(c.A.Duration), float64(c.B.Duration), func(v float64) string { return time.Duration(v).String() }},
+		{"input", float64(c.A.Input), float64(c.B.Input), nil},
+		{"output", float64(c.A.Output), float64(c.B.Output), nil},
+		{"failed", float64(c.A.Failed), float64(c.B.Failed), nil},
+		{"throughput/s", c.A.Throughput, c.B.Throughput, func(v float64) string { return fmt.Sprintf("%.1f", v) }},
+		{"p50", float64(c.A.P50), float64(c.B.P50), func(v float64) string { return time.Duration(v).String() }},
+		{"p90", float64(c.A.P90), float64(c.B.P90), func(v float64) string { return time.Duration(v).String() }},
+		{"p99", float64(c.A.P99), float64(c.B.P99), func(v float64) string { return time.Duration(v).String() }},
+		{"max", float64(c.A.Max), float64(c.B.Max), func(v float64) string { return time.Duration(v).String() }},
+		{"allocs", float64(c.A.Allocs), float64(c.B.Allocs), nil},
+		{"alloc bytes", float64(c.A.AllocBytes), float64(c.B.AllocBytes), nil},
+	}
+
+	for _, row := range rows {
+		text := row.text
+		if text == nil {
+			text = func(v float64) string { return fmt.Sprintf("%.0f", v) }
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", row.name, text(row.a), text(row.b), delta(row.a, row.b))
+	}
+
+	fmt.Fprintf(tw, "\nstage\t%s in/out/failed\t%s in/out/failed\t\n", c.A.Name, c.B.Name)
+
+	stages := func(result BenchmarkResult) map[string]BenchmarkStage {
+		byPath := make(map[string]BenchmarkStage)
+		for _, stage := range result.Stages {
+			byPath[stage.Path] = stage
+		}
+
+		return byPath
+	}
+
+	stagesA, stagesB := stages(c.A), stages(c.B)
+
+	format := func(stage BenchmarkStage, ok bool) string {
+		if !ok {
+			return "-"
+		}
+
+		return fmt.Sprintf("%d/%d/%d", stage.Input, stage.Output, stage.Failed)
+	}
+
+	var paths []string
+	for _, stage := range c.A.Stages {
+		paths = append(paths, stage.Path)
+	}
+
+	for _, stage := range c.B.Stages {
+		if _, ok := stagesA[stage.Path]; !ok {
+			paths = append(paths, stage.Path)
+		}
+	}
+
+	for _, path := range paths {
+		a, okA := stagesA[path]
+		b, okB := stagesB[path]
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t\n", path, format(a, okA), format(b, okB))
+	}
+
+	return tw.Flush()
+}
+
+/*
+	Dataset returns a dataset of the items recorded in the file at path, one
+	per line, decoded with the named codec, "json" by default. The file is
+	read again every time, so every run gets items of its own.
+*/
+func Dataset[E Traceable](path string, codecName string) func() ([]E, error) {
+	return func() ([]E, error) {
+		codec, err := lookupCodec(codecName)
+		if err != nil {
+			return nil, err
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		var items []E
+
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 16*1024*1024)
+
+		for line := 1; scanner.Scan(); line++ {
+			raw := bytes.TrimSpace(scanner.Bytes())
+			if len(raw) == 0 {
+				continue
+			}
+
+			var item E
+			if err := codec.Unmarshal(raw, &item); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+
+			items = append(items, item)
+		}
+
+		return items, scanner.Err()
+	}
+}
+
+/*
+	CompareCommand implements the compare subcommand, for the main of the
+	programs knowing the items and processors of their pipelines:
+
+		compare [-codec json] [-format text|json] a.json b.json dataset.jsonl
+
+	It builds both serialized definitions with factory, runs them one after
+	the other over the recorded dataset, and writes their comparison to out.
+*/
+func CompareCommand[E Traceable](ctx context.Context, args []string, factory ProcessorFactory[E], out io.Writer) error {
+	flags := flag.NewFlagSet("compare", flag.ContinueOnError)
+	flags.SetOutput(out)
+
+	codecName := flags.String("codec", "json", "codec of the dataset items")
+	format := flags.String("format", "text", "output format, text or json")
+
+	flags.Usage = func() {
+		fmt.Fprintln(out, "usage: compare [-codec json] [-format text|json] a.json b.json dataset.jsonl")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 3 {
+		flags.Usage()
+		return fmt.Errorf("compare: expected 2 definitions and a dataset, got %d arguments", flags.NArg())
+	}
+
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("compare: unknown format %q", *format)
+	}
+
+	load := func(path string) (Processor[E], error) {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var sp SerializedPipeline[E]
+		if err := json.Unmarshal(raw, &sp); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		sp.SetProcessorFactory(factory)
+
+		p, err := sp.Pipeline()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		return p, nil
+	}
+
+	a, err := load(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	b, err := load(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	comparison, err := Compare(ctx, a, b, Dataset[E](flags.Arg(2), *codecName))
+	if err != nil {
+		return err
+	}
+
+	// both definitions usually share their root name
+	if comparison.A.Name == comparison.B.Name {
+		comparison.A.Name, comparison.B.Name = flags.Arg(0), flags.Arg(1)
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(comparison)
+	}
+
+	return comparison.WriteTable(out)
+}
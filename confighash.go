@@ -0,0 +1,145 @@
+package pipeline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+const (
+	ConfigAdded   = "added"
+	ConfigRemoved = "removed"
+	ConfigChanged = "changed"
+)
+
+/*
+	ConfigHash returns a stable hash of the effective configuration of p and
+	everything below it.
+
+	Leaves are hashed from their type and JSON encoding. For leaves that can not
+	be encoded as a whole, such as those holding functions, the exported fields
+	that can be encoded are used. Composites are hashed from their type, name,
+	display template, cfg as serialized, and the hashes of their children in
+	order, so the hash of a composite changes whenever any node below changes.
+*/
+func ConfigHash[E Traceable](p Processor[E]) string {
+	h := sha256.New()
+	h.Write([]byte(nodeConfig(p)))
+
+	if composite, ok := p.(Composite[E]); ok {
+		for _, child := range composite.Children() {
+			h.Write([]byte(ConfigHash(child)))
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// nodeConfig is the configuration of p itself, excluding its children but
+// for their names
+func nodeConfig[E Traceable](p Processor[E]) string {
+	composite, ok := p.(Composite[E])
+	if !ok {
+		return fmt.Sprintf("%T %s", p, leafConfig(p))
+	}
+
+	var cfg map[string]interface{}
+	if configured, ok := p.(interface{ config() map[string]interface{} }); ok {
+		cfg = configured.config()
+	}
+
+	children := make([]string, 0)
+	for _, child := range composite.Children() {
+		children = append(children, child.Name())
+	}
+
+	encoded, _ := json.Marshal(map[string]interface{}{
+		"name":     p.Name(),
+		"display":  DisplayName(p),
+		"cfg":      cfg,
+		"children": children,
+	})
+
+	return fmt.Sprintf("%T %s", p, encoded)
+}
+
+type ConfigChange struct {
+	Path   string `json:"path"`
+	Change string `json:"change"`
+}
+
+/*
+	DiffConfig compares two versions of a pipeline node by node, matching nodes
+	by their Walk path, and returns the nodes added, removed, or whose own
+	configuration changed. Composites are only reported as changed when their
+	own configuration or their list of children changed, not when a node
+	below them did, so a reload can rebuild only what changed.
+*/
+func DiffConfig[E Traceable](old Processor[E], updated Processor[E]) []ConfigChange {
+	oldNodes := nodeConfigs(old)
+	newNodes := nodeConfigs(updated)
+
+	var changes []ConfigChange
+
+	for path, cfg := range newNodes {
+		oldCfg, found := oldNodes[path]
+
+		switch {
+		case !found:
+			changes = append(changes, ConfigChange{Path: path, Change: ConfigAdded})
+		case oldCfg != cfg:
+			changes = append(changes, ConfigChange{Path: path, Change: ConfigChanged})
+		}
+	}
+
+	for path := range oldNodes {
+		if _, found := newNodes[path]; !found {
+			changes = append(changes, ConfigChange{Path: path, Change: ConfigRemoved})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+
+	return changes
+}
+
+func nodeConfigs[E Traceable](root Processor[E]) map[string]string {
+	nodes := make(map[string]string)
+
+	if root == nil {
+		return nodes
+	}
+
+	Walk(root, func(path string, p Processor[E]) {
+		nodes[path] = nodeConfig(p)
+	})
+
+	return nodes
+}
+
+func leafConfig[E Traceable](p Processor[E]) []byte {
+	if encoded, err := json.Marshal(p); err == nil {
+		return encoded
+	}
+
+	v, ok := structValue(p)
+	if !ok {
+		return []byte(p.Name())
+	}
+
+	fields := make(map[string]json.RawMessage)
+
+	for _, f := range structFields(v.Type(), "json") {
+		encoded, err := json.Marshal(v.FieldByIndex(f.Index).Interface())
+		if err == nil {
+			fields[f.Name] = encoded
+		}
+	}
+
+	encoded, _ := json.Marshal(fields)
+	return encoded
+}
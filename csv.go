@@ -0,0 +1,213 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+/*
+	The CSVReader processor parses the raw data of RawCarrier items as CSV and
+	sends an item per record.
+
+	Columns are named after the first record when Header is set, or Columns
+	otherwise, or their position ("0", "1"...) if neither is given. Records are
+	set into the item returned by New: struct items get each column converted
+	to the type of the field tagged `csv:"column"`, and FieldSetter items get
+	the columns converted as told by Types. If New is nil, the record is set
+	into the raw item itself, which only makes sense for one record per item.
+
+	The header is only read once per run, so raw items may be whole files or
+	consecutive chunks of a single one.
+*/
+type CSVReader[E Traceable] struct {
+	ChainName string
+
+	Comma   rune
+	Comment rune
+	Header  bool
+	Columns []string
+	Types   map[string]string
+
+	New func(raw E) E
+}
+
+func (r *CSVReader[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	columns := r.Columns
+	headerRead := !r.Header
+
+	for m := range input {
+		TrackItemInput[E](ctx, r, m)
+
+		raw, ok := Traceable(m).(RawCarrier)
+		if !ok {
+			Log[E](ctx, r, "failed: %T: %s", m, ErrNotRaw)
+			TrackFailure[E](ctx, r)
+			ReportError(ctx, r, fmt.Errorf("%T: %w", m, ErrNotRaw))
+			continue
+		}
+
+		reader := csv.NewReader(bytes.NewReader(raw.RawBytes()))
+		reader.FieldsPerRecord = -1
+		reader.ReuseRecord = true
+
+		if r.Comma != 0 {
+			reader.Comma = r.Comma
+		}
+
+		reader.Comment = r.Comment
+
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				Log[E](ctx, r, "failed: %s", err)
+				TrackFailure[E](ctx, r)
+				ReportError(ctx, r, err)
+				break
+			}
+
+			if !headerRead {
+				columns = append([]string(nil), record...)
+				headerRead = true
+				continue
+			}
+
+			values := make(map[string]string, len(record))
+			for i, value := range record {
+				if i < len(columns) {
+					values[columns[i]] = value
+				} else {
+					values[strconv.Itoa(i)] = value
+				}
+			}
+
+			item := m
+			if r.New != nil {
+				item = r.New(m)
+			}
+
+			if err := setItemFields(item, "csv", values, r.Types); err != nil {
+				Log[E](ctx, r, "failed: %s", err)
+				TrackFailure[E](ctx, r)
+				ReportError(ctx, r, err)
+				continue
+			}
+
+			TrackOutput[E](ctx, r, item)
+			output <- item
+		}
+	}
+
+	close(output)
+}
+
+func (r *CSVReader[E]) Name() string {
+	return fmt.Sprintf("CSVReader/%s", r.ChainName)
+}
+
+/*
+	The CSVWriter processor formats every item as a CSV record, from its
+	FieldMap or its struct fields, named after their `csv` tag.
+
+	Columns sets which fields are written and in which order. By default they
+	are the struct fields in declaration order, or the sorted FieldMap keys, of
+	the first item. When Header is set, the column names are written before the
+	first record.
+
+	Records are written to Writer when set, and items sent on unchanged.
+	Otherwise each item carries its own record (prefixed by the header for the
+	first one), through Wrap or RawSetter.
+*/
+type CSVWriter[E Traceable] struct {
+	ChainName string
+
+	Comma   rune
+	Header  bool
+	Columns []string
+
+	Writer io.Writer
+	Wrap   func(item E, data []byte) E
+}
+
+func (w *CSVWriter[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	columns := w.Columns
+	headerWritten := !w.Header
+
+	buf := &bytes.Buffer{}
+	writer := csv.NewWriter(buf)
+
+	if w.Comma != 0 {
+		writer.Comma = w.Comma
+	}
+
+	executeItems[E](ctx, w, input, output, func(item E) (E, error) {
+		fields, ok := itemFields(item, "csv")
+		if !ok {
+			return item, fmt.Errorf("%T: %w", item, ErrUnsupportedField)
+		}
+
+		if columns == nil {
+			columns = itemColumns(item, fields)
+		}
+
+		buf.Reset()
+
+		if !headerWritten {
+			writer.Write(columns)
+			headerWritten = true
+		}
+
+		record := make([]string, len(columns))
+		for i, column := range columns {
+			record[i] = formatValue(fields[column])
+		}
+
+		writer.Write(record)
+		writer.Flush()
+
+		if err := writer.Error(); err != nil {
+			return item, err
+		}
+
+		if w.Writer != nil {
+			_, err := w.Writer.Write(buf.Bytes())
+			return item, err
+		}
+
+		return withRaw(item, bytes.Clone(buf.Bytes()), w.Wrap)
+	})
+}
+
+func (w *CSVWriter[E]) Name() string {
+	return fmt.Sprintf("CSVWriter/%s", w.ChainName)
+}
+
+func itemColumns(item interface{}, fields map[string]interface{}) []string {
+	if _, ok := item.(FieldMapper); !ok {
+		if v, ok := structValue(item); ok {
+			var columns []string
+			for _, f := range structFields(v.Type(), "csv") {
+				columns = append(columns, f.Name)
+			}
+
+			return columns
+		}
+	}
+
+	columns := make([]string, 0, len(fields))
+	for name := range fields {
+		columns = append(columns, name)
+	}
+
+	sort.Strings(columns)
+
+	return columns
+}
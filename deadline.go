@@ -0,0 +1,172 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrBudgetExhausted = fmt.Errorf("item deadline budget exhausted")
+
+/*
+	The Deadline processor has:
+
+	- One input
+	- One processor, which must be an ItemProcessor
+	- One output
+
+	It makes its processor deadline aware. When the context of the pipeline
+	has a deadline, every item goes through ProcessItem of the processor
+	with a context of its own, expiring once Share of the time left before
+	the deadline went by, all of it when Share is not within (0, 1). Items
+	run with the context of the pipeline otherwise.
+
+	Items with a budget shorter than MinBudget are not processed, and those
+	not processed within their budget fail with ErrBudgetExhausted, unless
+	the stage is Optional: they are then sent on as the processor left them,
+	so late items skip optional enrichment rather than delaying the whole
+	pipeline past its deadline. Skipped items are counted under the
+	"deadline" label dimension of the stats of the Deadline, as "skipped"
+	when they were not processed, and "expired" when they were late.
+*/
+type Deadline[E Traceable] struct {
+	ChainName string
+	Display   string
+	Tags      map[string]string
+
+	Processor Processor[E]
+	Share     float64
+	MinBudget time.Duration
+	Optional  bool
+
+	executions executions
+}
+
+func (deadline *Deadline[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	deadline.executions.begin()
+	defer deadline.executions.end()
+
+	Log[E](ctx, deadline, "starting")
+	TrackStarted[E](ctx, deadline)
+	ctx = withErrorScope[E](ctx, deadline)
+
+	if deadline.Processor == nil {
+		close(output)
+		return
+	}
+
+	proc, ok := deadline.Processor.(ItemProcessor[E])
+	if !ok {
+		ReportError(ctx, deadline, fmt.Errorf("%s: %w: %w", deadline.Processor.Name(), ErrNotItemProcessor, ErrFatal))
+		drain(input)
+		close(output)
+		return
+	}
+
+	if statDB, ok := ctx.Value(PipelineStatDB).(*StatDB[E]); ok {
+		statDB.register(proc)
+	}
+
+	setErrorReporter(ctx, proc)
+
+	for {
+		msg, ok := receive(ctx, input)
+		if !ok {
+			break
+		}
+
+		TrackItemInput[E](ctx, deadline, msg)
+
+		result, err := deadline.process(ctx, proc, msg)
+		if errors.Is(err, ErrDropped) {
+			Ack(msg)
+			continue
+		}
+
+		if errors.Is(err, ErrBudgetExhausted) && deadline.Optional {
+			TrackOutput[E](ctx, deadline, msg)
+			send(ctx, output, msg)
+			continue
+		}
+
+		if err != nil {
+			Log[E](ctx, deadline, "failed: %s", err)
+			TrackFailure[E](ctx, deadline)
+			ReportError(ctx, deadline, err)
+			SendToDLQ(ctx, deadline, msg, err)
+			continue
+		}
+
+		TrackOutput[E](ctx, deadline, result)
+		send(ctx, output, result)
+	}
+
+	inputClosed[E](ctx, deadline)
+	TrackFinished[E](ctx, deadline)
+	close(output)
+}
+
+// budget returns the time an item has from now, and whether it has a
+// budget at all
+func (deadline *Deadline[E]) budget(ctx context.Context) (time.Duration, bool) {
+	end, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+
+	left := time.Until(end)
+
+	if share := deadline.Share; share > 0 && share < 1 {
+		left = time.Duration(float64(left) * share)
+	}
+
+	return left, true
+}
+
+func (deadline *Deadline[E]) process(ctx context.Context, proc ItemProcessor[E], item E) (E, error) {
+	budget, ok := deadline.budget(ctx)
+	if !ok {
+		return deadline.run(ctx, proc, item)
+	}
+
+	if budget <= 0 || budget < deadline.MinBudget {
+		TrackLabel[E](ctx, deadline, "deadline", "skipped")
+		return item, fmt.Errorf("%s: %s left: %w", proc.Name(), budget, ErrBudgetExhausted)
+	}
+
+	itemCtx, cancel := context.WithTimeout(ctx, budget)
+	defer cancel()
+
+	result, err := deadline.run(itemCtx, proc, item)
+	if err != nil && itemCtx.Err() != nil && ctx.Err() == nil {
+		TrackLabel[E](ctx, deadline, "deadline", "expired")
+		return result, fmt.Errorf("%s: not done in %s: %w: %w", proc.Name(), budget, err, ErrBudgetExhausted)
+	}
+
+	return result, err
+}
+
+func (deadline *Deadline[E]) run(ctx context.Context, proc ItemProcessor[E], item E) (E, error) {
+	TrackItemInput[E](ctx, proc, item)
+
+	result, err := proc.ProcessItem(ctx, item)
+	if err == nil {
+		TrackOutput[E](ctx, proc, result)
+		return result, nil
+	}
+
+	if !errors.Is(err, ErrDropped) {
+		TrackFailure[E](ctx, proc)
+	}
+
+	return result, err
+}
+
+func (deadline *Deadline[E]) Name() string {
+	return fmt.Sprintf("Deadline/%s", deadline.ChainName)
+}
+
+func (deadline *Deadline[E]) Children() []Processor[E] {
+	return nonNil([]Processor[E]{deadline.Processor})
+}
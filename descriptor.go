@@ -0,0 +1,95 @@
+package pipeline
+
+import (
+	"context"
+	"sort"
+)
+
+const (
+	CapabilityComposite = "composite"
+	CapabilityBatch     = "batch"
+	CapabilityTap       = "tap"
+	CapabilityState     = "state"
+	CapabilitySeek      = "seek"
+)
+
+/*
+	A Descriptor identifies a processor for graphs, stats and control APIs:
+	its name, the version of its implementation, a hash of its configuration,
+	what it is able to do and its tags.
+*/
+type Descriptor struct {
+	Name         string            `json:"name"`
+	Version      string            `json:"version,omitempty"`
+	ConfigHash   string            `json:"config_hash,omitempty"`
+	Capabilities []string          `json:"capabilities,omitempty"`
+	Tags         map[string]string `json:"tags,omitempty"`
+}
+
+/*
+	Describer can be implemented by processors to describe themselves, maybe
+	depending on the context they run in. Processors not implementing it are
+	described from their Name.
+*/
+type Describer interface {
+	Describe(ctx context.Context) Descriptor
+}
+
+/*
+	Describe returns the descriptor of p. Capabilities detected from the
+	interfaces p implements are always included, and the ConfigHash and the
+	tags of a Tagger are used when not given.
+*/
+func Describe[E Traceable](ctx context.Context, p Processor[E]) Descriptor {
+	var d Descriptor
+
+	if describer, ok := p.(Describer); ok {
+		d = describer.Describe(ctx)
+	}
+
+	if d.Name == "" {
+		d.Name = p.Name()
+	}
+
+	if d.ConfigHash == "" {
+		d.ConfigHash = ConfigHash(p)
+	}
+
+	if d.Tags == nil {
+		d.Tags = processorTags(p)
+	}
+
+	capabilities := make(map[string]bool)
+	for _, c := range d.Capabilities {
+		capabilities[c] = true
+	}
+
+	if _, ok := p.(Composite[E]); ok {
+		capabilities[CapabilityComposite] = true
+	}
+
+	if _, ok := p.(BatchProcessor[E]); ok {
+		capabilities[CapabilityBatch] = true
+	}
+
+	if _, ok := p.(Tap[E]); ok {
+		capabilities[CapabilityTap] = true
+	}
+
+	if _, ok := p.(StateSnapshotter); ok {
+		capabilities[CapabilityState] = true
+	}
+
+	if _, ok := p.(Seeker); ok {
+		capabilities[CapabilitySeek] = true
+	}
+
+	d.Capabilities = make([]string, 0, len(capabilities))
+	for c := range capabilities {
+		d.Capabilities = append(d.Capabilities, c)
+	}
+
+	sort.Strings(d.Capabilities)
+
+	return d
+}
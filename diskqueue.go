@@ -0,0 +1,657 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	DefaultDiskQueueMemory  = 1000
+	DefaultDiskQueueSegment = 64 << 20
+)
+
+/*
+	The DiskQueue processor passes its items on in order, holding up to
+	MemoryItems of them, DefaultDiskQueueMemory when zero, and MemoryBytes
+	when set, while the stages after it are slower than those before. The
+	items coming once it holds as many are written to disk, to files of
+	SegmentSize bytes at most in Dir, and read back as the stages after it
+	catch up, so a sink being down for minutes does not stall, or lose the
+	items of, the stages before it.
+
+	Items are encoded with Codec, JSON by default. The queue on disk survives
+	the DiskQueue: items still on disk when its context is cancelled, and
+	those it held in memory, written behind them, are sent on first the
+	next time it runs with the same Dir. Items are removed from disk once
+	sent on, so they are delivered at least once.
+
+	Items are counted under the "disk_queue" label dimension of its stats,
+	as "memory" or "disk" depending on where they waited.
+*/
+type DiskQueue[E Traceable] struct {
+	ChainName string
+	Dir       string
+	Codec     string
+
+	MemoryItems int
+	MemoryBytes int64
+	SegmentSize int64
+
+	lock        sync.Mutex
+	log         *diskLog
+	memory      []E
+	memoryBytes int64
+	closed      bool
+	wake        chan struct{}
+}
+
+func NewDiskQueue[E Traceable](name string, dir string) *DiskQueue[E] {
+	return &DiskQueue[E]{
+		ChainName: name,
+		Dir:       dir,
+	}
+}
+
+// Init opens the queue on disk, so a Dir which can not be used fails Build
+func (q *DiskQueue[E]) Init(ctx context.Context) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.open()
+}
+
+func (q *DiskQueue[E]) open() error {
+	if q.log != nil {
+		return nil
+	}
+
+	if _, err := lookupCodec(q.Codec); err != nil {
+		return err
+	}
+
+	log, err := openDiskLog(q.Dir, q.segmentSize())
+	if err != nil {
+		return fmt.Errorf("%s: %w", q.Name(), err)
+	}
+
+	q.log = log
+
+	return nil
+}
+
+// Len returns the items waiting on disk
+func (q *DiskQueue[E]) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.log == nil {
+		return 0
+	}
+
+	return q.log.pending
+}
+
+func (q *DiskQueue[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	Log[E](ctx, q, "starting")
+	TrackStarted[E](ctx, q)
+
+	q.lock.Lock()
+	err := q.open()
+	q.memory, q.memoryBytes, q.closed = nil, 0, false
+	q.wake = make(chan struct{}, 1)
+	q.lock.Unlock()
+
+	if err != nil {
+		ReportError(ctx, q, fmt.Errorf("%w: %w", err, ErrFatal))
+		drain(input)
+		close(output)
+		return
+	}
+
+	codec, _ := lookupCodec(q.Codec)
+	received := make(chan struct{})
+
+	go func() {
+		defer close(received)
+
+		for {
+			msg, ok := receive(ctx, input)
+			if !ok {
+				break
+			}
+
+			TrackItemInput[E](ctx, q, msg)
+
+			if err := q.put(ctx, codec, msg); err != nil {
+				Log[E](ctx, q, "failed: %s", err)
+				TrackFailure[E](ctx, q)
+				ReportError(ctx, q, err)
+				SendToDLQ(ctx, q, msg, err)
+			}
+		}
+
+		inputClosed[E](ctx, q)
+
+		q.lock.Lock()
+		q.closed = true
+		q.lock.Unlock()
+
+		q.notify()
+	}()
+
+	for {
+		msg, fromDisk, ok := q.take(ctx, codec)
+		if !ok {
+			break
+		}
+
+		select {
+		case output <- msg:
+		case <-ctx.Done():
+			if !fromDisk {
+				q.restore(msg)
+			}
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		if fromDisk {
+			q.lock.Lock()
+			err := q.log.advance()
+			q.lock.Unlock()
+
+			if err != nil {
+				ReportError(ctx, q, err)
+			}
+		}
+
+		TrackOutput[E](ctx, q, msg)
+	}
+
+	<-received
+
+	if ctx.Err() != nil {
+		q.persist(ctx, codec)
+	}
+
+	TrackFinished[E](ctx, q)
+	close(output)
+}
+
+// put keeps m in memory, unless the memory is full or items wait on disk,
+// which it is then written behind
+func (q *DiskQueue[E]) put(ctx context.Context, codec Codec, m E) error {
+	size := itemSize(m)
+
+	q.lock.Lock()
+
+	fits := len(q.memory) < q.memoryItems() && (q.MemoryBytes <= 0 || len(q.memory) == 0 || q.memoryBytes+size <= q.MemoryBytes)
+
+	if q.log.pending == 0 && fits {
+		q.memory = append(q.memory, m)
+		q.memoryBytes += size
+		q.lock.Unlock()
+
+		TrackLabel[E](ctx, q, "disk_queue", "memory")
+		q.notify()
+
+		return nil
+	}
+
+	data, err := codec.Marshal(m)
+	if err == nil {
+		err = q.log.append(data)
+	}
+
+	q.lock.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	TrackLabel[E](ctx, q, "disk_queue", "disk")
+	q.notify()
+
+	return nil
+}
+
+/*
+	take returns the oldest item, from memory, or from disk once the memory
+	is empty, waiting for one until the input is closed or ctx is done.
+	Items from disk stay there until advance is called.
+*/
+func (q *DiskQueue[E]) take(ctx context.Context, codec Codec) (E, bool, bool) {
+	var zero E
+
+	for {
+		q.lock.Lock()
+
+		if len(q.memory) > 0 {
+			m := q.memory[0]
+			q.memory[0] = zero
+			q.memory = q.memory[1:]
+			q.memoryBytes -= itemSize(m)
+			q.lock.Unlock()
+
+			return m, false, true
+		}
+
+		if q.log.pending > 0 {
+			data, err := q.log.next()
+			if err != nil {
+				q.lock.Unlock()
+				ReportError(ctx, q, fmt.Errorf("%w: %w", err, ErrFatal))
+				return zero, false, false
+			}
+
+			var m E
+			if err := codec.Unmarshal(data, &m); err != nil {
+				if advanceErr := q.log.advance(); advanceErr != nil {
+					err = fmt.Errorf("%w: %w", err, advanceErr)
+				}
+
+				q.lock.Unlock()
+
+				Log[E](ctx, q, "dropping undecodable item: %s", err)
+				TrackFailure[E](ctx, q)
+				ReportError(ctx, q, err)
+				continue
+			}
+
+			q.lock.Unlock()
+
+			return m, true, true
+		}
+
+		closed := q.closed
+		q.lock.Unlock()
+
+		if closed {
+			return zero, false, false
+		}
+
+		select {
+		case <-q.wake:
+		case <-ctx.Done():
+			return zero, false, false
+		}
+	}
+}
+
+// restore puts back an item taken from memory and not sent on
+func (q *DiskQueue[E]) restore(m E) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.memory = append([]E{m}, q.memory...)
+	q.memoryBytes += itemSize(m)
+}
+
+// persist writes the items held in memory to disk, for the next run
+func (q *DiskQueue[E]) persist(ctx context.Context, codec Codec) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for _, m := range q.memory {
+		data, err := codec.Marshal(m)
+		if err == nil {
+			err = q.log.append(data)
+		}
+
+		if err != nil {
+			Log[E](ctx, q, "persisting failed: %s", err)
+			ReportError(ctx, q, err)
+			abandon(ctx, m)
+		}
+	}
+
+	if len(q.memory) > 0 {
+		Log[E](ctx, q, "%d items kept on disk", q.log.pending)
+	}
+
+	q.memory, q.memoryBytes = nil, 0
+}
+
+func (q *DiskQueue[E]) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *DiskQueue[E]) memoryItems() int {
+	if q.MemoryItems > 0 {
+		return q.MemoryItems
+	}
+
+	return DefaultDiskQueueMemory
+}
+
+func (q *DiskQueue[E]) segmentSize() int64 {
+	if q.SegmentSize > 0 {
+		return q.SegmentSize
+	}
+
+	return DefaultDiskQueueSegment
+}
+
+func (q *DiskQueue[E]) Name() string {
+	return fmt.Sprintf("DiskQueue/%s", q.ChainName)
+}
+
+func newDiskQueue[E Traceable](name string, cfg map[string]interface{}) (*DiskQueue[E], error) {
+	dir, _ := cfg["dir"].(string)
+	if dir == "" {
+		return nil, fmt.Errorf("diskqueue needs the directory of its queue: %w", ErrInvalidConfig)
+	}
+
+	queue := NewDiskQueue[E](name, dir)
+
+	queue.Codec, _ = cfg["codec"].(string)
+	if _, err := lookupCodec(queue.Codec); err != nil {
+		return nil, fmt.Errorf("%w: %w", err, ErrInvalidConfig)
+	}
+
+	var err error
+	if queue.MemoryItems, _, err = configInt(cfg, "memory_items", 0); err != nil {
+		return nil, err
+	}
+
+	memoryBytes, _, err := configInt(cfg, "memory_bytes", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	segmentSize, _, err := configInt(cfg, "segment_size", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	queue.MemoryBytes, queue.SegmentSize = int64(memoryBytes), int64(segmentSize)
+
+	return queue, nil
+}
+
+func marshalDiskQueue[E Traceable](queue *DiskQueue[E]) (string, map[string]interface{}, error) {
+	cfg := map[string]interface{}{
+		"dir": queue.Dir,
+	}
+
+	if queue.Codec != "" {
+		cfg["codec"] = queue.Codec
+	}
+
+	if queue.MemoryItems > 0 {
+		cfg["memory_items"] = queue.MemoryItems
+	}
+
+	if queue.MemoryBytes > 0 {
+		cfg["memory_bytes"] = queue.MemoryBytes
+	}
+
+	if queue.SegmentSize > 0 {
+		cfg["segment_size"] = queue.SegmentSize
+	}
+
+	return queue.ChainName, cfg, nil
+}
+
+/*
+	diskLog is a queue of records in numbered segment files, each record its
+	length and its data. The position of the oldest pending record is kept
+	in the cursor file, so the queue is read from there when opened again.
+	Segments are removed once read, and all but the last one, which is
+	truncated, when nothing is pending.
+*/
+type diskLog struct {
+	dir         string
+	segmentSize int64
+
+	segments []uint64
+	reader   *os.File
+	read     int64
+	writer   *os.File
+	written  int64
+	cursor   *os.File
+	pending  int
+
+	// the length of the record returned by next
+	current int64
+}
+
+const diskLogSuffix = ".queue"
+
+func openDiskLog(dir string, segmentSize int64) (*diskLog, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &diskLog{dir: dir, segmentSize: segmentSize}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, diskLogSuffix) {
+			continue
+		}
+
+		segment, err := strconv.ParseUint(strings.TrimSuffix(name, diskLogSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		l.segments = append(l.segments, segment)
+	}
+
+	sort.Slice(l.segments, func(i, j int) bool { return l.segments[i] < l.segments[j] })
+
+	if l.cursor, err = os.OpenFile(filepath.Join(dir, "cursor"), os.O_RDWR|os.O_CREATE, 0o644); err != nil {
+		return nil, err
+	}
+
+	position := make([]byte, 16)
+	if n, _ := l.cursor.ReadAt(position, 0); n == len(position) {
+		segment := binary.BigEndian.Uint64(position)
+
+		for len(l.segments) > 0 && l.segments[0] < segment {
+			os.Remove(l.path(l.segments[0]))
+			l.segments = l.segments[1:]
+		}
+
+		if len(l.segments) > 0 && l.segments[0] == segment {
+			l.read = int64(binary.BigEndian.Uint64(position[8:]))
+		}
+	}
+
+	if len(l.segments) == 0 {
+		l.segments = []uint64{1}
+	}
+
+	if err := l.openWriter(l.segments[len(l.segments)-1]); err != nil {
+		return nil, err
+	}
+
+	if l.reader, err = os.Open(l.path(l.segments[0])); err != nil {
+		return nil, err
+	}
+
+	if err := l.count(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *diskLog) path(segment uint64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%016d%s", segment, diskLogSuffix))
+}
+
+func (l *diskLog) openWriter(segment uint64) error {
+	writer, err := os.OpenFile(l.path(segment), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := writer.Stat()
+	if err != nil {
+		writer.Close()
+		return err
+	}
+
+	if l.writer != nil {
+		l.writer.Close()
+	}
+
+	l.writer, l.written = writer, info.Size()
+
+	return nil
+}
+
+// count counts the records pending from the cursor
+func (l *diskLog) count() error {
+	l.pending = 0
+
+	offset := l.read
+
+	for _, segment := range l.segments {
+		file, err := os.Open(l.path(segment))
+		if err != nil {
+			return err
+		}
+
+		header := make([]byte, 4)
+
+		for {
+			if n, _ := file.ReadAt(header, offset); n < len(header) {
+				break
+			}
+
+			offset += 4 + int64(binary.BigEndian.Uint32(header))
+			l.pending++
+		}
+
+		file.Close()
+		offset = 0
+	}
+
+	return nil
+}
+
+func (l *diskLog) append(data []byte) error {
+	if l.written > 0 && l.written+int64(4+len(data)) > l.segmentSize {
+		segment := l.segments[len(l.segments)-1] + 1
+
+		if err := l.openWriter(segment); err != nil {
+			return err
+		}
+
+		l.segments = append(l.segments, segment)
+	}
+
+	record := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(record, uint32(len(data)))
+	copy(record[4:], data)
+
+	if _, err := l.writer.WriteAt(record, l.written); err != nil {
+		return err
+	}
+
+	l.written += int64(len(record))
+	l.pending++
+
+	return nil
+}
+
+// next returns the oldest pending record, moving to the next segment when
+// the one being read is over
+func (l *diskLog) next() ([]byte, error) {
+	header := make([]byte, 4)
+
+	for {
+		n, err := l.reader.ReadAt(header, l.read)
+		if n == len(header) {
+			break
+		}
+
+		if err != io.EOF || len(l.segments) < 2 {
+			return nil, fmt.Errorf("reading %s: %d pending items lost: %w", l.reader.Name(), l.pending, io.ErrUnexpectedEOF)
+		}
+
+		l.reader.Close()
+		os.Remove(l.path(l.segments[0]))
+		l.segments = l.segments[1:]
+
+		if l.reader, err = os.Open(l.path(l.segments[0])); err != nil {
+			return nil, err
+		}
+
+		l.read = 0
+	}
+
+	data := make([]byte, binary.BigEndian.Uint32(header))
+	if n, err := l.reader.ReadAt(data, l.read+4); n < len(data) {
+		return nil, err
+	}
+
+	l.current = int64(4 + len(data))
+
+	return data, nil
+}
+
+// advance removes the record returned by next from the queue
+func (l *diskLog) advance() error {
+	l.read += l.current
+	l.current = 0
+	l.pending--
+
+	if l.pending == 0 {
+		if err := l.reset(); err != nil {
+			return err
+		}
+	}
+
+	position := make([]byte, 16)
+	binary.BigEndian.PutUint64(position, l.segments[0])
+	binary.BigEndian.PutUint64(position[8:], uint64(l.read))
+
+	_, err := l.cursor.WriteAt(position, 0)
+	return err
+}
+
+// reset empties the queue, keeping the last segment only
+func (l *diskLog) reset() error {
+	last := l.segments[len(l.segments)-1]
+
+	if l.segments[0] != last {
+		l.reader.Close()
+
+		for _, segment := range l.segments[:len(l.segments)-1] {
+			os.Remove(l.path(segment))
+		}
+
+		reader, err := os.Open(l.path(last))
+		if err != nil {
+			return err
+		}
+
+		l.reader, l.segments = reader, []uint64{last}
+	}
+
+	l.read, l.written = 0, 0
+
+	return l.writer.Truncate(0)
+}
@@ -0,0 +1,90 @@
+package pipeline
+
+import (
+	"strings"
+)
+
+/*
+	DisplayNamer is implemented by processors exposing a human friendly name,
+	used in graphs, dashboards and reports instead of Name, which is meant to
+	be unique and stable.
+*/
+type DisplayNamer interface {
+	DisplayName() string
+}
+
+/*
+	Composites build their display name from their Display template, which can
+	reference:
+
+	- {type}: the composite type, such as Fanout
+	- {ChainName}: the composite chain name
+	- {name}: the composite Name()
+
+	An empty template displays the Name.
+*/
+func DisplayName[E Traceable](p Processor[E]) string {
+	if dn, ok := p.(DisplayNamer); ok {
+		if name := dn.DisplayName(); name != "" {
+			return name
+		}
+	}
+
+	return p.Name()
+}
+
+func renderDisplayName(template, typename, chainName, name string) string {
+	if template == "" {
+		return name
+	}
+
+	return strings.NewReplacer(
+		"{type}", typename,
+		"{ChainName}", chainName,
+		"{name}", name,
+	).Replace(template)
+}
+
+func (fanout *Fanout[E]) DisplayName() string {
+	return renderDisplayName(fanout.Display, "Fanout", fanout.ChainName, fanout.Name())
+}
+
+func (sequential *Sequential[E]) DisplayName() string {
+	return renderDisplayName(sequential.Display, "Sequential", sequential.ChainName, sequential.Name())
+}
+
+func (parallel *Parallel[E]) DisplayName() string {
+	return renderDisplayName(parallel.Display, "Parallel", parallel.ChainName, parallel.Name())
+}
+
+func (shadow *Shadow[E]) DisplayName() string {
+	return renderDisplayName(shadow.Display, "Shadow", shadow.ChainName, shadow.Name())
+}
+
+func (bg *BlueGreen[E]) DisplayName() string {
+	return renderDisplayName(bg.Display, "BlueGreen", bg.ChainName, bg.Name())
+}
+
+func (pool *Pool[E]) DisplayName() string {
+	return renderDisplayName(pool.Display, "Pool", pool.ChainName, pool.Name())
+}
+
+func (pool *TenantPool[E]) DisplayName() string {
+	return renderDisplayName(pool.Display, "Tenants", pool.ChainName, pool.Name())
+}
+
+func (router *Router[E]) DisplayName() string {
+	return renderDisplayName(router.Display, "Router", router.ChainName, router.Name())
+}
+
+func (retry *Retry[E]) DisplayName() string {
+	return renderDisplayName(retry.Display, "Retry", retry.ChainName, retry.Name())
+}
+
+func (deadline *Deadline[E]) DisplayName() string {
+	return renderDisplayName(deadline.Display, "Deadline", deadline.ChainName, deadline.Name())
+}
+
+func (p *Pipeline[E]) DisplayName() string {
+	return renderDisplayName(p.Display, "Pipeline", p.ChainName, p.Name())
+}
@@ -0,0 +1,205 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+var PipelineDeadLetters PipelineContextKey = "pipeline_dead_letters"
+
+/*
+	A DeadLetter is an item a processor could not process, with the error it
+	failed with. Path has the names of the composites the processor runs in,
+	outermost first, as in a ProcessorError.
+*/
+type DeadLetter[E Traceable] struct {
+	Item      E         `json:"item"`
+	Processor string    `json:"processor"`
+	Path      []string  `json:"path,omitempty"`
+	RunID     string    `json:"run_id,omitempty"`
+	Time      time.Time `json:"time"`
+	Err       string    `json:"error"`
+}
+
+type DeadLetterHandler[E Traceable] func(letter *DeadLetter[E])
+
+/*
+	WithDeadLetters attaches a dead letter handler to the context, receiving
+	the items sent with SendToDLQ by every processor. Handlers already
+	attached keep receiving them. Handlers are called synchronously, by the
+	processor giving up on the item.
+*/
+func WithDeadLetters[E Traceable](ctx context.Context, handler DeadLetterHandler[E]) context.Context {
+	if parent, ok := ctx.Value(PipelineDeadLetters).(DeadLetterHandler[E]); ok {
+		next := handler
+
+		handler = func(letter *DeadLetter[E]) {
+			parent(letter)
+			next(letter)
+		}
+	}
+
+	return context.WithValue(ctx, PipelineDeadLetters, handler)
+}
+
+/*
+	WithDeadLetterChannel sends the dead letters to letters. Processors wait
+	for them to be received, until ctx is done, so letters must be drained or
+	buffered.
+*/
+func WithDeadLetterChannel[E Traceable](ctx context.Context, letters chan<- *DeadLetter[E]) context.Context {
+	return WithDeadLetters(ctx, func(letter *DeadLetter[E]) {
+		select {
+		case letters <- letter:
+		case <-ctx.Done():
+		}
+	})
+}
+
+/*
+	SendToDLQ hands item, which p failed to process with err, to the dead
+	letter handlers of the context, and counts it in the stats of p. It
+	returns false, and the item is lost, when there is none. The item is
+	nacked either way.
+*/
+func SendToDLQ[E Traceable](ctx context.Context, p Processor[E], item E, err error) bool {
+	Nack(item, err)
+
+	handler, ok := ctx.Value(PipelineDeadLetters).(DeadLetterHandler[E])
+	if !ok {
+		return false
+	}
+
+	if statDB, ok := ctx.Value(PipelineStatDB).(*StatDB[E]); ok {
+		statDB.trackDeadLetter(p)
+	}
+
+	scope, _ := ctx.Value(PipelineErrorScope).(*errorScope)
+
+	letter := &DeadLetter[E]{
+		Item:      item,
+		Processor: p.Name(),
+		Path:      scope.path(p),
+		RunID:     RunID(ctx),
+		Time:      time.Now(),
+	}
+
+	if err != nil {
+		letter.Err = err.Error()
+	}
+
+	handler(letter)
+
+	return true
+}
+
+/*
+	A DeadLetterCollector is a dead letter handler forwarding letters to
+	Forward, when set, and appending them to the file at Path, when set, one
+	JSON document per line.
+
+	The file is opened when the first letter arrives, and must be closed with
+	Close. Errors writing it are kept, and returned by Err and Close.
+*/
+type DeadLetterCollector[E Traceable] struct {
+	Path    string
+	Forward func(letter *DeadLetter[E])
+
+	lock      sync.Mutex
+	file      *os.File
+	err       error
+	collected atomic.Int64
+}
+
+func NewDeadLetterFile[E Traceable](path string) *DeadLetterCollector[E] {
+	return &DeadLetterCollector[E]{
+		Path: path,
+	}
+}
+
+func NewDeadLetterCallback[E Traceable](forward func(letter *DeadLetter[E])) *DeadLetterCollector[E] {
+	return &DeadLetterCollector[E]{
+		Forward: forward,
+	}
+}
+
+// Attach returns ctx with the collector as a dead letter handler
+func (c *DeadLetterCollector[E]) Attach(ctx context.Context) context.Context {
+	return WithDeadLetters(ctx, c.Handle)
+}
+
+func (c *DeadLetterCollector[E]) Handle(letter *DeadLetter[E]) {
+	c.collected.Inc()
+
+	if c.Forward != nil {
+		c.Forward(letter)
+	}
+
+	if c.Path == "" {
+		return
+	}
+
+	line, err := json.Marshal(letter)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.file == nil {
+		c.file, err = os.OpenFile(c.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			c.err = err
+			return
+		}
+	}
+
+	if _, err := c.file.Write(append(line, '\n')); err != nil {
+		c.err = err
+	}
+}
+
+func (c *DeadLetterCollector[E]) fail(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.err = err
+}
+
+// Collected returns how many letters the collector received
+func (c *DeadLetterCollector[E]) Collected() int64 {
+	return c.collected.Load()
+}
+
+// Err returns the last error writing the file
+func (c *DeadLetterCollector[E]) Err() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.err
+}
+
+func (c *DeadLetterCollector[E]) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.file == nil {
+		return c.err
+	}
+
+	closeErr := c.file.Close()
+	c.file = nil
+
+	if c.err != nil {
+		return c.err
+	}
+
+	return closeErr
+}
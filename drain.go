@@ -0,0 +1,193 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotStarted     = fmt.Errorf("runner not started")
+	ErrAlreadyStarted = fmt.Errorf("runner already started")
+	ErrNotDrained     = fmt.Errorf("pipeline not drained")
+)
+
+// StopDrained is the StopReason of the runs stopped by Drain or Stop
+const StopDrained StopReason = "drained"
+
+/*
+	Start runs the pipeline in the background, until Drain or Stop, or its
+	feed is over in BatchMode. The pipeline is built first unless Build was
+	already called, and Start fails without starting the intake if that
+	fails.
+*/
+func (r *Runner[E]) Start(ctx context.Context) error {
+	r.lock.Lock()
+	started, built := r.done != nil, r.built
+	r.lock.Unlock()
+
+	if started {
+		return ErrAlreadyStarted
+	}
+
+	if !built {
+		if err := r.Build(ctx); err != nil {
+			return err
+		}
+	}
+
+	ctx, stop := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+
+	r.lock.Lock()
+	r.stopIntake, r.done = stop, done
+	r.lock.Unlock()
+
+	go func() {
+		report, err := r.Open(ctx)
+		stop(nil)
+
+		r.lock.Lock()
+		r.result, r.resultErr = report, err
+		r.lock.Unlock()
+
+		close(done)
+	}()
+
+	return nil
+}
+
+/*
+	Drain stops the intake of a pipeline started with Start, so its input is
+	closed and its stages flush what they hold, and returns the report of
+	the run once every stage has finished. Pipelines not drained after
+	timeout are cancelled, and Drain fails with ErrNotDrained, telling the
+	stages which still had items in flight, as InFlight does.
+*/
+func (r *Runner[E]) Drain(timeout time.Duration) (*RunReport, error) {
+	r.lock.Lock()
+	stop, done := r.stopIntake, r.done
+	r.lock.Unlock()
+
+	if done == nil {
+		return nil, ErrNotStarted
+	}
+
+	stop(&runStopped{reason: StopDrained})
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return r.finish()
+	case <-timer.C:
+	}
+
+	inFlight := r.InFlight()
+
+	r.cancel()
+	<-done
+
+	report, _ := r.finish()
+
+	return report, fmt.Errorf("%w after %s: %s", ErrNotDrained, timeout, formatInFlight(inFlight))
+}
+
+// Stop stops the intake of a pipeline started with Start and cancels it,
+// without waiting for its items, and returns the report of the run
+func (r *Runner[E]) Stop() (*RunReport, error) {
+	r.lock.Lock()
+	stop, done := r.stopIntake, r.done
+	r.lock.Unlock()
+
+	if done == nil {
+		return nil, ErrNotStarted
+	}
+
+	stop(&runStopped{reason: StopDrained})
+	r.cancel()
+	<-done
+
+	return r.finish()
+}
+
+/*
+	InFlight returns the items each leaf of the running pipeline took in, by
+	Walk path, and has not sent on or failed yet, according to its stats.
+	Leaves dropping items, such as filters, count the ones they dropped
+	too.
+*/
+func (r *Runner[E]) InFlight() map[string]int64 {
+	r.lock.Lock()
+	statDB := r.statDB
+	r.lock.Unlock()
+
+	inFlight := make(map[string]int64)
+
+	if statDB == nil || r.Pipeline == nil {
+		return inFlight
+	}
+
+	Walk(r.Pipeline, func(path string, p Processor[E]) {
+		if _, composite := p.(Composite[E]); composite {
+			return
+		}
+
+		stats, ok := statDB.statsOf(p)
+		if !ok {
+			return
+		}
+
+		if pending := stats.Input.Load() - stats.Output.Load() - stats.Failed.Load(); pending > 0 {
+			inFlight[path] = pending
+		}
+	})
+
+	return inFlight
+}
+
+// cancel cancels the context of the pipeline of the current run
+func (r *Runner[E]) cancel() {
+	r.lock.Lock()
+	cancel := r.cancelPipeline
+	r.lock.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// finish returns the result of the run started with Start, once it is over,
+// and lets the Runner be started again
+func (r *Runner[E]) finish() (*RunReport, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	report, err := r.result, r.resultErr
+	r.stopIntake, r.done, r.result, r.resultErr = nil, nil, nil, nil
+
+	return report, err
+}
+
+func formatInFlight(inFlight map[string]int64) string {
+	if len(inFlight) == 0 {
+		return "no items in flight"
+	}
+
+	paths := make([]string, 0, len(inFlight))
+	for path := range inFlight {
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+
+	parts := make([]string, 0, len(paths))
+	for _, path := range paths {
+		parts = append(parts, fmt.Sprintf("%s has %d", path, inFlight[path]))
+	}
+
+	return strings.Join(parts, ", ")
+}
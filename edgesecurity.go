@@ -0,0 +1,290 @@
+package pipeline
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"os"
+	"slices"
+	"strings"
+)
+
+const (
+	HeaderAuthorization = "authorization"
+	HeaderSignature     = HeaderPrefix + "signature"
+)
+
+var ErrUnauthorized = fmt.Errorf("unauthorized")
+var ErrInvalidSecurity = fmt.Errorf("invalid edge security")
+
+/*
+	EdgeSecurity configures a remote edge, the link between two processes of a
+	federated pipeline: mutual TLS with the given certificates, and bearer
+	token auth. Over message buses, where messages are kept, the token is
+	never sent: messages are signed with it instead, see Signer.
+
+	It is declared in the topology definition as the "security" entry of the
+	cfg of the connector processors, and read with EdgeSecurityFromConfig.
+	The Token is read from there, but never written back nor encoded to
+	JSON: definitions served or saved only keep the TokenFile.
+*/
+type EdgeSecurity struct {
+	CertFile   string `json:"cert_file,omitempty"`
+	KeyFile    string `json:"key_file,omitempty"`
+	CAFile     string `json:"ca_file,omitempty"`
+	ServerName string `json:"server_name,omitempty"`
+
+	Token     string `json:"-"`
+	TokenFile string `json:"token_file,omitempty"`
+}
+
+// EdgeSecurityFromConfig returns nil if the config has no security entry
+func EdgeSecurityFromConfig(cfg map[string]interface{}) (*EdgeSecurity, error) {
+	raw, ok := cfg["security"]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+
+	enc, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSecurity, err)
+	}
+
+	security := &EdgeSecurity{}
+	if err := json.Unmarshal(enc, security); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSecurity, err)
+	}
+
+	// the token is left out of the JSON encoding, and read on its own
+	token := struct {
+		Token string `json:"token"`
+	}{}
+
+	if err := json.Unmarshal(enc, &token); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSecurity, err)
+	}
+
+	security.Token = token.Token
+
+	return security, nil
+}
+
+// Config returns the security entry EdgeSecurityFromConfig reads back,
+// without the Token
+func (s *EdgeSecurity) Config() map[string]interface{} {
+	cfg := map[string]interface{}{}
+
+	for key, value := range map[string]string{
+		"cert_file":   s.CertFile,
+		"key_file":    s.KeyFile,
+		"ca_file":     s.CAFile,
+		"server_name": s.ServerName,
+		"token_file":  s.TokenFile,
+	} {
+		if value != "" {
+			cfg[key] = value
+		}
+	}
+
+	return cfg
+}
+
+func (s *EdgeSecurity) TLSEnabled() bool {
+	return s != nil && s.CertFile != "" && s.KeyFile != ""
+}
+
+// ServerTLSConfig requires and verifies client certificates, so CAFile is
+// required
+func (s *EdgeSecurity) ServerTLSConfig() (*tls.Config, error) {
+	cfg, err := s.baseTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.RootCAs == nil {
+		return nil, fmt.Errorf("%w: ca_file is required to verify client certificates", ErrInvalidSecurity)
+	}
+
+	cfg.ClientCAs = cfg.RootCAs
+	cfg.RootCAs = nil
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+
+	return cfg, nil
+}
+
+func (s *EdgeSecurity) ClientTLSConfig() (*tls.Config, error) {
+	cfg, err := s.baseTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.ServerName = s.ServerName
+
+	return cfg, nil
+}
+
+func (s *EdgeSecurity) baseTLSConfig() (*tls.Config, error) {
+	if !s.TLSEnabled() {
+		return nil, fmt.Errorf("%w: cert_file and key_file are required for tls", ErrInvalidSecurity)
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSecurity, err)
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if s.CAFile != "" {
+		pem, err := os.ReadFile(s.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSecurity, err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w: no certificates found in %s", ErrInvalidSecurity, s.CAFile)
+		}
+
+		cfg.RootCAs = pool
+	}
+
+	return cfg, nil
+}
+
+// AuthToken returns the configured token, read from TokenFile if needed
+func (s *EdgeSecurity) AuthToken() (string, error) {
+	if s == nil {
+		return "", nil
+	}
+
+	if s.Token != "" || s.TokenFile == "" {
+		return s.Token, nil
+	}
+
+	token, err := os.ReadFile(s.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidSecurity, err)
+	}
+
+	return strings.TrimSpace(string(token)), nil
+}
+
+// Sign adds the authorization header for the configured token, for
+// requests made over TLS, never for messages kept by a broker
+func (s *EdgeSecurity) Sign(headers Headers) error {
+	token, err := s.AuthToken()
+	if err != nil || token == "" {
+		return err
+	}
+
+	headers[HeaderAuthorization] = "Bearer " + token
+	return nil
+}
+
+// Authorize checks the authorization header against the configured token.
+// Any request is authorized when no token is configured.
+func (s *EdgeSecurity) Authorize(headers Headers) error {
+	token, err := s.AuthToken()
+	if err != nil {
+		return err
+	}
+
+	if token == "" {
+		return nil
+	}
+
+	given, found := strings.CutPrefix(headers[HeaderAuthorization], "Bearer ")
+	if !found || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		return ErrUnauthorized
+	}
+
+	return nil
+}
+
+/*
+	A Signer signs messages sent over a message bus with the HMAC-SHA256 of
+	their payload and their pipeline headers, keyed by the token, in the
+	HeaderSignature header: receivers sharing the token check messages
+	come from a sender knowing it, without the token being sent.
+
+	The nil Signer, of an EdgeSecurity without token, signs nothing and
+	verifies any message.
+*/
+type Signer struct {
+	key []byte
+}
+
+// Signer reads the token once, for the signer to sign many messages
+func (s *EdgeSecurity) Signer() (*Signer, error) {
+	token, err := s.AuthToken()
+	if err != nil || token == "" {
+		return nil, err
+	}
+
+	return &Signer{key: []byte(token)}, nil
+}
+
+// Sign adds the signature of payload and headers to headers
+func (s *Signer) Sign(headers Headers, payload []byte) {
+	if s == nil {
+		return
+	}
+
+	headers[HeaderSignature] = hex.EncodeToString(s.sum(headers, payload))
+}
+
+// Verify returns ErrUnauthorized unless headers carry the signature of
+// payload and headers
+func (s *Signer) Verify(headers Headers, payload []byte) error {
+	if s == nil {
+		return nil
+	}
+
+	given, err := hex.DecodeString(headers[HeaderSignature])
+	if err != nil || !hmac.Equal(given, s.sum(headers, payload)) {
+		return ErrUnauthorized
+	}
+
+	return nil
+}
+
+// sum is the HMAC of the pipeline headers in order, but the signature, and
+// of payload
+func (s *Signer) sum(headers Headers, payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.key)
+
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		if strings.HasPrefix(key, HeaderPrefix) && key != HeaderSignature {
+			keys = append(keys, key)
+		}
+	}
+
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		writeField(mac, key)
+		writeField(mac, headers[key])
+	}
+
+	mac.Write(payload)
+
+	return mac.Sum(nil)
+}
+
+// writeField writes value prefixed by its length, so fields cannot run
+// into each other
+func writeField(h hash.Hash, value string) {
+	h.Write([]byte(fmt.Sprintf("%d:", len(value))))
+	h.Write([]byte(value))
+}
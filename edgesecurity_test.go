@@ -0,0 +1,182 @@
+package pipeline
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignerVerifiesSignedMessages(t *testing.T) {
+	signer, err := (&EdgeSecurity{Token: "secret"}).Signer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headers := Headers{HeaderRunID: "run", "other": "value"}
+	signer.Sign(headers, []byte("payload"))
+
+	for key, value := range headers {
+		if strings.Contains(value, "secret") {
+			t.Fatalf("header %s carries the token", key)
+		}
+	}
+
+	if err := signer.Verify(headers, []byte("payload")); err != nil {
+		t.Fatalf("signed message not verified: %v", err)
+	}
+
+	// headers outside the pipeline prefix are not signed
+	headers["other"] = "changed"
+	if err := signer.Verify(headers, []byte("payload")); err != nil {
+		t.Fatalf("message with other headers changed not verified: %v", err)
+	}
+
+	if err := signer.Verify(headers, []byte("tampered")); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("tampered payload: got %v, want ErrUnauthorized", err)
+	}
+
+	headers[HeaderRunID] = "forged"
+	if err := signer.Verify(headers, []byte("payload")); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("tampered header: got %v, want ErrUnauthorized", err)
+	}
+
+	other, _ := (&EdgeSecurity{Token: "other"}).Signer()
+	headers = Headers{}
+	other.Sign(headers, []byte("payload"))
+
+	if err := signer.Verify(headers, []byte("payload")); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("message signed with another token: got %v, want ErrUnauthorized", err)
+	}
+
+	if err := signer.Verify(Headers{}, []byte("payload")); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("unsigned message: got %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestSignerWithoutToken(t *testing.T) {
+	var security *EdgeSecurity
+
+	signer, err := security.Signer()
+	if err != nil || signer != nil {
+		t.Fatalf("got %v, %v, want no signer", signer, err)
+	}
+
+	headers := Headers{}
+	signer.Sign(headers, nil)
+
+	if len(headers) != 0 {
+		t.Fatalf("nil signer signed: %v", headers)
+	}
+
+	if err := signer.Verify(headers, nil); err != nil {
+		t.Fatalf("nil signer refused a message: %v", err)
+	}
+}
+
+func TestEdgeSecurityNeverSerializesToken(t *testing.T) {
+	cfg := map[string]interface{}{
+		"security": map[string]interface{}{
+			"token":      "secret",
+			"token_file": "/run/token",
+		},
+	}
+
+	security, err := EdgeSecurityFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if security.Token != "secret" || security.TokenFile != "/run/token" {
+		t.Fatalf("token not read from config: %+v", security)
+	}
+
+	if _, ok := security.Config()["token"]; ok {
+		t.Fatal("Config returns the token")
+	}
+
+	enc, err := json.Marshal(security)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(enc), "secret") {
+		t.Fatalf("JSON encoding carries the token: %s", enc)
+	}
+}
+
+func TestServerTLSConfigRequiresCA(t *testing.T) {
+	dir := t.TempDir()
+	cert, key := writeCertificate(t, dir)
+
+	security := &EdgeSecurity{CertFile: cert, KeyFile: key}
+
+	if _, err := security.ServerTLSConfig(); !errors.Is(err, ErrInvalidSecurity) {
+		t.Fatalf("without CA: got %v, want ErrInvalidSecurity", err)
+	}
+
+	security.CAFile = cert
+
+	cfg, err := security.ServerTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.ClientCAs == nil || cfg.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Fatalf("client certificates not verified: %v", cfg.ClientAuth)
+	}
+}
+
+// writeCertificate writes a self signed certificate and its key to dir
+func writeCertificate(t *testing.T, dir string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "pipeline"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		DNSNames:              []string{"localhost"},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
@@ -0,0 +1,193 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	PipelineErrorHandler PipelineContextKey = "pipeline_error_handler"
+	PipelineErrorScope   PipelineContextKey = "pipeline_error_scope"
+)
+
+// ErrFatal marks reported errors after which the pipeline should not go on
+var ErrFatal = fmt.Errorf("fatal processor error")
+
+/*
+	A ProcessorError is an error reported by a processor while it runs. Path
+	has the names of the composites the processor runs in, outermost first,
+	and Tags the tags of the processor, inherited from them.
+*/
+type ProcessorError struct {
+	Processor string            `json:"processor"`
+	Path      []string          `json:"path,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	Fatal     bool              `json:"fatal"`
+	RunID     string            `json:"run_id,omitempty"`
+	Time      time.Time         `json:"time"`
+	Err       error             `json:"-"`
+}
+
+func (e *ProcessorError) Error() string {
+	if len(e.Path) == 0 {
+		return fmt.Sprintf("%s: %s", e.Processor, e.Err)
+	}
+
+	return fmt.Sprintf("%s/%s: %s", strings.Join(e.Path, "/"), e.Processor, e.Err)
+}
+
+func (e *ProcessorError) Unwrap() error {
+	return e.Err
+}
+
+type ErrorHandler func(err *ProcessorError)
+
+/*
+	ErrorReporter is implemented by processors reporting errors from outside of
+	their Execute, such as callbacks of the libraries they use. Before running
+	them, composites give them a function reporting errors to the handlers of
+	the context, as ReportError does.
+*/
+type ErrorReporter interface {
+	SetErrorReporter(report func(err error))
+}
+
+/*
+	WithErrors attaches an error handler to the context, receiving the errors
+	reported by every processor. Handlers already attached keep receiving
+	errors. Like event handlers, they are called synchronously and must not
+	block.
+*/
+func WithErrors(ctx context.Context, handler ErrorHandler) context.Context {
+	if parent, ok := ctx.Value(PipelineErrorHandler).(ErrorHandler); ok {
+		next := handler
+
+		handler = func(err *ProcessorError) {
+			parent(err)
+			next(err)
+		}
+	}
+
+	return context.WithValue(ctx, PipelineErrorHandler, handler)
+}
+
+/*
+	WithErrorChannel sends the reported errors to errs. Processors reporting
+	errors wait for them to be received, until ctx is done, so errs must be
+	drained or buffered. Once ctx is done, errors are only sent while errs has
+	room for them.
+*/
+func WithErrorChannel(ctx context.Context, errs chan<- *ProcessorError) context.Context {
+	return WithErrors(ctx, func(err *ProcessorError) {
+		select {
+		case errs <- err:
+			return
+		default:
+		}
+
+		select {
+		case errs <- err:
+		case <-ctx.Done():
+		}
+	})
+}
+
+/*
+	WithFatalCancel returns a context cancelled when a fatal error is reported,
+	having the error as its cause.
+*/
+func WithFatalCancel(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	ctx = WithErrors(ctx, func(err *ProcessorError) {
+		if err.Fatal {
+			cancel(err)
+		}
+	})
+
+	return ctx, func() { cancel(context.Canceled) }
+}
+
+/*
+	ReportError reports err, raised by p, to the error handlers of the context
+	and counts it in the stats of p. Errors wrapping ErrFatal are reported as
+	fatal.
+*/
+func ReportError[E Traceable](ctx context.Context, p Processor[E], err error) {
+	if err == nil {
+		return
+	}
+
+	if statDB, ok := ctx.Value(PipelineStatDB).(*StatDB[E]); ok {
+		statDB.trackError(p)
+	}
+
+	handler, ok := ctx.Value(PipelineErrorHandler).(ErrorHandler)
+	if !ok {
+		return
+	}
+
+	scope, _ := ctx.Value(PipelineErrorScope).(*errorScope)
+
+	handler(&ProcessorError{
+		Processor: p.Name(),
+		Path:      scope.path(p),
+		Tags:      contextTags(ctx, p),
+		Fatal:     errors.Is(err, ErrFatal),
+		RunID:     RunID(ctx),
+		Time:      time.Now(),
+		Err:       err,
+	})
+}
+
+// errorScope is the chain of composites the errors of a processor go through
+type errorScope struct {
+	parent    *errorScope
+	processor interface{}
+	name      string
+}
+
+// withErrorScope is called by composites, so the errors of their children
+// are reported with their name in the path
+func withErrorScope[E Traceable](ctx context.Context, p Processor[E]) context.Context {
+	if _, ok := ctx.Value(PipelineErrorHandler).(ErrorHandler); !ok {
+		return ctx
+	}
+
+	parent, _ := ctx.Value(PipelineErrorScope).(*errorScope)
+
+	return context.WithValue(ctx, PipelineErrorScope, &errorScope{
+		parent:    parent,
+		processor: p,
+		name:      p.Name(),
+	})
+}
+
+// path returns the names in the scope, outermost first, leaving out p itself
+// when a composite reports its own errors
+func (s *errorScope) path(p interface{}) []string {
+	var path []string
+
+	for ; s != nil; s = s.parent {
+		if s.processor == p {
+			continue
+		}
+
+		path = append([]string{s.name}, path...)
+	}
+
+	return path
+}
+
+// setErrorReporter gives p a function reporting its errors, when it reports
+// errors by itself
+func setErrorReporter[E Traceable](ctx context.Context, p Processor[E]) {
+	if reporter, ok := p.(ErrorReporter); ok {
+		reporter.SetErrorReporter(func(err error) {
+			ReportError(ctx, p, err)
+		})
+	}
+}
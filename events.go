@@ -0,0 +1,68 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+var PipelineEventHandler PipelineContextKey = "pipeline_event_handler"
+
+/*
+	Events are notable things happening while a pipeline runs, such as a stage
+	under memory pressure. They are delivered synchronously to the handlers
+	attached to the context with WithEvents, so handlers must not block.
+*/
+type Event struct {
+	Type      string            `json:"type"`
+	Processor string            `json:"processor"`
+	Message   string            `json:"message"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	RunID     string            `json:"run_id,omitempty"`
+	Time      time.Time         `json:"time"`
+}
+
+type EventHandler func(Event)
+
+const (
+	EventMemoryPressure = "memory_pressure"
+	EventMemoryRelieved = "memory_relieved"
+)
+
+// WithEvents attaches an event handler to the context. Handlers already
+// attached keep receiving events.
+func WithEvents(ctx context.Context, handler EventHandler) context.Context {
+	if parent, ok := ctx.Value(PipelineEventHandler).(EventHandler); ok {
+		next := handler
+
+		handler = func(e Event) {
+			parent(e)
+			next(e)
+		}
+	}
+
+	return context.WithValue(ctx, PipelineEventHandler, handler)
+}
+
+func Emit[E Traceable](ctx context.Context, p Processor[E], eventType string, fmts string, args ...interface{}) {
+	handler, ok := ctx.Value(PipelineEventHandler).(EventHandler)
+	if !ok {
+		return
+	}
+
+	name := ""
+	var tags map[string]string
+	if p != nil {
+		name = p.Name()
+		tags = contextTags(ctx, p)
+	}
+
+	handler(Event{
+		Type:      eventType,
+		Processor: name,
+		Message:   fmt.Sprintf(fmts, args...),
+		Tags:      tags,
+		RunID:     RunID(ctx),
+		Time:      time.Now(),
+	})
+}
@@ -0,0 +1,131 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var PipelineEventTime PipelineContextKey = "pipeline_event_time"
+
+/*
+	An EventTimeExtractor returns when the event carried by an item happened,
+	or the zero time if unknown. It takes precedence over EventTimer, for items
+	whose timestamp lives in a field the item type does not expose as such.
+*/
+type EventTimeExtractor func(item Traceable) time.Time
+
+// WithEventTime makes stages and stats use the event time of items as
+// returned by extractor
+func WithEventTime(ctx context.Context, extractor EventTimeExtractor) context.Context {
+	return context.WithValue(ctx, PipelineEventTime, extractor)
+}
+
+/*
+	EventTime returns the event time of the item, using the extractor of the
+	context or the item own EventTime method. It returns the zero time when
+	neither is available, and callers should fall back to processing time.
+*/
+func EventTime(ctx context.Context, item Traceable) time.Time {
+	if extractor, ok := ctx.Value(PipelineEventTime).(EventTimeExtractor); ok {
+		if t := extractor(item); !t.IsZero() {
+			return t
+		}
+	}
+
+	if timer, ok := item.(EventTimer); ok {
+		return timer.EventTime()
+	}
+
+	return time.Time{}
+}
+
+// EventTimeOrNow returns the event time of the item, or the current time if
+// it has none
+func EventTimeOrNow(ctx context.Context, item Traceable) time.Time {
+	if t := EventTime(ctx, item); !t.IsZero() {
+		return t
+	}
+
+	return time.Now()
+}
+
+type LatePolicy int
+
+const (
+	// Late items are sent on like any other
+	LateAccept LatePolicy = iota
+	// Late items are discarded
+	LateDrop
+	// Late items are handed to OnLate and not sent on
+	LateRedirect
+)
+
+/*
+	The LateItems processor applies a policy to items arriving late, those
+	whose event time is older than the newest event time seen minus
+	AllowedLateness. Items without event time are never late.
+
+	Late items are counted under the "event_time" label dimension of the
+	processor stats, whatever the policy. The processor emits as watermark the
+	newest event time seen minus AllowedLateness.
+*/
+type LateItems[E Traceable] struct {
+	ChainName       string
+	AllowedLateness time.Duration
+	Policy          LatePolicy
+	OnLate          func(ctx context.Context, item E)
+
+	lock    sync.Mutex
+	maxSeen time.Time
+}
+
+func (l *LateItems[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	for m := range input {
+		TrackItemInput[E](ctx, l, m)
+
+		if !l.isLate(ctx, m) {
+			TrackOutput[E](ctx, l, m)
+			output <- m
+			continue
+		}
+
+		TrackLabel[E](ctx, l, "event_time", "late")
+
+		switch l.Policy {
+		case LateAccept:
+			TrackOutput[E](ctx, l, m)
+			output <- m
+		case LateRedirect:
+			if l.OnLate != nil {
+				l.OnLate(ctx, m)
+			}
+		}
+	}
+
+	close(output)
+}
+
+func (l *LateItems[E]) Name() string {
+	return fmt.Sprintf("LateItems/%s", l.ChainName)
+}
+
+func (l *LateItems[E]) isLate(ctx context.Context, item E) bool {
+	t := EventTime(ctx, item)
+	if t.IsZero() {
+		return false
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if t.After(l.maxSeen) {
+		l.maxSeen = t
+		EmitWatermark[E](ctx, l, t.Add(-l.AllowedLateness))
+
+		return false
+	}
+
+	return t.Before(l.maxSeen.Add(-l.AllowedLateness))
+}
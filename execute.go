@@ -0,0 +1,122 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/pprof"
+)
+
+var ErrPermanent = fmt.Errorf("permanent item failure")
+var ErrDropped = fmt.Errorf("item dropped")
+
+const (
+	ProcessorLabel   = "pipeline_processor"
+	ProcessorIDLabel = "pipeline_processor_id"
+)
+
+/*
+	runProcessor is used by composites to execute their child processors.
+
+	The goroutine running the child, and every goroutine it spawns, is labelled
+	with the processor name and identity so CPU profiles can be attributed to
+	pipeline stages.
+
+	Batch processors are fed with batches instead of having their Execute run,
+	leaf processors are audited when auditing is enabled, and every processor
+	has its contract checked in strict mode.
+
+	Once the child has finished, it is checked for leaked goroutines when leak
+	detection is enabled.
+*/
+func runProcessor[E Traceable](ctx context.Context, p Processor[E], input chan E, output chan E) {
+	if statDB, ok := ctx.Value(PipelineStatDB).(*StatDB[E]); ok {
+		statDB.register(p)
+	}
+
+	setErrorReporter(ctx, p)
+
+	labels := pprof.Labels(ProcessorLabel, p.Name(), ProcessorIDLabel, processorID(p))
+
+	execute := p.Execute
+	if bp, ok := p.(BatchProcessor[E]); ok {
+		execute = func(ctx context.Context, input chan E, output chan E) {
+			executeBatched[E](ctx, []BatchProcessor[E]{bp}, input, output)
+		}
+	}
+
+	if hasStrictMode(ctx) {
+		execute = strictExecute(p, execute)
+	}
+
+	pprof.Do(ctx, labels, func(ctx context.Context) {
+		if _, composite := p.(Composite[E]); hasAudit(ctx) && !composite {
+			executeAudited(ctx, p, input, output, execute)
+			return
+		}
+
+		execute(ctx, input, output)
+	})
+
+	endProcessorSpans(ctx, p)
+	checkLeaks[E](ctx, p)
+}
+
+func processorID[E Traceable](p Processor[E]) string {
+	return fmt.Sprintf("%p", p)
+}
+
+/*
+	An ItemProcessor handles items one at a time: ProcessItem returns the item
+	to send on, or the error the item failed with. Wrappers such as Retry call
+	it directly instead of running Execute, and may call it again with the
+	same item, so it must not track stats nor leave the item modified when it
+	fails.
+
+	Errors wrapping ErrPermanent, or ErrFatal, fail the item for good: there
+	is no point in trying it again. Errors wrapping ErrDropped drop the item,
+	which is neither sent on nor failed, as filters do.
+*/
+type ItemProcessor[E Traceable] interface {
+	Processor[E]
+	ProcessItem(ctx context.Context, item E) (E, error)
+}
+
+/*
+	executeItems implements the Execute of leaf processors transforming items
+	one at a time. Items for which fn fails are counted as failures, reported
+	and sent to the dead letter handlers instead of the output, but for those
+	it drops with ErrDropped, only counted as input, and acked.
+*/
+func executeItems[E Traceable](ctx context.Context, p Processor[E], input chan E, output chan E, fn func(item E) (E, error)) {
+	for m := range input {
+		TrackItemInput[E](ctx, p, m)
+
+		result, err := fn(m)
+		if errors.Is(err, ErrDropped) {
+			Ack(m)
+			continue
+		}
+
+		if err != nil {
+			Log[E](ctx, p, "failed: %s", err)
+			TrackFailure[E](ctx, p)
+			ReportError(ctx, p, err)
+			SendToDLQ(ctx, p, m, err)
+			continue
+		}
+
+		TrackOutput[E](ctx, p, result)
+		output <- result
+	}
+
+	close(output)
+}
+
+/*
+	ExecuteItems implements the Execute of leaf processors from other
+	packages, transforming items one at a time as those of this package do.
+*/
+func ExecuteItems[E Traceable](ctx context.Context, p Processor[E], input chan E, output chan E, fn func(item E) (E, error)) {
+	executeItems[E](ctx, p, input, output, fn)
+}
@@ -0,0 +1,235 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type ExportFormat string
+
+const (
+	ExportJSON    ExportFormat = "json"
+	ExportYAML    ExportFormat = "yaml"
+	ExportMermaid ExportFormat = "mermaid"
+	ExportDOT     ExportFormat = "dot"
+	ExportHTML    ExportFormat = "html"
+	ExportBundle  ExportFormat = "bundle"
+)
+
+var ErrUnknownFormat = fmt.Errorf("unknown export format")
+
+/*
+	A Bundle gathers everything known about a pipeline in a single JSON
+	document: its serialized topology, the stats of its processors when a
+	StatDB is available, and a report with one entry per processor.
+*/
+type Bundle struct {
+	Topology json.RawMessage `json:"topology"`
+	Stats    json.RawMessage `json:"stats,omitempty"`
+	Report   BundleReport    `json:"report"`
+}
+
+type BundleReport struct {
+	Generated  time.Time     `json:"generated"`
+	RunID      string        `json:"run_id,omitempty"`
+	Generation int           `json:"generation,omitempty"`
+	Graph      string        `json:"graph"`
+	Processors []BundleEntry `json:"processors"`
+}
+
+type BundleEntry struct {
+	Path string `json:"path"`
+	Descriptor
+
+	Input       int64 `json:"input"`
+	Output      int64 `json:"output"`
+	Passthrough int64 `json:"passthrough"`
+	Failed      int64 `json:"failed"`
+}
+
+// Export writes root to w in the given format
+func Export[E Traceable](root Processor[E], format ExportFormat, w io.Writer) error {
+	return ExportContext(context.Background(), root, format, w)
+}
+
+/*
+	ExportContext is Export with the processors described as in ctx. Bundles
+	include the stats of the StatDB found in ctx.
+*/
+func ExportContext[E Traceable](ctx context.Context, root Processor[E], format ExportFormat, w io.Writer) error {
+	var data []byte
+	var err error
+
+	switch format {
+	case ExportJSON:
+		data, err = json.Marshal(root)
+		data = append(data, '\n')
+
+	case ExportYAML:
+		data, err = exportYAML(root)
+
+	case ExportMermaid:
+		return NewProcessorGraphContext(ctx, root).Write(w)
+
+	case ExportDOT:
+		return NewProcessorGraphContext(ctx, root).WriteDOT(w)
+
+	case ExportHTML:
+		return NewProcessorGraphContext(ctx, root).WriteHTML(w)
+
+	case ExportBundle:
+		data, err = exportBundle(ctx, root)
+
+	default:
+		return fmt.Errorf("%s: %w", format, ErrUnknownFormat)
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not export pipeline as %s: %w", format, err)
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+/*
+	ExportFile writes root to path, in the format given by its extension:
+	.json, .yaml or .yml, .mmd or .mermaid, .dot or .gv, .html, and
+	.bundle.json for bundles.
+*/
+func ExportFile[E Traceable](ctx context.Context, root Processor[E], path string) error {
+	format, err := FormatForPath(path)
+	if err != nil {
+		return err
+	}
+
+	fd, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	err = ExportContext(ctx, root, format, fd)
+	if closeErr := fd.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+func FormatForPath(path string) (ExportFormat, error) {
+	if strings.HasSuffix(path, ".bundle.json") {
+		return ExportBundle, nil
+	}
+
+	switch filepath.Ext(path) {
+	case ".json":
+		return ExportJSON, nil
+	case ".yaml", ".yml":
+		return ExportYAML, nil
+	case ".mmd", ".mermaid":
+		return ExportMermaid, nil
+	case ".dot", ".gv":
+		return ExportDOT, nil
+	case ".html":
+		return ExportHTML, nil
+	default:
+		return "", fmt.Errorf("%s: %w", path, ErrUnknownFormat)
+	}
+}
+
+// exportYAML converts the JSON serialization, keeping the order of its keys
+func exportYAML[E Traceable](root Processor[E]) ([]byte, error) {
+	data, err := json.Marshal(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	blockStyle(&doc)
+
+	buf := bytes.NewBuffer(nil)
+
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// blockStyle drops the flow and quoting styles inherited from JSON
+func blockStyle(node *yaml.Node) {
+	node.Style = 0
+
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+func exportBundle[E Traceable](ctx context.Context, root Processor[E]) ([]byte, error) {
+	var bundle Bundle
+	var err error
+
+	bundle.Topology, err = json.Marshal(root)
+	if err != nil {
+		return nil, err
+	}
+
+	statDB, _ := ctx.Value(PipelineStatDB).(*StatDB[E])
+
+	if statDB != nil {
+		bundle.Stats, err = json.Marshal(statDB)
+		if err != nil {
+			return nil, err
+		}
+
+		bundle.Report.Generation = statDB.Generation()
+	}
+
+	bundle.Report.Generated = time.Now()
+	bundle.Report.RunID = RunID(ctx)
+	bundle.Report.Graph = NewProcessorGraphContext(ctx, root).String()
+
+	tags := treeTags(root)
+
+	Walk(root, func(path string, p Processor[E]) {
+		entry := BundleEntry{
+			Path:       path,
+			Descriptor: Describe(ctx, p),
+		}
+
+		entry.Tags = mergeTags(tags[p], entry.Tags)
+
+		if statDB != nil {
+			if stats, ok := statDB.statsOf(p); ok {
+				entry.Input = stats.Input.Load()
+				entry.Output = stats.Output.Load()
+				entry.Passthrough = stats.Passthrough.Load()
+				entry.Failed = stats.Failed.Load()
+			}
+		}
+
+		bundle.Report.Processors = append(bundle.Report.Processors, entry)
+	})
+
+	return json.MarshalIndent(bundle, "", "  ")
+}
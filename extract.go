@@ -1,23 +1,22 @@
 package pipeline
 
 import (
-	"encoding/json"
-	"fmt"
 	"os"
 	"strings"
 
 	"go.uber.org/zap"
 )
 
+// Deprecated: use Export with ExportJSON, which returns errors instead of
+// exiting the process
 func ExtractPipelineAsJSON(pline Processor[Traceable], logger *zap.Logger) {
-	d, err := json.Marshal(pline)
-	if err != nil {
+	if err := Export(pline, ExportJSON, os.Stdout); err != nil {
 		logger.Fatal("could not dump pipeline config", zap.Error(err))
 	}
-
-	fmt.Printf("%s\n", d)
 }
 
+// Deprecated: use ExportFile, which returns errors instead of exiting the
+// process
 func ExtractPipelineAsGraph(graph string, g *ProcessorGraph[Traceable], logger *zap.Logger) {
 	fd, err := os.Create(graph)
 	if err != nil {
@@ -0,0 +1,304 @@
+package pipeline
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrUnsupportedField = fmt.Errorf("unsupported field type")
+
+/*
+	FieldSetter is implemented by map-like items set from named fields, the
+	counterpart of FieldMapper.
+*/
+type FieldSetter interface {
+	SetFields(fields map[string]interface{})
+}
+
+type structField struct {
+	Name  string
+	Index []int
+	Type  reflect.Type
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// structFields returns the exported fields of a struct type, named after the
+// given tag when present. Fields tagged "-" are skipped.
+func structFields(t reflect.Type, tag string) []structField {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	fields := make([]structField, 0, t.NumField())
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name := f.Name
+		if value, ok := f.Tag.Lookup(tag); ok {
+			value, _, _ = strings.Cut(value, ",")
+			if value == "-" {
+				continue
+			}
+
+			if value != "" {
+				name = value
+			}
+		}
+
+		fields = append(fields, structField{
+			Name:  name,
+			Index: f.Index,
+			Type:  f.Type,
+		})
+	}
+
+	return fields
+}
+
+// StructFields returns the exported fields of a struct type as structFields
+// does, with their Name after tag, for the formats of other packages
+func StructFields(t reflect.Type, tag string) []reflect.StructField {
+	fields := structFields(t, tag)
+	exported := make([]reflect.StructField, len(fields))
+
+	for i, f := range fields {
+		exported[i] = reflect.StructField{Name: f.Name, Index: f.Index, Type: f.Type}
+	}
+
+	return exported
+}
+
+// StructValue returns the struct an item points to, if it does
+func StructValue(item interface{}) (reflect.Value, bool) {
+	return structValue(item)
+}
+
+// structValue returns the struct an item points to, if it does
+func structValue(item interface{}) (reflect.Value, bool) {
+	v := reflect.ValueOf(item)
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return reflect.Value{}, false
+		}
+
+		v = v.Elem()
+	}
+
+	return v, v.Kind() == reflect.Struct
+}
+
+// itemFields returns the named fields of an item, from FieldMap or from its
+// struct fields
+func itemFields(item interface{}, tag string) (map[string]interface{}, bool) {
+	if mapper, ok := item.(FieldMapper); ok {
+		return mapper.FieldMap(), true
+	}
+
+	v, ok := structValue(item)
+	if !ok {
+		return nil, false
+	}
+
+	fields := make(map[string]interface{})
+	for _, f := range structFields(v.Type(), tag) {
+		fields[f.Name] = v.FieldByIndex(f.Index).Interface()
+	}
+
+	return fields, true
+}
+
+// ItemFields returns the named fields of an item, for the processors of
+// other packages
+func ItemFields(item interface{}, tag string) (map[string]interface{}, bool) {
+	return itemFields(item, tag)
+}
+
+/*
+	setItemFields sets the fields of an item from their text representation.
+
+	Struct items get each value converted to the type of its field. FieldSetter
+	items get the values converted as told by types, a map from field names to
+	"int", "float", "bool", "time" or "duration", and left as strings otherwise.
+*/
+func setItemFields(item interface{}, tag string, values map[string]string, types map[string]string) error {
+	if setter, ok := item.(FieldSetter); ok {
+		fields := make(map[string]interface{}, len(values))
+
+		for name, value := range values {
+			converted, err := coerceNamed(value, types[name])
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+
+			fields[name] = converted
+		}
+
+		setter.SetFields(fields)
+		return nil
+	}
+
+	v := reflect.ValueOf(item)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("%T: %w", item, ErrUnsupportedField)
+	}
+
+	v = v.Elem()
+
+	for _, f := range structFields(v.Type(), tag) {
+		value, ok := values[f.Name]
+		if !ok {
+			continue
+		}
+
+		converted, err := coerce(value, f.Type)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+
+		v.FieldByIndex(f.Index).Set(converted)
+	}
+
+	return nil
+}
+
+func coerceNamed(s string, typename string) (interface{}, error) {
+	switch typename {
+	case "", "string":
+		return s, nil
+	case "int":
+		return strconv.ParseInt(s, 10, 64)
+	case "float":
+		return strconv.ParseFloat(s, 64)
+	case "bool":
+		return strconv.ParseBool(s)
+	case "time":
+		return time.Parse(time.RFC3339Nano, s)
+	case "duration":
+		return time.ParseDuration(s)
+	}
+
+	return nil, fmt.Errorf("%s: %w", typename, ErrUnsupportedField)
+}
+
+// coerce converts the text representation of a value to type t
+func coerce(s string, t reflect.Type) (reflect.Value, error) {
+	v := reflect.New(t).Elem()
+
+	if s == "" && t.Kind() != reflect.String {
+		return v, nil
+	}
+
+	switch {
+	case t == timeType:
+		parsed, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return v, err
+		}
+
+		v.Set(reflect.ValueOf(parsed))
+		return v, nil
+
+	case t == durationType:
+		parsed, err := time.ParseDuration(s)
+		if err != nil {
+			return v, err
+		}
+
+		v.SetInt(int64(parsed))
+		return v, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(s)
+		if err != nil {
+			return v, err
+		}
+		v.SetBool(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(s, 10, t.Bits())
+		if err != nil {
+			return v, err
+		}
+		v.SetInt(parsed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsed, err := strconv.ParseUint(s, 10, t.Bits())
+		if err != nil {
+			return v, err
+		}
+		v.SetUint(parsed)
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(s, t.Bits())
+		if err != nil {
+			return v, err
+		}
+		v.SetFloat(parsed)
+	case reflect.Pointer:
+		elem, err := coerce(s, t.Elem())
+		if err != nil {
+			return v, err
+		}
+		ptr := reflect.New(t.Elem())
+		ptr.Elem().Set(elem)
+		v.Set(ptr)
+	default:
+		return v, fmt.Errorf("%s: %w", t, ErrUnsupportedField)
+	}
+
+	return v, nil
+}
+
+// SetItemFields sets the fields of an item as setItemFields does, for the
+// processors of other packages
+func SetItemFields(item interface{}, tag string, values map[string]string, types map[string]string) error {
+	return setItemFields(item, tag, values, types)
+}
+
+// FormatValue returns the text representation of a value, as fields are set
+// from
+func FormatValue(value interface{}) string {
+	return formatValue(value)
+}
+
+// formatValue returns the text representation of a value, as understood by
+// coerce
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return v.String()
+	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return ""
+		}
+
+		return formatValue(rv.Elem().Interface())
+	}
+
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,179 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const EventFileRotated = "file_rotated"
+
+/*
+	A FileFormat encodes items into files of a given format. NewEncoder is
+	called for every new file with the first item to be written to it, from
+	which the schema can be derived.
+*/
+type FileFormat interface {
+	Extension() string
+	NewEncoder(w io.Writer, sample interface{}) (FileEncoder, error)
+}
+
+/*
+	A FileEncoder writes items to a file. Encode should write every batch
+	through to the file, so its size can be checked for rotation. Close writes
+	any trailer, but does not close the underlying writer.
+*/
+type FileEncoder interface {
+	Encode(items []interface{}) error
+	Close() error
+}
+
+/*
+	The FileSink writes batches of items to files in Dir, encoded with Format.
+
+	Files are rotated when they reach MaxSize bytes or are older than MaxAge,
+	as checked before writing every batch, and when the input is closed.
+	Files being written have an .inprogress suffix, removed once complete, so
+	only complete files are picked up by downstream consumers. OnRotate is
+	called with the path of every completed file.
+
+	Batches are of BatchSize items, or those received within Linger, unless a
+	Controller is set to tune both from the observed writes.
+*/
+type FileSink[E Traceable] struct {
+	ChainName string
+
+	Dir    string
+	Prefix string
+	Format FileFormat
+
+	BatchSize  int
+	Linger     time.Duration
+	Controller BatchController
+
+	MaxSize int64
+	MaxAge  time.Duration
+
+	OnRotate func(path string)
+
+	file    *os.File
+	counter *countingWriter
+	encoder FileEncoder
+	path    string
+	opened  time.Time
+	seq     int
+}
+
+func (fs *FileSink[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	executeSink[E](ctx, fs, input, output, fs.BatchSize, fs.Linger, fs.Controller, fs.write)
+
+	if err := fs.rotate(ctx); err != nil {
+		Log[E](ctx, fs, "failed closing %s: %s", fs.path, err)
+		ReportError(ctx, fs, fmt.Errorf("closing %s: %w", fs.path, err))
+	}
+}
+
+func (fs *FileSink[E]) Name() string {
+	return fmt.Sprintf("FileSink/%s", fs.ChainName)
+}
+
+func (fs *FileSink[E]) write(ctx context.Context, items []E) error {
+	if fs.file != nil && fs.full() {
+		if err := fs.rotate(ctx); err != nil {
+			return err
+		}
+	}
+
+	if fs.file == nil {
+		if err := fs.open(items[0]); err != nil {
+			return err
+		}
+	}
+
+	batch := make([]interface{}, len(items))
+	for i, m := range items {
+		batch[i] = m
+	}
+
+	return fs.encoder.Encode(batch)
+}
+
+func (fs *FileSink[E]) full() bool {
+	if fs.MaxSize > 0 && fs.counter.n >= fs.MaxSize {
+		return true
+	}
+
+	return fs.MaxAge > 0 && time.Since(fs.opened) >= fs.MaxAge
+}
+
+func (fs *FileSink[E]) open(sample E) error {
+	fs.seq++
+	fs.opened = time.Now()
+
+	name := fmt.Sprintf("%s%s-%04d%s", fs.Prefix, fs.opened.UTC().Format("20060102T150405"), fs.seq, fs.Format.Extension())
+	fs.path = filepath.Join(fs.Dir, name)
+
+	file, err := os.Create(fs.path + ".inprogress")
+	if err != nil {
+		return err
+	}
+
+	fs.counter = &countingWriter{w: file}
+
+	encoder, err := fs.Format.NewEncoder(fs.counter, sample)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+
+	fs.file = file
+	fs.encoder = encoder
+
+	return nil
+}
+
+func (fs *FileSink[E]) rotate(ctx context.Context) error {
+	if fs.file == nil {
+		return nil
+	}
+
+	file := fs.file
+	fs.file = nil
+
+	err := fs.encoder.Close()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(file.Name(), fs.path); err != nil {
+		return err
+	}
+
+	Emit[E](ctx, fs, EventFileRotated, "%s completed, %d bytes", fs.path, fs.counter.n)
+
+	if fs.OnRotate != nil {
+		fs.OnRotate(fs.path)
+	}
+
+	return nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+
+	return n, err
+}
@@ -0,0 +1,149 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var PipelineFlagProvider PipelineContextKey = "pipeline_flag_provider"
+
+/*
+	A FlagProvider gives access to an external feature flag system
+*/
+type FlagProvider interface {
+	Enabled(ctx context.Context, flag string) bool
+}
+
+type FlagFunc func(ctx context.Context, flag string) bool
+
+func (f FlagFunc) Enabled(ctx context.Context, flag string) bool {
+	return f(ctx, flag)
+}
+
+func WithFlags(ctx context.Context, provider FlagProvider) context.Context {
+	return context.WithValue(ctx, PipelineFlagProvider, provider)
+}
+
+/*
+	The Flagged processor has:
+
+	- One input
+	- One processor
+	- One output
+
+	While Flag is enabled, items go through the processor. While it is disabled
+	they bypass it and are sent directly to the output, so a stage can be
+	turned off at runtime (an enrichment during an incident, for instance)
+	without changing the pipeline definition.
+
+	The flag is checked for every item, or at most once every Interval when it
+	is set. Without a FlagProvider in the context, Default is used.
+*/
+type Flagged[E Traceable] struct {
+	Flag      string
+	Processor Processor[E]
+
+	Interval time.Duration
+	Default  bool
+	Tags     map[string]string
+
+	lock      sync.Mutex
+	enabled   bool
+	checkedAt time.Time
+
+	executions executions
+}
+
+func (flagged *Flagged[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	flagged.executions.begin()
+	defer flagged.executions.end()
+
+	Log[E](ctx, flagged, "starting")
+	TrackStarted[E](ctx, flagged)
+	ctx = withErrorScope[E](ctx, flagged)
+
+	if flagged.Processor == nil {
+		close(output)
+		return
+	}
+
+	wg := sync.WaitGroup{}
+
+	procInput := make(chan E)
+	procOutput := make(chan E)
+
+	wg.Add(1)
+	go func() {
+		runProcessor[E](ctx, flagged.Processor, procInput, procOutput)
+		wg.Done()
+	}()
+
+	wg.Add(1)
+	go func() {
+		for m := range procOutput {
+			if ctx.Err() != nil {
+				abandon(ctx, m)
+				continue
+			}
+
+			TrackOutput[E](ctx, flagged, m)
+			send(ctx, output, m)
+		}
+		wg.Done()
+	}()
+
+	for {
+		msg, ok := receive(ctx, input)
+		if !ok {
+			break
+		}
+
+		TrackItemInput[E](ctx, flagged, msg)
+
+		if flagged.isEnabled(ctx) {
+			send(ctx, procInput, msg)
+			continue
+		}
+
+		TrackPassthrough[E](ctx, flagged, msg)
+		send(ctx, output, msg)
+	}
+
+	inputClosed[E](ctx, flagged)
+	close(procInput)
+	wg.Wait()
+
+	TrackFinished[E](ctx, flagged)
+	close(output)
+}
+
+func (flagged *Flagged[E]) Name() string {
+	return fmt.Sprintf("Flagged/%s", flagged.Flag)
+}
+
+func (flagged *Flagged[E]) Children() []Processor[E] {
+	return nonNil([]Processor[E]{flagged.Processor})
+}
+
+func (flagged *Flagged[E]) isEnabled(ctx context.Context) bool {
+	provider, ok := ctx.Value(PipelineFlagProvider).(FlagProvider)
+	if !ok {
+		return flagged.Default
+	}
+
+	if flagged.Interval <= 0 {
+		return provider.Enabled(ctx, flagged.Flag)
+	}
+
+	flagged.lock.Lock()
+	defer flagged.lock.Unlock()
+
+	if time.Since(flagged.checkedAt) >= flagged.Interval {
+		flagged.enabled = provider.Enabled(ctx, flagged.Flag)
+		flagged.checkedAt = time.Now()
+	}
+
+	return flagged.enabled
+}
@@ -0,0 +1,100 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+)
+
+/*
+	The FilterProcessor sends on the items for which Keep returns true, and
+	drops the others. Dropped items are counted as input only, and acked.
+*/
+type FilterProcessor[E Traceable] struct {
+	ChainName string
+	Keep      func(item E) bool `json:"-"`
+}
+
+func NewFilter[E Traceable](name string, keep func(item E) bool) *FilterProcessor[E] {
+	return &FilterProcessor[E]{
+		ChainName: name,
+		Keep:      keep,
+	}
+}
+
+func (f *FilterProcessor[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	executeItems[E](ctx, f, input, output, func(item E) (E, error) {
+		return f.ProcessItem(ctx, item)
+	})
+}
+
+func (f *FilterProcessor[E]) Name() string {
+	return fmt.Sprintf("Filter/%s", f.ChainName)
+}
+
+// ProcessItem fails with ErrDropped for the items Keep rejects
+func (f *FilterProcessor[E]) ProcessItem(ctx context.Context, item E) (E, error) {
+	if !f.Keep(item) {
+		return item, fmt.Errorf("%s: %w", f.Name(), ErrDropped)
+	}
+
+	return item, nil
+}
+
+/*
+	The MapProcessor sends on the result of Map for every item
+*/
+type MapProcessor[E Traceable] struct {
+	ChainName string
+	Map       func(item E) E `json:"-"`
+}
+
+func NewMap[E Traceable](name string, fn func(item E) E) *MapProcessor[E] {
+	return &MapProcessor[E]{
+		ChainName: name,
+		Map:       fn,
+	}
+}
+
+func (m *MapProcessor[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	executeItems[E](ctx, m, input, output, func(item E) (E, error) {
+		return m.Map(item), nil
+	})
+}
+
+func (m *MapProcessor[E]) Name() string {
+	return fmt.Sprintf("Map/%s", m.ChainName)
+}
+
+func (m *MapProcessor[E]) ProcessItem(ctx context.Context, item E) (E, error) {
+	return m.Map(item), nil
+}
+
+/*
+	ItemFunc adapts a function handling single items to an ItemProcessor
+*/
+type ItemFunc[E Traceable] struct {
+	ProcName string
+
+	Fn func(ctx context.Context, item E) (E, error) `json:"-"`
+}
+
+func NewItemFunc[E Traceable](name string, fn func(ctx context.Context, item E) (E, error)) *ItemFunc[E] {
+	return &ItemFunc[E]{
+		ProcName: name,
+		Fn:       fn,
+	}
+}
+
+func (f *ItemFunc[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	executeItems[E](ctx, f, input, output, func(item E) (E, error) {
+		return f.Fn(ctx, item)
+	})
+}
+
+func (f *ItemFunc[E]) Name() string {
+	return f.ProcName
+}
+
+func (f *ItemFunc[E]) ProcessItem(ctx context.Context, item E) (E, error) {
+	return f.Fn(ctx, item)
+}
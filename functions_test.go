@@ -0,0 +1,54 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestFilterDropsRejectedItems(t *testing.T) {
+	filter := NewFilter("not-b", func(item *testItem) bool {
+		return item.Value != "b"
+	})
+
+	got := itemValues(runItems(t, context.Background(), filter, newItems("a", "b", "c")))
+	if !slices.Equal(got, []string{"a", "c"}) {
+		t.Fatalf("got %v, want a c", got)
+	}
+
+	if _, err := filter.ProcessItem(context.Background(), &testItem{Value: "b"}); !errors.Is(err, ErrDropped) {
+		t.Fatalf("rejected item: got %v, want ErrDropped", err)
+	}
+}
+
+// ackedItem is a testItem acked through its Acker
+type ackedItem struct {
+	testItem
+	*Acker
+}
+
+func TestFilterAcksDroppedItems(t *testing.T) {
+	filter := NewFilter("none", func(item *ackedItem) bool {
+		return false
+	})
+
+	acked := make(chan struct{}, 1)
+	item := &ackedItem{Acker: NewAcker(func() { acked <- struct{}{} }, nil)}
+
+	input, output := make(chan *ackedItem, 1), make(chan *ackedItem)
+	input <- item
+	close(input)
+
+	go filter.Execute(context.Background(), input, output)
+
+	if _, ok := <-output; ok {
+		t.Fatal("dropped item sent on")
+	}
+
+	select {
+	case <-acked:
+	default:
+		t.Fatal("dropped item not acked")
+	}
+}
@@ -0,0 +1,85 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+/*
+	FuzzSerializedPipeline is a fuzzing entry point for definitions, in the
+	go-fuzz convention: it returns 1 for data making a valid definition, 0
+	for data rejected with an error, and panics when a definition breaks the
+	serialization, so native fuzz tests can use it as well:
+
+		func FuzzDefinitions(f *testing.F) {
+			f.Fuzz(func(t *testing.T, data []byte) {
+				pipeline.FuzzSerializedPipeline[*Item](data, factory)
+			})
+		}
+
+	Leaves are built with factory, or as passthrough maps when it is nil.
+	A valid definition must marshal back into a definition building the
+	same number of processors.
+*/
+func FuzzSerializedPipeline[E Traceable](data []byte, factory ProcessorFactory[E]) int {
+	if factory == nil {
+		factory = func(name string, cfg map[string]interface{}) (Processor[E], error) {
+			return NewMap[E](name, func(item E) E { return item }), nil
+		}
+	}
+
+	built, ok := fuzzBuild(data, factory)
+	if !ok {
+		return 0
+	}
+
+	if _, composite := built.(Composite[E]); !composite {
+		return 1
+	}
+
+	enc, err := marshalProcessor(built)
+	if err != nil {
+		// processors of the factory may not be serializable
+		return 0
+	}
+
+	rebuilt, ok := fuzzBuild(enc, factory)
+	if !ok {
+		panic(fmt.Sprintf("definition marshalled into an invalid one: %s", enc))
+	}
+
+	if countProcessors(built) != countProcessors(rebuilt) {
+		panic(fmt.Sprintf("definition marshalled into a different one: %s", enc))
+	}
+
+	return 1
+}
+
+func fuzzBuild[E Traceable](data []byte, factory ProcessorFactory[E]) (Processor[E], bool) {
+	var sp SerializedPipeline[E]
+	if err := json.Unmarshal(data, &sp); err != nil {
+		return nil, false
+	}
+
+	sp.SetProcessorFactory(factory)
+
+	built, err := sp.Pipeline()
+	if err != nil {
+		if _, ok := err.(*DefinitionError); !ok {
+			panic(fmt.Sprintf("unstructured definition error: %s", err))
+		}
+
+		return nil, false
+	}
+
+	return built, true
+}
+
+func countProcessors[E Traceable](root Processor[E]) int {
+	n := 0
+	Walk(root, func(path string, p Processor[E]) {
+		n++
+	})
+
+	return n
+}
@@ -0,0 +1,272 @@
+/*
+	Package geoip locates the addresses of items in MaxMind databases.
+	Register makes its processor available to definitions, as the "geoip"
+	type.
+*/
+package geoip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ca0s/pipeline"
+	"github.com/oschwald/geoip2-golang"
+)
+
+// MMDBLoader loads a MaxMind database, as GeoIP processors look addresses up
+type MMDBLoader struct {
+	Path string
+}
+
+func (l *MMDBLoader) Load(ctx context.Context) (*geoip2.Reader, error) {
+	raw, err := os.ReadFile(l.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	// read from memory, so readers replaced by a refresh need no closing
+	// while items are still looked up in them
+	return geoip2.FromBytes(raw)
+}
+
+/*
+	The GeoIP processor sets the location of the address in the field Field
+	of every item, named by Tag as for pipeline.Fanout, as found in a
+	MaxMind, or compatible, database. City and country databases give the
+	fields country_code, country, continent, subdivision_code, subdivision,
+	city, postal_code, time_zone, latitude and longitude, in Language, "en"
+	by default; ASN databases give asn and as_org. Fields are named with
+	Prefix before them, and those the database has no value for are left
+	as they are. pipeline.FieldSetter items get latitude and longitude as
+	floats and asn as an int.
+
+	Database keeps the database loaded, and refreshed, as pipeline.RefData
+	does, and is run by the processor: it is not to be placed in the
+	pipeline too.
+	Items whose address is invalid, or not in the database, are sent on
+	unchanged, and counted under the "geoip" label dimension of the
+	processor stats, as "invalid" and "not_found", found ones as "found".
+*/
+type GeoIP[E pipeline.Traceable] struct {
+	ChainName string
+	Field     string
+	Tag       string
+	Prefix    string
+	Language  string
+
+	Database *pipeline.RefData[E, *geoip2.Reader] `json:"-"`
+}
+
+var geoIPTypes = map[string]string{
+	"latitude":  "float",
+	"longitude": "float",
+	"asn":       "int",
+}
+
+func NewGeoIP[E pipeline.Traceable](name string, field string, path string) *GeoIP[E] {
+	return &GeoIP[E]{
+		ChainName: name,
+		Field:     field,
+		Database: &pipeline.RefData[E, *geoip2.Reader]{
+			ChainName: name,
+			Loader:    &MMDBLoader{Path: path},
+		},
+	}
+}
+
+// Init loads the database
+func (g *GeoIP[E]) Init(ctx context.Context) error {
+	return g.Database.Init(ctx)
+}
+
+func (g *GeoIP[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	loaded := make(chan E)
+	go g.Database.Execute(ctx, input, loaded)
+
+	pipeline.ExecuteItems[E](ctx, g, loaded, output, func(item E) (E, error) {
+		result, status, err := g.lookup(item)
+		if status != "" {
+			pipeline.TrackLabel[E](ctx, g, "geoip", status)
+		}
+
+		return result, err
+	})
+}
+
+func (g *GeoIP[E]) Name() string {
+	return fmt.Sprintf("GeoIP/%s", g.ChainName)
+}
+
+func (g *GeoIP[E]) ProcessItem(ctx context.Context, item E) (E, error) {
+	result, _, err := g.lookup(item)
+	return result, err
+}
+
+// lookup sets the location of item, and returns the label of its address
+func (g *GeoIP[E]) lookup(item E) (E, string, error) {
+	db, ok := g.Database.Get()
+	if !ok {
+		return item, "", fmt.Errorf("%s: %w", g.Name(), pipeline.ErrNotLoaded)
+	}
+
+	fields, _ := pipeline.ItemFields(item, g.Tag)
+
+	address := fields[g.Field]
+	ip := net.ParseIP(strings.TrimSpace(pipeline.FormatValue(address)))
+	if address == nil || ip == nil {
+		return item, "invalid", nil
+	}
+
+	values, err := g.locate(db, ip)
+	if err != nil {
+		return item, "", fmt.Errorf("%s: %w", g.Name(), err)
+	}
+
+	if len(values) == 0 {
+		return item, "not_found", nil
+	}
+
+	named := make(map[string]string, len(values))
+	types := make(map[string]string, len(geoIPTypes))
+
+	for name, value := range values {
+		named[g.Prefix+name] = value
+
+		if typename, ok := geoIPTypes[name]; ok {
+			types[g.Prefix+name] = typename
+		}
+	}
+
+	if err := pipeline.SetItemFields(item, g.Tag, named, types); err != nil {
+		return item, "", err
+	}
+
+	return item, "found", nil
+}
+
+// locate returns the values the database has for ip, by unprefixed field
+func (g *GeoIP[E]) locate(db *geoip2.Reader, ip net.IP) (map[string]string, error) {
+	values := make(map[string]string)
+
+	set := func(name, value string) {
+		if value != "" {
+			values[name] = value
+		}
+	}
+
+	language := g.Language
+	if language == "" {
+		language = "en"
+	}
+
+	city, err := db.City(ip)
+
+	var invalid geoip2.InvalidMethodError
+	if errors.As(err, &invalid) {
+		asn, err := db.ASN(ip)
+		if err != nil {
+			return nil, err
+		}
+
+		if asn.AutonomousSystemNumber > 0 {
+			set("asn", strconv.FormatUint(uint64(asn.AutonomousSystemNumber), 10))
+		}
+
+		set("as_org", asn.AutonomousSystemOrganization)
+
+		return values, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	set("country_code", city.Country.IsoCode)
+	set("country", city.Country.Names[language])
+	set("continent", city.Continent.Code)
+	set("city", city.City.Names[language])
+	set("postal_code", city.Postal.Code)
+	set("time_zone", city.Location.TimeZone)
+
+	if len(city.Subdivisions) > 0 {
+		set("subdivision_code", city.Subdivisions[0].IsoCode)
+		set("subdivision", city.Subdivisions[0].Names[language])
+	}
+
+	if city.Location.Latitude != 0 || city.Location.Longitude != 0 {
+		set("latitude", strconv.FormatFloat(city.Location.Latitude, 'f', -1, 64))
+		set("longitude", strconv.FormatFloat(city.Location.Longitude, 'f', -1, 64))
+	}
+
+	return values, nil
+}
+
+/*
+	Register registers the GeoIP processor as the "geoip" processor type of
+	definitions, configured with path, field, tag, prefix, language and
+	refresh.
+*/
+func Register[E pipeline.Traceable]() {
+	pipeline.RegisterProcessorType[E, *GeoIP[E]]("geoip", newGeoIP[E], marshalGeoIP[E])
+}
+
+func newGeoIP[E pipeline.Traceable](name string, cfg map[string]interface{}) (*GeoIP[E], error) {
+	path, _ := cfg["path"].(string)
+	if path == "" {
+		return nil, fmt.Errorf("geoip needs the path of its database: %w", pipeline.ErrInvalidConfig)
+	}
+
+	geoip := NewGeoIP[E](name, "", path)
+
+	geoip.Field, _ = cfg["field"].(string)
+	geoip.Tag, _ = cfg["tag"].(string)
+	geoip.Prefix, _ = cfg["prefix"].(string)
+	geoip.Language, _ = cfg["language"].(string)
+
+	if refresh, ok := cfg["refresh"].(string); ok {
+		d, err := time.ParseDuration(refresh)
+		if err != nil {
+			return nil, fmt.Errorf("invalid refresh: %w: %w", err, pipeline.ErrInvalidConfig)
+		}
+
+		geoip.Database.Refresh = d
+	}
+
+	return geoip, nil
+}
+
+func marshalGeoIP[E pipeline.Traceable](geoip *GeoIP[E]) (string, map[string]interface{}, error) {
+	cfg := map[string]interface{}{
+		"field": geoip.Field,
+	}
+
+	if geoip.Database != nil {
+		if loader, ok := geoip.Database.Loader.(*MMDBLoader); ok {
+			cfg["path"] = loader.Path
+		}
+
+		if geoip.Database.Refresh > 0 {
+			cfg["refresh"] = geoip.Database.Refresh.String()
+		}
+	}
+
+	if geoip.Tag != "" {
+		cfg["tag"] = geoip.Tag
+	}
+
+	if geoip.Prefix != "" {
+		cfg["prefix"] = geoip.Prefix
+	}
+
+	if geoip.Language != "" {
+		cfg["language"] = geoip.Language
+	}
+
+	return geoip.ChainName, cfg, nil
+}
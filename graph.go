@@ -1,29 +1,185 @@
 package pipeline
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"math/rand"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
 )
 
 type ProcessorGraph[E Traceable] struct {
+	ctx       context.Context
 	root      Processor[E]
-	lines     []string
+	nodes     []graphNode
+	edges     []graphEdge
 	processed bool
+
+	// changes are the changes of the nodes highlighted, by Walk path
+	changes map[string]string
+	paths   map[interface{}]string
+}
+
+type graphShape int
+
+const (
+	graphBox graphShape = iota
+	graphEntry
+	graphExit
+	graphDecision
+	graphCircle
+	graphSource
+	graphSink
+)
+
+type graphNode struct {
+	id     string
+	label  string
+	shape  graphShape
+	config string
+	change string
+}
+
+type graphEdge struct {
+	from   string
+	to     string
+	label  string
+	dotted bool
 }
 
 func NewProcessorGraph[E Traceable](p Processor[E]) *ProcessorGraph[E] {
+	return NewProcessorGraphContext(context.Background(), p)
+}
+
+// NewProcessorGraphContext builds a graph of p, described as in ctx
+func NewProcessorGraphContext[E Traceable](ctx context.Context, p Processor[E]) *ProcessorGraph[E] {
 	return &ProcessorGraph[E]{
+		ctx:       ctx,
 		root:      p,
-		lines:     []string{"graph TD"},
 		processed: false,
 	}
 }
 
+// String renders the graph as a mermaid flowchart
 func (g *ProcessorGraph[E]) String() string {
+	lines := append([]string{"graph TD"}, g.mermaidLines()...)
+	lines = append(lines, mermaidChanges(g.nodes)...)
+
+	return strings.Join(lines, "\n")
+}
+
+// mermaidLines are the nodes and edges of the mermaid flowchart
+func (g *ProcessorGraph[E]) mermaidLines() []string {
+	g.process()
+
+	var lines []string
+
+	for _, node := range g.nodes {
+		switch node.shape {
+		case graphEntry:
+			lines = append(lines, fmt.Sprintf("%s[/%s\\]", node.id, node.label))
+		case graphExit:
+			lines = append(lines, fmt.Sprintf("%s[\\%s/end/]", node.id, node.label))
+		case graphDecision:
+			lines = append(lines, fmt.Sprintf("%s{%s}", node.id, node.label))
+		case graphCircle:
+			lines = append(lines, fmt.Sprintf("%s((%s))", node.id, node.label))
+		case graphSource:
+			lines = append(lines, fmt.Sprintf("%s([%s])", node.id, node.label))
+		case graphSink:
+			lines = append(lines, fmt.Sprintf("%s[(%s)]", node.id, node.label))
+		default:
+			lines = append(lines, fmt.Sprintf("%s[%s]", node.id, node.label))
+		}
+
+		if node.config != "" {
+			lines = append(lines, fmt.Sprintf("%%%% %s config %s", node.id, node.config))
+		}
+	}
+
+	for _, edge := range g.edges {
+		switch {
+		case edge.dotted && edge.label != "":
+			lines = append(lines, fmt.Sprintf("%s -. %s .-> %s", edge.from, edge.label, edge.to))
+		case edge.dotted:
+			lines = append(lines, fmt.Sprintf("%s -.-> %s", edge.from, edge.to))
+		case edge.label != "":
+			lines = append(lines, fmt.Sprintf("%s -- %s --> %s", edge.from, edge.label, edge.to))
+		default:
+			lines = append(lines, fmt.Sprintf("%s --> %s", edge.from, edge.to))
+		}
+	}
+
+	return lines
+}
+
+// DOT renders the graph in the graphviz dot language
+func (g *ProcessorGraph[E]) DOT() string {
+	lines := append([]string{"digraph pipeline {"}, g.dotLines("\t")...)
+	lines = append(lines, "}")
+
+	return strings.Join(lines, "\n")
+}
+
+// dotLines are the nodes and edges of the dot graph, indented by indent
+func (g *ProcessorGraph[E]) dotLines(indent string) []string {
 	g.process()
-	return strings.Join(g.lines, "\n")
+
+	var lines []string
+
+	for _, node := range g.nodes {
+		shape := "box"
+
+		switch node.shape {
+		case graphEntry:
+			shape = "trapezium"
+		case graphExit:
+			shape = "invtrapezium"
+		case graphDecision:
+			shape = "diamond"
+		case graphCircle:
+			shape = "circle"
+		case graphSource:
+			shape = "house"
+		case graphSink:
+			shape = "cylinder"
+		}
+
+		attrs := fmt.Sprintf("label=%s, shape=%s", strconv.Quote(node.label), shape)
+		if node.config != "" {
+			attrs += fmt.Sprintf(", tooltip=%s", strconv.Quote("config "+node.config))
+		}
+
+		if color, ok := changeColors[node.change]; ok {
+			attrs += fmt.Sprintf(", style=filled, fillcolor=%s", strconv.Quote(color))
+		}
+
+		lines = append(lines, fmt.Sprintf("%s%s [%s];", indent, strconv.Quote(node.id), attrs))
+	}
+
+	for _, edge := range g.edges {
+		var attrs []string
+
+		if edge.label != "" {
+			attrs = append(attrs, fmt.Sprintf("label=%s", strconv.Quote(edge.label)))
+		}
+
+		if edge.dotted {
+			attrs = append(attrs, "style=dashed")
+		}
+
+		line := fmt.Sprintf("%s%s -> %s", indent, strconv.Quote(edge.from), strconv.Quote(edge.to))
+		if len(attrs) > 0 {
+			line += fmt.Sprintf(" [%s]", strings.Join(attrs, ", "))
+		}
+
+		lines = append(lines, line+";")
+	}
+
+	return lines
 }
 
 func (g *ProcessorGraph[E]) process() {
@@ -31,20 +187,43 @@ func (g *ProcessorGraph[E]) process() {
 		return
 	}
 
-	inputID := g.randomID()
-	outputID := g.randomID()
+	if g.changes != nil {
+		g.paths = make(map[interface{}]string)
+
+		Walk(g.root, func(path string, p Processor[E]) {
+			if reflect.TypeOf(p).Comparable() {
+				g.paths[p] = path
+			}
+		})
+	}
 
-	g.lines = append(g.lines, fmt.Sprintf("%s[Input]", inputID))
-	g.lines = append(g.lines, fmt.Sprintf("%s[Output]", outputID))
+	inputID := g.node(graphBox, "Input")
+	outputID := g.node(graphBox, "Output")
 
 	entryNode, lastNode := g.processInternal(g.root)
 
-	g.lines = append(g.lines, fmt.Sprintf("%s --> %s", inputID, entryNode))
-	g.lines = append(g.lines, fmt.Sprintf("%s --> %s", lastNode, outputID))
+	g.edge(inputID, entryNode, "", false)
+	g.edge(lastNode, outputID, "", false)
 
 	g.processed = true
 }
 
+func (g *ProcessorGraph[E]) node(shape graphShape, label string) string {
+	id := g.randomID()
+	g.nodes = append(g.nodes, graphNode{id: id, label: label, shape: shape})
+
+	return id
+}
+
+func (g *ProcessorGraph[E]) edge(from, to, label string, dotted bool) {
+	g.edges = append(g.edges, graphEdge{from: from, to: to, label: label, dotted: dotted})
+}
+
+// composite adds the entry and exit nodes of a composite
+func (g *ProcessorGraph[E]) composite(label string) (string, string) {
+	return g.node(graphEntry, label), g.node(graphExit, label)
+}
+
 func (g *ProcessorGraph[E]) processInternal(node Processor[E]) (string, string) {
 	var entryNodeID string
 	var outputNodeID string
@@ -53,66 +232,244 @@ func (g *ProcessorGraph[E]) processInternal(node Processor[E]) (string, string)
 	case *Fanout[E]:
 		fanout := node.(*Fanout[E])
 
-		entryNodeID = g.randomID()
-		outputNodeID = g.randomID()
-
-		g.lines = append(g.lines, fmt.Sprintf("%s[/FanOut/%s\\]", entryNodeID, fanout.ChainName))
-		g.lines = append(g.lines, fmt.Sprintf("%s[\\FanOut/%s/end/]", outputNodeID, fanout.ChainName))
+		entryNodeID, outputNodeID = g.composite(g.compositeLabel(fanout.Display, "FanOut", fanout.ChainName, fanout))
 
 		for _, p := range fanout.Processors {
 			nodeEntry, nodeOutput := g.processInternal(p)
 
-			g.lines = append(g.lines, fmt.Sprintf("%s --> %s", entryNodeID, nodeEntry))
-			g.lines = append(g.lines, fmt.Sprintf("%s --> %s", nodeOutput, outputNodeID))
+			g.edge(entryNodeID, nodeEntry, "", false)
+			g.edge(nodeOutput, outputNodeID, "", false)
 		}
 
 	case *Parallel[E]:
 		parallel := node.(*Parallel[E])
 
-		entryNodeID = g.randomID()
-		outputNodeID = g.randomID()
-
-		g.lines = append(g.lines, fmt.Sprintf("%s[/Parallel/%s\\]", entryNodeID, parallel.ChainName))
-		g.lines = append(g.lines, fmt.Sprintf("%s[\\Parallel/%s/end/]", outputNodeID, parallel.ChainName))
+		entryNodeID, outputNodeID = g.composite(g.compositeLabel(parallel.Display, "Parallel", parallel.ChainName, parallel))
 
 		for _, p := range parallel.Processors {
 			nodeEntry, nodeOutput := g.processInternal(p)
 
-			g.lines = append(g.lines, fmt.Sprintf("%s -.-> %s", entryNodeID, nodeEntry))
-			g.lines = append(g.lines, fmt.Sprintf("%s -.-> %s", nodeOutput, outputNodeID))
+			g.edge(entryNodeID, nodeEntry, "", true)
+			g.edge(nodeOutput, outputNodeID, "", true)
 		}
 
 	case *Sequential[E]:
 		seq := node.(*Sequential[E])
 
-		entryNodeID = g.randomID()
-		outputNodeID = g.randomID()
-
-		g.lines = append(g.lines, fmt.Sprintf("%s[/Sequential/%s\\]", entryNodeID, seq.ChainName))
-		g.lines = append(g.lines, fmt.Sprintf("%s[\\Sequential/%s/end/]", outputNodeID, seq.ChainName))
+		entryNodeID, outputNodeID = g.composite(g.compositeLabel(seq.Display, "Sequential", seq.ChainName, seq))
 
 		prevNode := entryNodeID
 
 		for _, p := range seq.Processors {
 			nodeEntry, nodeOutput := g.processInternal(p)
 
-			g.lines = append(g.lines, fmt.Sprintf("%s --> %s", prevNode, nodeEntry))
+			g.edge(prevNode, nodeEntry, "", false)
+			prevNode = nodeOutput
+		}
+
+		g.edge(prevNode, outputNodeID, "", false)
+
+	case *Shadow[E]:
+		shadow := node.(*Shadow[E])
+
+		entryNodeID, outputNodeID = g.composite(g.compositeLabel(shadow.Display, "Shadow", shadow.ChainName, shadow))
+
+		if shadow.Primary != nil {
+			nodeEntry, nodeOutput := g.processInternal(shadow.Primary)
+
+			g.edge(entryNodeID, nodeEntry, "", false)
+			g.edge(nodeOutput, outputNodeID, "", false)
+		}
+
+		if shadow.Candidate != nil {
+			nodeEntry, nodeOutput := g.processInternal(shadow.Candidate)
+			discardID := g.node(graphCircle, "discard")
+
+			g.edge(entryNodeID, nodeEntry, "shadow", true)
+			g.edge(nodeOutput, discardID, "", true)
+		}
+
+	case *BlueGreen[E]:
+		bg := node.(*BlueGreen[E])
+
+		entryNodeID, outputNodeID = g.composite(g.compositeLabel(bg.Display, "BlueGreen", bg.ChainName, bg))
+
+		split := bg.Split()
+
+		if bg.Blue != nil {
+			nodeEntry, nodeOutput := g.processInternal(bg.Blue)
+
+			g.edge(entryNodeID, nodeEntry, fmt.Sprintf("blue %.0f%%", (1-split)*100), false)
+			g.edge(nodeOutput, outputNodeID, "", false)
+		}
+
+		if bg.Green != nil {
+			nodeEntry, nodeOutput := g.processInternal(bg.Green)
+
+			g.edge(entryNodeID, nodeEntry, fmt.Sprintf("green %.0f%%", split*100), false)
+			g.edge(nodeOutput, outputNodeID, "", false)
+		}
+
+	case *Flagged[E]:
+		flagged := node.(*Flagged[E])
+
+		entryNodeID = g.node(graphDecision, flagged.Name())
+		outputNodeID = g.node(graphExit, flagged.Name())
+
+		if flagged.Processor != nil {
+			nodeEntry, nodeOutput := g.processInternal(flagged.Processor)
+
+			g.edge(entryNodeID, nodeEntry, "on", false)
+			g.edge(nodeOutput, outputNodeID, "", false)
+		}
+
+		g.edge(entryNodeID, outputNodeID, "off", true)
+
+	case *Pool[E]:
+		pool := node.(*Pool[E])
+
+		entryNodeID, outputNodeID = g.composite(g.compositeLabel(pool.Display, "Pool", pool.ChainName, pool))
+
+		for _, p := range pool.Children() {
+			nodeEntry, nodeOutput := g.processInternal(p)
+
+			g.edge(entryNodeID, nodeEntry, "", true)
+			g.edge(nodeOutput, outputNodeID, "", true)
+		}
+
+	case *TenantPool[E]:
+		pool := node.(*TenantPool[E])
+
+		entryNodeID, outputNodeID = g.composite(g.compositeLabel(pool.Display, "Tenants", pool.ChainName, pool))
+
+		if pool.Template != nil {
+			nodeEntry, nodeOutput := g.processInternal(pool.Template)
+
+			g.edge(entryNodeID, nodeEntry, "", true)
+			g.edge(nodeOutput, outputNodeID, "", true)
+		}
+
+	case *Router[E]:
+		router := node.(*Router[E])
+
+		entryNodeID, outputNodeID = g.composite(g.compositeLabel(router.Display, "Router", router.ChainName, router))
+
+		for _, route := range router.Routes {
+			if route.Processor == nil {
+				continue
+			}
+
+			nodeEntry, nodeOutput := g.processInternal(route.Processor)
+
+			g.edge(entryNodeID, nodeEntry, route.Name, false)
+			g.edge(nodeOutput, outputNodeID, "", false)
+		}
+
+		if router.Default != nil {
+			nodeEntry, nodeOutput := g.processInternal(router.Default)
+
+			g.edge(entryNodeID, nodeEntry, "default", true)
+			g.edge(nodeOutput, outputNodeID, "", true)
+		}
+
+	case *Retry[E]:
+		retry := node.(*Retry[E])
+
+		entryNodeID, outputNodeID = g.composite(g.compositeLabel(retry.Display, "Retry", retry.ChainName, retry))
+
+		if retry.Processor != nil {
+			nodeEntry, nodeOutput := g.processInternal(retry.Processor)
+
+			g.edge(entryNodeID, nodeEntry, fmt.Sprintf("%d attempts", retry.Policy.attempts()), false)
+			g.edge(nodeOutput, outputNodeID, "", false)
+		}
+
+	case *Deadline[E]:
+		deadline := node.(*Deadline[E])
+
+		entryNodeID, outputNodeID = g.composite(g.compositeLabel(deadline.Display, "Deadline", deadline.ChainName, deadline))
+
+		if deadline.Processor != nil {
+			nodeEntry, nodeOutput := g.processInternal(deadline.Processor)
+
+			g.edge(entryNodeID, nodeEntry, "", deadline.Optional)
+			g.edge(nodeOutput, outputNodeID, "", deadline.Optional)
+		}
+
+	case *Pipeline[E]:
+		p := node.(*Pipeline[E])
+
+		entryNodeID, outputNodeID = g.composite(g.compositeLabel(p.Display, "Pipeline", p.ChainName, p))
+
+		prevNode := entryNodeID
+
+		for _, child := range p.Children() {
+			nodeEntry, nodeOutput := g.processInternal(child)
+
+			g.edge(prevNode, nodeEntry, "", false)
 			prevNode = nodeOutput
 		}
 
-		g.lines = append(g.lines, fmt.Sprintf("%s --> %s", prevNode, outputNodeID))
+		g.edge(prevNode, outputNodeID, "", false)
+
+	case *SourceStage[E]:
+		entryNodeID = g.node(graphSource, g.leafLabel(node))
+		outputNodeID = entryNodeID
+
+	case *SinkStage[E]:
+		entryNodeID = g.node(graphSink, g.leafLabel(node))
+		outputNodeID = entryNodeID
 
 	default:
-		nodeID := g.randomID()
-		g.lines = append(g.lines, fmt.Sprintf("%s[%s]", nodeID, node.Name()))
+		nodeID := g.node(graphBox, g.leafLabel(node))
+		g.nodes[len(g.nodes)-1].config = ConfigHash(node)
 
 		entryNodeID = nodeID
 		outputNodeID = nodeID
 	}
 
+	g.markChange(node, entryNodeID, outputNodeID)
+
 	return entryNodeID, outputNodeID
 }
 
+// markChange highlights the nodes of p when it changed
+func (g *ProcessorGraph[E]) markChange(p Processor[E], ids ...string) {
+	if g.paths == nil || !reflect.TypeOf(p).Comparable() {
+		return
+	}
+
+	change, ok := g.changes[g.paths[p]]
+	if !ok {
+		return
+	}
+
+	for i := range g.nodes {
+		if slices.Contains(ids, g.nodes[i].id) {
+			g.nodes[i].change = change
+		}
+	}
+}
+
+// compositeLabel keeps the historical type/ChainName labels unless a display
+// template is set
+func (g *ProcessorGraph[E]) compositeLabel(display, typename, chainName string, node Processor[E]) string {
+	if display == "" {
+		return fmt.Sprintf("%s/%s", typename, chainName)
+	}
+
+	return DisplayName(node)
+}
+
+// leafLabel is the display name of the node, with its version if known
+func (g *ProcessorGraph[E]) leafLabel(node Processor[E]) string {
+	if d := Describe(g.ctx, node); d.Version != "" {
+		return fmt.Sprintf("%s %s", DisplayName(node), d.Version)
+	}
+
+	return DisplayName(node)
+}
+
 func (g *ProcessorGraph[E]) randomID() string {
 	return fmt.Sprintf("%d", rand.Int())
 }
@@ -124,6 +481,11 @@ func (g *ProcessorGraph[E]) Write(dest io.Writer) error {
 	return err
 }
 
+func (g *ProcessorGraph[E]) WriteDOT(dest io.Writer) error {
+	_, err := dest.Write([]byte(g.DOT()))
+	return err
+}
+
 func (g *ProcessorGraph[E]) WriteHTML(dest io.Writer) error {
 	template := fmt.Sprintf(`<html>
     <body>
@@ -0,0 +1,138 @@
+package pipeline
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// colors of the nodes added, removed and changed in graph diffs
+var changeColors = map[string]string{
+	ConfigAdded:   "#2e7d32",
+	ConfigRemoved: "#c62828",
+	ConfigChanged: "#f9a825",
+}
+
+/*
+	A DiffGraph renders the changes between two versions of a pipeline, as
+	found by DiffConfig: the old version is drawn with its removed nodes in
+	red, next to the updated one with its added nodes in green. Nodes changed
+	are drawn in amber in both.
+*/
+type DiffGraph[E Traceable] struct {
+	before *ProcessorGraph[E]
+	after  *ProcessorGraph[E]
+}
+
+func NewDiffGraph[E Traceable](old Processor[E], updated Processor[E]) *DiffGraph[E] {
+	before := NewProcessorGraph(old)
+	after := NewProcessorGraph(updated)
+
+	before.changes = make(map[string]string)
+	after.changes = make(map[string]string)
+
+	for _, change := range DiffConfig(old, updated) {
+		switch change.Change {
+		case ConfigRemoved:
+			before.changes[change.Path] = change.Change
+		case ConfigAdded:
+			after.changes[change.Path] = change.Change
+		default:
+			before.changes[change.Path] = change.Change
+			after.changes[change.Path] = change.Change
+		}
+	}
+
+	return &DiffGraph[E]{before: before, after: after}
+}
+
+/*
+	NewDefinitionDiffGraph builds the pipelines of two versions of a
+	definition, and returns the DiffGraph between them.
+*/
+func NewDefinitionDiffGraph[E Traceable](old *SerializedPipeline[E], updated *SerializedPipeline[E]) (*DiffGraph[E], error) {
+	before, err := old.Pipeline()
+	if err != nil {
+		return nil, fmt.Errorf("old definition: %w", err)
+	}
+
+	after, err := updated.Pipeline()
+	if err != nil {
+		return nil, fmt.Errorf("updated definition: %w", err)
+	}
+
+	return NewDiffGraph(before, after), nil
+}
+
+// String renders the diff as a mermaid flowchart, with a subgraph per version
+func (d *DiffGraph[E]) String() string {
+	lines := []string{"graph LR"}
+
+	for _, version := range []struct {
+		id    string
+		title string
+		graph *ProcessorGraph[E]
+	}{{"before", "Before", d.before}, {"after", "After", d.after}} {
+		lines = append(lines, fmt.Sprintf("subgraph %s [%s]", version.id, version.title), "direction TD")
+		lines = append(lines, version.graph.mermaidLines()...)
+		lines = append(lines, "end")
+	}
+
+	lines = append(lines, mermaidChanges(slices.Concat(d.before.nodes, d.after.nodes))...)
+
+	return strings.Join(lines, "\n")
+}
+
+// DOT renders the diff in the graphviz dot language, with a cluster per
+// version
+func (d *DiffGraph[E]) DOT() string {
+	lines := []string{"digraph diff {"}
+
+	for _, version := range []struct {
+		id    string
+		title string
+		graph *ProcessorGraph[E]
+	}{{"before", "Before", d.before}, {"after", "After", d.after}} {
+		lines = append(lines, fmt.Sprintf("\tsubgraph cluster_%s {", version.id), fmt.Sprintf("\t\tlabel=%q;", version.title))
+		lines = append(lines, version.graph.dotLines("\t\t")...)
+		lines = append(lines, "\t}")
+	}
+
+	lines = append(lines, "}")
+
+	return strings.Join(lines, "\n")
+}
+
+func (d *DiffGraph[E]) Write(dest io.Writer) error {
+	_, err := dest.Write([]byte(d.String()))
+	return err
+}
+
+func (d *DiffGraph[E]) WriteDOT(dest io.Writer) error {
+	_, err := dest.Write([]byte(d.DOT()))
+	return err
+}
+
+// mermaidChanges are the class definitions and assignments coloring the
+// nodes changed
+func mermaidChanges(nodes []graphNode) []string {
+	byChange := make(map[string][]string)
+
+	for _, node := range nodes {
+		if node.change != "" {
+			byChange[node.change] = append(byChange[node.change], node.id)
+		}
+	}
+
+	var lines []string
+
+	for _, change := range []string{ConfigAdded, ConfigRemoved, ConfigChanged} {
+		if ids, ok := byChange[change]; ok {
+			lines = append(lines, fmt.Sprintf("classDef %s fill:%s,color:#fff", change, changeColors[change]))
+			lines = append(lines, fmt.Sprintf("class %s %s", strings.Join(ids, ","), change))
+		}
+	}
+
+	return lines
+}
@@ -0,0 +1,482 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var ErrNoMatch = fmt.Errorf("no pattern matched")
+var ErrInvalidPattern = fmt.Errorf("invalid grok pattern")
+
+type MissPolicy int
+
+const (
+	// Items matching no pattern are sent on unchanged
+	MissKeep MissPolicy = iota
+	// Items matching no pattern are discarded
+	MissDrop
+	// Items matching no pattern fail with ErrNoMatch
+	MissFail
+)
+
+/*
+	A GrokPattern is a regular expression, in the RE2 syntax, whose named
+	groups are the fields it extracts, and which may reference the patterns
+	of GrokPatterns, or those of the Grok processor, as %{NAME},
+	%{NAME:field}, extracting the field, or %{NAME:field:type}, converting
+	it as the Types of the processor do. Patterns are named by their index
+	when they have no Name.
+*/
+type GrokPattern struct {
+	Name    string `json:"name,omitempty"`
+	Pattern string `json:"pattern"`
+}
+
+/*
+	The Grok processor extracts fields from the text of the field Field of
+	every item, named by Tag as for Fanout, with the first of its Patterns
+	matching it, and applies OnMiss to the items none matches. Definitions
+	adds named patterns to GrokPatterns, or replaces some.
+
+	Items matched by a pattern are counted under the "grok_hit" label
+	dimension of the processor stats, with the name of the pattern as
+	value, and under "grok_miss" for every pattern they are not matched by.
+*/
+type Grok[E Traceable] struct {
+	ChainName   string
+	Field       string
+	Tag         string
+	Types       map[string]string
+	Patterns    []GrokPattern
+	Definitions map[string]string
+	OnMiss      MissPolicy
+
+	lock     sync.Mutex
+	compiled []*grokExpression
+}
+
+// grokExpression is a pattern compiled, with the fields of its groups
+type grokExpression struct {
+	name   string
+	re     *regexp.Regexp
+	fields []string
+	types  map[string]string
+}
+
+func NewGrok[E Traceable](name string, field string, patterns ...string) *Grok[E] {
+	grok := &Grok[E]{
+		ChainName: name,
+		Field:     field,
+	}
+
+	for _, pattern := range patterns {
+		grok.Patterns = append(grok.Patterns, GrokPattern{Pattern: pattern})
+	}
+
+	return grok
+}
+
+// Init compiles the patterns
+func (g *Grok[E]) Init(ctx context.Context) error {
+	_, err := g.expressions()
+	return err
+}
+
+func (g *Grok[E]) expressions() ([]*grokExpression, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.compiled != nil {
+		return g.compiled, nil
+	}
+
+	if len(g.Patterns) == 0 {
+		return nil, fmt.Errorf("%s: no patterns: %w", g.Name(), ErrInvalidPattern)
+	}
+
+	compiled := make([]*grokExpression, 0, len(g.Patterns))
+
+	for i, pattern := range g.Patterns {
+		name := pattern.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+
+		expr, err := compileGrok(pattern.Pattern, g.Definitions)
+		if err != nil {
+			return nil, fmt.Errorf("%s: pattern %s: %w", g.Name(), name, err)
+		}
+
+		expr.name = name
+		compiled = append(compiled, expr)
+	}
+
+	g.compiled = compiled
+
+	return compiled, nil
+}
+
+func (g *Grok[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	expressions, err := g.expressions()
+	if err != nil {
+		Log[E](ctx, g, "%s", err)
+		ReportError(ctx, g, fmt.Errorf("%w: %w", err, ErrFatal))
+	}
+
+	executeItems[E](ctx, g, input, output, func(item E) (E, error) {
+		if err != nil {
+			return item, err
+		}
+
+		result, matched, err := g.extract(item)
+
+		for i, expr := range expressions {
+			if i == matched {
+				TrackLabel[E](ctx, g, "grok_hit", expr.name)
+				break
+			}
+
+			TrackLabel[E](ctx, g, "grok_miss", expr.name)
+		}
+
+		return result, err
+	})
+}
+
+func (g *Grok[E]) Name() string {
+	return fmt.Sprintf("Grok/%s", g.ChainName)
+}
+
+func (g *Grok[E]) ProcessItem(ctx context.Context, item E) (E, error) {
+	result, _, err := g.extract(item)
+	return result, err
+}
+
+// extract sets the fields of the first pattern matching item, and returns
+// its index, -1 when none matches
+func (g *Grok[E]) extract(item E) (E, int, error) {
+	expressions, err := g.expressions()
+	if err != nil {
+		return item, -1, fmt.Errorf("%w: %w", err, ErrFatal)
+	}
+
+	fields, _ := itemFields(item, g.Tag)
+
+	value, found := fields[g.Field]
+	if found {
+		text := formatValue(value)
+
+		for i, expr := range expressions {
+			match := expr.re.FindStringSubmatchIndex(text)
+			if match == nil {
+				continue
+			}
+
+			values := make(map[string]string)
+			types := make(map[string]string, len(g.Types)+len(expr.types))
+
+			for name, typename := range g.Types {
+				types[name] = typename
+			}
+
+			for group, field := range expr.fields {
+				if field == "" || match[2*group] < 0 {
+					continue
+				}
+
+				values[field] = text[match[2*group]:match[2*group+1]]
+
+				if typename, ok := expr.types[field]; ok {
+					types[field] = typename
+				}
+			}
+
+			if err := setItemFields(item, g.Tag, values, types); err != nil {
+				return item, i, fmt.Errorf("%s: pattern %s: %w", g.Name(), expr.name, err)
+			}
+
+			return item, i, nil
+		}
+	}
+
+	switch g.OnMiss {
+	case MissDrop:
+		return item, -1, fmt.Errorf("%s: %w", g.Name(), ErrDropped)
+	case MissFail:
+		return item, -1, fmt.Errorf("%s: %w: %w", g.Name(), ErrNoMatch, ErrPermanent)
+	}
+
+	return item, -1, nil
+}
+
+var grokReference = regexp.MustCompile(`%\{(\w+)(?::([\w.@\[\]-]+))?(?::(\w+))?\}`)
+
+// references may nest this deep, deeper ones being most likely a cycle
+const grokMaxDepth = 32
+
+type grokCompiler struct {
+	definitions map[string]string
+	fields      map[string]string
+	types       map[string]string
+}
+
+// compileGrok expands the references of pattern and compiles it
+func compileGrok(pattern string, definitions map[string]string) (*grokExpression, error) {
+	c := &grokCompiler{
+		definitions: definitions,
+		fields:      make(map[string]string),
+		types:       make(map[string]string),
+	}
+
+	expanded, err := c.expand(pattern, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	re, err := regexp.Compile(expanded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", err, ErrInvalidPattern)
+	}
+
+	expr := &grokExpression{
+		re:     re,
+		fields: make([]string, len(re.SubexpNames())),
+		types:  c.types,
+	}
+
+	for i, group := range re.SubexpNames() {
+		if field, ok := c.fields[group]; ok {
+			expr.fields[i] = field
+		} else {
+			expr.fields[i] = group
+		}
+	}
+
+	return expr, nil
+}
+
+func (c *grokCompiler) expand(pattern string, depth int) (string, error) {
+	if depth > grokMaxDepth {
+		return "", fmt.Errorf("references nested deeper than %d: %w", grokMaxDepth, ErrInvalidPattern)
+	}
+
+	var err error
+
+	expanded := grokReference.ReplaceAllStringFunc(pattern, func(ref string) string {
+		if err != nil {
+			return ""
+		}
+
+		parts := grokReference.FindStringSubmatch(ref)
+		name, field, typename := parts[1], parts[2], parts[3]
+
+		definition, ok := c.definitions[name]
+		if !ok {
+			definition, ok = GrokPatterns[name]
+		}
+
+		if !ok {
+			err = fmt.Errorf("unknown pattern %s: %w", name, ErrInvalidPattern)
+			return ""
+		}
+
+		var sub string
+		if sub, err = c.expand(definition, depth+1); err != nil {
+			return ""
+		}
+
+		if field == "" {
+			return "(?:" + sub + ")"
+		}
+
+		// fields are not all valid group names, groups are named apart
+		group := fmt.Sprintf("grok__%d", len(c.fields))
+		c.fields[group] = field
+
+		if typename != "" {
+			c.types[field] = typename
+		}
+
+		return fmt.Sprintf("(?P<%s>%s)", group, sub)
+	})
+
+	return expanded, err
+}
+
+/*
+	GrokPatterns are the patterns Grok processors reference by name, the
+	most common ones of logstash adapted to RE2, which has no lookarounds.
+	Programs may add theirs before building their processors.
+*/
+var GrokPatterns = map[string]string{
+	"USERNAME":     `[a-zA-Z0-9._-]+`,
+	"USER":         `%{USERNAME}`,
+	"EMAILLOCAL":   `[a-zA-Z0-9!#$%&'*+/=?^_{|}~-]+(?:\.[a-zA-Z0-9!#$%&'*+/=?^_{|}~-]+)*`,
+	"EMAIL":        `%{EMAILLOCAL}@%{HOSTNAME}`,
+	"INT":          `[+-]?[0-9]+`,
+	"BASE10NUM":    `[+-]?(?:[0-9]+(?:\.[0-9]+)?|\.[0-9]+)`,
+	"NUMBER":       `%{BASE10NUM}`,
+	"BASE16NUM":    `[+-]?(?:0x)?[0-9A-Fa-f]+`,
+	"POSINT":       `\b[1-9][0-9]*\b`,
+	"NONNEGINT":    `\b[0-9]+\b`,
+	"WORD":         `\b\w+\b`,
+	"NOTSPACE":     `\S+`,
+	"SPACE":        `\s*`,
+	"DATA":         `.*?`,
+	"GREEDYDATA":   `.*`,
+	"QUOTEDSTRING": `"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'`,
+	"UUID":         `[A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}`,
+
+	"IPV4":     `(?:(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\.){3}(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)`,
+	"IPV6":     `(?:[0-9A-Fa-f]{1,4}:){7}[0-9A-Fa-f]{1,4}|(?:[0-9A-Fa-f]{1,4}:){1,7}:|(?:[0-9A-Fa-f]{1,4}:){1,6}:[0-9A-Fa-f]{1,4}|(?:[0-9A-Fa-f]{1,4}:){1,5}(?::[0-9A-Fa-f]{1,4}){1,2}|(?:[0-9A-Fa-f]{1,4}:){1,4}(?::[0-9A-Fa-f]{1,4}){1,3}|(?:[0-9A-Fa-f]{1,4}:){1,3}(?::[0-9A-Fa-f]{1,4}){1,4}|(?:[0-9A-Fa-f]{1,4}:){1,2}(?::[0-9A-Fa-f]{1,4}){1,5}|[0-9A-Fa-f]{1,4}:(?::[0-9A-Fa-f]{1,4}){1,6}|:(?::[0-9A-Fa-f]{1,4}){1,7}|::`,
+	"IP":       `%{IPV6}|%{IPV4}`,
+	"HOSTNAME": `\b[0-9A-Za-z][0-9A-Za-z-]{0,62}(?:\.[0-9A-Za-z][0-9A-Za-z-]{0,62})*\.?\b`,
+	"IPORHOST": `%{IP}|%{HOSTNAME}`,
+	"HOSTPORT": `%{IPORHOST}:%{POSINT}`,
+
+	"UNIXPATH":     `(?:/[^/\s]*)+`,
+	"WINPATH":      `(?:[A-Za-z]+:|\\)(?:\\[^\\?*]*)+`,
+	"PATH":         `%{UNIXPATH}|%{WINPATH}`,
+	"URIPROTO":     `[A-Za-z][A-Za-z0-9+.-]*`,
+	"URIHOST":      `%{IPORHOST}(?::%{POSINT})?`,
+	"URIPATH":      `(?:/[A-Za-z0-9$.+!*'(){},~:;=@#%&_-]*)+`,
+	"URIPARAM":     `\?[A-Za-z0-9$.+!*'|(){},~@#%&/=:;_?\[\]<>-]*`,
+	"URIPATHPARAM": `%{URIPATH}(?:%{URIPARAM})?`,
+	"URI":          `%{URIPROTO}://(?:%{USER}(?::[^@]*)?@)?%{URIHOST}?(?:%{URIPATHPARAM})?`,
+
+	"MONTH":             `\b(?:[Jj]an(?:uary)?|[Ff]eb(?:ruary)?|[Mm]ar(?:ch)?|[Aa]pr(?:il)?|[Mm]ay|[Jj]un(?:e)?|[Jj]ul(?:y)?|[Aa]ug(?:ust)?|[Ss]ep(?:tember)?|[Oo]ct(?:ober)?|[Nn]ov(?:ember)?|[Dd]ec(?:ember)?)\b`,
+	"MONTHNUM":          `0?[1-9]|1[0-2]`,
+	"MONTHDAY":          `0[1-9]|[12][0-9]|3[01]|[1-9]`,
+	"DAY":               `\b(?:Mon(?:day)?|Tue(?:sday)?|Wed(?:nesday)?|Thu(?:rsday)?|Fri(?:day)?|Sat(?:urday)?|Sun(?:day)?)\b`,
+	"YEAR":              `(?:\d\d){1,2}`,
+	"HOUR":              `2[0123]|[01]?[0-9]`,
+	"MINUTE":            `[0-5][0-9]`,
+	"SECOND":            `(?:[0-5]?[0-9]|60)(?:[:.,][0-9]+)?`,
+	"TIME":              `%{HOUR}:%{MINUTE}(?::%{SECOND})?`,
+	"ISO8601_TIMEZONE":  `Z|[+-]%{HOUR}(?::?%{MINUTE})?`,
+	"TIMESTAMP_ISO8601": `%{YEAR}-%{MONTHNUM}-%{MONTHDAY}[T ]%{HOUR}:?%{MINUTE}(?::?%{SECOND})?%{ISO8601_TIMEZONE}?`,
+	"HTTPDATE":          `%{MONTHDAY}/%{MONTH}/%{YEAR}:%{TIME} %{INT}`,
+	"SYSLOGTIMESTAMP":   `%{MONTH} +%{MONTHDAY} %{TIME}`,
+
+	"LOGLEVEL": `[Aa]lert|ALERT|[Tt]race|TRACE|[Dd]ebug|DEBUG|[Nn]otice|NOTICE|[Ii]nfo|INFO|[Ww]arn(?:ing)?|WARN(?:ING)?|[Ee]rr(?:or)?|ERR(?:OR)?|[Cc]rit(?:ical)?|CRIT(?:ICAL)?|[Ff]atal|FATAL|[Ss]evere|SEVERE|[Ee]merg(?:ency)?|EMERG(?:ENCY)?`,
+
+	"SYSLOGPROG":        `%{PROG:program}(?:\[%{POSINT:pid}\])?`,
+	"PROG":              `[\x21-\x5a\x5c\x5e-\x7e]+`,
+	"SYSLOGHOST":        `%{IPORHOST}`,
+	"SYSLOGBASE":        `%{SYSLOGTIMESTAMP:timestamp} %{SYSLOGHOST:logsource} %{SYSLOGPROG}:`,
+	"COMMONAPACHELOG":   `%{IPORHOST:clientip} %{USER:ident} %{USER:auth} \[%{HTTPDATE:timestamp}\] "(?:%{WORD:verb} %{NOTSPACE:request}(?: HTTP/%{NUMBER:httpversion})?|%{DATA:rawrequest})" %{NUMBER:response} (?:%{NUMBER:bytes}|-)`,
+	"COMBINEDAPACHELOG": `%{COMMONAPACHELOG} %{QUOTEDSTRING:referrer} %{QUOTEDSTRING:agent}`,
+}
+
+// grokMissPolicy parses the names of the miss policies in definitions
+func grokMissPolicy(name string) (MissPolicy, bool) {
+	switch strings.ToLower(name) {
+	case "", "keep":
+		return MissKeep, true
+	case "drop":
+		return MissDrop, true
+	case "fail":
+		return MissFail, true
+	}
+
+	return MissKeep, false
+}
+
+func (p MissPolicy) String() string {
+	switch p {
+	case MissDrop:
+		return "drop"
+	case MissFail:
+		return "fail"
+	}
+
+	return "keep"
+}
+
+func newGrok[E Traceable](name string, cfg map[string]interface{}) (*Grok[E], error) {
+	grok := &Grok[E]{ChainName: name}
+
+	grok.Field, _ = cfg["field"].(string)
+	grok.Tag, _ = cfg["tag"].(string)
+
+	if types, ok := cfg["types"].(map[string]interface{}); ok {
+		grok.Types = make(map[string]string, len(types))
+
+		for name, typename := range types {
+			if typename, ok := typename.(string); ok {
+				grok.Types[name] = typename
+			}
+		}
+	}
+
+	patterns, _ := cfg["patterns"].([]interface{})
+	for i, pattern := range patterns {
+		switch pattern := pattern.(type) {
+		case string:
+			grok.Patterns = append(grok.Patterns, GrokPattern{Pattern: pattern})
+		case map[string]interface{}:
+			name, _ := pattern["name"].(string)
+			text, ok := pattern["pattern"].(string)
+			if !ok {
+				return nil, fmt.Errorf("pattern %d has no pattern: %w", i, ErrInvalidConfig)
+			}
+
+			grok.Patterns = append(grok.Patterns, GrokPattern{Name: name, Pattern: text})
+		default:
+			return nil, fmt.Errorf("pattern %d is a %T, not a string or an object: %w", i, pattern, ErrInvalidConfig)
+		}
+	}
+
+	if definitions, ok := cfg["definitions"].(map[string]interface{}); ok {
+		grok.Definitions = make(map[string]string, len(definitions))
+
+		for name, definition := range definitions {
+			if definition, ok := definition.(string); ok {
+				grok.Definitions[name] = definition
+			}
+		}
+	}
+
+	miss, _ := cfg["on_miss"].(string)
+	policy, ok := grokMissPolicy(miss)
+	if !ok {
+		return nil, fmt.Errorf("invalid on_miss %q: %w", miss, ErrInvalidConfig)
+	}
+
+	grok.OnMiss = policy
+
+	if err := grok.Init(context.Background()); err != nil {
+		return nil, fmt.Errorf("%w: %w", err, ErrInvalidConfig)
+	}
+
+	return grok, nil
+}
+
+func marshalGrok[E Traceable](grok *Grok[E]) (string, map[string]interface{}, error) {
+	cfg := map[string]interface{}{
+		"field":    grok.Field,
+		"patterns": grok.Patterns,
+	}
+
+	if grok.Tag != "" {
+		cfg["tag"] = grok.Tag
+	}
+
+	if len(grok.Types) > 0 {
+		cfg["types"] = grok.Types
+	}
+
+	if len(grok.Definitions) > 0 {
+		cfg["definitions"] = grok.Definitions
+	}
+
+	if grok.OnMiss != MissKeep {
+		cfg["on_miss"] = grok.OnMiss.String()
+	}
+
+	return grok.ChainName, cfg, nil
+}
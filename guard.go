@@ -0,0 +1,165 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	EventGuardTripped = "guard_tripped"
+	EventGuardResumed = "guard_resumed"
+)
+
+type GuardAction int
+
+const (
+	GuardAbort GuardAction = iota
+	GuardPause
+)
+
+/*
+	A FailureGuard watches the failure rate of every processor of a Runner,
+	the items failed over the items received within the last Window. Once it
+	exceeds Threshold for a processor which received at least MinItems items
+	in the window, the guard trips.
+
+	With GuardAbort the Runner then stops its intake and drains, as for its
+	other stop conditions. With GuardPause the intake waits until Resume is
+	called, the pipeline keeping on with the items it holds.
+*/
+type FailureGuard[E Traceable] struct {
+	Threshold float64
+	Window    time.Duration
+	MinItems  int64
+	Action    GuardAction
+
+	lock    sync.Mutex
+	samples map[string][]guardSample
+	tripped *GuardTrip
+	resumed chan struct{}
+}
+
+type GuardTrip struct {
+	Path   string    `json:"path"`
+	Rate   float64   `json:"rate"`
+	Input  int64     `json:"input"`
+	Failed int64     `json:"failed"`
+	Time   time.Time `json:"time"`
+}
+
+type guardSample struct {
+	at     time.Time
+	input  int64
+	failed int64
+}
+
+// Tripped returns the trip the guard is paused on, nil when it is not
+func (g *FailureGuard[E]) Tripped() *GuardTrip {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	return g.tripped
+}
+
+// Resume lets a paused intake go on. The failure rates start over from a
+// new window.
+func (g *FailureGuard[E]) Resume() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.tripped == nil {
+		return
+	}
+
+	g.tripped = nil
+	g.samples = nil
+	close(g.resumed)
+}
+
+// wait blocks while the guard is paused, and returns false if ctx is done
+// meanwhile
+func (g *FailureGuard[E]) wait(ctx context.Context) bool {
+	g.lock.Lock()
+	resumed := g.resumed
+	paused := g.tripped != nil
+	g.lock.Unlock()
+
+	if !paused {
+		return true
+	}
+
+	select {
+	case <-resumed:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (g *FailureGuard[E]) interval() time.Duration {
+	if d := g.Window / 10; d > 10*time.Millisecond {
+		return d
+	}
+
+	return 10 * time.Millisecond
+}
+
+/*
+	check samples the stats of every processor of root, and returns the trip
+	of the first one over the threshold. Nothing is checked while paused.
+*/
+func (g *FailureGuard[E]) check(statDB *StatDB[E], root Processor[E]) *GuardTrip {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.tripped != nil {
+		return nil
+	}
+
+	if g.samples == nil {
+		g.samples = make(map[string][]guardSample)
+	}
+
+	now := time.Now()
+	var trip *GuardTrip
+
+	Walk(root, func(path string, p Processor[E]) {
+		stats, ok := statDB.statsOf(p)
+		if !ok {
+			return
+		}
+
+		current := guardSample{at: now, input: stats.Input.Load(), failed: stats.Failed.Load()}
+
+		samples := append(g.samples[path], current)
+		for len(samples) > 1 && now.Sub(samples[0].at) > g.Window {
+			samples = samples[1:]
+		}
+		g.samples[path] = samples
+
+		input := current.input - samples[0].input
+		failed := current.failed - samples[0].failed
+
+		if trip != nil || input <= 0 || input < g.MinItems {
+			return
+		}
+
+		if rate := float64(failed) / float64(input); rate > g.Threshold {
+			trip = &GuardTrip{
+				Path:   path,
+				Rate:   rate,
+				Input:  input,
+				Failed: failed,
+				Time:   now,
+			}
+		}
+	})
+
+	if trip != nil && g.Action == GuardPause {
+		g.tripped = trip
+		g.resumed = make(chan struct{})
+	}
+
+	return trip
+}
@@ -0,0 +1,372 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultHistogramBuckets  = 160
+	DefaultHistogramMaxScale = 20
+)
+
+/*
+	An ExponentialHistogram records the distribution of positive values, such
+	as latencies, with the bucket layout of OpenTelemetry exponential
+	histograms: bucket i holds the values in (base^i, base^(i+1)], where
+	base = 2^(2^-scale). Values of zero or less are counted in the zero bucket.
+
+	The histogram starts at DefaultHistogramMaxScale, the finest resolution,
+	and lowers its scale whenever the values recorded would need more than
+	its maximum number of buckets, so its relative error stays bounded
+	whatever the range of the values. Histograms with the same layout can be
+	merged losslessly, whatever their scales, here or in any OpenTelemetry
+	backend.
+
+	Durations are recorded in seconds, as OpenTelemetry conventions expect.
+*/
+type ExponentialHistogram struct {
+	lock sync.Mutex
+
+	maxBuckets int
+	scale      int32
+	zeroCount  uint64
+	count      uint64
+	sum        float64
+	min        float64
+	max        float64
+	offset     int32
+	counts     []uint64
+}
+
+/*
+	ExponentialHistogramData is the state of a histogram, with the fields of
+	the OTLP exponential histogram data points, and their JSON names.
+*/
+type ExponentialHistogramData struct {
+	Count     uint64             `json:"count"`
+	Sum       float64            `json:"sum"`
+	Min       float64            `json:"min"`
+	Max       float64            `json:"max"`
+	Scale     int32              `json:"scale"`
+	ZeroCount uint64             `json:"zeroCount"`
+	Positive  ExponentialBuckets `json:"positive"`
+}
+
+type ExponentialBuckets struct {
+	Offset       int32    `json:"offset"`
+	BucketCounts []uint64 `json:"bucketCounts"`
+}
+
+// NewExponentialHistogram returns a histogram of at most maxBuckets buckets,
+// DefaultHistogramBuckets when it is zero
+func NewExponentialHistogram(maxBuckets int) *ExponentialHistogram {
+	if maxBuckets <= 0 {
+		maxBuckets = DefaultHistogramBuckets
+	}
+
+	return &ExponentialHistogram{
+		maxBuckets: maxBuckets,
+		scale:      DefaultHistogramMaxScale,
+	}
+}
+
+func (h *ExponentialHistogram) Record(v float64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.record(v, 1)
+}
+
+func (h *ExponentialHistogram) RecordDuration(d time.Duration) {
+	h.Record(d.Seconds())
+}
+
+func (h *ExponentialHistogram) record(v float64, n uint64) {
+	if h.count == 0 || v < h.min {
+		h.min = v
+	}
+
+	if h.count == 0 || v > h.max {
+		h.max = v
+	}
+
+	h.count += n
+	h.sum += v * float64(n)
+
+	if v <= 0 {
+		h.zeroCount += n
+		return
+	}
+
+	h.insert(v, n)
+}
+
+// insert adds n values to the bucket of v, lowering the scale if needed
+func (h *ExponentialHistogram) insert(v float64, n uint64) {
+	index := bucketIndex(v, h.scale)
+
+	if len(h.counts) == 0 {
+		h.offset = index
+		h.counts = []uint64{n}
+		return
+	}
+
+	low, high := h.offset, h.offset+int32(len(h.counts))-1
+	if index < low {
+		low = index
+	}
+
+	if index > high {
+		high = index
+	}
+
+	var change int32
+	for (high>>change)-(low>>change)+1 > int32(h.maxBuckets) {
+		change++
+	}
+
+	if change > 0 {
+		h.downscale(change)
+		index = bucketIndex(v, h.scale)
+	}
+
+	h.add(index, n)
+}
+
+// add adds n values to the bucket index, which must fit in maxBuckets
+func (h *ExponentialHistogram) add(index int32, n uint64) {
+	if len(h.counts) == 0 {
+		h.offset = index
+		h.counts = []uint64{n}
+		return
+	}
+
+	if index < h.offset {
+		h.counts = append(make([]uint64, h.offset-index), h.counts...)
+		h.offset = index
+	}
+
+	if last := h.offset + int32(len(h.counts)) - 1; index > last {
+		h.counts = append(h.counts, make([]uint64, index-last)...)
+	}
+
+	h.counts[index-h.offset] += n
+}
+
+// downscale lowers the scale by change, merging buckets together
+func (h *ExponentialHistogram) downscale(change int32) {
+	if change <= 0 {
+		return
+	}
+
+	h.scale -= change
+
+	if len(h.counts) == 0 {
+		return
+	}
+
+	offset := h.offset >> change
+	counts := make([]uint64, (h.offset+int32(len(h.counts))-1)>>change-offset+1)
+
+	for i, c := range h.counts {
+		counts[(h.offset+int32(i))>>change-offset] += c
+	}
+
+	h.offset = offset
+	h.counts = counts
+}
+
+/*
+	Merge adds the values of other to the histogram. The result has the
+	lowest scale of both, lowered further when needed to fit the buckets of
+	both.
+*/
+func (h *ExponentialHistogram) Merge(other *ExponentialHistogram) {
+	data := other.Snapshot()
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if data.Count == 0 {
+		return
+	}
+
+	if h.count == 0 || data.Min < h.min {
+		h.min = data.Min
+	}
+
+	if h.count == 0 || data.Max > h.max {
+		h.max = data.Max
+	}
+
+	h.count += data.Count
+	h.sum += data.Sum
+	h.zeroCount += data.ZeroCount
+
+	h.downscale(h.scale - data.Scale)
+
+	counts := data.Positive.BucketCounts
+	if len(counts) == 0 {
+		return
+	}
+
+	change := h.scale - data.Scale
+	low := data.Positive.Offset >> -change
+	high := (data.Positive.Offset + int32(len(counts)) - 1) >> -change
+
+	if len(h.counts) > 0 {
+		if h.offset < low {
+			low = h.offset
+		}
+
+		if last := h.offset + int32(len(h.counts)) - 1; last > high {
+			high = last
+		}
+	}
+
+	var extra int32
+	for (high>>extra)-(low>>extra)+1 > int32(h.maxBuckets) {
+		extra++
+	}
+
+	h.downscale(extra)
+
+	shift := data.Scale - h.scale
+	for i, c := range counts {
+		if c > 0 {
+			h.add((data.Positive.Offset+int32(i))>>shift, c)
+		}
+	}
+}
+
+/*
+	Quantile returns an estimate of the q quantile of the values, the middle
+	of the bucket holding it, bounded by the smallest and largest values.
+*/
+func (h *ExponentialHistogram) Quantile(q float64) float64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.count == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Ceil(q * float64(h.count)))
+	if rank == 0 {
+		rank = 1
+	}
+
+	seen := h.zeroCount
+	if rank <= seen {
+		return math.Min(0, h.max)
+	}
+
+	for i, c := range h.counts {
+		seen += c
+		if rank > seen {
+			continue
+		}
+
+		index := h.offset + int32(i)
+		lower, upper := bucketBound(index, h.scale), bucketBound(index+1, h.scale)
+
+		return math.Max(h.min, math.Min(h.max, (lower+upper)/2))
+	}
+
+	return h.max
+}
+
+func (h *ExponentialHistogram) Count() uint64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return h.count
+}
+
+func (h *ExponentialHistogram) Snapshot() ExponentialHistogramData {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return ExponentialHistogramData{
+		Count:     h.count,
+		Sum:       h.sum,
+		Min:       h.min,
+		Max:       h.max,
+		Scale:     h.scale,
+		ZeroCount: h.zeroCount,
+		Positive: ExponentialBuckets{
+			Offset:       h.offset,
+			BucketCounts: append([]uint64(nil), h.counts...),
+		},
+	}
+}
+
+func (h *ExponentialHistogram) MarshalJSON() ([]byte, error) {
+	return json.Marshal(h.Snapshot())
+}
+
+// UnmarshalJSON restores a histogram from its data, keeping its maximum number
+// of buckets, or the default one for a zero histogram
+func (h *ExponentialHistogram) UnmarshalJSON(raw []byte) error {
+	var data ExponentialHistogramData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return err
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.maxBuckets <= 0 {
+		h.maxBuckets = DefaultHistogramBuckets
+	}
+
+	if len(data.Positive.BucketCounts) > h.maxBuckets {
+		h.maxBuckets = len(data.Positive.BucketCounts)
+	}
+
+	h.count = data.Count
+	h.sum = data.Sum
+	h.min = data.Min
+	h.max = data.Max
+	h.scale = data.Scale
+	h.zeroCount = data.ZeroCount
+	h.offset = data.Positive.Offset
+	h.counts = append([]uint64(nil), data.Positive.BucketCounts...)
+
+	return nil
+}
+
+/*
+	bucketIndex returns the index of the bucket of v at scale, as specified
+	by OpenTelemetry: exactly from the exponent of v for scales up to zero,
+	and from its logarithm for greater scales, powers of two being counted
+	in the bucket they bound.
+*/
+func bucketIndex(v float64, scale int32) int32 {
+	frac, exp := math.Frexp(v)
+	exp--
+
+	exact := frac == 0.5
+
+	if scale <= 0 {
+		if exact {
+			exp--
+		}
+
+		return int32(exp) >> -scale
+	}
+
+	if exact {
+		return int32(exp)<<scale - 1
+	}
+
+	return int32(math.Ceil(math.Log(v)*math.Ldexp(math.Log2E, int(scale)))) - 1
+}
+
+// bucketBound returns the lower bound of the bucket index at scale
+func bucketBound(index, scale int32) float64 {
+	return math.Exp2(math.Ldexp(float64(index), -int(scale)))
+}
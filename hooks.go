@@ -0,0 +1,95 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+const EventHookFailed = "hook_failed"
+
+var ErrHookFailed = fmt.Errorf("hook failed")
+
+// runnerHook is a hook registered with RegisterOnStart or RegisterOnStop
+type runnerHook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+/*
+	RegisterOnStart registers fn to be run by every run before its intake
+	opens, once the pipeline is built, such as to join a consumer group in
+	advance. Start hooks run in the order they were registered, and the
+	first one failing fails the run, which then does not open its intake.
+*/
+func (r *Runner[E]) RegisterOnStart(name string, fn func(ctx context.Context) error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.onStart = append(r.onStart, runnerHook{name: name, fn: fn})
+}
+
+/*
+	RegisterOnStop registers fn to be run by every run once the pipeline has
+	drained, such as to flush caches. Stop hooks run in the reverse order
+	they were registered, after the start hooks, even those of a run whose
+	start hooks failed, and with a context which is not cancelled. They all
+	run even when some fail, and their failures are returned by the run.
+*/
+func (r *Runner[E]) RegisterOnStop(name string, fn func(ctx context.Context) error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.onStop = append(r.onStop, runnerHook{name: name, fn: fn})
+}
+
+// runStartHooks runs the start hooks, until one fails
+func (r *Runner[E]) runStartHooks(ctx context.Context) error {
+	r.lock.Lock()
+	hooks := r.onStart
+	r.lock.Unlock()
+
+	for _, hook := range hooks {
+		if err := r.runHook(ctx, "start", hook); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runStopHooks runs every stop hook, last registered first
+func (r *Runner[E]) runStopHooks(ctx context.Context) error {
+	r.lock.Lock()
+	hooks := r.onStop
+	r.lock.Unlock()
+
+	ctx = context.WithoutCancel(ctx)
+
+	var errs []error
+
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if err := r.runHook(ctx, "stop", hooks[i]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (r *Runner[E]) runHook(ctx context.Context, stage string, hook runnerHook) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("panicked: %v", rec)
+		}
+
+		if err != nil {
+			err = fmt.Errorf("%s hook %s: %w: %w", stage, hook.name, err, ErrHookFailed)
+
+			Log[E](ctx, r.Pipeline, "%s", err)
+			Emit[E](ctx, r.Pipeline, EventHookFailed, "%s", err)
+		}
+	}()
+
+	return hook.fn(ctx)
+}
@@ -0,0 +1,414 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const EventWebhookRetried = "webhook_retried"
+
+const (
+	DefaultMaxBodySize     = 1 << 20
+	DefaultShutdownTimeout = 5 * time.Second
+)
+
+var ErrWebhookFailed = fmt.Errorf("webhook failed")
+
+/*
+	The HTTPSource listens on Addr, and sends an item for every request
+	POSTed to Path, "/" by default, made by Decode from the request. Bodies
+	are cut at MaxBodySize, one MiB by default.
+
+	Requests are answered Accepted once their item is sent, Bad Request when
+	Decode fails, Method Not Allowed when they are not POSTs, Unauthorized
+	when they lack the token of Security, and Service Unavailable when the
+	source stops before their item is sent. The values propagated in the
+	headers of a request are restored on its context before Decode runs, and
+	the lineage they carry is replayed onto its item.
+
+	With a Security having certificates, the source serves TLS, and verifies
+	client certificates against its CA.
+
+	The source stops with its context, and waits up to ShutdownTimeout,
+	five seconds by default, for the requests in flight. Listening failures
+	are returned by Err once it has stopped.
+*/
+type HTTPSource[E Traceable] struct {
+	ChainName string
+	Addr      string
+	Path      string
+
+	Decode func(r *http.Request) (E, error) `json:"-"`
+
+	MaxBodySize     int64
+	ShutdownTimeout time.Duration
+
+	Security *EdgeSecurity `json:"-"`
+
+	lock     sync.Mutex
+	listener net.Listener
+	err      error
+}
+
+func NewHTTPSource[E Traceable](name string, addr string, decode func(r *http.Request) (E, error)) *HTTPSource[E] {
+	return &HTTPSource[E]{
+		ChainName: name,
+		Addr:      addr,
+		Decode:    decode,
+	}
+}
+
+func (s *HTTPSource[E]) Name() string {
+	return fmt.Sprintf("HTTPSource/%s", s.ChainName)
+}
+
+// Err returns the error listening stopped on, if any
+func (s *HTTPSource[E]) Err() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.err
+}
+
+// ListenAddr returns the address the source listens on, once it has started
+func (s *HTTPSource[E]) ListenAddr() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.listener == nil {
+		return ""
+	}
+
+	return s.listener.Addr().String()
+}
+
+func (s *HTTPSource[E]) Execute(ctx context.Context, output chan E) {
+	defer close(output)
+
+	listener, err := s.listen()
+
+	s.lock.Lock()
+	s.listener, s.err = listener, err
+	s.lock.Unlock()
+
+	if err != nil {
+		return
+	}
+
+	// handlers sending items are waited for before the output is closed
+	inflight := sync.WaitGroup{}
+	defer inflight.Wait()
+
+	path := s.Path
+	if path == "" {
+		path = "/"
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		inflight.Add(1)
+		defer inflight.Done()
+
+		s.serve(ctx, output, w, r)
+	})
+
+	server := &http.Server{Handler: mux}
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- server.Serve(listener)
+	}()
+
+	select {
+	case err = <-stopped:
+	case <-ctx.Done():
+		timeout := s.ShutdownTimeout
+		if timeout <= 0 {
+			timeout = DefaultShutdownTimeout
+		}
+
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+		defer cancel()
+
+		err = server.Shutdown(shutdownCtx)
+		<-stopped
+	}
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		s.lock.Lock()
+		s.err = fmt.Errorf("serving %s: %w", s.Addr, err)
+		s.lock.Unlock()
+	}
+}
+
+// listen listens on Addr, with TLS when Security has certificates
+func (s *HTTPSource[E]) listen() (net.Listener, error) {
+	if !s.Security.TLSEnabled() {
+		return net.Listen("tcp", s.Addr)
+	}
+
+	tlsCfg, err := s.Security.ServerTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return tls.Listen("tcp", s.Addr, tlsCfg)
+}
+
+func (s *HTTPSource[E]) serve(ctx context.Context, output chan E, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	size := s.MaxBodySize
+	if size <= 0 {
+		size = DefaultMaxBodySize
+	}
+
+	headers := fromHTTPHeader(r.Header)
+
+	if err := s.Security.Authorize(headers); err != nil {
+		if !errors.Is(err, ErrUnauthorized) {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, size)
+	r = r.WithContext(ExtractContext(r.Context(), headers))
+
+	item, err := s.Decode(r)
+	if err == nil {
+		err = ExtractTraces(headers, item)
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case output <- item:
+		w.WriteHeader(http.StatusAccepted)
+	case <-r.Context().Done():
+		abandon(ctx, item)
+	case <-ctx.Done():
+		abandon(ctx, item)
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+	}
+}
+
+/*
+	The WebhookSink POSTs items to URL, one at a time, or in batches of up
+	to BatchSize items, those received within Linger, when BatchSize is
+	more than one. Encode makes the body of a request from its items, by
+	default their JSON encoding, as an array for batches.
+
+	Requests failing, or answered with a status other than 2xx, are tried
+	again as told by Retry, but for those answered with a 4xx other than Too
+	Many Requests, which fail for good. Every retry is emitted as
+	EventWebhookRetried. Items of the requests failing for good are failed,
+	and sent to the dead letter handlers. Items posted are acked.
+
+	Requests carry the values propagated from the context in their headers,
+	along with the lineage of their item when they post a single one, and
+	the authorization of the token of Security when it is set. Without a
+	Client, requests are made with the client TLS configuration of Security
+	when it has certificates.
+*/
+type WebhookSink[E Traceable] struct {
+	ChainName string
+	URL       string
+	Header    http.Header
+
+	Encode      func(items []E) ([]byte, error) `json:"-"`
+	ContentType string
+
+	BatchSize int
+	Linger    time.Duration
+	Retry     RetryPolicy
+
+	Security *EdgeSecurity `json:"-"`
+	Client   *http.Client  `json:"-"`
+
+	client *http.Client
+}
+
+func NewWebhookSink[E Traceable](name string, url string) *WebhookSink[E] {
+	return &WebhookSink[E]{
+		ChainName: name,
+		URL:       url,
+	}
+}
+
+func (ws *WebhookSink[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	post := ws.post
+
+	client, err := ws.httpClient()
+	if err != nil {
+		ReportError(ctx, ws, fmt.Errorf("%w: %w", err, ErrFatal))
+
+		post = func(ctx context.Context, items []E) error {
+			return err
+		}
+	}
+
+	ws.client = client
+
+	executeSink[E](ctx, ws, input, output, max(ws.BatchSize, 1), ws.Linger, nil, post)
+}
+
+func (ws *WebhookSink[E]) Name() string {
+	return fmt.Sprintf("WebhookSink/%s", ws.ChainName)
+}
+
+// httpClient is the Client, or a client with the TLS configuration of
+// Security when it has certificates
+func (ws *WebhookSink[E]) httpClient() (*http.Client, error) {
+	if ws.Client != nil {
+		return ws.Client, nil
+	}
+
+	if !ws.Security.TLSEnabled() {
+		return http.DefaultClient, nil
+	}
+
+	tlsCfg, err := ws.Security.ClientTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsCfg
+
+	return &http.Client{Transport: transport}, nil
+}
+
+func (ws *WebhookSink[E]) post(ctx context.Context, items []E) error {
+	body, err := ws.encode(items)
+	if err != nil {
+		return fmt.Errorf("%w: %w", err, ErrPermanent)
+	}
+
+	headers := InjectContext(ctx)
+	defer ReleaseHeaders(headers)
+
+	if err := ws.Security.Sign(headers); err != nil {
+		return fmt.Errorf("%w: %w", err, ErrPermanent)
+	}
+
+	if len(items) == 1 {
+		if err := InjectTraces(headers, items[0]); err != nil {
+			return fmt.Errorf("%w: %w", err, ErrPermanent)
+		}
+	}
+
+	attempts := ws.Retry.attempts()
+
+	for attempt := 1; ; attempt++ {
+		err = ws.request(ctx, body, headers)
+		if err == nil {
+			break
+		}
+
+		if attempt >= attempts || errors.Is(err, ErrPermanent) {
+			return err
+		}
+
+		Emit[E](ctx, ws, EventWebhookRetried, "attempt %d of %d items failed: %s", attempt, len(items), err)
+
+		if err := sleep(ctx, ws.Retry.delay(attempt)); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range items {
+		TrackOutput[E](ctx, ws, m)
+	}
+
+	return nil
+}
+
+func (ws *WebhookSink[E]) encode(items []E) ([]byte, error) {
+	if ws.Encode != nil {
+		return ws.Encode(items)
+	}
+
+	if ws.BatchSize <= 1 && len(items) == 1 {
+		return json.Marshal(items[0])
+	}
+
+	return json.Marshal(items)
+}
+
+func (ws *WebhookSink[E]) request(ctx context.Context, body []byte, headers Headers) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ws.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %w", err, ErrPermanent)
+	}
+
+	for key, values := range ws.Header {
+		req.Header[key] = values
+	}
+
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	contentType := ws.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := ws.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests:
+		return fmt.Errorf("%s answered %s: %w: %w", ws.URL, resp.Status, ErrWebhookFailed, ErrPermanent)
+	default:
+		return fmt.Errorf("%s answered %s: %w", ws.URL, resp.Status, ErrWebhookFailed)
+	}
+}
+
+// fromHTTPHeader returns the propagation and authorization headers of
+// header, lowercased as net/http canonicalizes their names, so propagated
+// keys should be lowercase
+func fromHTTPHeader(header http.Header) Headers {
+	headers := Headers{}
+
+	for key := range header {
+		name := strings.ToLower(key)
+
+		if strings.HasPrefix(name, HeaderPrefix) || name == HeaderAuthorization {
+			headers[name] = header.Get(key)
+		}
+	}
+
+	return headers
+}
@@ -0,0 +1,786 @@
+package pipeline
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidGraph = fmt.Errorf("invalid pipeline graph")
+
+/*
+	Import reads a mermaid or dot graph, as written by ProcessorGraph or
+	sketched by hand, and returns the skeleton of the pipeline it describes.
+
+	The import is best effort. Composites are recognized by their labels, or by
+	the shape of their edges when the label was customized with a Display
+	template, but only what can be seen in the graph is recovered: leaves are
+	imported with their label as name and no configuration, so a processor
+	factory matching them is still needed to build the pipeline. Chains of
+	nodes outside of any composite are imported as sequential processors.
+*/
+func Import[E Traceable](r io.Reader, format ExportFormat) (*SerializedPipeline[E], error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var decl *graphDecl
+
+	switch format {
+	case ExportMermaid:
+		decl, err = parseMermaid(string(data))
+	case ExportDOT:
+		decl, err = parseDOT(string(data))
+	default:
+		return nil, fmt.Errorf("%s: %w", format, ErrUnknownFormat)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newGraphImporter[E](decl).root()
+}
+
+type graphImporter[E Traceable] struct {
+	nodes    map[string]graphNode
+	order    []string
+	out      map[string][]graphEdge
+	in       map[string]int
+	visited  map[string]bool
+	consumed map[string]bool
+}
+
+func newGraphImporter[E Traceable](decl *graphDecl) *graphImporter[E] {
+	imp := &graphImporter[E]{
+		nodes:    make(map[string]graphNode),
+		out:      make(map[string][]graphEdge),
+		in:       make(map[string]int),
+		visited:  make(map[string]bool),
+		consumed: make(map[string]bool),
+	}
+
+	for _, node := range decl.nodes {
+		imp.nodes[node.id] = node
+		imp.order = append(imp.order, node.id)
+	}
+
+	for _, edge := range decl.edges {
+		imp.out[edge.from] = append(imp.out[edge.from], edge)
+		imp.in[edge.to]++
+	}
+
+	return imp
+}
+
+// root finds where the pipeline starts, after the Input node or at the first
+// node without incoming edges
+func (imp *graphImporter[E]) root() (*SerializedPipeline[E], error) {
+	start := ""
+
+	for _, id := range imp.order {
+		if imp.in[id] == 0 && imp.isTerminal(id, "Input") {
+			start = imp.next(id)
+			break
+		}
+	}
+
+	if start == "" {
+		for _, id := range imp.order {
+			if imp.in[id] == 0 && !imp.isTerminal(id, "Input") && !imp.isTerminal(id, "Output") {
+				start = id
+				break
+			}
+		}
+	}
+
+	if start == "" {
+		return nil, fmt.Errorf("no processors found: %w", ErrInvalidGraph)
+	}
+
+	children, _, err := imp.chain(start)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(children) == 0 {
+		return nil, fmt.Errorf("no processors found: %w", ErrInvalidGraph)
+	}
+
+	return imp.wrap("pipeline", children), nil
+}
+
+// isTerminal tells whether id is the Input or Output node drawn around the
+// pipeline
+func (imp *graphImporter[E]) isTerminal(id, label string) bool {
+	node := imp.nodes[id]
+	return node.shape == graphBox && node.label == label
+}
+
+func (imp *graphImporter[E]) next(id string) string {
+	if edges := imp.out[id]; len(edges) > 0 {
+		return edges[0].to
+	}
+
+	return ""
+}
+
+// chain imports the processors linked one after the other from start, until
+// the exit of the enclosing composite. It returns them with the node it
+// stopped at.
+func (imp *graphImporter[E]) chain(start string) ([]SerializedPipeline[E], string, error) {
+	var children []SerializedPipeline[E]
+
+	for id := start; id != ""; {
+		node := imp.nodes[id]
+
+		if node.shape == graphExit || node.shape == graphCircle {
+			return children, id, nil
+		}
+
+		if imp.isTerminal(id, "Output") && len(imp.out[id]) == 0 {
+			return children, id, nil
+		}
+
+		child, end, err := imp.build(id)
+		if err != nil {
+			return nil, "", err
+		}
+
+		children = append(children, *child)
+		id = imp.next(end)
+	}
+
+	return children, "", nil
+}
+
+// wrap turns a chain into a single processor
+func (imp *graphImporter[E]) wrap(name string, children []SerializedPipeline[E]) *SerializedPipeline[E] {
+	if len(children) == 1 {
+		return &children[0]
+	}
+
+	return &SerializedPipeline[E]{
+		Type:       "sequential",
+		Name:       name,
+		Processors: children,
+	}
+}
+
+// build imports the processor starting at id, and returns it with the node
+// its output leaves from
+func (imp *graphImporter[E]) build(id string) (*SerializedPipeline[E], string, error) {
+	node := imp.nodes[id]
+
+	if imp.visited[id] {
+		return nil, "", fmt.Errorf("%s: cycle found: %w", node.label, ErrInvalidGraph)
+	}
+
+	imp.visited[id] = true
+
+	switch node.shape {
+	case graphEntry:
+		return imp.composite(id)
+
+	case graphDecision:
+		return imp.flagged(id)
+
+	case graphBox:
+		return &SerializedPipeline[E]{
+			Type: "processor",
+			Name: node.label,
+		}, id, nil
+
+	default:
+		return nil, "", fmt.Errorf("%s: unexpected node: %w", node.label, ErrInvalidGraph)
+	}
+}
+
+var compositeTypes = map[string]string{
+	"FanOut":     "fanout",
+	"Parallel":   "parallel",
+	"Sequential": "sequential",
+	"Shadow":     "shadow",
+	"BlueGreen":  "bluegreen",
+	"Router":     "router",
+	"Pool":       "pool",
+	"Tenants":    "tenants",
+	"Retry":      "retry",
+	"Deadline":   "deadline",
+}
+
+func (imp *graphImporter[E]) composite(id string) (*SerializedPipeline[E], string, error) {
+	node := imp.nodes[id]
+	edges := imp.out[id]
+
+	sp := &SerializedPipeline[E]{Name: node.label}
+
+	if prefix, name, found := strings.Cut(node.label, "/"); found && compositeTypes[prefix] != "" {
+		sp.Type = compositeTypes[prefix]
+		sp.Name = name
+	} else {
+		sp.Type = inferCompositeType(edges)
+	}
+
+	var exit string
+
+	if sp.Type == "sequential" {
+		if len(edges) > 0 {
+			children, end, err := imp.chain(edges[0].to)
+			if err != nil {
+				return nil, "", err
+			}
+
+			sp.Processors = children
+			exit = end
+		}
+	} else {
+		branches := make(map[string]SerializedPipeline[E])
+		var routes []interface{}
+
+		for pos, edge := range edges {
+			branch, end, err := imp.chain(edge.to)
+			if err != nil {
+				return nil, "", err
+			}
+
+			if len(branch) == 0 {
+				continue
+			}
+
+			role := fmt.Sprintf("%d", pos)
+
+			switch {
+			case sp.Type == "shadow" && edge.label == "shadow":
+				role = "candidate"
+			case sp.Type == "shadow":
+				role = "primary"
+			case sp.Type == "bluegreen" && strings.HasPrefix(edge.label, "green"):
+				role = "green"
+
+				var percent float64
+				if _, err := fmt.Sscanf(edge.label, "green %f%%", &percent); err == nil {
+					sp.Config = map[string]interface{}{"split": percent / 100}
+				}
+			case sp.Type == "bluegreen":
+				role = "blue"
+			case sp.Type == "router" && edge.label == "default":
+				role = "default"
+			case sp.Type == "router" && edge.label != "":
+				role = edge.label
+			case sp.Type == "retry":
+				var attempts int
+				if _, err := fmt.Sscanf(edge.label, "%d attempts", &attempts); err == nil {
+					sp.Config = map[string]interface{}{"max_attempts": float64(attempts)}
+				}
+			}
+
+			if sp.Type == "router" && role != "default" {
+				routes = append(routes, role)
+			}
+
+			if exit == "" || role == "primary" {
+				exit = end
+			}
+
+			branches[role] = *imp.wrap(fmt.Sprintf("%s/%s", sp.Name, role), branch)
+
+			if sp.Type != "shadow" && sp.Type != "bluegreen" && role != "default" {
+				sp.Processors = append(sp.Processors, branches[role])
+			}
+		}
+
+		if sp.Type == "pool" && len(sp.Processors) > 0 {
+			sp.Config = map[string]interface{}{"replicas": float64(len(sp.Processors))}
+			sp.Processors = sp.Processors[:1]
+		}
+
+		if sp.Type == "router" {
+			_, hasDefault := branches["default"]
+			if hasDefault {
+				sp.Processors = append(sp.Processors, branches["default"])
+			}
+
+			sp.Config = map[string]interface{}{
+				"routes":  routes,
+				"all":     false,
+				"default": hasDefault,
+			}
+		}
+
+		roles := map[string][]string{
+			"shadow":    {"primary", "candidate"},
+			"bluegreen": {"blue", "green"},
+		}
+
+		for _, role := range roles[sp.Type] {
+			branch, found := branches[role]
+			if !found {
+				return nil, "", fmt.Errorf("%s: %s processor not found: %w", node.label, role, ErrInvalidGraph)
+			}
+
+			sp.Processors = append(sp.Processors, branch)
+		}
+
+		if sp.Type == "shadow" {
+			sp.Config = map[string]interface{}{"sample_rate": 1.0}
+		}
+	}
+
+	if exit == "" || imp.nodes[exit].shape != graphExit {
+		exit = imp.exitOf(id)
+	}
+
+	if exit == "" {
+		return nil, "", fmt.Errorf("%s: end of composite not found: %w", node.label, ErrInvalidGraph)
+	}
+
+	imp.consumed[exit] = true
+
+	return sp, exit, nil
+}
+
+// exitOf finds the exit node of a composite without children, the first
+// unused one declared after its entry with the same label
+func (imp *graphImporter[E]) exitOf(entry string) string {
+	label := imp.nodes[entry].label
+	after := false
+
+	for _, id := range imp.order {
+		if id == entry {
+			after = true
+			continue
+		}
+
+		node := imp.nodes[id]
+		if after && !imp.consumed[id] && node.shape == graphExit && node.label == label {
+			return id
+		}
+	}
+
+	return ""
+}
+
+// inferCompositeType guesses the type of a composite from the edges leaving
+// its entry
+func inferCompositeType(edges []graphEdge) string {
+	dotted := len(edges) > 0
+
+	for _, edge := range edges {
+		if edge.label == "shadow" {
+			return "shadow"
+		}
+
+		if strings.HasPrefix(edge.label, "blue") || strings.HasPrefix(edge.label, "green") {
+			return "bluegreen"
+		}
+
+		dotted = dotted && edge.dotted
+	}
+
+	switch {
+	case dotted:
+		return "parallel"
+	case len(edges) > 1:
+		return "fanout"
+	default:
+		return "sequential"
+	}
+}
+
+func (imp *graphImporter[E]) flagged(id string) (*SerializedPipeline[E], string, error) {
+	node := imp.nodes[id]
+
+	sp := &SerializedPipeline[E]{
+		Type: "flagged",
+		Name: strings.TrimPrefix(node.label, "Flagged/"),
+	}
+
+	var exit string
+
+	for _, edge := range imp.out[id] {
+		if edge.label == "off" {
+			exit = edge.to
+			continue
+		}
+
+		if len(sp.Processors) > 0 {
+			return nil, "", fmt.Errorf("%s: flagged needs exactly one processor: %w", node.label, ErrInvalidGraph)
+		}
+
+		branch, end, err := imp.chain(edge.to)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if len(branch) == 0 {
+			continue
+		}
+
+		sp.Processors = []SerializedPipeline[E]{*imp.wrap(sp.Name, branch)}
+
+		if exit == "" {
+			exit = end
+		}
+	}
+
+	if len(sp.Processors) == 0 {
+		return nil, "", fmt.Errorf("%s: flagged needs exactly one processor: %w", node.label, ErrInvalidGraph)
+	}
+
+	if exit == "" {
+		return nil, "", fmt.Errorf("%s: end of flagged processor not found: %w", node.label, ErrInvalidGraph)
+	}
+
+	imp.consumed[exit] = true
+
+	return sp, exit, nil
+}
+
+// graphDecl collects the nodes and edges of a parsed graph in declaration
+// order. Nodes first seen in an edge are replaced by their definition.
+type graphDecl struct {
+	nodes   []graphNode
+	index   map[string]int
+	defined map[string]bool
+	edges   []graphEdge
+}
+
+func newGraphDecl() *graphDecl {
+	return &graphDecl{
+		index:   make(map[string]int),
+		defined: make(map[string]bool),
+	}
+}
+
+func (d *graphDecl) node(node graphNode, defined bool) {
+	pos, found := d.index[node.id]
+	if !found {
+		d.index[node.id] = len(d.nodes)
+		d.nodes = append(d.nodes, node)
+		d.defined[node.id] = defined
+		return
+	}
+
+	if defined && !d.defined[node.id] {
+		d.nodes[pos] = node
+		d.defined[node.id] = true
+	}
+}
+
+var (
+	mermaidOperator = regexp.MustCompile(`\s*(-->(?:\|([^|]*)\|)?|-\.->(?:\|([^|]*)\|)?|--\s*(\S.*?)\s*-->|-\.\s*(\S.*?)\s*\.->|==>)\s*`)
+	mermaidNode     = regexp.MustCompile(`^([^\s\[\]{}()]+)\s*(.*)$`)
+)
+
+func parseMermaid(text string) (*graphDecl, error) {
+	decl := newGraphDecl()
+	scanner := bufio.NewScanner(strings.NewReader(text))
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(scanner.Text()), ";"))
+
+		if line == "" || strings.HasPrefix(line, "%%") || strings.HasPrefix(line, "graph") || strings.HasPrefix(line, "flowchart") {
+			continue
+		}
+
+		if err := parseMermaidStatement(decl, line); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return decl, nil
+}
+
+// parseMermaidStatement parses a node definition or a chain of edges, whose
+// ends can define nodes too
+func parseMermaidStatement(decl *graphDecl, line string) error {
+	masked := maskBrackets(line)
+
+	var exprs []string
+	var edges []graphEdge
+
+	for {
+		loc := mermaidOperator.FindStringSubmatchIndex(masked)
+		if loc == nil {
+			exprs = append(exprs, line)
+			break
+		}
+
+		exprs = append(exprs, line[:loc[0]])
+
+		edge := graphEdge{dotted: strings.HasPrefix(line[loc[2]:loc[3]], "-.")}
+
+		for group := 2; group <= 5; group++ {
+			if loc[2*group] >= 0 {
+				edge.label = strings.TrimSpace(line[loc[2*group]:loc[2*group+1]])
+			}
+		}
+
+		edges = append(edges, edge)
+
+		line = line[loc[1]:]
+		masked = masked[loc[1]:]
+	}
+
+	ids := make([]string, len(exprs))
+
+	for i, expr := range exprs {
+		node, defined, err := parseMermaidNode(expr)
+		if err != nil {
+			return err
+		}
+
+		decl.node(node, defined)
+		ids[i] = node.id
+	}
+
+	for i, edge := range edges {
+		edge.from = ids[i]
+		edge.to = ids[i+1]
+		decl.edges = append(decl.edges, edge)
+	}
+
+	return nil
+}
+
+func parseMermaidNode(expr string) (graphNode, bool, error) {
+	m := mermaidNode.FindStringSubmatch(strings.TrimSpace(expr))
+	if m == nil {
+		return graphNode{}, false, fmt.Errorf("%q: %w", expr, ErrInvalidGraph)
+	}
+
+	node := graphNode{id: m[1], label: m[1], shape: graphBox}
+	shape := m[2]
+
+	switch {
+	case shape == "":
+		return node, false, nil
+
+	case strings.HasPrefix(shape, "[/") && strings.HasSuffix(shape, "\\]"):
+		node.shape = graphEntry
+		node.label = shape[2 : len(shape)-2]
+
+	case strings.HasPrefix(shape, "[\\") && strings.HasSuffix(shape, "/]"):
+		node.shape = graphExit
+		node.label = strings.TrimSuffix(shape[2:len(shape)-2], "/end")
+
+	case strings.HasPrefix(shape, "((") && strings.HasSuffix(shape, "))"):
+		node.shape = graphCircle
+		node.label = shape[2 : len(shape)-2]
+
+	case strings.HasPrefix(shape, "{") && strings.HasSuffix(shape, "}"):
+		node.shape = graphDecision
+		node.label = strings.Trim(shape, "{}")
+
+	case strings.HasPrefix(shape, "[") && strings.HasSuffix(shape, "]"),
+		strings.HasPrefix(shape, "(") && strings.HasSuffix(shape, ")"):
+		node.label = strings.Trim(shape, "[]()")
+
+	default:
+		return graphNode{}, false, fmt.Errorf("%q: unknown node shape: %w", expr, ErrInvalidGraph)
+	}
+
+	node.label = strings.Trim(strings.TrimSpace(node.label), `"`)
+
+	return node, true, nil
+}
+
+// maskBrackets hides the labels of nodes, so that operators are only searched
+// for between them
+func maskBrackets(line string) string {
+	masked := []byte(line)
+	depth := 0
+
+	for i, c := range masked {
+		switch c {
+		case '[', '{', '(':
+			depth++
+		case ']', '}', ')':
+			depth--
+		default:
+			if depth > 0 {
+				masked[i] = '_'
+			}
+		}
+	}
+
+	return string(masked)
+}
+
+var (
+	dotAttribute  = regexp.MustCompile(`(\w+)\s*=\s*("(?:[^"\\]|\\.)*"|[^,;\s\]]+)`)
+	dotIdentifier = regexp.MustCompile(`^("(?:[^"\\]|\\.)*"|[\w.]+)$`)
+	dotOperator   = regexp.MustCompile(`->|--`)
+)
+
+func parseDOT(text string) (*graphDecl, error) {
+	start := strings.Index(text, "{")
+	end := strings.LastIndex(text, "}")
+
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no graph body: %w", ErrInvalidGraph)
+	}
+
+	decl := newGraphDecl()
+
+	for _, stmt := range splitOutsideQuotes(text[start+1:end], ";\n") {
+		stmt = strings.TrimSpace(stmt)
+
+		if stmt == "" || strings.HasPrefix(stmt, "//") || strings.HasPrefix(stmt, "#") {
+			continue
+		}
+
+		attrs := make(map[string]string)
+
+		if open := strings.Index(maskQuotes(stmt), "["); open >= 0 {
+			for _, m := range dotAttribute.FindAllStringSubmatch(stmt[open:], -1) {
+				value := m[2]
+
+				if unquoted, err := strconv.Unquote(value); err == nil {
+					value = unquoted
+				}
+
+				attrs[m[1]] = value
+			}
+
+			stmt = strings.TrimSpace(stmt[:open])
+		}
+
+		switch {
+		case stmt == "graph" || stmt == "node" || stmt == "edge" || strings.Contains(maskQuotes(stmt), "="):
+			continue
+
+		case dotOperator.MatchString(maskQuotes(stmt)):
+			if err := parseDOTEdges(decl, stmt, attrs); err != nil {
+				return nil, err
+			}
+
+		default:
+			id, err := dotID(stmt)
+			if err != nil {
+				return nil, err
+			}
+
+			decl.node(dotNode(id, attrs), true)
+		}
+	}
+
+	return decl, nil
+}
+
+func parseDOTEdges(decl *graphDecl, stmt string, attrs map[string]string) error {
+	var ids []string
+
+	masked := maskQuotes(stmt)
+	last := 0
+
+	bounds := append(dotOperator.FindAllStringIndex(masked, -1), []int{len(stmt), len(stmt)})
+
+	for _, bound := range bounds {
+		id, err := dotID(stmt[last:bound[0]])
+		if err != nil {
+			return err
+		}
+
+		decl.node(graphNode{id: id, label: id, shape: graphBox}, false)
+		ids = append(ids, id)
+
+		last = bound[1]
+	}
+
+	style := attrs["style"]
+
+	for i := 0; i+1 < len(ids); i++ {
+		decl.edges = append(decl.edges, graphEdge{
+			from:   ids[i],
+			to:     ids[i+1],
+			label:  attrs["label"],
+			dotted: style == "dashed" || style == "dotted",
+		})
+	}
+
+	return nil
+}
+
+func dotNode(id string, attrs map[string]string) graphNode {
+	node := graphNode{id: id, label: id, shape: graphBox}
+
+	if label, ok := attrs["label"]; ok {
+		node.label = label
+	}
+
+	switch attrs["shape"] {
+	case "trapezium":
+		node.shape = graphEntry
+	case "invtrapezium":
+		node.shape = graphExit
+	case "diamond":
+		node.shape = graphDecision
+	case "circle", "doublecircle", "point":
+		node.shape = graphCircle
+	}
+
+	return node
+}
+
+func dotID(text string) (string, error) {
+	text = strings.TrimSpace(text)
+
+	if !dotIdentifier.MatchString(text) {
+		return "", fmt.Errorf("%q: %w", text, ErrInvalidGraph)
+	}
+
+	if strings.HasPrefix(text, `"`) {
+		return strconv.Unquote(text)
+	}
+
+	return text, nil
+}
+
+// maskQuotes hides quoted strings, keeping the position of everything else
+func maskQuotes(text string) string {
+	masked := []byte(text)
+	quoted := false
+
+	for i := 0; i < len(masked); i++ {
+		switch {
+		case masked[i] == '"':
+			quoted = !quoted
+		case quoted && masked[i] == '\\' && i+1 < len(masked):
+			masked[i] = '_'
+			masked[i+1] = '_'
+			i++
+		case quoted:
+			masked[i] = '_'
+		}
+	}
+
+	return string(masked)
+}
+
+// splitOutsideQuotes splits text at every separator which is not part of a
+// quoted string
+func splitOutsideQuotes(text string, separators string) []string {
+	masked := maskQuotes(text)
+
+	var parts []string
+	last := 0
+
+	for i := 0; i < len(masked); i++ {
+		if strings.IndexByte(separators, masked[i]) >= 0 {
+			parts = append(parts, text[last:i])
+			last = i + 1
+		}
+	}
+
+	return append(parts, text[last:])
+}
@@ -0,0 +1,92 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"regexp"
+)
+
+var PipelineItemLogger PipelineContextKey = "pipeline_item_logger"
+
+type ItemLogPoint int
+
+const (
+	ItemLogInput ItemLogPoint = 1 << iota
+	ItemLogOutput
+)
+
+/*
+	An ItemLogger logs summaries of the items entering and leaving stages, for
+	payload level debugging in production.
+
+	Only a SampleRate fraction of the items is logged, at the selected Points.
+	Items are summarized with Summarize, or formatted with %+v when it is nil,
+	and the summary goes through Redact before being written so sensitive
+	fields can be masked.
+*/
+type ItemLogger[E Traceable] struct {
+	Points     ItemLogPoint
+	SampleRate float64
+
+	Summarize func(E) string
+	Redact    func(string) string
+
+	Logger *log.Logger
+}
+
+func WithItemLogging[E Traceable](ctx context.Context, logger *ItemLogger[E]) context.Context {
+	return context.WithValue(ctx, PipelineItemLogger, logger)
+}
+
+// LogItem logs the item if item logging is enabled for the point
+func LogItem[E Traceable](ctx context.Context, p Processor[E], point ItemLogPoint, item E) {
+	logger, ok := ctx.Value(PipelineItemLogger).(*ItemLogger[E])
+	if !ok || logger.Points&point == 0 {
+		return
+	}
+
+	if logger.SampleRate < 1 && rand.Float64() >= logger.SampleRate {
+		return
+	}
+
+	var summary string
+	if logger.Summarize != nil {
+		summary = logger.Summarize(item)
+	} else {
+		summary = fmt.Sprintf("%+v", item)
+	}
+
+	if logger.Redact != nil {
+		summary = logger.Redact(summary)
+	}
+
+	direction := "in"
+	if point == ItemLogOutput {
+		direction = "out"
+	}
+
+	printf := log.Printf
+	if logger.Logger != nil {
+		printf = logger.Logger.Printf
+	}
+
+	if runID := RunID(ctx); runID != "" {
+		printf("[%s] [%s] %s: %s", runID, p.Name(), direction, summary)
+		return
+	}
+
+	printf("[%s] %s: %s", p.Name(), direction, summary)
+}
+
+// RedactPatterns returns a Redact hook replacing every match of the patterns
+func RedactPatterns(replacement string, patterns ...*regexp.Regexp) func(string) string {
+	return func(s string) string {
+		for _, re := range patterns {
+			s = re.ReplaceAllString(s, replacement)
+		}
+
+		return s
+	}
+}
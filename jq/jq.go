@@ -0,0 +1,276 @@
+/*
+	Package jq runs jq programs on the items of pipelines. Register makes
+	its processor available to definitions, as the "jq" type.
+*/
+package jq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/ca0s/pipeline"
+	"github.com/itchyny/gojq"
+)
+
+var ErrQueryFailed = fmt.Errorf("jq query failed")
+
+// results a query may give for an item when its processor sets no MaxResults
+const DefaultJQMaxResults = 1000
+
+/*
+	The JQ processor runs a jq program, as implemented by gojq, on the JSON
+	encoding of every item, and sends on an item for each result: queries
+	select items by giving no result, transform them by giving another one,
+	and explode them by giving several. Null results are dropped.
+
+	Results are decoded back into the item, the first one, or into copies of
+	it for the others, so the fields a result does not have keep their
+	value. Queries taking more than Timeout, one second by default, or
+	giving more than MaxResults results, fail.
+*/
+type JQ[E pipeline.Traceable] struct {
+	ChainName  string
+	Query      string
+	Timeout    time.Duration
+	MaxResults int
+
+	lock sync.Mutex
+	code *gojq.Code
+}
+
+func NewJQ[E pipeline.Traceable](name string, query string) *JQ[E] {
+	return &JQ[E]{
+		ChainName: name,
+		Query:     query,
+	}
+}
+
+// Init compiles the query
+func (q *JQ[E]) Init(ctx context.Context) error {
+	_, err := q.compiled()
+	return err
+}
+
+func (q *JQ[E]) compiled() (*gojq.Code, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.code != nil {
+		return q.code, nil
+	}
+
+	query, err := gojq.Parse(q.Query)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %w", q.Name(), err, ErrQueryFailed)
+	}
+
+	code, err := gojq.Compile(query)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %w", q.Name(), err, ErrQueryFailed)
+	}
+
+	q.code = code
+
+	return code, nil
+}
+
+func (q *JQ[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	if err := q.Init(ctx); err != nil {
+		pipeline.Log[E](ctx, q, "%s", err)
+		pipeline.ReportError(ctx, q, fmt.Errorf("%w: %w", err, pipeline.ErrFatal))
+	}
+
+	for m := range input {
+		pipeline.TrackItemInput[E](ctx, q, m)
+
+		results, err := q.run(ctx, m)
+		if err != nil {
+			pipeline.Log[E](ctx, q, "failed: %s", err)
+			pipeline.TrackFailure[E](ctx, q)
+			pipeline.ReportError(ctx, q, err)
+			pipeline.SendToDLQ(ctx, q, m, err)
+			continue
+		}
+
+		for _, result := range results {
+			pipeline.TrackOutput[E](ctx, q, result)
+			output <- result
+		}
+	}
+
+	close(output)
+}
+
+func (q *JQ[E]) Name() string {
+	return fmt.Sprintf("JQ/%s", q.ChainName)
+}
+
+/*
+	ProcessItem runs the query for item, failing with pipeline.ErrDropped
+	when it gives no result, and with ErrQueryFailed when it gives several,
+	which only Execute sends on.
+*/
+func (q *JQ[E]) ProcessItem(ctx context.Context, item E) (E, error) {
+	results, err := q.run(ctx, item)
+	if err != nil {
+		return item, err
+	}
+
+	switch len(results) {
+	case 0:
+		return item, fmt.Errorf("%s: %w", q.Name(), pipeline.ErrDropped)
+	case 1:
+		return results[0], nil
+	}
+
+	return item, fmt.Errorf("%s: query gave %d results: %w", q.Name(), len(results), ErrQueryFailed)
+}
+
+// run returns the items the query gives for item, leaving item as it was
+// when it fails
+func (q *JQ[E]) run(ctx context.Context, item E) ([]E, error) {
+	code, err := q.compiled()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", err, pipeline.ErrFatal)
+	}
+
+	enc, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(enc, &doc); err != nil {
+		return nil, err
+	}
+
+	timeout := q.Timeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	limit := q.MaxResults
+	if limit <= 0 {
+		limit = DefaultJQMaxResults
+	}
+
+	var encoded [][]byte
+
+	iter := code.RunWithContext(runCtx, doc)
+	for {
+		value, ok := iter.Next()
+		if !ok {
+			break
+		}
+
+		if err, ok := value.(error); ok {
+			if halt, ok := err.(*gojq.HaltError); ok && halt.Value() == nil {
+				break
+			}
+
+			return nil, fmt.Errorf("%s: %w: %w", q.Name(), err, ErrQueryFailed)
+		}
+
+		if value == nil {
+			continue
+		}
+
+		if len(encoded) >= limit {
+			return nil, fmt.Errorf("%s: more than %d results: %w", q.Name(), limit, ErrQueryFailed)
+		}
+
+		result, err := gojq.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w: %w", q.Name(), err, ErrQueryFailed)
+		}
+
+		encoded = append(encoded, result)
+	}
+
+	results := make([]E, 0, len(encoded))
+
+	// decoded apart first, so a failure leaves the item as it was
+	for _, result := range encoded {
+		var copied E
+		if err := json.Unmarshal(enc, &copied); err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(result, &copied); err != nil {
+			return nil, fmt.Errorf("%s: %w: %w", q.Name(), err, ErrQueryFailed)
+		}
+
+		results = append(results, copied)
+	}
+
+	if len(encoded) > 0 {
+		if err := json.Unmarshal(encoded[0], &item); err != nil {
+			return nil, err
+		}
+
+		results[0] = item
+	}
+
+	return results, nil
+}
+
+/*
+	Register registers the JQ processor as the "jq" processor type of
+	definitions, configured with query, timeout and max_results. Queries are
+	compiled when they are built, so broken ones fail their definition.
+*/
+func Register[E pipeline.Traceable]() {
+	pipeline.RegisterProcessorType[E, *JQ[E]]("jq", newJQ[E], marshalJQ[E])
+}
+
+func newJQ[E pipeline.Traceable](name string, cfg map[string]interface{}) (*JQ[E], error) {
+	jq := &JQ[E]{ChainName: name}
+
+	jq.Query, _ = cfg["query"].(string)
+
+	if timeout, ok := cfg["timeout"].(string); ok {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %w: %w", err, pipeline.ErrInvalidConfig)
+		}
+
+		jq.Timeout = d
+	}
+
+	if results, ok := cfg["max_results"].(float64); ok {
+		if results < 0 || results > math.MaxInt32 || results != math.Trunc(results) {
+			return nil, fmt.Errorf("invalid max_results %v: %w", results, pipeline.ErrInvalidConfig)
+		}
+
+		jq.MaxResults = int(results)
+	}
+
+	if err := jq.Init(context.Background()); err != nil {
+		return nil, fmt.Errorf("%w: %w", err, pipeline.ErrInvalidConfig)
+	}
+
+	return jq, nil
+}
+
+func marshalJQ[E pipeline.Traceable](jq *JQ[E]) (string, map[string]interface{}, error) {
+	cfg := map[string]interface{}{
+		"query": jq.Query,
+	}
+
+	if jq.Timeout > 0 {
+		cfg["timeout"] = jq.Timeout.String()
+	}
+
+	if jq.MaxResults > 0 {
+		cfg["max_results"] = jq.MaxResults
+	}
+
+	return jq.ChainName, cfg, nil
+}
@@ -0,0 +1,694 @@
+/*
+	Package kafka connects pipelines to Kafka topics: a KafkaSource feeds a
+	pipeline from a consumer group, committing the offsets of the items the
+	pipeline is done with, and a KafkaSink writes items to a topic.
+*/
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ca0s/pipeline"
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+// offsets acked by the pipeline are committed to the group at this interval
+const DefaultCommitInterval = time.Second
+
+var ErrNoTopic = fmt.Errorf("no topic")
+
+/*
+	A Message is a record read from Kafka, handed to the New of a
+	KafkaSource to make its item. The item embeds Acker, so that its offset
+	is committed once the pipeline is done with it. The lineage sent along
+	with the record, in its headers, is replayed onto the item once it is
+	decoded.
+*/
+type Message struct {
+	Topic     string
+	Partition int
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   pipeline.Headers
+	Time      time.Time
+
+	// Context is the context of the source, with the values propagated by
+	// the producer of the record restored
+	Context context.Context
+
+	Acker *pipeline.Acker
+}
+
+/*
+	The KafkaSource is a pipeline.Source consuming Topics as a member of the
+	consumer group GroupID. Every record read is made into an item by New,
+	and its value decoded into it with the named Codec, "json" by default,
+	unless the Codec is "raw": the item then carries the value as it was
+	read, and New must keep it.
+
+	Offsets are tracked by a pipeline.Checkpointer: the offset of a
+	partition is committed to the group every CommitInterval, once every
+	record before it was acked. Records nacked hold back the commit of
+	their partition, to be consumed again after a restart, unless
+	SkipNacked is set. Records acked after the source has stopped are not
+	committed, and consumed again by the next member of the group: delivery
+	is at least once.
+
+	With a Security, the brokers are dialed with its client TLS
+	configuration when it has certificates, and records must carry the
+	signature of its token, as a KafkaSink with the same Security signs
+	them: see pipeline.Signer.
+
+	Records which fail to decode, or are not authorized, are reported by Err
+	once the source has stopped, unless Skip is set: they are then skipped,
+	and their offset committed.
+*/
+type KafkaSource[E pipeline.Traceable] struct {
+	ChainName string
+
+	Brokers []string
+	Topics  []string
+	GroupID string
+
+	Codec string
+	New   func(msg *Message) E `json:"-"`
+
+	CommitInterval time.Duration
+	SkipNacked     bool
+	Skip           bool
+
+	Security *pipeline.EdgeSecurity `json:"-"`
+
+	// Config adjusts the configuration of the consumer before it starts
+	Config func(config *kafkago.ReaderConfig) `json:"-"`
+
+	lock sync.Mutex
+	err  error
+}
+
+func NewKafkaSource[E pipeline.Traceable](name string, brokers []string, group string, topics []string, fn func(msg *Message) E) *KafkaSource[E] {
+	return &KafkaSource[E]{
+		ChainName: name,
+		Brokers:   brokers,
+		Topics:    topics,
+		GroupID:   group,
+		New:       fn,
+	}
+}
+
+func (s *KafkaSource[E]) Name() string {
+	return fmt.Sprintf("KafkaSource/%s", s.ChainName)
+}
+
+// Init checks the source can run, before it is started
+func (s *KafkaSource[E]) Init(ctx context.Context) error {
+	if len(s.Topics) == 0 {
+		return ErrNoTopic
+	}
+
+	if _, err := tlsConfig(s.Security); err != nil {
+		return err
+	}
+
+	_, err := codec(s.Codec)
+	return err
+}
+
+// Err returns the error the source stopped on, if any
+func (s *KafkaSource[E]) Err() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.err
+}
+
+func (s *KafkaSource[E]) fail(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.err = err
+}
+
+func (s *KafkaSource[E]) Execute(ctx context.Context, output chan E) {
+	defer close(output)
+
+	s.fail(nil)
+
+	dec, err := codec(s.Codec)
+	if err != nil {
+		s.fail(err)
+		return
+	}
+
+	tlsCfg, err := tlsConfig(s.Security)
+	if err != nil {
+		s.fail(err)
+		return
+	}
+
+	signer, err := s.Security.Signer()
+	if err != nil {
+		s.fail(err)
+		return
+	}
+
+	config := kafkago.ReaderConfig{
+		Brokers:     s.Brokers,
+		GroupID:     s.GroupID,
+		GroupTopics: s.Topics,
+	}
+
+	if tlsCfg != nil {
+		config.Dialer = &kafkago.Dialer{Timeout: 10 * time.Second, DualStack: true, TLS: tlsCfg}
+	}
+
+	if s.Config != nil {
+		s.Config(&config)
+	}
+
+	reader := kafkago.NewReader(config)
+	defer reader.Close()
+
+	interval := s.CommitInterval
+	if interval <= 0 {
+		interval = DefaultCommitInterval
+	}
+
+	checkpointer := &pipeline.Checkpointer[E]{
+		Store:      &groupCommits{reader: reader},
+		Interval:   interval,
+		SkipNacked: s.SkipNacked,
+	}
+
+	// the checkpointer stops with the source, saving the offsets acked so
+	// far, whether the source stops with ctx or on an error
+	cpCtx, cancel := context.WithCancel(ctx)
+	committed := make(chan struct{})
+
+	defer func() {
+		cancel()
+		<-committed
+	}()
+
+	go func() {
+		defer close(committed)
+		checkpointer.Run(cpCtx)
+	}()
+
+	for {
+		record, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				s.fail(fmt.Errorf("fetching from %s: %w", strings.Join(s.Topics, ","), err))
+			}
+
+			return
+		}
+
+		msg := newMessage(ctx, record)
+		msg.Acker = checkpointer.Track(partitionKey(record.Topic, record.Partition), record.Offset)
+
+		item := s.New(msg)
+
+		err = signer.Verify(msg.Headers, msg.Value)
+		if err == nil {
+			err = decode(dec, msg, item)
+		}
+
+		if err != nil {
+			err = fmt.Errorf("reading %s at %d: %w", partitionKey(record.Topic, record.Partition), record.Offset, err)
+
+			if !s.Skip {
+				s.fail(err)
+				return
+			}
+
+			msg.Acker.Ack()
+			continue
+		}
+
+		select {
+		case output <- item:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func newMessage(ctx context.Context, record kafkago.Message) *Message {
+	msg := &Message{
+		Topic:     record.Topic,
+		Partition: record.Partition,
+		Offset:    record.Offset,
+		Key:       record.Key,
+		Value:     record.Value,
+		Time:      record.Time,
+	}
+
+	msg.Headers = make(pipeline.Headers, len(record.Headers))
+	for _, header := range record.Headers {
+		msg.Headers[header.Key] = string(header.Value)
+	}
+
+	msg.Context = pipeline.ExtractContext(ctx, msg.Headers)
+
+	return msg
+}
+
+// decode decodes the value of msg into item, with its lineage
+func decode[E pipeline.Traceable](dec pipeline.Codec, msg *Message, item E) error {
+	if dec != nil {
+		if err := dec.Unmarshal(msg.Value, item); err != nil {
+			return err
+		}
+	}
+
+	return pipeline.ExtractTraces(msg.Headers, item)
+}
+
+// codec returns the named codec, nil for "raw"
+func codec(name string) (pipeline.Codec, error) {
+	switch name {
+	case "raw":
+		return nil, nil
+	case "":
+		name = "json"
+	}
+
+	c, ok := pipeline.LookupCodec(name)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, pipeline.ErrUnknownCodec)
+	}
+
+	return c, nil
+}
+
+// tlsConfig is the client TLS configuration of security, nil without
+// certificates
+func tlsConfig(security *pipeline.EdgeSecurity) (*tls.Config, error) {
+	if !security.TLSEnabled() {
+		return nil, nil
+	}
+
+	return security.ClientTLSConfig()
+}
+
+func partitionKey(topic string, partition int) string {
+	return fmt.Sprintf("%s/%d", topic, partition)
+}
+
+/*
+	groupCommits is the pipeline.CheckpointStore of a KafkaSource: offsets
+	are saved by committing them to the consumer group, which keeps them.
+*/
+type groupCommits struct {
+	reader *kafkago.Reader
+}
+
+func (g *groupCommits) SaveCheckpoint(ctx context.Context, offsets map[string]int64) error {
+	records := make([]kafkago.Message, 0, len(offsets))
+
+	for key, offset := range offsets {
+		i := strings.LastIndex(key, "/")
+		if i < 0 {
+			continue
+		}
+
+		partition, err := strconv.Atoi(key[i+1:])
+		if err != nil {
+			continue
+		}
+
+		records = append(records, kafkago.Message{Topic: key[:i], Partition: partition, Offset: offset})
+	}
+
+	return g.reader.CommitMessages(ctx, records...)
+}
+
+// LoadCheckpoint has nothing to load: the group resumes from its commits
+func (g *groupCommits) LoadCheckpoint(ctx context.Context) (map[string]int64, error) {
+	return map[string]int64{}, nil
+}
+
+/*
+	The KafkaSink writes items to Topic, encoded with the named Codec, "json"
+	by default, or as the raw data they carry when it is "raw". Key returns
+	the key of the record of an item, which Kafka partitions by, and records
+	have no key when it is nil.
+
+	Records carry the values of the context propagated across remote edges,
+	and the lineage of their item, in their headers, as pipeline.InjectContext
+	and pipeline.InjectTraces encode them. With a Security, they are also
+	signed with its token, which they never carry, and the brokers are
+	reached with its client TLS configuration when it has certificates.
+
+	Items are written in batches of BatchSize items, or those received
+	within Linger. Batches which fail to be written are failed, and sent to
+	the dead letter handlers. Items written are acked.
+*/
+type KafkaSink[E pipeline.Traceable] struct {
+	ChainName string
+
+	Brokers []string
+	Topic   string
+
+	Codec string
+	Key   func(item E) []byte `json:"-"`
+
+	BatchSize int
+	Linger    time.Duration
+
+	Security *pipeline.EdgeSecurity `json:"-"`
+
+	// Writer adjusts the producer before it starts
+	Writer func(writer *kafkago.Writer) `json:"-"`
+
+	writer *kafkago.Writer
+	codec  pipeline.Codec
+}
+
+func NewKafkaSink[E pipeline.Traceable](name string, brokers []string, topic string) *KafkaSink[E] {
+	return &KafkaSink[E]{
+		ChainName: name,
+		Brokers:   brokers,
+		Topic:     topic,
+	}
+}
+
+func (s *KafkaSink[E]) Name() string {
+	return fmt.Sprintf("KafkaSink/%s", s.ChainName)
+}
+
+func (s *KafkaSink[E]) Init(ctx context.Context) error {
+	if s.Topic == "" {
+		return ErrNoTopic
+	}
+
+	if _, err := tlsConfig(s.Security); err != nil {
+		return err
+	}
+
+	_, err := codec(s.Codec)
+	return err
+}
+
+func (s *KafkaSink[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	c, err := codec(s.Codec)
+
+	tlsCfg, tlsErr := tlsConfig(s.Security)
+	err = errors.Join(err, tlsErr)
+
+	if err != nil {
+		pipeline.ReportError(ctx, s, fmt.Errorf("%w: %w", err, pipeline.ErrFatal))
+	}
+
+	s.codec = c
+	s.writer = &kafkago.Writer{
+		Addr:     kafkago.TCP(s.Brokers...),
+		Topic:    s.Topic,
+		Balancer: &kafkago.Hash{},
+	}
+
+	if tlsCfg != nil {
+		s.writer.Transport = &kafkago.Transport{TLS: tlsCfg}
+	}
+
+	if s.Writer != nil {
+		s.Writer(s.writer)
+	}
+
+	write := s.write
+	if err != nil {
+		write = func(ctx context.Context, items []E) error {
+			return err
+		}
+	}
+
+	pipeline.ExecuteSink[E](ctx, s, input, output, s.BatchSize, s.Linger, nil, write)
+
+	if err := s.writer.Close(); err != nil {
+		pipeline.ReportError(ctx, s, fmt.Errorf("closing writer: %w", err))
+	}
+}
+
+func (s *KafkaSink[E]) write(ctx context.Context, items []E) error {
+	records := make([]kafkago.Message, 0, len(items))
+
+	// the token is read once per batch, and signs all its records
+	signer, err := s.Security.Signer()
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		value, err := s.encode(item)
+		if err != nil {
+			return err
+		}
+
+		headers, err := pipeline.InjectItem(ctx, item)
+		if err != nil {
+			return err
+		}
+
+		signer.Sign(headers, value)
+
+		record := kafkago.Message{Value: value}
+		if s.Key != nil {
+			record.Key = s.Key(item)
+		}
+
+		for key, value := range headers {
+			record.Headers = append(record.Headers, kafkago.Header{Key: key, Value: []byte(value)})
+		}
+
+		pipeline.ReleaseHeaders(headers)
+
+		records = append(records, record)
+	}
+
+	err = s.writer.WriteMessages(ctx, records...)
+
+	var writeErrors kafkago.WriteErrors
+	if errors.As(err, &writeErrors) {
+		return fmt.Errorf("%d of %d records not written: %w", writeErrors.Count(), len(records), err)
+	}
+
+	return err
+}
+
+func (s *KafkaSink[E]) encode(item E) ([]byte, error) {
+	if s.codec != nil {
+		return s.codec.Marshal(item)
+	}
+
+	raw, ok := pipeline.Traceable(item).(pipeline.RawCarrier)
+	if !ok {
+		return nil, fmt.Errorf("%T: %w", item, pipeline.ErrNotRaw)
+	}
+
+	return raw.RawBytes(), nil
+}
+
+/*
+	RegisterSource registers the KafkaSource as the "kafkasource" processor
+	type of definitions, configured with brokers, topics, group, codec,
+	commit_interval, skip_nacked, skip and security. Its items are new
+	values of the pipeline type, which must point to a struct embedding a
+	*pipeline.Acker, for their offsets to be committed, and carry the value
+	of their record when the codec is "raw", for which they must be
+	pipeline.RawSetters.
+*/
+func RegisterSource[E pipeline.Traceable]() {
+	pipeline.RegisterSourceType[E, *KafkaSource[E]]("kafkasource", newSource[E], marshalSource[E])
+}
+
+/*
+	RegisterSink registers the KafkaSink as the "kafkasink" processor type of
+	definitions, configured with brokers, topic, codec, batch_size, linger
+	and security.
+*/
+func RegisterSink[E pipeline.Traceable]() {
+	pipeline.RegisterProcessorType[E, *KafkaSink[E]]("kafkasink", newSink[E], marshalSink[E])
+}
+
+func newSource[E pipeline.Traceable](name string, cfg map[string]interface{}) (*KafkaSource[E], error) {
+	source := &KafkaSource[E]{ChainName: name}
+
+	source.Brokers = configStrings(cfg, "brokers")
+	source.Topics = configStrings(cfg, "topics")
+	source.GroupID, _ = cfg["group"].(string)
+	source.Codec, _ = cfg["codec"].(string)
+	source.SkipNacked, _ = cfg["skip_nacked"].(bool)
+	source.Skip, _ = cfg["skip"].(bool)
+
+	if interval, ok := cfg["commit_interval"].(string); ok {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid commit_interval: %w: %w", err, pipeline.ErrInvalidConfig)
+		}
+
+		source.CommitInterval = d
+	}
+
+	security, err := pipeline.EdgeSecurityFromConfig(cfg)
+	if err != nil {
+		return nil, errors.Join(err, pipeline.ErrInvalidConfig)
+	}
+
+	source.Security = security
+
+	if source.New, err = newItem[E](source.Codec == "raw"); err != nil {
+		return nil, errors.Join(err, pipeline.ErrInvalidConfig)
+	}
+
+	if err := source.Init(context.Background()); err != nil {
+		return nil, errors.Join(err, pipeline.ErrInvalidConfig)
+	}
+
+	return source, nil
+}
+
+func marshalSource[E pipeline.Traceable](source *KafkaSource[E]) (string, map[string]interface{}, error) {
+	cfg := map[string]interface{}{
+		"brokers": source.Brokers,
+		"topics":  source.Topics,
+		"group":   source.GroupID,
+	}
+
+	if source.Codec != "" {
+		cfg["codec"] = source.Codec
+	}
+
+	if source.CommitInterval > 0 {
+		cfg["commit_interval"] = source.CommitInterval.String()
+	}
+
+	if source.SkipNacked {
+		cfg["skip_nacked"] = true
+	}
+
+	if source.Skip {
+		cfg["skip"] = true
+	}
+
+	if source.Security != nil {
+		cfg["security"] = source.Security.Config()
+	}
+
+	return source.ChainName, cfg, nil
+}
+
+func newSink[E pipeline.Traceable](name string, cfg map[string]interface{}) (*KafkaSink[E], error) {
+	sink := &KafkaSink[E]{ChainName: name}
+
+	sink.Brokers = configStrings(cfg, "brokers")
+	sink.Topic, _ = cfg["topic"].(string)
+	sink.Codec, _ = cfg["codec"].(string)
+
+	if size, ok := cfg["batch_size"].(float64); ok {
+		sink.BatchSize = int(size)
+	}
+
+	if linger, ok := cfg["linger"].(string); ok {
+		d, err := time.ParseDuration(linger)
+		if err != nil {
+			return nil, fmt.Errorf("invalid linger: %w: %w", err, pipeline.ErrInvalidConfig)
+		}
+
+		sink.Linger = d
+	}
+
+	security, err := pipeline.EdgeSecurityFromConfig(cfg)
+	if err != nil {
+		return nil, errors.Join(err, pipeline.ErrInvalidConfig)
+	}
+
+	sink.Security = security
+
+	if err := sink.Init(context.Background()); err != nil {
+		return nil, errors.Join(err, pipeline.ErrInvalidConfig)
+	}
+
+	return sink, nil
+}
+
+func marshalSink[E pipeline.Traceable](sink *KafkaSink[E]) (string, map[string]interface{}, error) {
+	cfg := map[string]interface{}{
+		"brokers": sink.Brokers,
+		"topic":   sink.Topic,
+	}
+
+	if sink.Codec != "" {
+		cfg["codec"] = sink.Codec
+	}
+
+	if sink.BatchSize > 0 {
+		cfg["batch_size"] = sink.BatchSize
+	}
+
+	if sink.Linger > 0 {
+		cfg["linger"] = sink.Linger.String()
+	}
+
+	if sink.Security != nil {
+		cfg["security"] = sink.Security.Config()
+	}
+
+	return sink.ChainName, cfg, nil
+}
+
+// newItem is the New of sources declared in definitions: items are new
+// values of the pipeline type, with the Acker of their record, and its
+// value when raw
+func newItem[E pipeline.Traceable](raw bool) (func(msg *Message) E, error) {
+	item, err := pipeline.NewItem[E]()
+	if err != nil {
+		return nil, err
+	}
+
+	if !pipeline.SetAcker(item, nil) {
+		return nil, fmt.Errorf("%T: %w", item, pipeline.ErrNoAcker)
+	}
+
+	if _, ok := pipeline.Traceable(item).(pipeline.RawSetter); raw && !ok {
+		return nil, fmt.Errorf("%T: %w", item, pipeline.ErrNotRaw)
+	}
+
+	return func(msg *Message) E {
+		item, _ := pipeline.NewItem[E]()
+		pipeline.SetAcker(item, msg.Acker)
+
+		if setter, ok := pipeline.Traceable(item).(pipeline.RawSetter); ok && raw {
+			setter.SetRawBytes(msg.Value)
+		}
+
+		return item
+	}, nil
+}
+
+// configStrings returns the strings of the list at key
+func configStrings(cfg map[string]interface{}, key string) []string {
+	if values, ok := cfg[key].([]string); ok {
+		return values
+	}
+
+	values, _ := cfg[key].([]interface{})
+
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if value, ok := value.(string); ok {
+			result = append(result, value)
+		}
+	}
+
+	return result
+}
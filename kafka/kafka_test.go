@@ -0,0 +1,81 @@
+package kafka
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ca0s/pipeline"
+)
+
+type item struct {
+	*pipeline.Acker
+	Value string `json:"value"`
+}
+
+func (i *item) AddTrace(trace string) {}
+
+type unackable struct {
+	Value string `json:"value"`
+}
+
+func (u *unackable) AddTrace(trace string) {}
+
+func build[E pipeline.Traceable](t *testing.T, definition string) (pipeline.Processor[E], error) {
+	t.Helper()
+
+	sp := &pipeline.SerializedPipeline[E]{}
+	if err := json.Unmarshal([]byte(definition), sp); err != nil {
+		t.Fatal(err)
+	}
+
+	return sp.Pipeline()
+}
+
+func TestDefinitionsDeclareSourceAndSink(t *testing.T) {
+	RegisterSource[*item]()
+	RegisterSink[*item]()
+
+	definition := `{"type":"sequential","name":"bus","processors":[
+		{"type":"kafkasource","name":"in","cfg":{"brokers":["localhost:9092"],"topics":["events"],"group":"workers","commit_interval":"5s","security":{"token":"secret"}}},
+		{"type":"kafkasink","name":"out","cfg":{"brokers":["localhost:9092"],"topic":"results","batch_size":10,"linger":"1s"}}
+	]}`
+
+	p, err := build[*item](t, definition)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := p.(pipeline.Composite[*item]).Children()[0].(*pipeline.SourceStage[*item]).Source.(*KafkaSource[*item])
+	if source.GroupID != "workers" || source.Topics[0] != "events" || source.Security.Token != "secret" {
+		t.Fatalf("source not configured: %+v", source)
+	}
+
+	msg := &Message{Acker: pipeline.NewAcker(nil, nil)}
+	if made := source.New(msg); made.Acker != msg.Acker {
+		t.Fatal("items are not given the Acker of their record")
+	}
+
+	data, err := pipeline.MarshalPipeline(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(data), "secret") {
+		t.Fatalf("definition carries the token: %s", data)
+	}
+
+	if _, err := build[*item](t, string(data)); err != nil {
+		t.Fatalf("marshalled definition does not build: %v\n%s", err, data)
+	}
+}
+
+func TestDeclaredSourceNeedsAckableItems(t *testing.T) {
+	RegisterSource[*unackable]()
+
+	_, err := build[*unackable](t, `{"type":"kafkasource","name":"in","cfg":{"brokers":["localhost:9092"],"topics":["events"]}}`)
+	if !errors.Is(err, pipeline.ErrNoAcker) {
+		t.Fatalf("got %v, want ErrNoAcker", err)
+	}
+}
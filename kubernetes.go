@@ -0,0 +1,245 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/atomic"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	EventDefinitionReloaded = "definition_reloaded"
+	EventDefinitionInvalid  = "definition_invalid"
+)
+
+/*
+	The DefinitionWatcher loads a pipeline definition from a file, typically a
+	mounted ConfigMap, and calls OnChange every time its content changes.
+	Files named .yaml or .yml are read as YAML, and the others as JSON.
+
+	Kubernetes updates ConfigMap volumes by swapping a symlink in the mount
+	directory, so the whole directory is watched, and reloads are triggered
+	too when the process receives SIGHUP. Definitions that can not be parsed
+	are reported as events and otherwise ignored, keeping the current one.
+*/
+type DefinitionWatcher[E Traceable] struct {
+	Path     string
+	Debounce time.Duration
+
+	OnChange func(*SerializedPipeline[E])
+
+	last []byte
+}
+
+func NewDefinitionWatcher[E Traceable](path string, onChange func(*SerializedPipeline[E])) *DefinitionWatcher[E] {
+	return &DefinitionWatcher[E]{
+		Path:     path,
+		Debounce: 500 * time.Millisecond,
+		OnChange: onChange,
+	}
+}
+
+// Run loads the definition once, then watches it until ctx is done
+func (w *DefinitionWatcher[E]) Run(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(filepath.Dir(w.Path)); err != nil {
+		return err
+	}
+
+	if err := w.reload(ctx); err != nil {
+		return err
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var debounce <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case _, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+
+			debounce = time.After(w.Debounce)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+
+			Emit[E](ctx, nil, EventDefinitionInvalid, "watching %s: %s", w.Path, err)
+
+		case <-hup:
+			w.reload(ctx)
+
+		case <-debounce:
+			debounce = nil
+			w.reload(ctx)
+		}
+	}
+}
+
+func (w *DefinitionWatcher[E]) reload(ctx context.Context) error {
+	data, err := os.ReadFile(w.Path)
+	if err != nil {
+		Emit[E](ctx, nil, EventDefinitionInvalid, "reading %s: %s", w.Path, err)
+		return err
+	}
+
+	if bytes.Equal(data, w.last) {
+		return nil
+	}
+
+	unmarshal := json.Unmarshal
+	if format, _ := FormatForPath(w.Path); format == ExportYAML {
+		unmarshal = yaml.Unmarshal
+	}
+
+	definition := &SerializedPipeline[E]{}
+	if err := unmarshal(data, definition); err != nil {
+		Emit[E](ctx, nil, EventDefinitionInvalid, "parsing %s: %s", w.Path, err)
+		return err
+	}
+
+	w.last = data
+
+	Emit[E](ctx, nil, EventDefinitionReloaded, "loaded %s", w.Path)
+	w.OnChange(definition)
+
+	return nil
+}
+
+/*
+	DrainOnSignal blocks until SIGTERM or SIGINT is received, or ctx is done,
+	and then calls drain with a context expiring after timeout, the time left
+	before the pod is killed (terminationGracePeriodSeconds).
+*/
+func DrainOnSignal(ctx context.Context, timeout time.Duration, drain func(ctx context.Context) error) error {
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(term)
+
+	select {
+	case <-term:
+	case <-ctx.Done():
+	}
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return drain(drainCtx)
+}
+
+/*
+	Health backs the liveness and readiness probes of the daemon. It starts
+	live and not ready.
+*/
+type Health struct {
+	live  atomic.Bool
+	ready atomic.Bool
+
+	lock   sync.RWMutex
+	checks map[string]func() error
+}
+
+func NewHealth() *Health {
+	h := &Health{
+		checks: make(map[string]func() error),
+	}
+	h.live.Store(true)
+
+	return h
+}
+
+func (h *Health) SetLive(live bool) {
+	h.live.Store(live)
+}
+
+func (h *Health) SetReady(ready bool) {
+	h.ready.Store(ready)
+}
+
+func (h *Health) Live() bool {
+	return h.live.Load()
+}
+
+// AddCheck registers a check that must pass for the daemon to be ready
+func (h *Health) AddCheck(name string, check func() error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.checks[name] = check
+}
+
+// Ready returns nil if the daemon is ready, or the reasons it is not
+func (h *Health) Ready() map[string]string {
+	failures := make(map[string]string)
+
+	if !h.ready.Load() {
+		failures["pipeline"] = "not ready"
+	}
+
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	for name, check := range h.checks {
+		if err := check(); err != nil {
+			failures[name] = err.Error()
+		}
+	}
+
+	if len(failures) == 0 {
+		return nil
+	}
+
+	return failures
+}
+
+// Handler serves /healthz for liveness and /readyz for readiness
+func (h *Health) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if !h.live.Load() {
+			http.Error(w, "not live", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Write([]byte("ok"))
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		failures := h.Ready()
+		if failures == nil {
+			w.Write([]byte("ok"))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(failures)
+	})
+
+	return mux
+}
@@ -0,0 +1,169 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"go.uber.org/atomic"
+)
+
+const (
+	DefaultLabelLimit = 100
+	OtherLabel        = "other"
+)
+
+/*
+	A LabelLimit bounds the number of distinct values tracked for a stats label
+	dimension, such as tenant or category. Allowed values are always tracked on
+	their own. Other values are tracked while there are less than Max of them,
+	and counted under OtherLabel afterwards.
+*/
+type LabelLimit struct {
+	Max     int
+	Allowed map[string]struct{}
+}
+
+/*
+	LabelCounters counts items per label dimension and value
+*/
+type LabelCounters struct {
+	lock     sync.RWMutex
+	counters map[string]map[string]*atomic.Int64
+}
+
+func newLabelCounters() *LabelCounters {
+	return &LabelCounters{
+		counters: make(map[string]map[string]*atomic.Int64),
+	}
+}
+
+func (lc *LabelCounters) inc(dimension, value string, limit LabelLimit) {
+	lc.lock.RLock()
+	counter, ok := lc.counters[dimension][value]
+	lc.lock.RUnlock()
+
+	if ok {
+		counter.Inc()
+		return
+	}
+
+	lc.lock.Lock()
+	defer lc.lock.Unlock()
+
+	values, ok := lc.counters[dimension]
+	if !ok {
+		values = make(map[string]*atomic.Int64)
+		lc.counters[dimension] = values
+	}
+
+	if _, allowed := limit.Allowed[value]; !allowed && len(values) >= limit.Max {
+		value = OtherLabel
+	}
+
+	counter, ok = values[value]
+	if !ok {
+		counter = atomic.NewInt64(0)
+		values[value] = counter
+	}
+
+	counter.Inc()
+}
+
+// carryOver adds the counts of previous, from an earlier generation
+func (lc *LabelCounters) carryOver(previous *LabelCounters) {
+	previous.lock.RLock()
+	defer previous.lock.RUnlock()
+
+	lc.lock.Lock()
+	defer lc.lock.Unlock()
+
+	for dimension, values := range previous.counters {
+		if lc.counters[dimension] == nil {
+			lc.counters[dimension] = make(map[string]*atomic.Int64)
+		}
+
+		for value, counter := range values {
+			if lc.counters[dimension][value] == nil {
+				lc.counters[dimension][value] = atomic.NewInt64(0)
+			}
+
+			lc.counters[dimension][value].Add(counter.Load())
+		}
+	}
+}
+
+// Get returns the count of a label value
+func (lc *LabelCounters) Get(dimension, value string) int64 {
+	lc.lock.RLock()
+	defer lc.lock.RUnlock()
+
+	counter, ok := lc.counters[dimension][value]
+	if !ok {
+		return 0
+	}
+
+	return counter.Load()
+}
+
+func (lc *LabelCounters) MarshalJSON() ([]byte, error) {
+	lc.lock.RLock()
+	defer lc.lock.RUnlock()
+
+	data := make(map[string]map[string]int64, len(lc.counters))
+
+	for dimension, values := range lc.counters {
+		data[dimension] = make(map[string]int64, len(values))
+
+		for value, counter := range values {
+			data[dimension][value] = counter.Load()
+		}
+	}
+
+	return json.Marshal(data)
+}
+
+// LimitLabels sets the cardinality protection of a label dimension
+func (db *StatDB[E]) LimitLabels(dimension string, max int, allowed ...string) {
+	limit := LabelLimit{
+		Max:     max,
+		Allowed: make(map[string]struct{}, len(allowed)),
+	}
+
+	for _, value := range allowed {
+		limit.Allowed[value] = struct{}{}
+	}
+
+	db.itemLock.Lock()
+	defer db.itemLock.Unlock()
+
+	db.labelLimits[dimension] = limit
+}
+
+func (db *StatDB[E]) labelLimit(dimension string) LabelLimit {
+	db.itemLock.RLock()
+	defer db.itemLock.RUnlock()
+
+	limit, ok := db.labelLimits[dimension]
+	if !ok {
+		return LabelLimit{Max: DefaultLabelLimit}
+	}
+
+	return limit
+}
+
+func (db *StatDB[E]) trackLabel(p Processor[E], dimension, value string) {
+	stats := db.getStats(p)
+	stats.Labels.inc(dimension, value, db.labelLimit(dimension))
+}
+
+// TrackLabel counts an item of the processor under a label value, such as the
+// tenant or category it belongs to
+func TrackLabel[E Traceable](ctx context.Context, processor Processor[E], dimension, value string) {
+	statDB, ok := ctx.Value(PipelineStatDB).(*StatDB[E])
+	if !ok {
+		return
+	}
+
+	statDB.trackLabel(processor, dimension, value)
+}
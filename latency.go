@@ -0,0 +1,123 @@
+package pipeline
+
+import (
+	"sync"
+	"time"
+)
+
+// at most this many items are waiting to come out of a processor for their
+// latency to be measured, items it drops being forgotten eventually
+const DefaultMaxPendingLatencies = 10000
+
+/*
+	Identifiable is implemented by items carrying an ID, unique to each of
+	them, with which the stats correlate an item entering a processor with
+	the same item coming out of it to measure its latency. Items of
+	comparable types, such as pointers, are correlated by their value
+	when they do not implement it.
+*/
+type Identifiable interface {
+	Traceable
+	ItemID() string
+}
+
+/*
+	latencyTracker keeps when the items entered a processor, in two
+	generations: once the current one is full it becomes the previous one,
+	and the items of the previous one, most likely dropped, are forgotten.
+*/
+type latencyTracker struct {
+	lock     sync.Mutex
+	current  map[interface{}]time.Time
+	previous map[interface{}]time.Time
+}
+
+func itemKey(item Traceable) (interface{}, bool) {
+	if identified, ok := item.(Identifiable); ok {
+		return identified.ItemID(), true
+	}
+
+	if !hashable(item) {
+		return nil, false
+	}
+
+	return item, true
+}
+
+func (t *latencyTracker) start(key interface{}, at time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.current == nil {
+		t.current = make(map[interface{}]time.Time)
+	}
+
+	// the maps are swapped, the forgotten one being reused as the current
+	if len(t.current) >= DefaultMaxPendingLatencies/2 {
+		t.previous, t.current = t.current, t.previous
+		if t.current == nil {
+			t.current = make(map[interface{}]time.Time)
+		}
+
+		clear(t.current)
+	}
+
+	t.current[key] = at
+}
+
+// end returns when the item entered the processor, and forgets it
+func (t *latencyTracker) end(key interface{}) (time.Time, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if at, found := t.current[key]; found {
+		delete(t.current, key)
+		return at, true
+	}
+
+	if at, found := t.previous[key]; found {
+		delete(t.previous, key)
+		return at, true
+	}
+
+	return time.Time{}, false
+}
+
+func (t *latencyTracker) reset() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.current, t.previous = nil, nil
+}
+
+func (db *StatDB[E]) trackItemStarted(p Processor[E], item Traceable) {
+	key, ok := itemKey(item)
+	if !ok {
+		return
+	}
+
+	stats := db.getStats(p)
+	stats.pending.start(key, time.Now())
+}
+
+// trackItemDone records the latency of an item sent on by p, or only
+// forgets it when it was passed through unprocessed
+func (db *StatDB[E]) trackItemDone(p Processor[E], item Traceable, record bool) {
+	key, ok := itemKey(item)
+	if !ok {
+		return
+	}
+
+	stats := db.getStats(p)
+
+	at, found := stats.pending.end(key)
+	if found && record {
+		stats.Latency.RecordDuration(time.Since(at))
+	}
+}
+
+// LatencyQuantile returns an estimate of the q quantile of the time the items
+// took to come out of the processor
+func (s *Stats) LatencyQuantile(q float64) time.Duration {
+	return time.Duration(s.Latency.Quantile(q) * float64(time.Second))
+}
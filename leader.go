@@ -0,0 +1,151 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	EventLeaderElected  = "leader_elected"
+	EventLeadershipLost = "leadership_lost"
+)
+
+/*
+	A LeaderElector is a backend able to decide which instance of a deployment
+	is the leader: a Kubernetes lease, an etcd election, a redis lock...
+
+	Campaign blocks until leadership is acquired or the context is done. The
+	returned channel is closed if leadership is lost afterwards. Resign gives
+	leadership up so a standby instance can take over.
+
+	LocalLease and FileLockElector elect between the instances of a host, and
+	the LeaseElector of the redis package between those of a deployment.
+*/
+type LeaderElector interface {
+	Campaign(ctx context.Context) (<-chan struct{}, error)
+	Resign(ctx context.Context) error
+}
+
+/*
+	RunAsLeader runs a singleton pipeline: run is only called while this
+	instance is the leader, others stand by in Campaign.
+
+	If leadership is lost the context given to run is cancelled, and once run
+	returns the instance goes back to standby and campaigns again. run should
+	resume from its last checkpoint whenever it is started. RunAsLeader returns
+	when run returns while still leading, or when ctx is done.
+*/
+func RunAsLeader(ctx context.Context, elector LeaderElector, retry time.Duration, run func(ctx context.Context) error) error {
+	for {
+		lost, err := elector.Campaign(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			select {
+			case <-time.After(retry):
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		Emit[Traceable](ctx, nil, EventLeaderElected, "acquired leadership")
+
+		leaderCtx, cancel := context.WithCancel(ctx)
+
+		lostLeadership := make(chan struct{})
+		go func() {
+			select {
+			case <-lost:
+				close(lostLeadership)
+				cancel()
+			case <-leaderCtx.Done():
+			}
+		}()
+
+		err = run(leaderCtx)
+		cancel()
+
+		select {
+		case <-lostLeadership:
+			Emit[Traceable](ctx, nil, EventLeadershipLost, "lost leadership, standing by")
+			continue
+		default:
+		}
+
+		resignCtx, resignCancel := context.WithTimeout(context.Background(), retry)
+		elector.Resign(resignCtx)
+		resignCancel()
+
+		return err
+	}
+}
+
+/*
+	LocalLease elects a leader between candidates living in the same process,
+	which is mostly useful for tests and for single host deployments.
+*/
+type LocalLease struct {
+	lock     sync.Mutex
+	leader   *localElector
+	released chan struct{}
+}
+
+func NewLocalLease() *LocalLease {
+	return &LocalLease{
+		released: make(chan struct{}),
+	}
+}
+
+// Elector returns a new candidate for the lease
+func (l *LocalLease) Elector() LeaderElector {
+	return &localElector{lease: l}
+}
+
+type localElector struct {
+	lease *LocalLease
+	lost  chan struct{}
+}
+
+func (e *localElector) Campaign(ctx context.Context) (<-chan struct{}, error) {
+	for {
+		e.lease.lock.Lock()
+
+		if e.lease.leader == nil {
+			e.lease.leader = e
+			e.lost = make(chan struct{})
+			e.lease.lock.Unlock()
+
+			return e.lost, nil
+		}
+
+		released := e.lease.released
+		e.lease.lock.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (e *localElector) Resign(ctx context.Context) error {
+	e.lease.lock.Lock()
+	defer e.lease.lock.Unlock()
+
+	if e.lease.leader != e {
+		return nil
+	}
+
+	close(e.lost)
+	e.lease.leader = nil
+
+	close(e.lease.released)
+	e.lease.released = make(chan struct{})
+
+	return nil
+}
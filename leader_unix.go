@@ -0,0 +1,75 @@
+//go:build unix
+
+package pipeline
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"time"
+)
+
+/*
+	A FileLockElector elects the instance holding an exclusive flock on a
+	file, for instances sharing a host or a filesystem with working locks.
+	Leadership is only lost when the process resigns or dies.
+*/
+type FileLockElector struct {
+	Path         string
+	PollInterval time.Duration
+
+	file *os.File
+	lost chan struct{}
+}
+
+func NewFileLockElector(path string) *FileLockElector {
+	return &FileLockElector{
+		Path:         path,
+		PollInterval: time.Second,
+	}
+}
+
+func (e *FileLockElector) Campaign(ctx context.Context) (<-chan struct{}, error) {
+	fd, err := os.OpenFile(e.Path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		err := syscall.Flock(int(fd.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+
+		if err != syscall.EWOULDBLOCK {
+			fd.Close()
+			return nil, err
+		}
+
+		select {
+		case <-time.After(e.PollInterval):
+		case <-ctx.Done():
+			fd.Close()
+			return nil, ctx.Err()
+		}
+	}
+
+	e.file = fd
+	e.lost = make(chan struct{})
+
+	return e.lost, nil
+}
+
+func (e *FileLockElector) Resign(ctx context.Context) error {
+	if e.file == nil {
+		return nil
+	}
+
+	err := syscall.Flock(int(e.file.Fd()), syscall.LOCK_UN)
+	e.file.Close()
+	e.file = nil
+
+	close(e.lost)
+
+	return err
+}
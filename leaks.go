@@ -0,0 +1,144 @@
+package pipeline
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"regexp"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var PipelineLeakDetection PipelineContextKey = "pipeline_leak_detection"
+
+const EventGoroutineLeak = "goroutine_leak"
+
+var processorIDLabelRe = regexp.MustCompile(`"` + ProcessorIDLabel + `":"([^"]+)"`)
+
+/*
+	WithLeakDetection verifies that every processor run by a composite leaves
+	no goroutine behind once it has finished.
+
+	Goroutines are attributed to processors through the labels set when they
+	are executed, which are inherited by every goroutine a processor spawns. A
+	grace period after Execute returns lets goroutines that are already on
+	their way out finish before they are counted.
+
+	Leaks are reported as EventGoroutineLeak events and logged. Detection takes
+	a goroutine profile per finished processor, so it is meant for development
+	and debugging rather than for busy production pipelines.
+*/
+func WithLeakDetection(ctx context.Context, grace time.Duration) context.Context {
+	return context.WithValue(ctx, PipelineLeakDetection, grace)
+}
+
+func checkLeaks[E Traceable](ctx context.Context, p Processor[E]) {
+	grace, ok := ctx.Value(PipelineLeakDetection).(time.Duration)
+	if !ok {
+		return
+	}
+
+	id := processorID(p)
+
+	go func() {
+		// the checker must not be counted as a goroutine of the composite
+		// that ran p
+		pprof.SetGoroutineLabels(context.Background())
+
+		time.Sleep(grace)
+
+		leaked := goroutinesByProcessor()[id]
+		if leaked == 0 {
+			return
+		}
+
+		Emit[E](ctx, p, EventGoroutineLeak, "%d goroutine(s) still running after the processor finished", leaked)
+		Log[E](ctx, p, "%d goroutine(s) still running after the processor finished", leaked)
+	}()
+}
+
+// goroutinesByProcessor counts running goroutines by processor id label
+func goroutinesByProcessor() map[string]int {
+	counts := make(map[string]int)
+
+	for _, group := range goroutineGroups() {
+		if group.processorID != "" {
+			counts[group.processorID] += group.count
+		}
+	}
+
+	return counts
+}
+
+/*
+	goroutineGroup is an entry of the goroutine profile: the goroutines sharing
+	a stack and labels
+*/
+type goroutineGroup struct {
+	count       int
+	processorID string
+	labels      string
+	stack       string
+}
+
+func goroutineGroups() []goroutineGroup {
+	var groups []goroutineGroup
+
+	buf := bytes.NewBuffer(nil)
+	if err := pprof.Lookup("goroutine").WriteTo(buf, 1); err != nil {
+		return groups
+	}
+
+	scanner := bufio.NewScanner(buf)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	var group *goroutineGroup
+	var stack strings.Builder
+
+	flush := func() {
+		if group != nil {
+			group.stack = stack.String()
+			groups = append(groups, *group)
+		}
+
+		group = nil
+		stack.Reset()
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if n, _, found := strings.Cut(line, " @ "); found {
+			flush()
+
+			group = &goroutineGroup{}
+			group.count, _ = strconv.Atoi(n)
+			continue
+		}
+
+		if group == nil {
+			continue
+		}
+
+		if labels, found := strings.CutPrefix(line, "# labels: "); found {
+			group.labels = labels
+
+			if m := processorIDLabelRe.FindStringSubmatch(line); m != nil {
+				group.processorID = m[1]
+			}
+
+			continue
+		}
+
+		if strings.HasPrefix(line, "#\t") {
+			stack.WriteString(line)
+			stack.WriteString("\n")
+		}
+	}
+
+	flush()
+
+	return groups
+}
@@ -0,0 +1,250 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrNotBuilt          = fmt.Errorf("pipeline not built")
+	ErrUnknownDependency = fmt.Errorf("unknown dependency")
+	ErrDependencyCycle   = fmt.Errorf("dependency cycle between stages")
+)
+
+const EventStageReady = "stage_ready"
+
+/*
+	Initializer is implemented by processors preparing themselves before any
+	item is sent to them, such as opening connections or loading data.
+*/
+type Initializer interface {
+	Init(ctx context.Context) error
+}
+
+/*
+	HealthChecker is implemented by processors able to verify they can work,
+	such as pinging the services they depend on.
+*/
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) error
+}
+
+/*
+	Build prepares the processors of root before they run: Init is called on
+	every Initializer, parents before their children, and then HealthCheck on
+	every HealthChecker. Build stops at the first Init failing. Health checks
+	are all run, and their failures returned together. Errors are prefixed by
+	the Walk path of their processor.
+*/
+func Build[E Traceable](ctx context.Context, root Processor[E]) error {
+	_, err := startup(ctx, root, nil)
+	return err
+}
+
+/*
+	A StageStartup tells how a processor was started by Build: how long its
+	Init took, and whether it is ready to receive items, or why it is not.
+*/
+type StageStartup struct {
+	Path     string        `json:"path"`
+	Name     string        `json:"name"`
+	Ready    bool          `json:"ready"`
+	Duration time.Duration `json:"duration"`
+	Err      string        `json:"error,omitempty"`
+}
+
+type startupStage[E Traceable] struct {
+	path string
+	proc Processor[E]
+	deps []int
+}
+
+/*
+	startup initializes the processors of root in Walk order, except that the
+	processors a stage depends on, looked up by Walk path then by name, are
+	initialized before it. It returns the startup of every processor reached.
+*/
+func startup[E Traceable](ctx context.Context, root Processor[E], dependsOn map[string][]string) ([]StageStartup, error) {
+	var stages []*startupStage[E]
+
+	Walk(root, func(path string, p Processor[E]) {
+		stages = append(stages, &startupStage[E]{path: path, proc: p})
+	})
+
+	order, err := startupOrder(stages, dependsOn)
+	if err != nil {
+		return nil, err
+	}
+
+	var report []StageStartup
+
+	for _, stage := range order {
+		status := StageStartup{Path: stage.path, Name: stage.proc.Name(), Ready: true}
+
+		if initializer, ok := stage.proc.(Initializer); ok {
+			started := time.Now()
+			initErr := initializer.Init(ctx)
+			status.Duration = time.Since(started)
+
+			if initErr != nil {
+				status.Ready = false
+				status.Err = initErr.Error()
+				report = append(report, status)
+
+				return report, fmt.Errorf("%s: init: %w", stage.path, initErr)
+			}
+		}
+
+		report = append(report, status)
+	}
+
+	var errs []error
+
+	for i, stage := range order {
+		checker, ok := stage.proc.(HealthChecker)
+		if !ok {
+			continue
+		}
+
+		if healthErr := checker.HealthCheck(ctx); healthErr != nil {
+			report[i].Ready = false
+			report[i].Err = healthErr.Error()
+			errs = append(errs, fmt.Errorf("%s: health check: %w", stage.path, healthErr))
+		}
+	}
+
+	for i, stage := range order {
+		if report[i].Ready {
+			Emit[E](ctx, stage.proc, EventStageReady, "ready in %s", report[i].Duration)
+		}
+	}
+
+	return report, errors.Join(errs...)
+}
+
+// startupOrder sorts stages after their dependencies, keeping the Walk order
+// otherwise
+func startupOrder[E Traceable](stages []*startupStage[E], dependsOn map[string][]string) ([]*startupStage[E], error) {
+	for _, stage := range stages {
+		deps, ok := dependsOn[stage.path]
+		if !ok {
+			deps = dependsOn[stage.proc.Name()]
+		}
+
+		for _, dep := range deps {
+			found := -1
+
+			for i, other := range stages {
+				if other.path == dep {
+					found = i
+					break
+				}
+
+				if found < 0 && other.proc.Name() == dep {
+					found = i
+				}
+			}
+
+			if found < 0 {
+				return nil, fmt.Errorf("%s depends on %s: %w", stage.path, dep, ErrUnknownDependency)
+			}
+
+			stage.deps = append(stage.deps, found)
+		}
+	}
+
+	order := make([]*startupStage[E], 0, len(stages))
+	done := make([]bool, len(stages))
+
+	for len(order) < len(stages) {
+		next := -1
+
+		for i, stage := range stages {
+			if done[i] {
+				continue
+			}
+
+			ready := true
+			for _, dep := range stage.deps {
+				ready = ready && done[dep]
+			}
+
+			if ready {
+				next = i
+				break
+			}
+		}
+
+		if next < 0 {
+			return nil, ErrDependencyCycle
+		}
+
+		done[next] = true
+		order = append(order, stages[next])
+	}
+
+	return order, nil
+}
+
+/*
+	Build initializes and verifies the pipeline of the Runner, as Build does,
+	without starting the intake. Stages listed in DependsOn are initialized
+	after the stages they depend on, and the readiness of every stage is kept
+	in the report. When Health is set, the health checks of the processors are
+	registered with it.
+*/
+func (r *Runner[E]) Build(ctx context.Context) error {
+	r.setState(ctx, StateStarting, "building")
+
+	stages, err := startup(ctx, r.Pipeline, r.DependsOn)
+
+	r.lock.Lock()
+	r.built = err == nil
+	r.startup = stages
+	r.lock.Unlock()
+
+	if err != nil {
+		r.setState(ctx, StateFailed, err.Error())
+		return err
+	}
+
+	if r.Health == nil {
+		return nil
+	}
+
+	Walk(r.Pipeline, func(path string, p Processor[E]) {
+		if checker, ok := p.(HealthChecker); ok {
+			r.Health.AddCheck(path, func() error {
+				return checker.HealthCheck(context.Background())
+			})
+		}
+	})
+
+	return nil
+}
+
+/*
+	Open starts the intake of a pipeline built with Build, and returns the
+	report of the run once it has finished, as Run does. Health is ready while
+	it runs.
+*/
+func (r *Runner[E]) Open(ctx context.Context) (*RunReport, error) {
+	r.lock.Lock()
+	built := r.built
+	r.lock.Unlock()
+
+	if !built {
+		return nil, ErrNotBuilt
+	}
+
+	r.setState(ctx, StateStarting, "")
+
+	if r.Health != nil {
+		r.Health.SetReady(true)
+		defer r.Health.SetReady(false)
+	}
+
+	return r.run(ctx)
+}
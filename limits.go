@@ -0,0 +1,74 @@
+package pipeline
+
+import (
+	"fmt"
+)
+
+var ErrDefinitionLimit = fmt.Errorf("definition limit exceeded")
+
+/*
+	DefinitionLimits bound the topologies Pipeline builds from definitions,
+	for the programs building definitions they do not trust: MaxDepth is
+	the deepest nesting of composites, MaxNodes the number of processors the
+	tree is made of, pool replicas included, and MaxWidth the number of
+	children of a composite or of replicas of a pool.
+
+	Limits left at zero are the ones of DefaultDefinitionLimits, and
+	negative ones are not enforced.
+*/
+type DefinitionLimits struct {
+	MaxDepth int
+	MaxNodes int
+	MaxWidth int
+}
+
+var DefaultDefinitionLimits = DefinitionLimits{
+	MaxDepth: 64,
+	MaxNodes: 10000,
+	MaxWidth: 1000,
+}
+
+func (l DefinitionLimits) orDefault() DefinitionLimits {
+	if l.MaxDepth == 0 {
+		l.MaxDepth = DefaultDefinitionLimits.MaxDepth
+	}
+
+	if l.MaxNodes == 0 {
+		l.MaxNodes = DefaultDefinitionLimits.MaxNodes
+	}
+
+	if l.MaxWidth == 0 {
+		l.MaxWidth = DefaultDefinitionLimits.MaxWidth
+	}
+
+	return l
+}
+
+// definitionBudget counts the processors built for a definition
+type definitionBudget struct {
+	limits DefinitionLimits
+	nodes  int
+}
+
+// check counts a processor at depth having width children
+func (b *definitionBudget) check(depth, width int) error {
+	b.nodes++
+
+	if b.limits.MaxNodes > 0 && b.nodes > b.limits.MaxNodes {
+		return fmt.Errorf("more than %d processors: %w", b.limits.MaxNodes, ErrDefinitionLimit)
+	}
+
+	if b.limits.MaxDepth > 0 && depth > b.limits.MaxDepth {
+		return fmt.Errorf("deeper than %d: %w", b.limits.MaxDepth, ErrDefinitionLimit)
+	}
+
+	return b.checkWidth(width)
+}
+
+func (b *definitionBudget) checkWidth(width int) error {
+	if b.limits.MaxWidth > 0 && width > b.limits.MaxWidth {
+		return fmt.Errorf("width %d, more than %d: %w", width, b.limits.MaxWidth, ErrDefinitionLimit)
+	}
+
+	return nil
+}
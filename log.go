@@ -17,6 +17,11 @@ func WithLogLevel(ctx context.Context, level int) context.Context {
 
 func Log[E Traceable](ctx context.Context, proc Processor[E], fmts string, args ...interface{}) {
 	if ctx.Value(PipeLineLogLevel) == PipelineLogLevelDebug {
+		if runID := RunID(ctx); runID != "" {
+			log.Printf("[%s] [%s] %s", runID, proc.Name(), fmt.Sprintf(fmts, args...))
+			return
+		}
+
 		log.Printf("[%s] %s", proc.Name(), fmt.Sprintf(fmts, args...))
 	}
 }
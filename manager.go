@@ -0,0 +1,500 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+const (
+	EventPipelineSwapped = "pipeline_swapped"
+	EventPipelineDrained = "pipeline_drained"
+	EventGreenStarted    = "green_started"
+	EventPipelineCutover = "pipeline_cutover"
+	EventGreenRolledBack = "green_rolled_back"
+)
+
+var ErrManagerStopped = fmt.Errorf("pipeline manager not running")
+var ErrNoGreen = fmt.Errorf("no green pipeline")
+var ErrNoBlue = fmt.Errorf("no blue pipeline")
+
+/*
+	The PipelineManager runs a pipeline whose topology can be replaced while
+	it runs, by Swap, Reload with a new definition, or Watch, which reloads
+	the definition at Path every time it changes.
+
+	The new pipeline is built with Build in the context of the manager, and
+	kept out if that fails. Once it is, the input of the manager is bridged
+	to it, and the input of the old one closed, so it drains whatever it
+	has in flight, for DrainTimeout at most when set, before it is
+	cancelled. The output of both is forwarded to the output of the manager
+	meanwhile, so no item is lost. Items wait in the input until the
+	manager has a pipeline.
+
+	Instead of replacing the pipeline at once, StartGreen, or ReloadGreen
+	with a definition, runs the new version, green, next to the current one,
+	blue, against the same input: every item goes to green with the
+	probability of the split, changed with SetSplit. Cutover then makes green
+	the pipeline, draining blue as Swap does, and Rollback drains green and
+	keeps blue.
+
+	Swapped pipelines are new processors, with stats of their own: callers
+	carrying stats over call NewGeneration on their StatDB once Swap
+	returns.
+*/
+type PipelineManager[E Traceable] struct {
+	ChainName string
+	Path      string
+
+	// Factory builds the "processor" leaves of the definitions which have
+	// no factory of their own
+	Factory ProcessorFactory[E]
+
+	DrainTimeout time.Duration
+
+	lock    sync.Mutex
+	state   stateMachine
+	current Processor[E]
+	green   Processor[E]
+	split   atomic.Float64
+	running bool
+	swaps   chan *pipelineSwap[E]
+	stopped chan struct{}
+}
+
+type swapKind int
+
+const (
+	swapReplace swapKind = iota
+	swapGreen
+	swapCutover
+	swapRollback
+)
+
+// pipelineSwap is a change of pipeline waiting for the running manager
+type pipelineSwap[E Traceable] struct {
+	kind swapKind
+	root Processor[E]
+	done chan error
+}
+
+// managedPipeline is a pipeline started by a manager
+type managedPipeline[E Traceable] struct {
+	root    Processor[E]
+	input   chan E
+	cancel  context.CancelFunc
+	drained chan struct{}
+}
+
+func NewPipelineManager[E Traceable](name string, root Processor[E]) *PipelineManager[E] {
+	return &PipelineManager[E]{
+		ChainName: name,
+		current:   root,
+	}
+}
+
+// Current returns the pipeline the manager sends its input to
+func (m *PipelineManager[E]) Current() Processor[E] {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.current
+}
+
+// Green returns the green pipeline running next to the current one, if any
+func (m *PipelineManager[E]) Green() Processor[E] {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.green
+}
+
+func (m *PipelineManager[E]) Children() []Processor[E] {
+	return nonNil([]Processor[E]{m.Current(), m.Green()})
+}
+
+/*
+	Swap replaces the pipeline by root, and returns once root runs in place
+	of the old one, or the error of its Build. Managers which are not
+	running only keep root, for the Runner to build.
+*/
+func (m *PipelineManager[E]) Swap(root Processor[E]) error {
+	if isNil(root) {
+		return ErrNilProcessor
+	}
+
+	m.lock.Lock()
+	if !m.running {
+		m.current = root
+		m.lock.Unlock()
+		return nil
+	}
+
+	m.lock.Unlock()
+
+	err := m.request(swapReplace, root)
+	if err == ErrManagerStopped {
+		return m.Swap(root)
+	}
+
+	return err
+}
+
+/*
+	StartGreen builds green and runs it next to the current pipeline, with
+	split of the items, until Cutover or Rollback. A green pipeline already
+	running is replaced, and drained. Managers which are not running fail
+	with ErrManagerStopped, and those running no pipeline yet with
+	ErrNoBlue: Swap starts their first one.
+*/
+func (m *PipelineManager[E]) StartGreen(green Processor[E], split float64) error {
+	if isNil(green) {
+		return ErrNilProcessor
+	}
+
+	m.SetSplit(split)
+
+	return m.request(swapGreen, green)
+}
+
+// ReloadGreen validates and builds definition, and starts it as green
+func (m *PipelineManager[E]) ReloadGreen(definition *SerializedPipeline[E], split float64) error {
+	if definition.processorFactory == nil && m.Factory != nil {
+		definition.SetProcessorFactory(m.Factory)
+	}
+
+	if err := definition.Validate(); err != nil {
+		return err
+	}
+
+	root, err := definition.Pipeline()
+	if err != nil {
+		return err
+	}
+
+	return m.StartGreen(root, split)
+}
+
+// Split returns the fraction of the items sent to green
+func (m *PipelineManager[E]) Split() float64 {
+	return m.split.Load()
+}
+
+// SetSplit changes the fraction of the items sent to green. Values are
+// clamped to [0, 1].
+func (m *PipelineManager[E]) SetSplit(ratio float64) {
+	m.split.Store(min(max(ratio, 0), 1))
+}
+
+// Cutover makes green the pipeline, and drains the previous one, or fails
+// with ErrNoGreen
+func (m *PipelineManager[E]) Cutover() error {
+	return m.request(swapCutover, nil)
+}
+
+// Rollback drains green and keeps the current pipeline, or fails with
+// ErrNoGreen
+func (m *PipelineManager[E]) Rollback() error {
+	return m.request(swapRollback, nil)
+}
+
+// request hands a change of pipeline to the running manager, and returns
+// its result
+func (m *PipelineManager[E]) request(kind swapKind, root Processor[E]) error {
+	m.lock.Lock()
+	if !m.running {
+		m.lock.Unlock()
+
+		if kind == swapCutover || kind == swapRollback {
+			return ErrNoGreen
+		}
+
+		return ErrManagerStopped
+	}
+
+	swaps, stopped := m.swaps, m.stopped
+	m.lock.Unlock()
+
+	swap := &pipelineSwap[E]{kind: kind, root: root, done: make(chan error, 1)}
+
+	select {
+	case swaps <- swap:
+		return <-swap.done
+	case <-stopped:
+		return ErrManagerStopped
+	}
+}
+
+// Reload validates and builds definition, and swaps the pipeline for it
+func (m *PipelineManager[E]) Reload(definition *SerializedPipeline[E]) error {
+	if definition.processorFactory == nil && m.Factory != nil {
+		definition.SetProcessorFactory(m.Factory)
+	}
+
+	if err := definition.Validate(); err != nil {
+		return err
+	}
+
+	root, err := definition.Pipeline()
+	if err != nil {
+		return err
+	}
+
+	return m.Swap(root)
+}
+
+/*
+	Watch reloads the pipeline from the definition at Path, once and then
+	every time it changes, as a DefinitionWatcher, until ctx is done.
+	Definitions failing to reload are emitted as EventDefinitionInvalid,
+	and the pipeline kept.
+*/
+func (m *PipelineManager[E]) Watch(ctx context.Context) error {
+	watcher := NewDefinitionWatcher[E](m.Path, func(definition *SerializedPipeline[E]) {
+		if err := m.Reload(definition); err != nil {
+			Log[E](ctx, m, "reloading %s failed: %s", m.Path, err)
+			Emit[E](ctx, m, EventDefinitionInvalid, "reloading %s: %s", m.Path, err)
+		}
+	})
+
+	return watcher.Run(ctx)
+}
+
+func (m *PipelineManager[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	Log[E](ctx, m, "starting")
+	TrackStarted[E](ctx, m)
+	ctx = withErrorScope[E](ctx, m)
+
+	m.setState(ctx, StateStarting, "")
+
+	m.lock.Lock()
+	m.running = true
+	m.swaps = make(chan *pipelineSwap[E])
+	m.stopped = make(chan struct{})
+	root, swaps := m.current, m.swaps
+	m.lock.Unlock()
+
+	wg := sync.WaitGroup{}
+
+	start := func(root Processor[E]) *managedPipeline[E] {
+		procCtx, cancel := context.WithCancel(ctx)
+
+		managed := &managedPipeline[E]{
+			root:    root,
+			input:   make(chan E),
+			cancel:  cancel,
+			drained: make(chan struct{}),
+		}
+
+		procOutput := make(chan E)
+
+		wg.Add(1)
+		go func() {
+			runProcessor[E](procCtx, root, managed.input, procOutput)
+			wg.Done()
+		}()
+
+		wg.Add(1)
+		go func() {
+			for msg := range procOutput {
+				if ctx.Err() != nil {
+					abandon(ctx, msg)
+					continue
+				}
+
+				TrackOutput[E](ctx, m, msg)
+				send(ctx, output, msg)
+			}
+
+			close(managed.drained)
+			cancel()
+			wg.Done()
+		}()
+
+		return managed
+	}
+
+	retire := func(managed *managedPipeline[E]) {
+		close(managed.input)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var timeout <-chan time.Time
+			if m.DrainTimeout > 0 {
+				timer := time.NewTimer(m.DrainTimeout)
+				defer timer.Stop()
+
+				timeout = timer.C
+			}
+
+			select {
+			case <-managed.drained:
+				Emit[E](ctx, m, EventPipelineDrained, "%s drained", managed.root.Name())
+			case <-timeout:
+				Log[E](ctx, m, "%s not drained after %s, cancelling", managed.root.Name(), m.DrainTimeout)
+				managed.cancel()
+			}
+		}()
+	}
+
+	var active, green *managedPipeline[E]
+	if root != nil {
+		active = start(root)
+	}
+
+	setGreen := func(managed *managedPipeline[E]) {
+		green = managed
+
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
+		m.green = nil
+		if managed != nil {
+			m.green = managed.root
+		}
+	}
+
+	// change applies a change of pipeline, other than a replacement
+	change := func(swap *pipelineSwap[E]) error {
+		if swap.kind == swapGreen {
+			if active == nil {
+				return ErrNoBlue
+			}
+
+			if err := Build(ctx, swap.root); err != nil {
+				return err
+			}
+
+			if green != nil {
+				retire(green)
+			}
+
+			setGreen(start(swap.root))
+			Emit[E](ctx, m, EventGreenStarted, "running %s with %.0f%% of the items", swap.root.Name(), m.Split()*100)
+
+			return nil
+		}
+
+		if green == nil {
+			return ErrNoGreen
+		}
+
+		if swap.kind == swapRollback {
+			Log[E](ctx, m, "rolling back, draining %s", green.root.Name())
+			retire(green)
+			setGreen(nil)
+
+			Emit[E](ctx, m, EventGreenRolledBack, "running %s", active.root.Name())
+			return nil
+		}
+
+		previous := active
+		active = green
+
+		m.lock.Lock()
+		m.current = active.root
+		m.lock.Unlock()
+
+		setGreen(nil)
+
+		Log[E](ctx, m, "cutover to %s, draining %s", active.root.Name(), previous.root.Name())
+		retire(previous)
+
+		Emit[E](ctx, m, EventPipelineCutover, "running %s", active.root.Name())
+		return nil
+	}
+
+	m.setState(ctx, StateRunning, "")
+
+	for running := true; running; {
+		// items wait until there is a pipeline to take them
+		in := input
+		if active == nil {
+			in = nil
+		}
+
+		select {
+		case swap := <-swaps:
+			if swap.kind != swapReplace {
+				swap.done <- change(swap)
+				break
+			}
+
+			if err := Build(ctx, swap.root); err != nil {
+				swap.done <- err
+				break
+			}
+
+			m.lock.Lock()
+			m.current = swap.root
+			m.lock.Unlock()
+
+			previous := active
+			active = start(swap.root)
+
+			if previous != nil {
+				Log[E](ctx, m, "swapped to %s, draining %s", swap.root.Name(), previous.root.Name())
+				retire(previous)
+			}
+
+			if green != nil {
+				retire(green)
+				setGreen(nil)
+			}
+
+			Emit[E](ctx, m, EventPipelineSwapped, "running %s", swap.root.Name())
+			swap.done <- nil
+
+		case <-ctx.Done():
+			running = false
+
+		case msg, ok := <-in:
+			if !ok {
+				running = false
+				break
+			}
+
+			TrackItemInput[E](ctx, m, msg)
+
+			target := active
+			if green != nil && rand.Float64() < m.Split() {
+				target = green
+			}
+
+			send(ctx, target.input, msg)
+		}
+	}
+
+	inputClosed[E](ctx, m)
+	m.setState(ctx, StateDraining, "")
+
+	m.lock.Lock()
+	m.running = false
+	close(m.stopped)
+	m.lock.Unlock()
+
+	if active != nil {
+		close(active.input)
+	}
+
+	if green != nil {
+		close(green.input)
+		setGreen(nil)
+	}
+
+	wg.Wait()
+
+	m.setState(ctx, StateStopped, "")
+	TrackFinished[E](ctx, m)
+	close(output)
+}
+
+func (m *PipelineManager[E]) Name() string {
+	return fmt.Sprintf("PipelineManager/%s", m.ChainName)
+}
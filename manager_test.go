@@ -0,0 +1,82 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func upper(name string) Processor[*testItem] {
+	return NewItemFunc[*testItem](name, func(ctx context.Context, item *testItem) (*testItem, error) {
+		item.Value = strings.ToUpper(item.Value)
+		return item, nil
+	})
+}
+
+func TestPipelineManagerGreenNeedsBlue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := NewPipelineManager[*testItem]("manager", nil)
+
+	input := make(chan *testItem)
+	output := make(chan *testItem)
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		m.Execute(ctx, input, output)
+	}()
+
+	// requests wait for the manager to run
+	for m.StartGreen(upper("green"), 0.5) == ErrManagerStopped {
+	}
+
+	if err := m.StartGreen(upper("green"), 0.5); !errors.Is(err, ErrNoBlue) {
+		t.Fatalf("StartGreen without blue: got %v, want ErrNoBlue", err)
+	}
+
+	if err := m.Cutover(); !errors.Is(err, ErrNoGreen) {
+		t.Fatalf("Cutover without green: got %v, want ErrNoGreen", err)
+	}
+
+	if err := m.Rollback(); !errors.Is(err, ErrNoGreen) {
+		t.Fatalf("Rollback without green: got %v, want ErrNoGreen", err)
+	}
+
+	if err := m.Swap(upper("blue")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.StartGreen(upper("green"), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Cutover(); err != nil {
+		t.Fatal(err)
+	}
+
+	if current := m.Current().Name(); current != "green" {
+		t.Fatalf("got current %s after cutover, want green", current)
+	}
+
+	input <- &testItem{Value: "a"}
+
+	if item := <-output; item.Value != "A" {
+		t.Fatalf("got %q, want A", item.Value)
+	}
+
+	close(input)
+
+	got := []string{}
+	for item := range output {
+		got = append(got, item.Value)
+	}
+
+	<-stopped
+
+	if len(got) > 0 {
+		t.Fatalf("unexpected output after closing the input: %v", got)
+	}
+}
@@ -0,0 +1,248 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+)
+
+var PipelineMemoryBudget PipelineContextKey = "pipeline_memory_budget"
+
+// Sizer is implemented by items able to estimate their size in memory, in bytes
+type Sizer interface {
+	Size() int
+}
+
+/*
+	A MemoryBudget bounds the estimated memory held by the inter-stage buffers
+	of every composite running with it in their context.
+
+	Items are sized with their Size method when they implement Sizer, or
+	counted as DefaultItemSize bytes otherwise. When the budget is exhausted,
+	composites stop filling their buffers until downstream stages consume
+	enough items, which applies backpressure to the pipeline input long before
+	buffers are full. A buffer always accepts a single item when the budget is
+	empty, so oversized items can not deadlock the pipeline.
+*/
+type MemoryBudget struct {
+	Limit           int64
+	DefaultItemSize int64
+
+	lock     sync.Mutex
+	used     int64
+	released chan struct{}
+	pressure bool
+}
+
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	return &MemoryBudget{
+		Limit:           limit,
+		DefaultItemSize: 1024,
+		released:        make(chan struct{}),
+	}
+}
+
+func WithMemoryBudget(ctx context.Context, budget *MemoryBudget) context.Context {
+	return context.WithValue(ctx, PipelineMemoryBudget, budget)
+}
+
+// Used returns the estimated bytes currently held in buffers
+func (b *MemoryBudget) Used() int64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.used
+}
+
+func (b *MemoryBudget) sizeOf(item interface{}) int64 {
+	if s, ok := item.(Sizer); ok {
+		return int64(s.Size())
+	}
+
+	return b.DefaultItemSize
+}
+
+// acquire blocks until size bytes fit in the budget. If the context is
+// cancelled while waiting the bytes are accounted anyway and false is returned.
+func (b *MemoryBudget) acquire(ctx context.Context, size int64, onPressure func(bool)) bool {
+	for {
+		b.lock.Lock()
+
+		if b.used == 0 || b.used+size <= b.Limit {
+			b.used += size
+			b.lock.Unlock()
+			return true
+		}
+
+		released := b.released
+		changed := !b.pressure
+		b.pressure = true
+
+		b.lock.Unlock()
+
+		if changed {
+			onPressure(true)
+		}
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			b.lock.Lock()
+			b.used += size
+			b.lock.Unlock()
+
+			return false
+		}
+	}
+}
+
+func (b *MemoryBudget) release(size int64, onPressure func(bool)) {
+	b.lock.Lock()
+
+	b.used -= size
+
+	close(b.released)
+	b.released = make(chan struct{})
+
+	changed := b.pressure && b.used <= b.Limit/2
+	if changed {
+		b.pressure = false
+	}
+
+	b.lock.Unlock()
+
+	if changed {
+		onPressure(false)
+	}
+}
+
+/*
+	stageBuffer is a buffered hop owned by a composite in front of one of its
+	child processors. Items are written with send and read by the child from
+	output.
+
+	Without a MemoryBudget in the context it is a plain buffered channel. With
+	one, items are accounted while they sit in the buffer and a relay goroutine
+	hands them to the child, releasing their size from the budget.
+*/
+type stageBuffer[E Traceable] struct {
+	ctx    context.Context
+	owner  Processor[E]
+	budget *MemoryBudget
+
+	input  chan E
+	output chan E
+
+	// the overflow policy of buffers made by newPolicyBuffer
+	policy       BufferPolicy
+	spill        *spillFile
+	overflowLock sync.Mutex
+	full         bool
+}
+
+func newStageBuffer[E Traceable](ctx context.Context, owner Processor[E], size int) *stageBuffer[E] {
+	buf := &stageBuffer[E]{
+		ctx:   ctx,
+		owner: owner,
+		input: make(chan E, size),
+	}
+
+	budget, ok := ctx.Value(PipelineMemoryBudget).(*MemoryBudget)
+	if !ok || budget == nil {
+		buf.output = buf.input
+		return buf
+	}
+
+	buf.budget = budget
+	buf.output = make(chan E)
+
+	go func() {
+		for m := range buf.input {
+			budget.release(budget.sizeOf(m), buf.pressure)
+			buf.output <- m
+		}
+
+		close(buf.output)
+	}()
+
+	return buf
+}
+
+// send applies the overflow policy of the buffer, waiting for room with
+// BufferBlock
+func (buf *stageBuffer[E]) send(m E) {
+	if buf.policy != BufferBlock {
+		buf.overflow(m)
+		return
+	}
+
+	buf.push(m)
+}
+
+// push gives up when the context of the owner is done
+func (buf *stageBuffer[E]) push(m E) {
+	var size int64
+
+	if buf.budget != nil {
+		size = buf.budget.sizeOf(m)
+		buf.budget.acquire(buf.ctx, size, buf.pressure)
+	}
+
+	select {
+	case buf.input <- m:
+	case <-buf.ctx.Done():
+		if buf.budget != nil {
+			buf.budget.release(size, buf.pressure)
+		}
+
+		abandon(buf.ctx, m)
+	}
+}
+
+// trySend never blocks, it returns false if the item did not fit
+func (buf *stageBuffer[E]) trySend(m E) bool {
+	var size int64
+
+	if buf.budget != nil {
+		size = buf.budget.sizeOf(m)
+
+		buf.budget.lock.Lock()
+		fits := buf.budget.used == 0 || buf.budget.used+size <= buf.budget.Limit
+		if fits {
+			buf.budget.used += size
+		}
+		buf.budget.lock.Unlock()
+
+		if !fits {
+			return false
+		}
+	}
+
+	select {
+	case buf.input <- m:
+		return true
+	default:
+		if buf.budget != nil {
+			buf.budget.release(size, buf.pressure)
+		}
+
+		return false
+	}
+}
+
+func (buf *stageBuffer[E]) close() {
+	if buf.spill != nil {
+		buf.spill.close()
+		return
+	}
+
+	close(buf.input)
+}
+
+func (buf *stageBuffer[E]) pressure(on bool) {
+	if on {
+		Emit[E](buf.ctx, buf.owner, EventMemoryPressure, "memory budget exhausted (%d bytes), applying backpressure", buf.budget.Limit)
+		Log[E](buf.ctx, buf.owner, "memory budget exhausted, applying backpressure")
+	} else {
+		Emit[E](buf.ctx, buf.owner, EventMemoryRelieved, "memory budget usage back under %d bytes", buf.budget.Limit/2)
+	}
+}
@@ -0,0 +1,151 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrRejected = fmt.Errorf("request rejected")
+var ErrInvalidRequest = fmt.Errorf("invalid request")
+
+/*
+	HTTPMiddleware runs the requests of an http.Handler chain through a
+	pipeline, as one item each: Decode makes the item of a request, and
+	Encode applies the item the pipeline sends out to the request handed to
+	the next handler, which is the request itself when Encode is nil.
+
+	Requests the pipeline drops, fails, or does not finish within Timeout
+	when it is set, are rejected with ErrRejected, and so are those giving
+	more than one item. Reject writes their response, by default Forbidden,
+	Bad Request for those Decode fails with, and Service Unavailable for the
+	timed out ones.
+
+	Requests run the pipeline with Context, which holds its stats and event
+	handlers, cancelled with the request. The pipeline is built by the
+	caller, with Build, before it serves requests.
+*/
+type HTTPMiddleware[E Traceable] struct {
+	Pipeline Processor[E]
+	Context  context.Context
+	Timeout  time.Duration
+
+	Decode func(r *http.Request) (E, error)
+	Encode func(item E, r *http.Request) (*http.Request, error)
+	Reject func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// Handler returns the middleware in front of next
+func (m *HTTPMiddleware[E]) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		item, err := m.Decode(r)
+		if err != nil {
+			m.reject(w, r, fmt.Errorf("%w: %w: %w", err, ErrInvalidRequest, ErrRejected))
+			return
+		}
+
+		result, err := m.Process(r.Context(), item)
+		if err != nil {
+			m.reject(w, r, err)
+			return
+		}
+
+		if m.Encode != nil {
+			encoded, err := m.Encode(result, r)
+			if err != nil {
+				m.reject(w, r, fmt.Errorf("%w: %w", err, ErrRejected))
+				return
+			}
+
+			r = encoded
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+/*
+	Process runs item alone through the pipeline, until it is sent out or
+	reqCtx is done, and returns it, or ErrRejected with the error of the
+	processor failing it when one did.
+*/
+func (m *HTTPMiddleware[E]) Process(reqCtx context.Context, item E) (E, error) {
+	base := m.Context
+	if base == nil {
+		base = context.Background()
+	}
+
+	ctx, cancel := context.WithCancel(base)
+	defer cancel()
+
+	stop := context.AfterFunc(reqCtx, cancel)
+	defer stop()
+
+	if m.Timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, m.Timeout)
+		defer cancelTimeout()
+	}
+
+	lock := sync.Mutex{}
+	var failure string
+
+	ctx = WithDeadLetters(ctx, func(letter *DeadLetter[E]) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if failure == "" {
+			failure = fmt.Sprintf("%s: %s", letter.Processor, letter.Err)
+		}
+	})
+
+	input := make(chan E, 1)
+	output := make(chan E)
+
+	input <- item
+	close(input)
+
+	go runProcessor[E](ctx, m.Pipeline, input, output)
+
+	var results []E
+	for result := range output {
+		results = append(results, result)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	switch {
+	case len(results) == 1 && ctx.Err() == nil:
+		return results[0], nil
+	case ctx.Err() != nil:
+		return item, fmt.Errorf("%w: %w", ctx.Err(), ErrRejected)
+	case failure != "":
+		return item, fmt.Errorf("%s: %w", failure, ErrRejected)
+	case len(results) == 0:
+		return item, fmt.Errorf("%s dropped the request: %w", m.Pipeline.Name(), ErrRejected)
+	}
+
+	return item, fmt.Errorf("%s gave %d items: %w", m.Pipeline.Name(), len(results), ErrRejected)
+}
+
+func (m *HTTPMiddleware[E]) reject(w http.ResponseWriter, r *http.Request, err error) {
+	if m.Reject != nil {
+		m.Reject(w, r, err)
+		return
+	}
+
+	status := http.StatusForbidden
+
+	switch {
+	case errors.Is(err, ErrInvalidRequest):
+		status = http.StatusBadRequest
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		status = http.StatusServiceUnavailable
+	}
+
+	http.Error(w, http.StatusText(status), status)
+}
@@ -0,0 +1,726 @@
+/*
+	Package nats connects pipelines to NATS: a NATSSource feeds a pipeline
+	from a subject, or from a durable JetStream consumer, and a NATSSink
+	publishes items to a subject.
+*/
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ca0s/pipeline"
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	EventDisconnected = "nats_disconnected"
+	EventReconnected  = "nats_reconnected"
+)
+
+// connections wait this long between reconnection attempts by default
+const DefaultReconnectWait = 2 * time.Second
+
+var ErrNoSubject = fmt.Errorf("no subject")
+
+/*
+	A Message is a message read from NATS, handed to the New of a NATSSource
+	to make its item. Items of JetStream consumers embed Acker, so that their
+	message is acked once the pipeline is done with it, or nacked to be
+	redelivered. Core NATS messages are not acked. The lineage sent along
+	with the message, in its headers, is replayed onto the item once it is
+	decoded.
+*/
+type Message struct {
+	Subject string
+	Reply   string
+	Data    []byte
+	Headers pipeline.Headers
+
+	// Context is the context of the source, with the values propagated by
+	// the publisher of the message restored
+	Context context.Context
+
+	Acker *pipeline.Acker
+}
+
+// newMessage makes the Message of a NATS message read in ctx
+func newMessage(ctx context.Context, subject string, reply string, data []byte, header natsgo.Header) *Message {
+	msg := &Message{Subject: subject, Reply: reply, Data: data, Headers: make(pipeline.Headers, len(header))}
+
+	for key := range header {
+		msg.Headers[key] = header.Get(key)
+	}
+
+	msg.Context = pipeline.ExtractContext(ctx, msg.Headers)
+
+	return msg
+}
+
+/*
+	The NATSSource is a pipeline.Source reading messages from URL. Every
+	message is made into an item by New, and its data decoded into it with
+	the named Codec, "json" by default, unless the Codec is "raw": the item
+	then carries the data as it was read, and New must keep it.
+
+	With a Stream, messages come from the durable JetStream consumer Durable
+	of the stream, created or updated to take the messages of Subject, or
+	all those of the stream when Subject is empty. They are acked once the
+	pipeline is done with them, and redelivered by the server when they are
+	nacked or left unacked. Without a Stream, messages come from Subject in
+	core NATS, shared by the members of the queue group Queue when it is
+	set, and are not redelivered.
+
+	The connection is kept up, reconnecting every ReconnectWait, two seconds
+	by default, and disconnections and reconnections are emitted as events.
+	With a Security, the connection uses its client TLS configuration when it
+	has certificates, and messages must carry the signature of its token, as
+	a NATSSink with the same Security signs them: see pipeline.Signer.
+
+	Messages which fail to decode, or are not authorized, are reported by
+	Err once the source has stopped, unless Skip is set: they are then
+	skipped, and terminated when they come from JetStream.
+*/
+type NATSSource[E pipeline.Traceable] struct {
+	ChainName string
+
+	URL     string
+	Subject string
+	Queue   string
+
+	Stream  string
+	Durable string
+
+	Codec string
+	New   func(msg *Message) E `json:"-"`
+	Skip  bool
+
+	ReconnectWait time.Duration
+	Security      *pipeline.EdgeSecurity `json:"-"`
+	Options       []natsgo.Option        `json:"-"`
+
+	lock sync.Mutex
+	err  error
+}
+
+func NewNATSSource[E pipeline.Traceable](name string, url string, subject string, fn func(msg *Message) E) *NATSSource[E] {
+	return &NATSSource[E]{
+		ChainName: name,
+		URL:       url,
+		Subject:   subject,
+		New:       fn,
+	}
+}
+
+func (s *NATSSource[E]) Name() string {
+	return fmt.Sprintf("NATSSource/%s", s.ChainName)
+}
+
+// Init checks the source can run, before it is started
+func (s *NATSSource[E]) Init(ctx context.Context) error {
+	if s.Subject == "" && s.Stream == "" {
+		return ErrNoSubject
+	}
+
+	if s.Security.TLSEnabled() {
+		if _, err := s.Security.ClientTLSConfig(); err != nil {
+			return err
+		}
+	}
+
+	_, err := codec(s.Codec)
+	return err
+}
+
+// Err returns the error the source stopped on, if any
+func (s *NATSSource[E]) Err() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.err
+}
+
+func (s *NATSSource[E]) fail(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.err = err
+}
+
+func (s *NATSSource[E]) Execute(ctx context.Context, output chan E) {
+	defer close(output)
+
+	s.fail(nil)
+
+	dec, err := codec(s.Codec)
+	if err != nil {
+		s.fail(err)
+		return
+	}
+
+	signer, err := s.Security.Signer()
+	if err != nil {
+		s.fail(err)
+		return
+	}
+
+	conn, err := connect[E](ctx, s.URL, s.ReconnectWait, s.Security, s.Options)
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	defer conn.Close()
+
+	next := s.core
+	if s.Stream != "" {
+		next = s.jetStream
+	}
+
+	messages, err := next(ctx, conn)
+	if err != nil {
+		s.fail(err)
+		return
+	}
+
+	for {
+		msg, terminate, err := messages()
+		if err != nil {
+			if ctx.Err() == nil {
+				s.fail(err)
+			}
+
+			return
+		}
+
+		item := s.New(msg)
+
+		err = signer.Verify(msg.Headers, msg.Data)
+		if err == nil {
+			err = decode(dec, msg, item)
+		}
+
+		if err != nil {
+			err = fmt.Errorf("reading message of %s: %w", msg.Subject, err)
+
+			if !s.Skip {
+				s.fail(err)
+				return
+			}
+
+			terminate()
+			continue
+		}
+
+		select {
+		case output <- item:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// nextMessage returns the next message, and the function terminating it
+type nextMessage func() (msg *Message, terminate func(), err error)
+
+// core subscribes to Subject in core NATS
+func (s *NATSSource[E]) core(ctx context.Context, conn *natsgo.Conn) (nextMessage, error) {
+	subscribe := func() (*natsgo.Subscription, error) {
+		if s.Queue != "" {
+			return conn.QueueSubscribeSync(s.Subject, s.Queue)
+		}
+
+		return conn.SubscribeSync(s.Subject)
+	}
+
+	sub, err := subscribe()
+	if err != nil {
+		return nil, fmt.Errorf("subscribing to %s: %w", s.Subject, err)
+	}
+
+	context.AfterFunc(ctx, func() {
+		sub.Unsubscribe()
+	})
+
+	return func() (*Message, func(), error) {
+		m, err := sub.NextMsgWithContext(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		msg := newMessage(ctx, m.Subject, m.Reply, m.Data, m.Header)
+
+		return msg, func() {}, nil
+	}, nil
+}
+
+// jetStream consumes Stream with the durable consumer Durable
+func (s *NATSSource[E]) jetStream(ctx context.Context, conn *natsgo.Conn) (nextMessage, error) {
+	js, err := jetstream.New(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	consumer, err := js.CreateOrUpdateConsumer(ctx, s.Stream, jetstream.ConsumerConfig{
+		Durable:       s.Durable,
+		FilterSubject: s.Subject,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("consuming %s: %w", s.Stream, err)
+	}
+
+	iter, err := consumer.Messages()
+	if err != nil {
+		return nil, fmt.Errorf("consuming %s: %w", s.Stream, err)
+	}
+
+	context.AfterFunc(ctx, iter.Stop)
+
+	return func() (*Message, func(), error) {
+		m, err := iter.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		msg := newMessage(ctx, m.Subject(), m.Reply(), m.Data(), m.Headers())
+		msg.Acker = pipeline.NewAcker(func() {
+			m.Ack()
+		}, func(err error) {
+			m.Nak()
+		})
+
+		return msg, func() { m.Term() }, nil
+	}, nil
+}
+
+// connect connects to url, reconnecting forever, with the events of the
+// connection emitted in ctx, and the client TLS configuration of security
+// when it has certificates
+func connect[E pipeline.Traceable](ctx context.Context, url string, wait time.Duration, security *pipeline.EdgeSecurity, options []natsgo.Option) (*natsgo.Conn, error) {
+	if wait <= 0 {
+		wait = DefaultReconnectWait
+	}
+
+	if security.TLSEnabled() {
+		tlsCfg, err := security.ClientTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+
+		options = append([]natsgo.Option{natsgo.Secure(tlsCfg)}, options...)
+	}
+
+	options = append([]natsgo.Option{
+		natsgo.MaxReconnects(-1),
+		natsgo.ReconnectWait(wait),
+		natsgo.DisconnectErrHandler(func(conn *natsgo.Conn, err error) {
+			if err != nil {
+				pipeline.Emit[E](ctx, nil, EventDisconnected, "disconnected from %s: %s", url, err)
+			}
+		}),
+		natsgo.ReconnectHandler(func(conn *natsgo.Conn) {
+			pipeline.Emit[E](ctx, nil, EventReconnected, "reconnected to %s", conn.ConnectedUrl())
+		}),
+	}, options...)
+
+	conn, err := natsgo.Connect(url, options...)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", url, err)
+	}
+
+	return conn, nil
+}
+
+// decode decodes the data of msg into item, with its lineage
+func decode[E pipeline.Traceable](dec pipeline.Codec, msg *Message, item E) error {
+	if dec != nil {
+		if err := dec.Unmarshal(msg.Data, item); err != nil {
+			return err
+		}
+	}
+
+	return pipeline.ExtractTraces(msg.Headers, item)
+}
+
+// codec returns the named codec, nil for "raw"
+func codec(name string) (pipeline.Codec, error) {
+	switch name {
+	case "raw":
+		return nil, nil
+	case "":
+		name = "json"
+	}
+
+	c, ok := pipeline.LookupCodec(name)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, pipeline.ErrUnknownCodec)
+	}
+
+	return c, nil
+}
+
+/*
+	The NATSSink publishes items to Subject on URL, encoded with the named
+	Codec, "json" by default, or as the raw data they carry when it is "raw".
+	SubjectOf returns the subject of an item instead, when set. With
+	JetStream, every item is published to the stream taking its subject, and
+	written once the server has acknowledged it.
+
+	Items are published in batches of BatchSize items, or those received
+	within Linger, and core NATS batches are flushed before they count as
+	written. Batches failing are failed, and sent to the dead letter
+	handlers. Items written are acked.
+
+	The values propagated from the context, and the lineage of every item,
+	are sent in the headers of its message. With a Security, messages are
+	signed with its token, which they never carry, and the connection uses
+	its client TLS configuration when it has certificates.
+*/
+type NATSSink[E pipeline.Traceable] struct {
+	ChainName string
+
+	URL       string
+	Subject   string
+	SubjectOf func(item E) string `json:"-"`
+	JetStream bool
+
+	Codec string
+
+	BatchSize int
+	Linger    time.Duration
+
+	ReconnectWait time.Duration
+	Security      *pipeline.EdgeSecurity `json:"-"`
+	Options       []natsgo.Option        `json:"-"`
+
+	conn  *natsgo.Conn
+	js    jetstream.JetStream
+	codec pipeline.Codec
+}
+
+func NewNATSSink[E pipeline.Traceable](name string, url string, subject string) *NATSSink[E] {
+	return &NATSSink[E]{
+		ChainName: name,
+		URL:       url,
+		Subject:   subject,
+	}
+}
+
+func (s *NATSSink[E]) Name() string {
+	return fmt.Sprintf("NATSSink/%s", s.ChainName)
+}
+
+func (s *NATSSink[E]) Init(ctx context.Context) error {
+	if s.Subject == "" && s.SubjectOf == nil {
+		return ErrNoSubject
+	}
+
+	if s.Security.TLSEnabled() {
+		if _, err := s.Security.ClientTLSConfig(); err != nil {
+			return err
+		}
+	}
+
+	_, err := codec(s.Codec)
+	return err
+}
+
+func (s *NATSSink[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	write := s.write
+
+	if err := s.open(ctx); err != nil {
+		pipeline.ReportError(ctx, s, fmt.Errorf("%w: %w", err, pipeline.ErrFatal))
+
+		write = func(ctx context.Context, items []E) error {
+			return err
+		}
+	}
+
+	pipeline.ExecuteSink[E](ctx, s, input, output, s.BatchSize, s.Linger, nil, write)
+
+	if s.conn != nil {
+		if err := s.conn.Drain(); err != nil {
+			pipeline.ReportError(ctx, s, fmt.Errorf("closing connection: %w", err))
+		}
+	}
+}
+
+func (s *NATSSink[E]) open(ctx context.Context) error {
+	c, err := codec(s.Codec)
+	if err != nil {
+		return err
+	}
+
+	s.codec = c
+
+	s.conn, err = connect[E](ctx, s.URL, s.ReconnectWait, s.Security, s.Options)
+	if err != nil {
+		return err
+	}
+
+	if s.JetStream {
+		s.js, err = jetstream.New(s.conn)
+	}
+
+	return err
+}
+
+func (s *NATSSink[E]) write(ctx context.Context, items []E) error {
+	// the token is read once per batch, and signs all its messages
+	signer, err := s.Security.Signer()
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		msg, err := s.message(ctx, item, signer)
+		if err != nil {
+			return err
+		}
+
+		if s.js != nil {
+			if _, err := s.js.PublishMsg(ctx, msg); err != nil {
+				return fmt.Errorf("publishing to %s: %w", msg.Subject, err)
+			}
+
+			continue
+		}
+
+		if err := s.conn.PublishMsg(msg); err != nil {
+			return fmt.Errorf("publishing to %s: %w", msg.Subject, err)
+		}
+	}
+
+	if s.js != nil {
+		return nil
+	}
+
+	return s.conn.FlushWithContext(ctx)
+}
+
+// message is the NATS message of item, with the values of ctx and the
+// lineage of item in its headers, signed by signer
+func (s *NATSSink[E]) message(ctx context.Context, item E, signer *pipeline.Signer) (*natsgo.Msg, error) {
+	data, err := s.encode(item)
+	if err != nil {
+		return nil, err
+	}
+
+	headers, err := pipeline.InjectItem(ctx, item)
+	if err != nil {
+		return nil, err
+	}
+
+	signer.Sign(headers, data)
+
+	msg := natsgo.NewMsg(s.Subject)
+	if s.SubjectOf != nil {
+		msg.Subject = s.SubjectOf(item)
+	}
+
+	msg.Data = data
+
+	for key, value := range headers {
+		msg.Header.Set(key, value)
+	}
+
+	pipeline.ReleaseHeaders(headers)
+
+	return msg, nil
+}
+
+func (s *NATSSink[E]) encode(item E) ([]byte, error) {
+	if s.codec != nil {
+		return s.codec.Marshal(item)
+	}
+
+	raw, ok := pipeline.Traceable(item).(pipeline.RawCarrier)
+	if !ok {
+		return nil, fmt.Errorf("%T: %w", item, pipeline.ErrNotRaw)
+	}
+
+	return raw.RawBytes(), nil
+}
+
+/*
+	RegisterSource registers the NATSSource as the "natssource" processor type
+	of definitions, configured with url, subject, queue, stream, durable,
+	codec, skip and security. Its items are new values of the pipeline type,
+	which must point to a struct, embedding a *pipeline.Acker when they come
+	from a stream, for their messages to be acked, and carry the data of
+	their message when the codec is "raw", for which they must be
+	pipeline.RawSetters.
+*/
+func RegisterSource[E pipeline.Traceable]() {
+	pipeline.RegisterSourceType[E, *NATSSource[E]]("natssource", newSource[E], marshalSource[E])
+}
+
+func newSource[E pipeline.Traceable](name string, cfg map[string]interface{}) (*NATSSource[E], error) {
+	source := &NATSSource[E]{ChainName: name}
+
+	source.URL, _ = cfg["url"].(string)
+	source.Subject, _ = cfg["subject"].(string)
+	source.Queue, _ = cfg["queue"].(string)
+	source.Stream, _ = cfg["stream"].(string)
+	source.Durable, _ = cfg["durable"].(string)
+	source.Codec, _ = cfg["codec"].(string)
+	source.Skip, _ = cfg["skip"].(bool)
+
+	security, err := pipeline.EdgeSecurityFromConfig(cfg)
+	if err != nil {
+		return nil, errors.Join(err, pipeline.ErrInvalidConfig)
+	}
+
+	source.Security = security
+
+	if source.New, err = newItem[E](source.Stream != "", source.Codec == "raw"); err != nil {
+		return nil, errors.Join(err, pipeline.ErrInvalidConfig)
+	}
+
+	if err := source.Init(context.Background()); err != nil {
+		return nil, errors.Join(err, pipeline.ErrInvalidConfig)
+	}
+
+	return source, nil
+}
+
+func marshalSource[E pipeline.Traceable](source *NATSSource[E]) (string, map[string]interface{}, error) {
+	cfg := map[string]interface{}{
+		"url": source.URL,
+	}
+
+	for key, value := range map[string]string{
+		"subject": source.Subject,
+		"queue":   source.Queue,
+		"stream":  source.Stream,
+		"durable": source.Durable,
+		"codec":   source.Codec,
+	} {
+		if value != "" {
+			cfg[key] = value
+		}
+	}
+
+	if source.Skip {
+		cfg["skip"] = true
+	}
+
+	if source.Security != nil {
+		cfg["security"] = source.Security.Config()
+	}
+
+	return source.ChainName, cfg, nil
+}
+
+// newItem is the New of sources declared in definitions: items are new
+// values of the pipeline type, with the Acker of their message when acked,
+// and its data when raw
+func newItem[E pipeline.Traceable](acked bool, raw bool) (func(msg *Message) E, error) {
+	item, err := pipeline.NewItem[E]()
+	if err != nil {
+		return nil, err
+	}
+
+	if !pipeline.SetAcker(item, nil) && acked {
+		return nil, fmt.Errorf("%T: %w", item, pipeline.ErrNoAcker)
+	}
+
+	if _, ok := pipeline.Traceable(item).(pipeline.RawSetter); raw && !ok {
+		return nil, fmt.Errorf("%T: %w", item, pipeline.ErrNotRaw)
+	}
+
+	return func(msg *Message) E {
+		item, _ := pipeline.NewItem[E]()
+
+		// core NATS messages are not acked, but their items still can be
+		acker := msg.Acker
+		if acker == nil {
+			acker = pipeline.NewAcker(nil, nil)
+		}
+
+		pipeline.SetAcker(item, acker)
+
+		if setter, ok := pipeline.Traceable(item).(pipeline.RawSetter); ok && raw {
+			setter.SetRawBytes(msg.Data)
+		}
+
+		return item
+	}, nil
+}
+
+/*
+	RegisterSink registers the NATSSink as the "natssink" processor type of
+	definitions, configured with url, subject, jetstream, codec, batch_size,
+	linger and security.
+*/
+func RegisterSink[E pipeline.Traceable]() {
+	pipeline.RegisterProcessorType[E, *NATSSink[E]]("natssink", newSink[E], marshalSink[E])
+}
+
+func newSink[E pipeline.Traceable](name string, cfg map[string]interface{}) (*NATSSink[E], error) {
+	sink := &NATSSink[E]{ChainName: name}
+
+	sink.URL, _ = cfg["url"].(string)
+	sink.Subject, _ = cfg["subject"].(string)
+	sink.JetStream, _ = cfg["jetstream"].(bool)
+	sink.Codec, _ = cfg["codec"].(string)
+
+	if size, ok := cfg["batch_size"].(float64); ok {
+		sink.BatchSize = int(size)
+	}
+
+	if linger, ok := cfg["linger"].(string); ok {
+		d, err := time.ParseDuration(linger)
+		if err != nil {
+			return nil, fmt.Errorf("invalid linger: %w: %w", err, pipeline.ErrInvalidConfig)
+		}
+
+		sink.Linger = d
+	}
+
+	security, err := pipeline.EdgeSecurityFromConfig(cfg)
+	if err != nil {
+		return nil, errors.Join(err, pipeline.ErrInvalidConfig)
+	}
+
+	sink.Security = security
+
+	if err := sink.Init(context.Background()); err != nil {
+		return nil, errors.Join(err, pipeline.ErrInvalidConfig)
+	}
+
+	return sink, nil
+}
+
+func marshalSink[E pipeline.Traceable](sink *NATSSink[E]) (string, map[string]interface{}, error) {
+	cfg := map[string]interface{}{
+		"url":     sink.URL,
+		"subject": sink.Subject,
+	}
+
+	if sink.JetStream {
+		cfg["jetstream"] = true
+	}
+
+	if sink.Codec != "" {
+		cfg["codec"] = sink.Codec
+	}
+
+	if sink.BatchSize > 0 {
+		cfg["batch_size"] = sink.BatchSize
+	}
+
+	if sink.Linger > 0 {
+		cfg["linger"] = sink.Linger.String()
+	}
+
+	if sink.Security != nil {
+		cfg["security"] = sink.Security.Config()
+	}
+
+	return sink.ChainName, cfg, nil
+}
@@ -0,0 +1,118 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ca0s/pipeline"
+)
+
+type item struct {
+	*pipeline.Acker
+	Value string `json:"value"`
+}
+
+func (i *item) AddTrace(trace string) {}
+
+type unackable struct {
+	Value string `json:"value"`
+}
+
+func (u *unackable) AddTrace(trace string) {}
+
+func build[E pipeline.Traceable](t *testing.T, definition string) (pipeline.Processor[E], error) {
+	t.Helper()
+
+	sp := &pipeline.SerializedPipeline[E]{}
+	if err := json.Unmarshal([]byte(definition), sp); err != nil {
+		t.Fatal(err)
+	}
+
+	return sp.Pipeline()
+}
+
+func TestDefinitionsDeclareSourceAndSink(t *testing.T) {
+	RegisterSource[*item]()
+	RegisterSink[*item]()
+
+	definition := `{"type":"sequential","name":"bus","processors":[
+		{"type":"natssource","name":"in","cfg":{"url":"nats://localhost:4222","subject":"events","stream":"EVENTS","durable":"workers","security":{"token":"secret"}}},
+		{"type":"natssink","name":"out","cfg":{"url":"nats://localhost:4222","subject":"results","security":{"token":"secret"}}}
+	]}`
+
+	p, err := build[*item](t, definition)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := p.(pipeline.Composite[*item]).Children()[0].(*pipeline.SourceStage[*item]).Source.(*NATSSource[*item])
+	if source.Stream != "EVENTS" || source.Durable != "workers" || source.Security.Token != "secret" {
+		t.Fatalf("source not configured: %+v", source)
+	}
+
+	// core NATS messages have no Acker, their items still get one
+	if made := source.New(&Message{}); made.Acker == nil {
+		t.Fatal("item made without an Acker")
+	}
+
+	data, err := pipeline.MarshalPipeline(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(data), "secret") {
+		t.Fatalf("definition carries the token: %s", data)
+	}
+
+	if _, err := build[*item](t, string(data)); err != nil {
+		t.Fatalf("marshalled definition does not build: %v\n%s", err, data)
+	}
+}
+
+func TestDeclaredStreamSourceNeedsAckableItems(t *testing.T) {
+	RegisterSource[*unackable]()
+
+	if _, err := build[*unackable](t, `{"type":"natssource","name":"in","cfg":{"url":"nats://localhost:4222","subject":"events"}}`); err != nil {
+		t.Fatalf("core source refused items without Acker: %v", err)
+	}
+
+	_, err := build[*unackable](t, `{"type":"natssource","name":"in","cfg":{"url":"nats://localhost:4222","stream":"EVENTS"}}`)
+	if !errors.Is(err, pipeline.ErrNoAcker) {
+		t.Fatalf("got %v, want ErrNoAcker", err)
+	}
+}
+
+func TestSinkSignsWithoutSendingToken(t *testing.T) {
+	security := &pipeline.EdgeSecurity{Token: "secret"}
+
+	sink := &NATSSink[*item]{Subject: "results", Security: security}
+	sink.codec, _ = codec("")
+
+	signer, err := security.Signer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	published, err := sink.message(context.Background(), &item{Value: "a"}, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key, values := range published.Header {
+		if strings.Contains(strings.Join(values, ","), "secret") {
+			t.Fatalf("header %s carries the token", key)
+		}
+	}
+
+	msg := newMessage(context.Background(), published.Subject, "", published.Data, published.Header)
+	if err := signer.Verify(msg.Headers, msg.Data); err != nil {
+		t.Fatalf("published message not verified: %v", err)
+	}
+
+	if err := signer.Verify(msg.Headers, []byte(`{"value":"b"}`)); !errors.Is(err, pipeline.ErrUnauthorized) {
+		t.Fatalf("tampered message: got %v, want ErrUnauthorized", err)
+	}
+}
@@ -0,0 +1,222 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const EventNotificationsSuppressed = "notifications_suppressed"
+
+var ErrNotificationFailed = fmt.Errorf("notification failed")
+
+type Notification struct {
+	Subject string
+	Body    string
+}
+
+/*
+	A Notifier delivers notifications to humans
+*/
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+/*
+	The NotifySink sends items as notifications.
+
+	Without Digest, a notification is sent per item. With Digest, items are
+	grouped over that period and sent as a single notification of at most
+	MaxDigest items, rendered by Format.
+
+	At most Limit notifications are sent per Interval when Limit is set. Items
+	of the notifications over the limit are counted as passthrough, reported
+	as an event, and mentioned in the next notification sent.
+*/
+type NotifySink[E Traceable] struct {
+	ChainName string
+	Notifier  Notifier
+
+	Digest    time.Duration
+	MaxDigest int
+
+	Limit    int
+	Interval time.Duration
+
+	Format func(items []E) Notification
+
+	lock        sync.Mutex
+	windowStart time.Time
+	sent        int
+	suppressed  int
+}
+
+func (ns *NotifySink[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	size, linger := 1, time.Millisecond
+	if ns.Digest > 0 {
+		size, linger = ns.MaxDigest, ns.Digest
+		if size <= 0 {
+			size = DefaultBatchSize
+		}
+	}
+
+	executeSink[E](ctx, ns, input, output, size, linger, nil, ns.notify)
+}
+
+func (ns *NotifySink[E]) Name() string {
+	return fmt.Sprintf("NotifySink/%s", ns.ChainName)
+}
+
+func (ns *NotifySink[E]) notify(ctx context.Context, items []E) error {
+	suppressed, ok := ns.allow(len(items))
+	if !ok {
+		Emit[E](ctx, ns, EventNotificationsSuppressed, "%d items over the limit of %d notifications per %s", len(items), ns.Limit, ns.Interval)
+
+		for _, m := range items {
+			TrackPassthrough[E](ctx, ns, m)
+		}
+
+		return nil
+	}
+
+	format := ns.Format
+	if format == nil {
+		format = ns.defaultFormat
+	}
+
+	n := format(items)
+	if suppressed > 0 {
+		n.Body += fmt.Sprintf("\n\n%d more items were suppressed by rate limiting", suppressed)
+	}
+
+	if err := ns.Notifier.Notify(ctx, n); err != nil {
+		return err
+	}
+
+	for _, m := range items {
+		TrackOutput[E](ctx, ns, m)
+	}
+
+	return nil
+}
+
+// allow returns whether a notification can be sent now, and how many items
+// were suppressed since the last one sent
+func (ns *NotifySink[E]) allow(items int) (int, bool) {
+	ns.lock.Lock()
+	defer ns.lock.Unlock()
+
+	if ns.Limit <= 0 {
+		return 0, true
+	}
+
+	if time.Since(ns.windowStart) >= ns.Interval {
+		ns.windowStart = time.Now()
+		ns.sent = 0
+	}
+
+	if ns.sent >= ns.Limit {
+		ns.suppressed += items
+		return 0, false
+	}
+
+	ns.sent++
+
+	suppressed := ns.suppressed
+	ns.suppressed = 0
+
+	return suppressed, true
+}
+
+func (ns *NotifySink[E]) defaultFormat(items []E) Notification {
+	lines := make([]string, len(items))
+
+	for i, m := range items {
+		encoded, err := json.Marshal(m)
+		if err != nil {
+			lines[i] = fmt.Sprintf("%v", m)
+			continue
+		}
+
+		lines[i] = string(encoded)
+	}
+
+	subject := fmt.Sprintf("%s: %d items", ns.ChainName, len(items))
+	if len(items) == 1 {
+		subject = fmt.Sprintf("%s: %s", ns.ChainName, lines[0])
+	}
+
+	return Notification{
+		Subject: subject,
+		Body:    strings.Join(lines, "\n"),
+	}
+}
+
+/*
+	SMTPNotifier sends notifications as plain text emails
+*/
+type SMTPNotifier struct {
+	Addr string
+	Auth smtp.Auth
+	From string
+	To   []string
+}
+
+func (s *SMTPNotifier) Notify(ctx context.Context, n Notification) error {
+	msg := &bytes.Buffer{}
+
+	fmt.Fprintf(msg, "From: %s\r\n", s.From)
+	fmt.Fprintf(msg, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(msg, "Subject: %s\r\n", strings.ReplaceAll(n.Subject, "\n", " "))
+	fmt.Fprintf(msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(n.Body, "\n", "\r\n"))
+
+	return smtp.SendMail(s.Addr, s.Auth, s.From, s.To, msg.Bytes())
+}
+
+/*
+	SlackNotifier posts notifications to a Slack incoming webhook
+*/
+type SlackNotifier struct {
+	WebhookURL string
+	Client     *http.Client
+}
+
+func (s *SlackNotifier) Notify(ctx context.Context, n Notification) error {
+	payload, err := json.Marshal(map[string]string{
+		"text": fmt.Sprintf("*%s*\n%s", n.Subject, n.Body),
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.WebhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack answered %s: %w", resp.Status, ErrNotificationFailed)
+	}
+
+	return nil
+}
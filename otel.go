@@ -0,0 +1,223 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var PipelineOTel PipelineContextKey = "pipeline_otel"
+
+// at most this many item spans are kept open, the oldest ones being ended
+// first, so items dropped in a stream do not keep spans forever
+const DefaultMaxOpenSpans = 10000
+
+/*
+	TraceContextCarrier is implemented by items carrying the span context of
+	the trace they belong to, from one stage to the next, and possibly from
+	the services they came from.
+*/
+type TraceContextCarrier interface {
+	SpanContext() trace.SpanContext
+	SetSpanContext(sc trace.SpanContext)
+}
+
+type itemSpan struct {
+	span      trace.Span
+	parent    trace.SpanContext
+	processor interface{}
+}
+
+func (s *itemSpan) Reset() {
+	*s = itemSpan{}
+}
+
+// itemSpans are the records of the open spans, one per item and processor,
+// reused once their span is ended
+var itemSpans = NewObjectPool(func() *itemSpan {
+	return &itemSpan{}
+})
+
+type otelTracing struct {
+	tracer trace.Tracer
+
+	lock  sync.Mutex
+	open  map[trace.SpanID]*itemSpan
+	order []trace.SpanID
+}
+
+/*
+	WithOTel enables OpenTelemetry tracing of the items implementing
+	TraceContextCarrier: every processor creates a span for every item it
+	receives, child of the span the item carries, and ended once the item is
+	sent on. Spans of composites are the parents of the spans of their
+	children, so every hop of an item shows up in its distributed trace.
+
+	Spans of items a processor does not send on, as when it drops or fails
+	them, are ended when the processor finishes. Items sent to several
+	branches at once, as by a Fanout, must synchronize their span context.
+*/
+func WithOTel(ctx context.Context, tracer trace.Tracer) context.Context {
+	return context.WithValue(ctx, PipelineOTel, &otelTracing{
+		tracer: tracer,
+		open:   make(map[trace.SpanID]*itemSpan),
+	})
+}
+
+/*
+	ItemContext returns ctx with the span context of item, so the calls a
+	processor makes for the item, such as HTTP requests or database queries,
+	are traced as part of its span.
+*/
+func ItemContext(ctx context.Context, item Traceable) context.Context {
+	carrier, ok := item.(TraceContextCarrier)
+	if !ok {
+		return ctx
+	}
+
+	if sc := carrier.SpanContext(); sc.IsValid() {
+		return trace.ContextWithSpanContext(ctx, sc)
+	}
+
+	return ctx
+}
+
+func startItemSpan[E Traceable](ctx context.Context, p Processor[E], item Traceable) {
+	tracing, ok := ctx.Value(PipelineOTel).(*otelTracing)
+	if !ok {
+		return
+	}
+
+	carrier, ok := item.(TraceContextCarrier)
+	if !ok {
+		return
+	}
+
+	parent := carrier.SpanContext()
+
+	tracing.lock.Lock()
+	defer tracing.lock.Unlock()
+
+	// an item received again by the same processor, as when it is retried
+	if open, found := tracing.open[parent.SpanID()]; found && open.processor == p {
+		id := parent.SpanID()
+		parent = open.parent
+		tracing.end(id, false)
+	}
+
+	spanCtx := trace.ContextWithRemoteSpanContext(ctx, parent)
+	if !parent.IsValid() {
+		spanCtx = ctx
+	}
+
+	_, span := tracing.tracer.Start(spanCtx, p.Name(), trace.WithAttributes(
+		attribute.String("pipeline.processor", p.Name()),
+		attribute.String("pipeline.run_id", RunID(ctx)),
+	))
+
+	sc := span.SpanContext()
+	if !sc.IsValid() {
+		span.End()
+		return
+	}
+
+	open := itemSpans.Get()
+	open.span, open.parent, open.processor = span, parent, p
+
+	tracing.open[sc.SpanID()] = open
+	tracing.order = append(tracing.order, sc.SpanID())
+
+	for len(tracing.open) > DefaultMaxOpenSpans {
+		tracing.end(tracing.order[0], false)
+	}
+
+	carrier.SetSpanContext(sc)
+}
+
+// endItemSpan ends the span p started for item, which then carries the span
+// it had when p received it again
+func endItemSpan[E Traceable](ctx context.Context, p Processor[E], item Traceable) {
+	tracing, ok := ctx.Value(PipelineOTel).(*otelTracing)
+	if !ok {
+		return
+	}
+
+	carrier, ok := item.(TraceContextCarrier)
+	if !ok {
+		return
+	}
+
+	id := carrier.SpanContext().SpanID()
+
+	tracing.lock.Lock()
+	defer tracing.lock.Unlock()
+
+	open, found := tracing.open[id]
+	if !found || open.processor != p {
+		return
+	}
+
+	parent := open.parent
+	tracing.end(id, true)
+	carrier.SetSpanContext(parent)
+}
+
+// endProcessorSpans ends the spans of the items p did not send on
+func endProcessorSpans[E Traceable](ctx context.Context, p Processor[E]) {
+	tracing, ok := ctx.Value(PipelineOTel).(*otelTracing)
+	if !ok {
+		return
+	}
+
+	tracing.lock.Lock()
+	defer tracing.lock.Unlock()
+
+	for id, open := range tracing.open {
+		if open.processor == p {
+			tracing.end(id, false)
+		}
+	}
+}
+
+// end ends an open span, with the lock held
+func (t *otelTracing) end(id trace.SpanID, sent bool) {
+	open, found := t.open[id]
+	if !found {
+		t.compact()
+		return
+	}
+
+	open.span.SetAttributes(attribute.Bool("pipeline.item.sent", sent))
+	open.span.End()
+
+	delete(t.open, id)
+	itemSpans.Put(open)
+
+	t.compact()
+}
+
+// compact drops the ended spans from the order
+func (t *otelTracing) compact() {
+	for len(t.order) > 0 {
+		if _, found := t.open[t.order[0]]; found {
+			break
+		}
+
+		t.order = t.order[1:]
+	}
+
+	if len(t.order) <= 2*DefaultMaxOpenSpans {
+		return
+	}
+
+	order := make([]trace.SpanID, 0, len(t.open))
+	for _, id := range t.order {
+		if _, found := t.open[id]; found {
+			order = append(order, id)
+		}
+	}
+
+	t.order = order
+}
@@ -0,0 +1,302 @@
+package pipeline
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	The Outbox helps pipelines stay consistent with a primary database: items
+	are written to an outbox table in the same transaction as the business
+	data they describe, and an OutboxRelay publishes them to the pipeline once
+	the transaction is committed.
+
+	The table must have these columns, the id being assigned by the database in
+	increasing order:
+
+		id         integer primary key
+		payload    blob / bytea / text
+		created_at timestamp
+*/
+type Outbox struct {
+	Table string
+	Codec string
+
+	// Placeholder returns the nth (starting at 1) query parameter placeholder
+	// of the SQL dialect, "?" when nil. For PostgreSQL use PostgresPlaceholder.
+	Placeholder func(n int) string
+}
+
+func PostgresPlaceholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func (o *Outbox) table() string {
+	if o.Table == "" {
+		return "pipeline_outbox"
+	}
+
+	return o.Table
+}
+
+func (o *Outbox) placeholder(n int) string {
+	if o.Placeholder == nil {
+		return "?"
+	}
+
+	return o.Placeholder(n)
+}
+
+// Write adds the item to the outbox as part of tx
+func (o *Outbox) Write(ctx context.Context, tx *sql.Tx, item interface{}) error {
+	codec, err := lookupCodec(o.Codec)
+	if err != nil {
+		return err
+	}
+
+	payload, err := codec.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (payload, created_at) VALUES (%s, %s)", o.table(), o.placeholder(1), o.placeholder(2))
+
+	_, err = tx.ExecContext(ctx, query, payload, time.Now().UTC())
+	return err
+}
+
+/*
+	The OutboxRelay processor publishes the items of an Outbox, polling it
+	every PollInterval for at most BatchSize rows. Items received from the
+	input are sent on unchanged, and polling stops once the input is closed
+	and the outbox is empty.
+
+	Published rows are deleted once sent to the output, so items are delivered
+	at least once. With Retain, rows are kept and the relay position, the last
+	id published, is instead part of the state of the relay, snapshot with the
+	rest of the pipeline, and can be moved with SeekOffset to publish items
+	again. Retain assumes ids become visible in increasing order.
+*/
+type OutboxRelay[E Traceable] struct {
+	ChainName string
+
+	DB     *sql.DB
+	Outbox *Outbox
+	New    func() E
+
+	PollInterval time.Duration
+	BatchSize    int
+	Retain       bool
+
+	lock     sync.Mutex
+	position int64
+}
+
+func (r *OutboxRelay[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	inputDone := make(chan struct{})
+
+	go func() {
+		for m := range input {
+			TrackItemInput[E](ctx, r, m)
+			TrackPassthrough[E](ctx, r, m)
+			output <- m
+		}
+
+		close(inputDone)
+	}()
+
+	interval := r.PollInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for running := true; ; {
+		n, err := r.relay(ctx, output)
+		if err != nil {
+			Log[E](ctx, r, "failed relaying outbox: %s", err)
+			TrackFailure[E](ctx, r)
+			ReportError(ctx, r, fmt.Errorf("relaying outbox: %w", err))
+		}
+
+		if err == nil && n == r.batchSize() {
+			continue
+		}
+
+		if !running {
+			break
+		}
+
+		select {
+		case <-ticker.C:
+		case <-inputDone:
+			// one last poll for the items written before the input was closed
+			running = false
+		case <-ctx.Done():
+			<-inputDone
+			close(output)
+			return
+		}
+	}
+
+	<-inputDone
+	close(output)
+}
+
+func (r *OutboxRelay[E]) Name() string {
+	return fmt.Sprintf("OutboxRelay/%s", r.ChainName)
+}
+
+func (r *OutboxRelay[E]) batchSize() int {
+	if r.BatchSize <= 0 {
+		return DefaultBatchSize
+	}
+
+	return r.BatchSize
+}
+
+// relay publishes a batch of rows, and returns how many were found
+func (r *OutboxRelay[E]) relay(ctx context.Context, output chan E) (int, error) {
+	codec, err := lookupCodec(r.Outbox.Codec)
+	if err != nil {
+		return 0, err
+	}
+
+	r.lock.Lock()
+	position := r.position
+	r.lock.Unlock()
+
+	query := fmt.Sprintf("SELECT id, payload FROM %s ORDER BY id LIMIT %d", r.Outbox.table(), r.batchSize())
+	args := []interface{}{}
+
+	if r.Retain {
+		query = fmt.Sprintf("SELECT id, payload FROM %s WHERE id > %s ORDER BY id LIMIT %d", r.Outbox.table(), r.Outbox.placeholder(1), r.batchSize())
+		args = append(args, position)
+	}
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	var ids []int64
+	var payloads [][]byte
+
+	for rows.Next() {
+		var id int64
+		var payload []byte
+
+		if err := rows.Scan(&id, &payload); err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		ids = append(ids, id)
+		payloads = append(payloads, payload)
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for i, payload := range payloads {
+		item := r.New()
+
+		if err := codec.Unmarshal(payload, item); err != nil {
+			Log[E](ctx, r, "failed decoding outbox row %d: %s", ids[i], err)
+			TrackFailure[E](ctx, r)
+			ReportError(ctx, r, fmt.Errorf("decoding outbox row %d: %w", ids[i], err))
+			continue
+		}
+
+		TrackOutput[E](ctx, r, item)
+		output <- item
+	}
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	if r.Retain {
+		r.lock.Lock()
+		r.position = ids[len(ids)-1]
+		r.lock.Unlock()
+
+		return len(ids), nil
+	}
+
+	placeholders := make([]string, len(ids))
+	args = make([]interface{}, len(ids))
+
+	for i, id := range ids {
+		placeholders[i] = r.Outbox.placeholder(i + 1)
+		args[i] = id
+	}
+
+	query = fmt.Sprintf("DELETE FROM %s WHERE id IN (%s)", r.Outbox.table(), strings.Join(placeholders, ", "))
+	if _, err := r.DB.ExecContext(ctx, query, args...); err != nil {
+		return len(ids), err
+	}
+
+	return len(ids), nil
+}
+
+func (r *OutboxRelay[E]) SnapshotState() ([]byte, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return []byte(strconv.FormatInt(r.position, 10)), nil
+}
+
+func (r *OutboxRelay[E]) RestoreState(state []byte) error {
+	return r.SeekOffset(context.Background(), string(state))
+}
+
+// SeekOffset makes the relay publish the rows after the given id
+func (r *OutboxRelay[E]) SeekOffset(ctx context.Context, offset string) error {
+	position, err := strconv.ParseInt(offset, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.position = position
+
+	return nil
+}
+
+// SeekTime makes the relay publish the rows created at or after t
+func (r *OutboxRelay[E]) SeekTime(ctx context.Context, t time.Time) error {
+	query := fmt.Sprintf("SELECT COALESCE(MIN(id), 0) FROM %s WHERE created_at >= %s", r.Outbox.table(), r.Outbox.placeholder(1))
+
+	var id int64
+	if err := r.DB.QueryRowContext(ctx, query, t.UTC()).Scan(&id); err != nil {
+		return err
+	}
+
+	if id == 0 {
+		// nothing created since t, skip everything there is
+		query = fmt.Sprintf("SELECT COALESCE(MAX(id), 0) + 1 FROM %s", r.Outbox.table())
+		if err := r.DB.QueryRowContext(ctx, query).Scan(&id); err != nil {
+			return err
+		}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.position = id - 1
+
+	return nil
+}
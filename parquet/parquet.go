@@ -0,0 +1,76 @@
+/*
+	Package parquet writes the files of a pipeline.FileSink as Parquet.
+*/
+package parquet
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/ca0s/pipeline"
+	"github.com/parquet-go/parquet-go"
+)
+
+/*
+	ParquetFormat writes Parquet files, a row group per batch.
+
+	The schema is Schema when set, or derived from the struct type of the first
+	item of every file, following the `parquet` struct tags.
+
+	Compression is "snappy", "gzip", "zstd" or empty for none.
+*/
+type ParquetFormat struct {
+	Schema      *parquet.Schema
+	Compression string
+}
+
+func (f *ParquetFormat) Extension() string {
+	return ".parquet"
+}
+
+func (f *ParquetFormat) NewEncoder(w io.Writer, sample interface{}) (pipeline.FileEncoder, error) {
+	schema := f.Schema
+	if schema == nil {
+		v, ok := pipeline.StructValue(sample)
+		if !ok {
+			return nil, fmt.Errorf("%T: %w", sample, pipeline.ErrUnsupportedField)
+		}
+
+		schema = parquet.SchemaOf(v.Interface())
+	}
+
+	// unbuffered, so every row group reaches the file when flushed
+	options := []parquet.WriterOption{schema, parquet.WriteBufferSize(0)}
+
+	switch f.Compression {
+	case "":
+	case "snappy":
+		options = append(options, parquet.Compression(&parquet.Snappy))
+	case "gzip":
+		options = append(options, parquet.Compression(&parquet.Gzip))
+	case "zstd":
+		options = append(options, parquet.Compression(&parquet.Zstd))
+	default:
+		return nil, fmt.Errorf("unknown parquet compression %s: %w", f.Compression, pipeline.ErrUnknownCodec)
+	}
+
+	return &parquetEncoder{writer: parquet.NewWriter(w, options...)}, nil
+}
+
+type parquetEncoder struct {
+	writer *parquet.Writer
+}
+
+func (e *parquetEncoder) Encode(items []interface{}) error {
+	for _, item := range items {
+		if err := e.writer.Write(item); err != nil {
+			return err
+		}
+	}
+
+	return e.writer.Flush()
+}
+
+func (e *parquetEncoder) Close() error {
+	return e.writer.Close()
+}
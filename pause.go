@@ -0,0 +1,106 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+)
+
+/*
+	Pausable is implemented by the composites whose input can be held while
+	they run, such as during the maintenance of a downstream system: Fanout,
+	Sequential and Parallel. Pause stops them from taking items from their
+	input, which then wait upstream, while the items they already took go
+	on through their processors. Resume lets them take items again. Neither
+	drops any item.
+*/
+type Pausable interface {
+	Pause()
+	Resume()
+	Paused() bool
+}
+
+// pauser holds the input forwarding of a composite while it is paused
+type pauser struct {
+	lock    sync.Mutex
+	resumed chan struct{}
+}
+
+func (p *pauser) pause() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.resumed == nil {
+		p.resumed = make(chan struct{})
+	}
+}
+
+func (p *pauser) resume() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.resumed != nil {
+		close(p.resumed)
+		p.resumed = nil
+	}
+}
+
+func (p *pauser) paused() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.resumed != nil
+}
+
+// wait returns once the composite is not paused, false if ctx is done first
+func (p *pauser) wait(ctx context.Context) bool {
+	p.lock.Lock()
+	resumed := p.resumed
+	p.lock.Unlock()
+
+	if resumed == nil {
+		return true
+	}
+
+	select {
+	case <-resumed:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (fanout *Fanout[E]) Pause() {
+	fanout.pauses.pause()
+}
+
+func (fanout *Fanout[E]) Resume() {
+	fanout.pauses.resume()
+}
+
+func (fanout *Fanout[E]) Paused() bool {
+	return fanout.pauses.paused()
+}
+
+func (sequential *Sequential[E]) Pause() {
+	sequential.pauses.pause()
+}
+
+func (sequential *Sequential[E]) Resume() {
+	sequential.pauses.resume()
+}
+
+func (sequential *Sequential[E]) Paused() bool {
+	return sequential.pauses.paused()
+}
+
+func (parallel *Parallel[E]) Pause() {
+	parallel.pauses.pause()
+}
+
+func (parallel *Parallel[E]) Resume() {
+	parallel.pauses.resume()
+}
+
+func (parallel *Parallel[E]) Paused() bool {
+	return parallel.pauses.paused()
+}
@@ -4,8 +4,13 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 )
 
+// DefaultFanoutBuffer is the buffer size of the branches of a Fanout without
+// a BufferSize
+const DefaultFanoutBuffer = 200
+
 /*
 	A processor is the basic block of this library. An implementation should:
 
@@ -32,13 +37,36 @@ type Processor[E Traceable] interface {
 
 	Input is forwarded to ALL processors. Their output is collected and forwarded
 	to the Fanout output.
+
+	Branches named in NonCritical are supervised: a panic in their Execute is
+	recovered, and they are abandoned if still running CloseTimeout after the
+	input is closed, so the Fanout can finish without them. Critical branches
+	still running after CloseTimeout are reported, and waited for.
+
+	The input and output of every branch are buffered for BufferSize items,
+	DefaultFanoutBuffer when it is zero. Overflow is what the input of a
+	branch does once it is full, blocking the Fanout by default, so a
+	stalled branch can drop or spill its items instead of holding back the
+	others.
 */
 type Fanout[E Traceable] struct {
-	ChainName string
+	ChainName  string
+	Display    string
+	Tags       map[string]string
+	BufferSize int
+	Overflow   BufferPolicy
+	SpillDir   string
 
 	Processors   []Processor[E]
-	procInChans  []chan E
+	procInChans  []*stageBuffer[E]
 	procOutChans []chan E
+	queues       sync.RWMutex
+
+	CloseTimeout time.Duration
+	NonCritical  []string
+
+	executions executions
+	pauses     pauser
 }
 
 /*
@@ -52,12 +80,27 @@ type Fanout[E Traceable] struct {
 	to the next one in the list sequentially.
 
 	The output of the last processor is collected and sent to the Sequential output.
+
+	The output of every processor is buffered for BufferSize items, and
+	unbuffered when it is zero. With an Overflow policy other than
+	BufferBlock, the input of every processor is buffered instead, and
+	applies it once full.
 */
 type Sequential[E Traceable] struct {
-	ChainName string
+	ChainName  string
+	Display    string
+	Tags       map[string]string
+	BufferSize int
+	Overflow   BufferPolicy
+	SpillDir   string
 
 	Processors   []Processor[E]
 	procOutChans []chan E
+	procInBufs   []*stageBuffer[E]
+	queues       sync.RWMutex
+
+	executions executions
+	pauses     pauser
 }
 
 /*
@@ -70,17 +113,36 @@ type Sequential[E Traceable] struct {
 	Each item coming from the input is forwarded to the first available processor.
 
 	Processor's output is collected and forwarded to the Parallel output.
+
+	The output of every processor is buffered for BufferSize items, and
+	unbuffered when it is zero. With an Overflow policy other than
+	BufferBlock, the input shared by the processors is buffered for
+	BufferSize items too, and applies it once full.
 */
 type Parallel[E Traceable] struct {
-	ChainName string
+	ChainName  string
+	Display    string
+	Tags       map[string]string
+	BufferSize int
+	Overflow   BufferPolicy
+	SpillDir   string
 
 	Processors []Processor[E]
 	procChans  []chan E
+	procInBuf  *stageBuffer[E]
+	queues     sync.RWMutex
+
+	executions executions
+	pauses     pauser
 }
 
 func (fanout *Fanout[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	fanout.executions.begin()
+	defer fanout.executions.end()
+
 	Log[E](ctx, fanout, "starting")
 	TrackStarted[E](ctx, fanout)
+	ctx = withErrorScope[E](ctx, fanout)
 
 	if len(fanout.Processors) == 0 {
 		close(output)
@@ -90,54 +152,77 @@ func (fanout *Fanout[E]) Execute(ctx context.Context, input chan E, output chan
 	wg := sync.WaitGroup{}
 	collectorWg := sync.WaitGroup{}
 
-	fanout.procInChans = make([]chan E, len(fanout.Processors))
-	fanout.procOutChans = make([]chan E, len(fanout.Processors))
+	fanout.queues.Lock()
+	fanout.procInChans = make([]*stageBuffer[E], 0, len(fanout.Processors))
+	fanout.procOutChans = make([]chan E, 0, len(fanout.Processors))
 
 	fanoutCollector := make(chan E)
 
 	collectorWg.Add(1)
 	go func() {
 		for m := range fanoutCollector {
+			if ctx.Err() != nil {
+				abandon(ctx, m)
+				continue
+			}
+
 			TrackOutput[E](ctx, fanout, m)
-			output <- m
+			send(ctx, output, m)
 		}
 		collectorWg.Done()
 	}()
 
-	for procIndex, proc := range fanout.Processors {
-		procInput := make(chan E, 200)
-		procOutput := make(chan E, 200)
+	var taps []Tap[E]
+	var branches []*branch[E]
 
-		fanout.procInChans[procIndex] = procInput
-		fanout.procOutChans[procIndex] = procOutput
+	for _, proc := range fanout.Processors {
+		if t, ok := proc.(Tap[E]); ok {
+			taps = append(taps, t)
+			continue
+		}
 
-		wg.Add(1)
-		go func(p Processor[E]) {
-			p.Execute(ctx, procInput, procOutput)
-			wg.Done()
-		}(proc)
+		procInput := newPolicyBuffer[E](ctx, fanout, fanout.bufferSize(), fanout.Overflow, fanout.SpillDir)
+		procOutput := make(chan E, fanout.bufferSize())
 
-		wg.Add(1)
-		go func() {
-			for m := range procOutput {
-				fanoutCollector <- m
-			}
-			wg.Done()
-		}()
+		fanout.procInChans = append(fanout.procInChans, procInput)
+		fanout.procOutChans = append(fanout.procOutChans, procOutput)
+
+		b := newBranch[E](proc, fanout.isCritical(proc), procInput.output)
+		branches = append(branches, b)
+
+		go b.run(ctx, fanout, procOutput)
+		go b.forward(procOutput, fanoutCollector)
 	}
+	fanout.queues.Unlock()
+
+	startTaps(ctx, taps)
 
 	wg.Add(1)
 	go func() {
-		for msg := range input {
-			TrackInput[E](ctx, fanout)
+		for fanout.pauses.wait(ctx) {
+			msg, ok := receive(ctx, input)
+			if !ok {
+				break
+			}
+
+			TrackItemInput[E](ctx, fanout, msg)
 
 			for _, procInput := range fanout.procInChans {
-				procInput <- msg
+				procInput.send(msg)
+			}
+
+			// every tap is a branch of its own, sending the item on
+			observe(ctx, taps, msg)
+
+			for range taps {
+				send(ctx, fanoutCollector, msg)
 			}
 		}
 
+		inputClosed[E](ctx, fanout)
+
 		for _, procInput := range fanout.procInChans {
-			close(procInput)
+			procInput.close()
 		}
 
 		wg.Done()
@@ -145,20 +230,45 @@ func (fanout *Fanout[E]) Execute(ctx context.Context, input chan E, output chan
 
 	wg.Wait()
 
+	superviseClose(ctx, fanout, branches, fanout.CloseTimeout)
+
 	close(fanoutCollector)
 	collectorWg.Wait()
 
+	finishTaps(ctx, taps)
 	TrackFinished[E](ctx, fanout)
 	close(output)
 }
 
+func (fanout *Fanout[E]) bufferSize() int {
+	if fanout.BufferSize <= 0 {
+		return DefaultFanoutBuffer
+	}
+
+	return fanout.BufferSize
+}
+
+func (fanout *Fanout[E]) isCritical(p Processor[E]) bool {
+	for _, name := range fanout.NonCritical {
+		if name == p.Name() {
+			return false
+		}
+	}
+
+	return true
+}
+
 func (fanout *Fanout[E]) Name() string {
 	return fmt.Sprintf("Fanout/%s", fanout.ChainName)
 }
 
 func (chain *Sequential[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	chain.executions.begin()
+	defer chain.executions.end()
+
 	Log[E](ctx, chain, "starting")
 	TrackStarted[E](ctx, chain)
+	ctx = withErrorScope[E](ctx, chain)
 
 	if len(chain.Processors) == 0 {
 		close(output)
@@ -167,12 +277,23 @@ func (chain *Sequential[E]) Execute(ctx context.Context, input chan E, output ch
 
 	wg := sync.WaitGroup{}
 
-	lastIndex := len(chain.Processors) - 1
-	chain.procOutChans = make([]chan E, len(chain.Processors))
+	stages, entryTaps, exitTaps := planTaps(chain.Processors)
+	stages = fuseBatchStages(stages)
+
+	startTaps(ctx, entryTaps)
+	startTaps(ctx, exitTaps)
+
+	chain.queues.Lock()
+	chain.procOutChans = make([]chan E, len(stages))
+
+	chain.procInBufs = nil
+	if chain.Overflow != BufferBlock {
+		chain.procInBufs = make([]*stageBuffer[E], len(stages))
+	}
 
 	var entryChannel chan E
 
-	for procIndex, proc := range chain.Processors {
+	for procIndex, proc := range stages {
 		var procInput chan E
 		var procOutput chan E
 
@@ -183,24 +304,58 @@ func (chain *Sequential[E]) Execute(ctx context.Context, input chan E, output ch
 			procInput = chain.procOutChans[procIndex-1]
 		}
 
-		procOutput = make(chan E)
+		procOutput = make(chan E, chain.BufferSize)
+
+		if chain.procInBufs != nil {
+			procBuffer := newPolicyBuffer[E](ctx, chain, chain.BufferSize, chain.Overflow, chain.SpillDir)
+			chain.procInBufs[procIndex] = procBuffer
+
+			wg.Add(1)
+			go func(upstream chan E) {
+				procBuffer.relay(upstream)
+				wg.Done()
+			}(procInput)
+
+			procInput = procBuffer.output
+			procOutput = make(chan E)
+		}
 
 		chain.procOutChans[procIndex] = procOutput
 
 		wg.Add(1)
 		go func(s Processor[E]) {
-			s.Execute(ctx, procInput, procOutput)
+			runProcessor[E](ctx, s, procInput, procOutput)
 			wg.Done()
 		}(proc)
 	}
+	chain.queues.Unlock()
+
+	var lastOutput chan E
+
+	if len(stages) == 0 {
+		entryChannel = make(chan E)
+		lastOutput = entryChannel
+	} else {
+		lastOutput = chain.procOutChans[len(stages)-1]
+	}
 
 	wg.Add(1)
 	go func() {
-		for msg := range input {
-			TrackInput[E](ctx, chain)
-			entryChannel <- msg
+		for chain.pauses.wait(ctx) {
+			msg, ok := receive(ctx, input)
+			if !ok {
+				break
+			}
+
+			TrackItemInput[E](ctx, chain, msg)
+			observe(ctx, entryTaps, msg)
+
+			if !send(ctx, entryChannel, msg) {
+				break
+			}
 		}
 
+		inputClosed[E](ctx, chain)
 		close(entryChannel)
 
 		wg.Done()
@@ -208,15 +363,24 @@ func (chain *Sequential[E]) Execute(ctx context.Context, input chan E, output ch
 
 	wg.Add(1)
 	go func() {
-		for m := range chain.procOutChans[lastIndex] {
+		for m := range lastOutput {
+			if ctx.Err() != nil {
+				abandon(ctx, m)
+				continue
+			}
+
+			observe(ctx, exitTaps, m)
 			TrackOutput[E](ctx, chain, m)
-			output <- m
+			send(ctx, output, m)
 		}
 		wg.Done()
 	}()
 
 	wg.Wait()
 
+	finishTaps(ctx, entryTaps)
+	finishTaps(ctx, exitTaps)
+
 	TrackFinished[E](ctx, chain)
 	close(output)
 }
@@ -226,8 +390,12 @@ func (sequential *Sequential[E]) Name() string {
 }
 
 func (chain *Parallel[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	chain.executions.begin()
+	defer chain.executions.end()
+
 	Log[E](ctx, chain, "starting")
 	TrackStarted[E](ctx, chain)
+	ctx = withErrorScope[E](ctx, chain)
 
 	if len(chain.Processors) == 0 {
 		close(output)
@@ -235,29 +403,88 @@ func (chain *Parallel[E]) Execute(ctx context.Context, input chan E, output chan
 	}
 
 	wg := sync.WaitGroup{}
+	collectorWg := sync.WaitGroup{}
+
+	collector := make(chan E)
+
+	collectorWg.Add(1)
+	go func() {
+		for m := range collector {
+			if ctx.Err() != nil {
+				abandon(ctx, m)
+				continue
+			}
+
+			TrackOutput[E](ctx, chain, m)
+			send(ctx, output, m)
+		}
+		collectorWg.Done()
+	}()
+
+	procInput := make(chan E)
+
+	wg.Add(1)
+	go func() {
+		for chain.pauses.wait(ctx) {
+			msg, ok := receive(ctx, input)
+			if !ok {
+				break
+			}
+
+			TrackItemInput[E](ctx, chain, msg)
+
+			if !send(ctx, procInput, msg) {
+				break
+			}
+		}
+
+		inputClosed[E](ctx, chain)
+		close(procInput)
+		wg.Done()
+	}()
+
+	chain.queues.Lock()
+	chain.procInBuf = nil
+	procsInput := procInput
+
+	if chain.Overflow != BufferBlock {
+		chain.procInBuf = newPolicyBuffer[E](ctx, chain, chain.BufferSize, chain.Overflow, chain.SpillDir)
+		procsInput = chain.procInBuf.output
+
+		wg.Add(1)
+		go func(procBuffer *stageBuffer[E]) {
+			procBuffer.relay(procInput)
+			wg.Done()
+		}(chain.procInBuf)
+	}
+
+	chain.procChans = make([]chan E, len(chain.Processors))
 
 	for procIndex, proc := range chain.Processors {
-		procOutput := make(chan E)
+		procOutput := make(chan E, chain.BufferSize)
 		chain.procChans[procIndex] = procOutput
 
 		wg.Add(1)
 		go func() {
-			proc.Execute(ctx, input, procOutput)
+			runProcessor[E](ctx, proc, procsInput, procOutput)
 			wg.Done()
 		}()
 
 		wg.Add(1)
 		go func() {
 			for m := range procOutput {
-				TrackOutput[E](ctx, chain, m)
-				output <- m
+				collector <- m
 			}
 			wg.Done()
 		}()
 	}
+	chain.queues.Unlock()
 
 	wg.Wait()
 
+	close(collector)
+	collectorWg.Wait()
+
 	TrackFinished[E](ctx, chain)
 	close(output)
 }
@@ -0,0 +1,83 @@
+package pipeline
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+)
+
+// testItem is the item processors are tested with
+type testItem struct {
+	Value  string `json:"value"`
+	traces []string
+}
+
+func (item *testItem) AddTrace(trace string) {
+	item.traces = append(item.traces, trace)
+}
+
+func (item *testItem) Traces() []string {
+	return item.traces
+}
+
+func newItems(values ...string) []*testItem {
+	items := make([]*testItem, 0, len(values))
+
+	for _, value := range values {
+		items = append(items, &testItem{Value: value})
+	}
+
+	return items
+}
+
+func itemValues(items []*testItem) []string {
+	values := make([]string, 0, len(items))
+
+	for _, item := range items {
+		values = append(values, item.Value)
+	}
+
+	sort.Strings(values)
+
+	return values
+}
+
+// runItems executes p on items, and returns its output once it is closed
+func runItems(t *testing.T, ctx context.Context, p Processor[*testItem], items []*testItem) []*testItem {
+	t.Helper()
+
+	input := make(chan *testItem)
+	output := make(chan *testItem)
+
+	go func() {
+		defer close(input)
+
+		for _, item := range items {
+			select {
+			case input <- item:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go p.Execute(ctx, input, output)
+
+	result := []*testItem{}
+	timeout := time.After(5 * time.Second)
+
+	for {
+		select {
+		case item, ok := <-output:
+			if !ok {
+				return result
+			}
+
+			result = append(result, item)
+		case <-timeout:
+			t.Fatalf("%s did not close its output", p.Name())
+			return nil
+		}
+	}
+}
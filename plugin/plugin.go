@@ -0,0 +1,279 @@
+/*
+	Package plugin registers processors from plugins: Go plugins loaded in
+	the process, or plugin programs run next to it.
+*/
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/rpc"
+	"os/exec"
+	"plugin"
+
+	"github.com/ca0s/pipeline"
+	"github.com/hashicorp/go-hclog"
+	goplugin "github.com/hashicorp/go-plugin"
+)
+
+var ErrInvalidPlugin = fmt.Errorf("invalid plugin")
+
+/*
+	LoadPlugin opens the Go plugin at path, built with -buildmode=plugin
+	against the same version of the pipeline package, and calls the func()
+	it exports as Register, which registers its processors with
+	pipeline.RegisterProcessor. Plugins registering their processors in their init
+	functions need not export Register.
+*/
+func LoadPlugin(path string) error {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return fmt.Errorf("%s: %w: %w", path, err, ErrInvalidPlugin)
+	}
+
+	symbol, err := p.Lookup("Register")
+	if err != nil {
+		return nil
+	}
+
+	register, ok := symbol.(func())
+	if !ok {
+		return fmt.Errorf("%s: Register is a %T, not a func(): %w", path, symbol, ErrInvalidPlugin)
+	}
+
+	register()
+
+	return nil
+}
+
+// PluginHandshake is shared by the host and its out of process plugins, so
+// they do not run as plain programs
+var PluginHandshake = goplugin.HandshakeConfig{
+	ProtocolVersion:  1,
+	MagicCookieKey:   "PIPELINE_PLUGIN",
+	MagicCookieValue: "items",
+}
+
+/*
+	ItemPlugin is implemented by out of process plugins: Process handles an
+	item, encoded with the codec of the host, with the processor named name
+	and returns it encoded the same way.
+*/
+type ItemPlugin interface {
+	Names() []string
+	Process(name string, item []byte) ([]byte, error)
+}
+
+/*
+	ServePlugin runs the plugin program serving impl, until the host stops
+	it. It is the only call the main of a plugin makes.
+*/
+func ServePlugin(impl ItemPlugin) {
+	goplugin.Serve(&goplugin.ServeConfig{
+		HandshakeConfig: PluginHandshake,
+		Plugins: map[string]goplugin.Plugin{
+			"items": &itemPluginRPC{impl: impl},
+		},
+	})
+}
+
+/*
+	A RemotePlugin is a plugin program run by the host. It registers the
+	processors of the plugin, and keeps the program running until Close.
+*/
+type RemotePlugin struct {
+	client *goplugin.Client
+	plugin ItemPlugin
+	names  []string
+}
+
+/*
+	LoadRemotePlugin starts the plugin program cmd and registers each of its
+	processors with pipeline.RegisterProcessor, as RemoteProcessors exchanging items
+	encoded with the named codec, "json" by default, and serialized by the
+	name of their processor in the plugin.
+*/
+func LoadRemotePlugin[E pipeline.Traceable](cmd *exec.Cmd, codec string) (*RemotePlugin, error) {
+	client := goplugin.NewClient(&goplugin.ClientConfig{
+		HandshakeConfig:  PluginHandshake,
+		Plugins:          map[string]goplugin.Plugin{"items": &itemPluginRPC{}},
+		Cmd:              cmd,
+		AllowedProtocols: []goplugin.Protocol{goplugin.ProtocolNetRPC},
+		Logger:           hclog.New(&hclog.LoggerOptions{Name: "plugin", Level: hclog.Warn}),
+	})
+
+	rpcClient, err := client.Client()
+	if err != nil {
+		client.Kill()
+		return nil, fmt.Errorf("%s: %w: %w", cmd.Path, err, ErrInvalidPlugin)
+	}
+
+	raw, err := rpcClient.Dispense("items")
+	if err != nil {
+		client.Kill()
+		return nil, fmt.Errorf("%s: %w: %w", cmd.Path, err, ErrInvalidPlugin)
+	}
+
+	items := raw.(*itemPluginClient)
+
+	names, err := items.names()
+	if err != nil {
+		client.Kill()
+		return nil, fmt.Errorf("%s: %w: %w", cmd.Path, err, ErrInvalidPlugin)
+	}
+
+	remote := &RemotePlugin{client: client, plugin: items, names: names}
+
+	for _, name := range remote.names {
+		pipeline.RegisterProcessor[E](name, func(name string, cfg map[string]interface{}) (pipeline.Processor[E], error) {
+			return &RemoteProcessor[E]{ProcName: name, Codec: codec, Plugin: remote.plugin}, nil
+		})
+	}
+
+	pipeline.RegisterProcessorMarshaller[E](&RemoteProcessor[E]{}, func(p pipeline.Processor[E]) (string, map[string]interface{}, error) {
+		return p.(*RemoteProcessor[E]).ProcName, nil, nil
+	})
+
+	return remote, nil
+}
+
+// Names returns the names of the processors of the plugin
+func (p *RemotePlugin) Names() []string {
+	return p.names
+}
+
+// Close stops the plugin program, failing the items of its processors
+func (p *RemotePlugin) Close() {
+	p.client.Kill()
+}
+
+/*
+	A RemoteProcessor handles items with a processor of an out of process
+	plugin. Items are decoded back into themselves, so the fields the plugin
+	does not send back are kept.
+*/
+type RemoteProcessor[E pipeline.Traceable] struct {
+	ProcName string
+	Codec    string
+
+	Plugin ItemPlugin `json:"-"`
+}
+
+func (r *RemoteProcessor[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	pipeline.ExecuteItems[E](ctx, r, input, output, func(item E) (E, error) {
+		return r.ProcessItem(ctx, item)
+	})
+}
+
+func (r *RemoteProcessor[E]) Name() string {
+	return fmt.Sprintf("Remote/%s", r.ProcName)
+}
+
+func (r *RemoteProcessor[E]) ProcessItem(ctx context.Context, item E) (E, error) {
+	codec, err := lookupCodec(r.Codec)
+	if err != nil {
+		return item, fmt.Errorf("%w: %w", err, pipeline.ErrFatal)
+	}
+
+	enc, err := codec.Marshal(item)
+	if err != nil {
+		return item, err
+	}
+
+	result, err := r.Plugin.Process(r.ProcName, enc)
+	if err != nil {
+		return item, err
+	}
+
+	// decoded apart first, so a failure leaves the item as it was
+	var decoded E
+	if err := codec.Unmarshal(result, &decoded); err != nil {
+		return item, err
+	}
+
+	processed := item
+	if err := codec.Unmarshal(result, &processed); err != nil {
+		return item, err
+	}
+
+	return processed, nil
+}
+
+// lookupCodec returns the named codec, "json" by default
+func lookupCodec(name string) (pipeline.Codec, error) {
+	if name == "" {
+		name = "json"
+	}
+
+	c, ok := pipeline.LookupCodec(name)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, pipeline.ErrUnknownCodec)
+	}
+
+	return c, nil
+}
+
+// itemPluginRPC carries ItemPlugin over net/rpc
+type itemPluginRPC struct {
+	impl ItemPlugin
+}
+
+func (p *itemPluginRPC) Server(*goplugin.MuxBroker) (interface{}, error) {
+	return &ItemPluginServer{impl: p.impl}, nil
+}
+
+func (p *itemPluginRPC) Client(b *goplugin.MuxBroker, c *rpc.Client) (interface{}, error) {
+	return &itemPluginClient{client: c}, nil
+}
+
+type itemPluginClient struct {
+	client *rpc.Client
+}
+
+func (c *itemPluginClient) Names() []string {
+	names, _ := c.names()
+	return names
+}
+
+func (c *itemPluginClient) names() ([]string, error) {
+	var names []string
+	err := c.client.Call("Plugin.Names", struct{}{}, &names)
+
+	return names, err
+}
+
+func (c *itemPluginClient) Process(name string, item []byte) ([]byte, error) {
+	var result []byte
+	err := c.client.Call("Plugin.Process", PluginRequest{Name: name, Item: item}, &result)
+
+	return result, err
+}
+
+type PluginRequest struct {
+	Name string
+	Item []byte
+}
+
+/*
+	ItemPluginServer is the net/rpc side of an ItemPlugin in the plugin
+	program. It is exported because net/rpc only serves exported types.
+*/
+type ItemPluginServer struct {
+	impl ItemPlugin
+}
+
+func (s *ItemPluginServer) Names(args struct{}, names *[]string) error {
+	*names = s.impl.Names()
+	return nil
+}
+
+func (s *ItemPluginServer) Process(req PluginRequest, result *[]byte) error {
+	processed, err := s.impl.Process(req.Name, req.Item)
+	if err != nil {
+		return err
+	}
+
+	*result = processed
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package pipeline
+
+import (
+	"reflect"
+	"sync"
+)
+
+/*
+	Resetter is implemented by pooled objects. Reset must drop every reference
+	held by the object, so a pooled value never keeps items alive or leaks data
+	into its next use, but it may keep allocated capacity around.
+*/
+type Resetter interface {
+	Reset()
+}
+
+/*
+	An ObjectPool is a typed sync.Pool for the containers the library allocates
+	per item or per batch. Values are reset when they are returned to the pool,
+	and must not be used by the caller after Put.
+*/
+type ObjectPool[T Resetter] struct {
+	pool sync.Pool
+}
+
+func NewObjectPool[T Resetter](newFn func() T) *ObjectPool[T] {
+	return &ObjectPool[T]{
+		pool: sync.Pool{
+			New: func() interface{} {
+				return newFn()
+			},
+		},
+	}
+}
+
+func (p *ObjectPool[T]) Get() T {
+	return p.pool.Get().(T)
+}
+
+func (p *ObjectPool[T]) Put(v T) {
+	v.Reset()
+	p.pool.Put(v)
+}
+
+/*
+	A Batch groups items travelling together. It is Traceable itself: a trace
+	added to the batch is added to every item in it.
+*/
+type Batch[E Traceable] struct {
+	Items []E
+}
+
+func (b *Batch[E]) AddTrace(trace string) {
+	for _, item := range b.Items {
+		item.AddTrace(trace)
+	}
+}
+
+func (b *Batch[E]) Len() int {
+	return len(b.Items)
+}
+
+func (b *Batch[E]) Reset() {
+	var zero E
+
+	for i := range b.Items {
+		b.Items[i] = zero
+	}
+
+	b.Items = b.Items[:0]
+}
+
+// NewBatchPool returns a pool of batches preallocated for capacity items
+func NewBatchPool[E Traceable](capacity int) *ObjectPool[*Batch[E]] {
+	return NewObjectPool(func() *Batch[E] {
+		return &Batch[E]{
+			Items: make([]E, 0, capacity),
+		}
+	})
+}
+
+// batchPools are the batch pools shared by the stages of every pipeline, by
+// item type
+var batchPools sync.Map
+
+// batchPool returns the batch pool shared by the stages handling items of
+// type E, so batches are reused across stages and runs
+func batchPool[E Traceable]() *ObjectPool[*Batch[E]] {
+	key := reflect.TypeOf((*E)(nil)).Elem()
+
+	if pool, ok := batchPools.Load(key); ok {
+		return pool.(*ObjectPool[*Batch[E]])
+	}
+
+	pool, _ := batchPools.LoadOrStore(key, NewBatchPool[E](DefaultBatchSize))
+	return pool.(*ObjectPool[*Batch[E]])
+}
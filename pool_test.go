@@ -0,0 +1,65 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+)
+
+func TestObjectPoolResetsOnPut(t *testing.T) {
+	pool := NewBatchPool[*testItem](4)
+
+	batch := pool.Get()
+	if cap(batch.Items) != 4 {
+		t.Fatalf("batch of capacity %d, want 4", cap(batch.Items))
+	}
+
+	items := newItems("a", "b")
+	batch.Items = append(batch.Items, items...)
+
+	batch.AddTrace("batched")
+	for _, item := range items {
+		if len(item.traces) != 1 || item.traces[0] != "batched" {
+			t.Fatalf("batch trace not added to its items: %v", item.traces)
+		}
+	}
+
+	backing := batch.Items[:2]
+	pool.Put(batch)
+
+	if batch.Len() != 0 {
+		t.Fatalf("batch of %d items after Put", batch.Len())
+	}
+
+	// the items are not kept alive by the pooled batch
+	for i, item := range backing {
+		if item != nil {
+			t.Fatalf("item %d still referenced after Put", i)
+		}
+	}
+}
+
+func TestReleasedHeadersAreEmptied(t *testing.T) {
+	ctx := WithRunID(context.Background(), "run")
+
+	headers, err := InjectItem(ctx, &testItem{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if headers[HeaderRunID] != "run" {
+		t.Fatalf("run id not injected: %v", headers)
+	}
+
+	ReleaseHeaders(headers)
+
+	if len(headers) != 0 {
+		t.Fatalf("released headers not emptied: %v", headers)
+	}
+
+	// headers taken from the pool again carry nothing of their last use
+	if headers := InjectContext(context.Background()); len(headers) != 0 {
+		t.Fatalf("headers of an empty context: %v", headers)
+	}
+
+	ReleaseHeaders(nil)
+}
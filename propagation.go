@@ -0,0 +1,185 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	HeaderPrefix        = "pipeline-"
+	HeaderContextPrefix = HeaderPrefix + "ctx-"
+	HeaderTraces        = HeaderPrefix + "traces"
+	HeaderTracesEnabled = HeaderPrefix + "traces-enabled"
+	HeaderLogLevel      = HeaderPrefix + "log-level"
+	HeaderRunID         = HeaderPrefix + "run-id"
+)
+
+/*
+	Headers is the wire representation of the context values and lineage
+	metadata crossing a remote edge, to be carried as message headers by
+	connectors (gRPC metadata, Kafka or NATS headers, HTTP headers...).
+*/
+type Headers map[string]string
+
+// Reset empties the headers, keeping their room, for them to be pooled
+func (h Headers) Reset() {
+	clear(h)
+}
+
+// headerPool holds the headers InjectContext makes, one set per item sent
+// by connectors
+var headerPool = NewObjectPool(func() Headers {
+	return Headers{}
+})
+
+/*
+	ReleaseHeaders returns headers from InjectContext or InjectItem to the
+	pool, once they were copied into the message sent. They must not be
+	used after.
+*/
+func ReleaseHeaders(headers Headers) {
+	if headers != nil {
+		headerPool.Put(headers)
+	}
+}
+
+/*
+	TraceCarrier is implemented by items able to return the traces added to
+	them, so their lineage can be sent along with them to a remote process.
+*/
+type TraceCarrier interface {
+	Traceable
+	Traces() []string
+}
+
+var propagatedKeysLock sync.RWMutex
+var propagatedKeys = map[PipelineContextKey]struct{}{}
+
+/*
+	RegisterPropagatedKey marks a context key as propagated across remote
+	edges. Values must be strings or implement fmt.Stringer, and are restored
+	as strings on the remote side.
+*/
+func RegisterPropagatedKey(key PipelineContextKey) {
+	propagatedKeysLock.Lock()
+	defer propagatedKeysLock.Unlock()
+
+	propagatedKeys[key] = struct{}{}
+}
+
+// InjectContext encodes the propagated values of the context, in headers
+// which may be released with ReleaseHeaders
+func InjectContext(ctx context.Context) Headers {
+	headers := headerPool.Get()
+
+	if HasTracesEnabled(ctx) {
+		headers[HeaderTracesEnabled] = "true"
+	}
+
+	if level, ok := ctx.Value(PipeLineLogLevel).(int); ok {
+		headers[HeaderLogLevel] = strconv.Itoa(level)
+	}
+
+	if runID := RunID(ctx); runID != "" {
+		headers[HeaderRunID] = runID
+	}
+
+	propagatedKeysLock.RLock()
+	defer propagatedKeysLock.RUnlock()
+
+	for key := range propagatedKeys {
+		switch v := ctx.Value(key).(type) {
+		case string:
+			headers[HeaderContextPrefix+string(key)] = v
+		case fmt.Stringer:
+			headers[HeaderContextPrefix+string(key)] = v.String()
+		}
+	}
+
+	return headers
+}
+
+// ExtractContext restores the values encoded by InjectContext on top of ctx
+func ExtractContext(ctx context.Context, headers Headers) context.Context {
+	if headers[HeaderTracesEnabled] == "true" {
+		ctx = WithTraces(ctx)
+	}
+
+	if level, err := strconv.Atoi(headers[HeaderLogLevel]); err == nil {
+		ctx = WithLogLevel(ctx, level)
+	}
+
+	if runID, ok := headers[HeaderRunID]; ok {
+		ctx = WithRunID(ctx, runID)
+	}
+
+	propagatedKeysLock.RLock()
+	defer propagatedKeysLock.RUnlock()
+
+	for name, value := range headers {
+		key, found := strings.CutPrefix(name, HeaderContextPrefix)
+		if !found {
+			continue
+		}
+
+		if _, ok := propagatedKeys[PipelineContextKey(key)]; ok {
+			ctx = context.WithValue(ctx, PipelineContextKey(key), value)
+		}
+	}
+
+	return ctx
+}
+
+/*
+	InjectItem encodes the propagated values of the context and the lineage
+	of item, as connectors send them along with every item.
+*/
+func InjectItem(ctx context.Context, item Traceable) (Headers, error) {
+	headers := InjectContext(ctx)
+
+	if err := InjectTraces(headers, item); err != nil {
+		ReleaseHeaders(headers)
+		return nil, err
+	}
+
+	return headers, nil
+}
+
+// InjectTraces adds the lineage of the item to the headers, if available
+func InjectTraces(headers Headers, item Traceable) error {
+	carrier, ok := item.(TraceCarrier)
+	if !ok {
+		return nil
+	}
+
+	traces, err := json.Marshal(carrier.Traces())
+	if err != nil {
+		return err
+	}
+
+	headers[HeaderTraces] = string(traces)
+	return nil
+}
+
+// ExtractTraces replays the lineage found in the headers onto the item
+func ExtractTraces(headers Headers, item Traceable) error {
+	encoded, ok := headers[HeaderTraces]
+	if !ok {
+		return nil
+	}
+
+	var traces []string
+	if err := json.Unmarshal([]byte(encoded), &traces); err != nil {
+		return err
+	}
+
+	for _, trace := range traces {
+		item.AddTrace(trace)
+	}
+
+	return nil
+}
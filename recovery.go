@@ -0,0 +1,258 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+)
+
+var PipelineRecovery PipelineContextKey = "pipeline_recovery"
+
+// The sources of the items of a RecoveryFile
+const (
+	RecoveredInFlight   = "in_flight"
+	RecoveredBuffered   = "buffered"
+	RecoveredDeadLetter = "dead_letter"
+)
+
+/*
+	A Recovery keeps the items a Runner could not deliver when its pipeline
+	was cancelled before it drained: those its stages were handing on, those
+	left in the buffers of its composites, and the dead letters of the
+	stages failing once it was cancelled. They are encoded with Codec, JSON
+	by default, to the RecoveryFile at Path, and fed again before the items
+	of Feed the next time the Runner runs.
+
+	The file is removed once all of its items were fed, and written again by
+	runs stopped before they delivered them, so items are delivered at least
+	once. Items a stage was processing when it was cancelled, and did not
+	hand on or dead letter, are lost.
+*/
+type Recovery struct {
+	Path  string
+	Codec string
+}
+
+type RecoveryFile struct {
+	RunID string          `json:"run_id,omitempty"`
+	Time  time.Time       `json:"time"`
+	Codec string          `json:"codec,omitempty"`
+	Items []RecoveredItem `json:"items"`
+}
+
+/*
+	A RecoveredItem is an encoded item, with where it was found: Source is
+	one of RecoveredInFlight, RecoveredBuffered and RecoveredDeadLetter, and
+	Processor the Walk path of the composite buffering it, or the name of
+	the processor dead lettering it.
+*/
+type RecoveredItem struct {
+	Source    string `json:"source"`
+	Processor string `json:"processor,omitempty"`
+	Data      []byte `json:"data"`
+}
+
+/*
+	A ShutdownReport tells the items a run did not deliver, by source, and
+	the recovery file they were written to, or why they could not be.
+*/
+type ShutdownReport struct {
+	Undelivered map[string]int `json:"undelivered"`
+	Path        string         `json:"path,omitempty"`
+	Err         string         `json:"error,omitempty"`
+}
+
+// LoadRecoveryFile returns the RecoveryFile at path, nil when there is none
+func LoadRecoveryFile(path string) (*RecoveryFile, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	file := &RecoveryFile{}
+	if err := json.Unmarshal(data, file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return file, nil
+}
+
+// recoveredItems returns the items of the recovery file at path, decoded
+func recoveredItems[E Traceable](path string) ([]E, error) {
+	file, err := LoadRecoveryFile(path)
+	if err != nil || file == nil {
+		return nil, err
+	}
+
+	codec, err := lookupCodec(file.Codec)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	items := make([]E, 0, len(file.Items))
+
+	for i, recovered := range file.Items {
+		var item E
+		if err := codec.Unmarshal(recovered.Data, &item); err != nil {
+			return nil, fmt.Errorf("%s: item %d: %w", path, i, err)
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// undelivered collects the items a run abandoned, once each
+type undelivered[E Traceable] struct {
+	lock  sync.Mutex
+	seen  map[uintptr]bool
+	items []abandonedItem[E]
+}
+
+type abandonedItem[E Traceable] struct {
+	item      E
+	source    string
+	processor string
+}
+
+func newUndelivered[E Traceable]() *undelivered[E] {
+	return &undelivered[E]{seen: make(map[uintptr]bool)}
+}
+
+func (u *undelivered[E]) add(item E, source, processor string) {
+	if isNil(item) {
+		return
+	}
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	// items sent to several branches are only kept once
+	if v := reflect.ValueOf(item); v.Kind() == reflect.Pointer {
+		if u.seen[v.Pointer()] {
+			return
+		}
+
+		u.seen[v.Pointer()] = true
+	}
+
+	u.items = append(u.items, abandonedItem[E]{item: item, source: source, processor: processor})
+}
+
+// abandon keeps m, which could not be handed on because ctx is done, in the
+// undelivered items of the run, when it has a Recovery
+func abandon[E Traceable](ctx context.Context, m E) {
+	if u, ok := ctx.Value(PipelineRecovery).(*undelivered[E]); ok {
+		u.add(m, RecoveredInFlight, "")
+	}
+}
+
+// collectBuffered takes the items left in the buffers of the composites of
+// root
+func (u *undelivered[E]) collectBuffered(root Processor[E]) {
+	Walk(root, func(path string, p Processor[E]) {
+		for _, queue := range stageQueues(p) {
+			for m, ok := tryReceive(queue.c); ok; m, ok = tryReceive(queue.c) {
+				u.add(m, RecoveredBuffered, path)
+			}
+		}
+	})
+}
+
+func tryReceive[E Traceable](c chan E) (E, bool) {
+	select {
+	case m, ok := <-c:
+		return m, ok
+	default:
+		var zero E
+		return zero, false
+	}
+}
+
+/*
+	export writes the undelivered items to the recovery file, replacing it,
+	and returns the report of the shutdown, nil when every item was
+	delivered.
+*/
+func (u *undelivered[E]) export(recovery *Recovery, runID string) *ShutdownReport {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	if len(u.items) == 0 {
+		return nil
+	}
+
+	report := &ShutdownReport{Undelivered: make(map[string]int)}
+
+	for _, abandoned := range u.items {
+		report.Undelivered[abandoned.source]++
+	}
+
+	if err := u.write(recovery, runID); err != nil {
+		report.Err = err.Error()
+		return report
+	}
+
+	report.Path = recovery.Path
+
+	return report
+}
+
+func (u *undelivered[E]) write(recovery *Recovery, runID string) error {
+	codec, err := lookupCodec(recovery.Codec)
+	if err != nil {
+		return err
+	}
+
+	file := RecoveryFile{
+		RunID: runID,
+		Time:  time.Now(),
+		Codec: recovery.Codec,
+		Items: make([]RecoveredItem, 0, len(u.items)),
+	}
+
+	for _, abandoned := range u.items {
+		data, err := codec.Marshal(abandoned.item)
+		if err != nil {
+			return err
+		}
+
+		file.Items = append(file.Items, RecoveredItem{
+			Source:    abandoned.source,
+			Processor: abandoned.processor,
+			Data:      data,
+		})
+	}
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(recovery.Path), filepath.Base(recovery.Path)+".*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), recovery.Path)
+}
@@ -0,0 +1,192 @@
+/*
+	Package redis elects the leader of a deployment with a lease kept in
+	Redis, so pipeline.RunAsLeader runs singleton pipelines across hosts.
+*/
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	redisgo "github.com/redis/go-redis/v9"
+)
+
+// leases expire this long after their last renewal by default
+const DefaultTTL = 15 * time.Second
+
+var ErrNoKey = fmt.Errorf("no key")
+
+// renew extends the lease when it is still held by the candidate
+var renew = redisgo.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// release deletes the lease when it is still held by the candidate
+var release = redisgo.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+/*
+	A LeaseElector is a pipeline.LeaderElector electing the candidate holding
+	Key, set to its ID for TTL, fifteen seconds by default. Candidates
+	standing by try to take the key every RetryInterval, and the leader
+	renews it every RenewInterval, both a third of TTL by default.
+
+	Leadership is lost when the key is found held by another candidate, or
+	could not be renewed before it expired, as when Redis is unreachable:
+	the leader stops before a standby candidate can take over, so one
+	instance at most runs the pipeline, as long as their clocks agree on
+	the length of TTL.
+*/
+type LeaseElector struct {
+	Client redisgo.UniversalClient
+	Key    string
+	ID     string
+
+	TTL           time.Duration
+	RenewInterval time.Duration
+	RetryInterval time.Duration
+
+	lock    sync.Mutex
+	lost    chan struct{}
+	stop    context.CancelFunc
+	stopped chan struct{}
+}
+
+// NewLeaseElector returns a candidate for the lease key, with a random ID
+func NewLeaseElector(client redisgo.UniversalClient, key string) *LeaseElector {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	return &LeaseElector{
+		Client: client,
+		Key:    key,
+		ID:     hex.EncodeToString(id),
+	}
+}
+
+func (e *LeaseElector) ttl() time.Duration {
+	if e.TTL <= 0 {
+		return DefaultTTL
+	}
+
+	return e.TTL
+}
+
+func (e *LeaseElector) interval(d time.Duration) time.Duration {
+	if d <= 0 {
+		return e.ttl() / 3
+	}
+
+	return d
+}
+
+// Campaign blocks until the lease is taken, or ctx is done. Failures to
+// reach Redis are returned, for RunAsLeader to campaign again.
+func (e *LeaseElector) Campaign(ctx context.Context) (<-chan struct{}, error) {
+	if e.Key == "" {
+		return nil, ErrNoKey
+	}
+
+	for {
+		taken := time.Now()
+
+		ok, err := e.Client.SetNX(ctx, e.Key, e.ID, e.ttl()).Result()
+		if err != nil {
+			return nil, fmt.Errorf("taking %s: %w", e.Key, err)
+		}
+
+		if ok {
+			return e.lead(taken), nil
+		}
+
+		select {
+		case <-time.After(e.interval(e.RetryInterval)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// lead renews the lease taken at taken until it is lost or resigned
+func (e *LeaseElector) lead(taken time.Time) <-chan struct{} {
+	ctx, stop := context.WithCancel(context.Background())
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	lost := make(chan struct{})
+	stopped := make(chan struct{})
+
+	e.lost, e.stop, e.stopped = lost, stop, stopped
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(e.interval(e.RenewInterval))
+		defer ticker.Stop()
+
+		// the lease is held until ttl after it was last set
+		expires := taken.Add(e.ttl())
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+
+			renewed := time.Now()
+
+			held, err := renew.Run(ctx, e.Client, []string{e.Key}, e.ID, e.ttl().Milliseconds()).Int()
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err == nil && held == 1 {
+				expires = renewed.Add(e.ttl())
+				continue
+			}
+
+			if err == nil || !time.Now().Add(e.interval(e.RenewInterval)).Before(expires) {
+				close(lost)
+				return
+			}
+		}
+	}()
+
+	return lost
+}
+
+// Resign releases the lease, if this candidate still holds it
+func (e *LeaseElector) Resign(ctx context.Context) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.stop == nil {
+		return nil
+	}
+
+	e.stop()
+	<-e.stopped
+
+	select {
+	case <-e.lost:
+	default:
+		close(e.lost)
+	}
+
+	e.stop, e.stopped = nil, nil
+
+	return release.Run(ctx, e.Client, []string{e.Key}, e.ID).Err()
+}
@@ -0,0 +1,286 @@
+package pipeline
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrNotLoaded = fmt.Errorf("reference data not loaded")
+
+// A RefLoader loads a reference dataset, such as a lookup table
+type RefLoader[T any] interface {
+	Load(ctx context.Context) (T, error)
+}
+
+type RefLoaderFunc[T any] func(ctx context.Context) (T, error)
+
+func (f RefLoaderFunc[T]) Load(ctx context.Context) (T, error) {
+	return f(ctx)
+}
+
+// FileLoader decodes a file with the named codec, "json" by default
+type FileLoader[T any] struct {
+	Path  string
+	Codec string
+}
+
+func (l *FileLoader[T]) Load(ctx context.Context) (T, error) {
+	var data T
+
+	raw, err := os.ReadFile(l.Path)
+	if err != nil {
+		return data, err
+	}
+
+	return decodeRef[T](l.Codec, raw)
+}
+
+// HTTPLoader decodes the body of a GET request to URL with the named codec,
+// "json" by default
+type HTTPLoader[T any] struct {
+	URL    string
+	Codec  string
+	Client *http.Client
+}
+
+func (l *HTTPLoader[T]) Load(ctx context.Context) (T, error) {
+	var data T
+
+	client := l.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.URL, nil)
+	if err != nil {
+		return data, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return data, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return data, fmt.Errorf("%s: unexpected status %s", l.URL, resp.Status)
+	}
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return data, err
+	}
+
+	return decodeRef[T](l.Codec, raw)
+}
+
+// SQLLoader runs Query, and adds every row to the dataset with Scan
+type SQLLoader[T any] struct {
+	DB    *sql.DB
+	Query string
+	Scan  func(rows *sql.Rows, data *T) error
+}
+
+func (l *SQLLoader[T]) Load(ctx context.Context) (T, error) {
+	var data T
+
+	rows, err := l.DB.QueryContext(ctx, l.Query)
+	if err != nil {
+		return data, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := l.Scan(rows, &data); err != nil {
+			return data, err
+		}
+	}
+
+	return data, rows.Err()
+}
+
+func decodeRef[T any](codecName string, raw []byte) (T, error) {
+	var data T
+
+	codec, err := lookupCodec(codecName)
+	if err != nil {
+		return data, err
+	}
+
+	err = codec.Unmarshal(raw, &data)
+	return data, err
+}
+
+/*
+	The RefData processor keeps a reference dataset loaded by Loader, for the
+	stages enriching items with it. It loads the data in its Init, so the
+	Runner starts the stages depending on it once it is loaded, and reloads
+	it every Refresh while it runs. A failed reload keeps the previous data.
+
+	Items are sent on unchanged. Loads are tracked in the stats of the
+	processor: their count, the time of the last one, and the error of the
+	last one when it failed.
+*/
+type RefData[E Traceable, T any] struct {
+	ChainName string
+	Loader    RefLoader[T] `json:"-"`
+	Refresh   time.Duration
+
+	lock     sync.RWMutex
+	data     T
+	loadedAt time.Time
+	err      error
+	loaded   chan struct{}
+	initOnce sync.Once
+}
+
+func (r *RefData[E, T]) init() {
+	r.initOnce.Do(func() {
+		r.loaded = make(chan struct{})
+	})
+}
+
+// Init loads the data, unless it was already loaded
+func (r *RefData[E, T]) Init(ctx context.Context) error {
+	r.init()
+
+	if _, ok := r.Get(); ok {
+		return nil
+	}
+
+	return r.load(ctx)
+}
+
+func (r *RefData[E, T]) load(ctx context.Context) error {
+	data, err := r.Loader.Load(ctx)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.err = err
+	if err != nil {
+		return err
+	}
+
+	first := r.loadedAt.IsZero()
+
+	r.data = data
+	r.loadedAt = time.Now()
+
+	if first {
+		close(r.loaded)
+	}
+
+	return nil
+}
+
+// Get returns the data, and whether it was loaded
+func (r *RefData[E, T]) Get() (T, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.data, !r.loadedAt.IsZero()
+}
+
+// Wait returns the data once it is first loaded, or ErrNotLoaded if ctx is
+// done before
+func (r *RefData[E, T]) Wait(ctx context.Context) (T, error) {
+	r.init()
+
+	select {
+	case <-r.loaded:
+		data, _ := r.Get()
+		return data, nil
+	case <-ctx.Done():
+		var data T
+		return data, fmt.Errorf("%s: %w", r.Name(), ErrNotLoaded)
+	}
+}
+
+// Age returns how long ago the data was loaded, zero when it never was
+func (r *RefData[E, T]) Age() time.Duration {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if r.loadedAt.IsZero() {
+		return 0
+	}
+
+	return time.Since(r.loadedAt)
+}
+
+// Err returns the error of the last load, nil if it succeeded
+func (r *RefData[E, T]) Err() error {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.err
+}
+
+func (r *RefData[E, T]) Execute(ctx context.Context, input chan E, output chan E) {
+	if err := r.Init(ctx); err != nil {
+		Log[E](ctx, r, "loading failed: %s", err)
+		ReportError(ctx, r, err)
+	}
+
+	r.trackLoad(ctx)
+
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+
+	if r.Refresh > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ticker := time.NewTicker(r.Refresh)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+				case <-done:
+					return
+				case <-ctx.Done():
+					return
+				}
+
+				if err := r.load(ctx); err != nil {
+					Log[E](ctx, r, "reloading failed: %s", err)
+					ReportError(ctx, r, err)
+				}
+
+				r.trackLoad(ctx)
+			}
+		}()
+	}
+
+	for m := range input {
+		TrackItemInput[E](ctx, r, m)
+		TrackOutput[E](ctx, r, m)
+		output <- m
+	}
+
+	close(done)
+	wg.Wait()
+
+	close(output)
+}
+
+func (r *RefData[E, T]) trackLoad(ctx context.Context) {
+	r.lock.RLock()
+	at, err := r.loadedAt, r.err
+	r.lock.RUnlock()
+
+	TrackLoad[E](ctx, r, at, err)
+}
+
+func (r *RefData[E, T]) Name() string {
+	return fmt.Sprintf("RefData/%s", r.ChainName)
+}
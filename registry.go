@@ -0,0 +1,256 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"sort"
+	"sync"
+)
+
+var processors = struct {
+	lock       sync.RWMutex
+	byName     map[string]interface{}
+	byType     map[reflect.Type]interface{}
+	byTypeName map[typeName]interface{}
+}{
+	byName:     make(map[string]interface{}),
+	byType:     make(map[reflect.Type]interface{}),
+	byTypeName: make(map[typeName]interface{}),
+}
+
+// typeName is a definition type of the pipelines of items of Go type item
+type typeName struct {
+	name string
+	item reflect.Type
+}
+
+func typeNameOf[E Traceable](name string) typeName {
+	return typeName{name: name, item: reflect.TypeOf((*E)(nil)).Elem()}
+}
+
+// composite types built by SerializedPipeline itself, which registered types
+// can not replace, nor "processor", the leaves built by a factory
+var builtinTypes = []string{"fanout", "parallel", "sequential", "shadow", "bluegreen", "flagged", "pool", "tenants", "router", "retry", "deadline"}
+
+// builtinLeaves registers the leaves of this package once per item type
+var builtinLeaves sync.Map
+
+func registerBuiltinLeaves[E Traceable]() {
+	once, _ := builtinLeaves.LoadOrStore(reflect.TypeOf((*E)(nil)).Elem(), &sync.Once{})
+
+	once.(*sync.Once).Do(func() {
+		registerProcessorType[E, *Grok[E]]("grok", newGrok[E], marshalGrok[E])
+		registerProcessorType[E, *UserAgent[E]]("useragent", newUserAgent[E], marshalUserAgent[E])
+		registerProcessorType[E, *DiskQueue[E]]("diskqueue", newDiskQueue[E], marshalDiskQueue[E])
+		registerProcessorType[E, *Noop[E]]("noop", newNoop[E], marshalNoop[E])
+		registerProcessorType[E, *FixedDelay[E]]("fixeddelay", newFixedDelay[E], marshalFixedDelay[E])
+		registerProcessorType[E, *RandomDelay[E]]("randomdelay", newRandomDelay[E], marshalRandomDelay[E])
+		registerProcessorType[E, *Generator[E]]("generator", newGenerator[E], marshalGenerator[E])
+		registerProcessorType[E, *Counter[E]]("counter", newCounter[E], marshalCounter[E])
+	})
+}
+
+/*
+	A ProcessorMarshaller is the reverse of a ProcessorFactory: it returns
+	the name and configuration p is built back from.
+*/
+type ProcessorMarshaller[E Traceable] func(p Processor[E]) (name string, cfg map[string]interface{}, err error)
+
+// processorType is how the processors of a Go type are built and serialized
+type processorType[E Traceable] struct {
+	name     string
+	factory  ProcessorFactory[E]
+	marshal  ProcessorMarshaller[E]
+	validate func(cfg map[string]interface{}) error
+}
+
+/*
+	RegisterProcessor makes factory available to build the processors named
+	name of deserialized definitions, instead of the factory set on the
+	definition, so their processors can come from plugins. Registering a
+	name again replaces its factory.
+*/
+func RegisterProcessor[E Traceable](name string, factory ProcessorFactory[E]) {
+	processors.lock.Lock()
+	defer processors.lock.Unlock()
+
+	processors.byName[name] = factory
+}
+
+/*
+	RegisterFunc registers fn as the processor named name of deserialized
+	definitions, wrapped in an ItemFunc: its items are counted, those it
+	fails are reported and sent to the dead letter handlers, and it is not
+	called once the run is cancelled. The ItemFunc is named name, so its
+	definitions are serialized back under it.
+
+		RegisterFunc[*Item]("upper", func(item *Item) (*Item, error) { ... })
+*/
+func RegisterFunc[E Traceable](name string, fn func(item E) (E, error)) {
+	RegisterProcessor[E](name, func(string, map[string]interface{}) (Processor[E], error) {
+		return NewItemFunc[E](name, func(ctx context.Context, item E) (E, error) {
+			if err := ctx.Err(); err != nil {
+				return item, err
+			}
+
+			return fn(item)
+		}), nil
+	})
+}
+
+func lookupProcessor[E Traceable](name string) (ProcessorFactory[E], bool) {
+	processors.lock.RLock()
+	defer processors.lock.RUnlock()
+
+	factory, ok := processors.byName[name].(ProcessorFactory[E])
+	return factory, ok
+}
+
+/*
+	RegisterProcessorMarshaller makes marshal serialize the processors of the
+	type of sample, so their definitions name the factory building them back
+	instead of their Name.
+*/
+func RegisterProcessorMarshaller[E Traceable](sample Processor[E], marshal ProcessorMarshaller[E]) {
+	processors.lock.Lock()
+	defer processors.lock.Unlock()
+
+	processors.byType[reflect.TypeOf(sample)] = &processorType[E]{name: "processor", marshal: marshal}
+}
+
+/*
+	RegisterProcessorType registers the leaves of Go type P as the definitions
+	of type typeName: factory builds them from their name and configuration,
+	and marshal returns them, so definitions go through load, run and save
+	without a processor factory. The built-in composite types, such as
+	"sequential", are never replaced, but the leaves of this package, such
+	as "grok", registered the same way, can be. When P implements
+	ConfigValidator, Validate checks the configuration of the definitions
+	of the type with a zero P.
+
+		RegisterProcessorType[*Item]("upper", NewUpper, MarshalUpper)
+*/
+func RegisterProcessorType[E Traceable, P Processor[E]](typeName string, factory func(name string, cfg map[string]interface{}) (P, error), marshal func(p P) (name string, cfg map[string]interface{}, err error)) {
+	registerBuiltinLeaves[E]()
+	registerProcessorType[E, P](typeName, factory, marshal)
+}
+
+// registerProcessorType registers P without registering the leaves of this
+// package first, which is how they are registered themselves
+func registerProcessorType[E Traceable, P Processor[E]](typeName string, factory func(name string, cfg map[string]interface{}) (P, error), marshal func(p P) (name string, cfg map[string]interface{}, err error)) {
+	registerType[E, P](typeName, &processorType[E]{
+		name: typeName,
+		factory: func(name string, cfg map[string]interface{}) (Processor[E], error) {
+			p, err := factory(name, cfg)
+			if err != nil || isNil(p) {
+				return nil, err
+			}
+
+			return p, nil
+		},
+		marshal: func(p Processor[E]) (string, map[string]interface{}, error) {
+			return marshal(p.(P))
+		},
+	})
+}
+
+/*
+	RegisterSourceType registers the sources of Go type S as the definitions
+	of type typeName, as RegisterProcessorType does processors. They are
+	built into a SourceStage, which discards its input, so definitions can
+	declare the entry of their pipeline, such as the first processor of a
+	"sequential".
+
+		RegisterSourceType[*Item]("tail", NewTailSource, MarshalTailSource)
+*/
+func RegisterSourceType[E Traceable, S Source[E]](typeName string, factory func(name string, cfg map[string]interface{}) (S, error), marshal func(s S) (name string, cfg map[string]interface{}, err error)) {
+	registerBuiltinLeaves[E]()
+
+	registerType[E, S](typeName, &processorType[E]{
+		name: typeName,
+		factory: func(name string, cfg map[string]interface{}) (Processor[E], error) {
+			s, err := factory(name, cfg)
+			if err != nil || isNil(s) {
+				return nil, err
+			}
+
+			return &SourceStage[E]{Source: s}, nil
+		},
+		marshal: func(p Processor[E]) (string, map[string]interface{}, error) {
+			return marshal(p.(*SourceStage[E]).Source.(S))
+		},
+	})
+}
+
+// registerType registers t for the Go type T, validating configurations
+// with a zero T when it is a ConfigValidator
+func registerType[E Traceable, T any](name string, t *processorType[E]) {
+	goType := reflect.TypeOf((*T)(nil)).Elem()
+
+	if goType.Implements(reflect.TypeOf((*ConfigValidator)(nil)).Elem()) {
+		t.validate = func(cfg map[string]interface{}) error {
+			zero := reflect.New(goType).Elem()
+			if goType.Kind() == reflect.Pointer {
+				zero = reflect.New(goType.Elem())
+			}
+
+			return zero.Interface().(ConfigValidator).ValidateConfig(cfg)
+		}
+	}
+
+	processors.lock.Lock()
+	defer processors.lock.Unlock()
+
+	processors.byType[goType] = t
+	processors.byTypeName[typeNameOf[E](name)] = t
+}
+
+// lookupProcessorMarshaller returns the type of p, or of its Source when it
+// is a SourceStage
+func lookupProcessorMarshaller[E Traceable](p Processor[E]) (*processorType[E], bool) {
+	registerBuiltinLeaves[E]()
+
+	goType := reflect.TypeOf(p)
+	if stage, ok := p.(*SourceStage[E]); ok {
+		goType = reflect.TypeOf(stage.Source)
+	}
+
+	processors.lock.RLock()
+	defer processors.lock.RUnlock()
+
+	t, ok := processors.byType[goType].(*processorType[E])
+	return t, ok
+}
+
+func lookupProcessorType[E Traceable](name string) (*processorType[E], bool) {
+	registerBuiltinLeaves[E]()
+
+	processors.lock.RLock()
+	defer processors.lock.RUnlock()
+
+	t, ok := processors.byTypeName[typeNameOf[E](name)].(*processorType[E])
+	return t, ok
+}
+
+// processorTypeNames returns the built-in types and the types registered for
+// E, leaves of this package included
+func processorTypeNames[E Traceable]() []string {
+	registerBuiltinLeaves[E]()
+
+	processors.lock.RLock()
+	defer processors.lock.RUnlock()
+
+	item := reflect.TypeOf((*E)(nil)).Elem()
+
+	registered := make([]string, 0, len(processors.byTypeName))
+	for t := range processors.byTypeName {
+		if t.item == item && !slices.Contains(builtinTypes, t.name) {
+			registered = append(registered, t.name)
+		}
+	}
+
+	sort.Strings(registered)
+
+	return append(append(slices.Clone(builtinTypes), "processor"), registered...)
+}
@@ -0,0 +1,121 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRegisterSourceTypeDeclaresSources(t *testing.T) {
+	RegisterSourceType[*testItem, *SliceSource[*testItem]]("testslice", func(name string, cfg map[string]interface{}) (*SliceSource[*testItem], error) {
+		values, _ := cfg["values"].([]interface{})
+
+		source := &SliceSource[*testItem]{ChainName: name}
+		for _, value := range values {
+			source.Items = append(source.Items, &testItem{Value: value.(string)})
+		}
+
+		return source, nil
+	}, func(source *SliceSource[*testItem]) (string, map[string]interface{}, error) {
+		values := []string{}
+		for _, item := range source.Items {
+			values = append(values, item.Value)
+		}
+
+		return source.ChainName, map[string]interface{}{"values": values}, nil
+	})
+
+	RegisterFunc[*testItem]("registry-upper", func(item *testItem) (*testItem, error) {
+		item.Value = strings.ToUpper(item.Value)
+		return item, nil
+	})
+
+	definition := `{"type":"sequential","name":"chain","processors":[
+		{"type":"testslice","name":"in","cfg":{"values":["a","b"]}},
+		{"type":"processor","name":"registry-upper"}
+	]}`
+
+	sp := &SerializedPipeline[*testItem]{}
+	if err := json.Unmarshal([]byte(definition), sp); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sp.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := sp.Pipeline()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the source discards the input of the pipeline
+	got := itemValues(runItems(t, context.Background(), p, newItems("ignored")))
+	if !slices.Equal(got, []string{"A", "B"}) {
+		t.Fatalf("got %v, want A B", got)
+	}
+
+	data, err := MarshalPipeline(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(data), `{"name":"in","type":"testslice"`) {
+		t.Fatalf("source not marshalled as its type: %s", data)
+	}
+}
+
+func TestBuiltinLeavesAreRegisteredTypes(t *testing.T) {
+	for _, name := range []string{"grok", "useragent", "diskqueue", "noop", "fixeddelay", "randomdelay", "generator", "counter"} {
+		if slices.Contains(builtinTypes, name) {
+			t.Errorf("leaf %q listed as a composite", name)
+		}
+
+		if _, ok := lookupProcessorType[*testItem](name); !ok {
+			t.Errorf("leaf %q not registered", name)
+		}
+	}
+
+	definition := `{"type":"sequential","name":"chain","processors":[
+		{"type":"noop","name":"pass"},
+		{"type":"fixeddelay","name":"wait","cfg":{"delay":"1ms"}},
+		{"type":"counter","name":"count","cfg":{"every":2}}
+	]}`
+
+	sp := &SerializedPipeline[*testItem]{}
+	if err := json.Unmarshal([]byte(definition), sp); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := sp.Pipeline()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := itemValues(runItems(t, context.Background(), p, newItems("a", "b")))
+	if !slices.Equal(got, []string{"a", "b"}) {
+		t.Fatalf("got %v, want a b", got)
+	}
+
+	data, err := MarshalPipeline(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{`"type":"noop"`, `"type":"fixeddelay"`, `"delay":"1ms"`, `"type":"counter"`, `"every":2`} {
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("%s missing from %s", want, data)
+		}
+	}
+
+	rebuilt := &SerializedPipeline[*testItem]{}
+	if err := json.Unmarshal(data, rebuilt); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := rebuilt.Pipeline(); err != nil {
+		t.Fatalf("marshalled definition does not build: %v", err)
+	}
+}
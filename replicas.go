@@ -0,0 +1,140 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+/*
+	The Pool processor has:
+
+	- One input
+	- Replicas identical processors, built by New
+	- One output
+
+	Like in a Parallel, each item coming from the input is forwarded to the
+	first available replica, and their output is collected and forwarded to
+	the Pool output.
+
+	New is called once per replica with its name, ChainName#i, which replicas
+	should use as their own so their stats can be told apart. Replicas are
+	built on first use, and then kept for every Execute.
+*/
+type Pool[E Traceable] struct {
+	ChainName string
+	Display   string
+	Tags      map[string]string
+
+	Replicas int
+	New      func(name string) Processor[E]
+
+	buildOnce sync.Once
+	replicas  []Processor[E]
+
+	executions executions
+}
+
+func (pool *Pool[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	pool.executions.begin()
+	defer pool.executions.end()
+
+	Log[E](ctx, pool, "starting")
+	TrackStarted[E](ctx, pool)
+	ctx = withErrorScope[E](ctx, pool)
+
+	replicas := pool.Children()
+
+	if len(replicas) == 0 {
+		close(output)
+		return
+	}
+
+	wg := sync.WaitGroup{}
+	collectorWg := sync.WaitGroup{}
+
+	collector := make(chan E)
+
+	collectorWg.Add(1)
+	go func() {
+		for m := range collector {
+			if ctx.Err() != nil {
+				abandon(ctx, m)
+				continue
+			}
+
+			TrackOutput[E](ctx, pool, m)
+			send(ctx, output, m)
+		}
+		collectorWg.Done()
+	}()
+
+	procInput := make(chan E)
+
+	wg.Add(1)
+	go func() {
+		for {
+			msg, ok := receive(ctx, input)
+			if !ok {
+				break
+			}
+
+			TrackItemInput[E](ctx, pool, msg)
+
+			if !send(ctx, procInput, msg) {
+				break
+			}
+		}
+
+		inputClosed[E](ctx, pool)
+		close(procInput)
+		wg.Done()
+	}()
+
+	for _, replica := range replicas {
+		procOutput := make(chan E)
+
+		wg.Add(1)
+		go func() {
+			runProcessor[E](ctx, replica, procInput, procOutput)
+			wg.Done()
+		}()
+
+		wg.Add(1)
+		go func() {
+			for m := range procOutput {
+				collector <- m
+			}
+			wg.Done()
+		}()
+	}
+
+	wg.Wait()
+
+	close(collector)
+	collectorWg.Wait()
+
+	TrackFinished[E](ctx, pool)
+	close(output)
+}
+
+func (pool *Pool[E]) Name() string {
+	return fmt.Sprintf("Pool/%s", pool.ChainName)
+}
+
+// Children returns the replicas, building them if needed
+func (pool *Pool[E]) Children() []Processor[E] {
+	pool.buildOnce.Do(func() {
+		if pool.New == nil {
+			return
+		}
+
+		for i := 0; i < pool.Replicas; i++ {
+			pool.replicas = append(pool.replicas, pool.New(fmt.Sprintf("%s#%d", pool.ChainName, i)))
+		}
+
+		pool.replicas = nonNil(pool.replicas)
+	})
+
+	return pool.replicas
+}
@@ -0,0 +1,57 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPoolDefinitionBuildsRegisteredReplicas(t *testing.T) {
+	RegisterFunc[*testItem]("pool-upper", func(item *testItem) (*testItem, error) {
+		item.Value = strings.ToUpper(item.Value)
+		return item, nil
+	})
+
+	definition := `{"type":"pool","name":"workers","cfg":{"replicas":2},"processors":[{"type":"processor","name":"pool-upper"}]}`
+
+	sp := &SerializedPipeline[*testItem]{}
+	if err := json.Unmarshal([]byte(definition), sp); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := sp.Pipeline()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := p.(*Pool[*testItem])
+	if replicas := pool.Children(); len(replicas) != 2 || replicas[0] == replicas[1] {
+		t.Fatalf("got replicas %v, want 2 distinct replicas", replicas)
+	}
+
+	paths := []string{}
+	Walk(p, func(path string, _ Processor[*testItem]) {
+		paths = append(paths, path)
+	})
+
+	if len(slices.Compact(slices.Clone(paths))) != 3 {
+		t.Fatalf("replica paths are not unique: %v", paths)
+	}
+
+	got := itemValues(runItems(t, context.Background(), p, newItems("a", "b", "c")))
+	if !slices.Equal(got, []string{"A", "B", "C"}) {
+		t.Fatalf("got %v, want A B C", got)
+	}
+
+	// the definition of the replicas keeps its name
+	data, err := MarshalPipeline(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(data), `"name":"pool-upper"`) {
+		t.Fatalf("replica definition renamed: %s", data)
+	}
+}
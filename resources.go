@@ -0,0 +1,296 @@
+package pipeline
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"runtime/metrics"
+	"runtime/pprof"
+	"time"
+)
+
+var ErrInvalidProfile = fmt.Errorf("invalid cpu profile")
+
+const allocMetric = "/gc/heap/allocs:bytes"
+
+/*
+	The ResourceSampler attributes approximate CPU time and heap allocations to
+	the processors tracked by a StatDB.
+
+	It keeps the runtime CPU profiler running in windows of Interval. At the end
+	of each window the profile samples are attributed to processors using the
+	goroutine labels set by the composites, and the CPUTime of each processor
+	stats is increased accordingly.
+
+	Heap profiles carry no goroutine labels, so the bytes allocated during the
+	window (from runtime/metrics) are split between processors proportionally
+	to their share of the sampled CPU time.
+
+	Only one CPU profile can be active in a process: Run fails if a profile is
+	already being collected.
+*/
+type ResourceSampler[E Traceable] struct {
+	Stats    *StatDB[E]
+	Interval time.Duration
+}
+
+func NewResourceSampler[E Traceable](sdb *StatDB[E], interval time.Duration) *ResourceSampler[E] {
+	return &ResourceSampler[E]{
+		Stats:    sdb,
+		Interval: interval,
+	}
+}
+
+// Run samples until the context is cancelled
+func (rs *ResourceSampler[E]) Run(ctx context.Context) error {
+	interval := rs.Interval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	allocSample := []metrics.Sample{{Name: allocMetric}}
+	metrics.Read(allocSample)
+	lastAllocs := readUint64Metric(allocSample[0])
+
+	for {
+		buf := bytes.NewBuffer(nil)
+
+		if err := pprof.StartCPUProfile(buf); err != nil {
+			return err
+		}
+
+		timer := time.NewTimer(interval)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+		}
+
+		pprof.StopCPUProfile()
+
+		metrics.Read(allocSample)
+		allocs := readUint64Metric(allocSample[0])
+
+		cpu, err := cpuByProcessor(buf.Bytes())
+		if err != nil {
+			return err
+		}
+
+		rs.attribute(cpu, int64(allocs-lastAllocs))
+		lastAllocs = allocs
+
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+func (rs *ResourceSampler[E]) attribute(cpu map[string]time.Duration, allocs int64) {
+	var total time.Duration
+	for _, d := range cpu {
+		total += d
+	}
+
+	for id, d := range cpu {
+		p, ok := rs.Stats.lookup(id)
+		if !ok {
+			continue
+		}
+
+		stats := rs.Stats.getStats(p)
+		stats.CPUTime.Add(d)
+
+		if total > 0 && allocs > 0 {
+			stats.AllocBytes.Add(int64(float64(allocs) * float64(d) / float64(total)))
+		}
+	}
+}
+
+func readUint64Metric(s metrics.Sample) uint64 {
+	if s.Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+
+	return s.Value.Uint64()
+}
+
+/*
+	cpuByProcessor decodes the subset of the pprof protobuf format required to
+	sum the CPU time of the samples by their processor id label.
+*/
+func cpuByProcessor(profile []byte) (map[string]time.Duration, error) {
+	result := make(map[string]time.Duration)
+
+	if len(profile) == 0 {
+		return result, nil
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(profile))
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := io.ReadAll(gz)
+	if err != nil {
+		return nil, err
+	}
+
+	type sample struct {
+		values []int64
+		labels map[int64]int64
+	}
+
+	var samples []sample
+	var stringTable []string
+
+	err = walkProto(raw, func(field int, wire int, value uint64, data []byte) error {
+		switch field {
+		case 2:
+			s := sample{labels: make(map[int64]int64)}
+
+			err := walkProto(data, func(field int, wire int, value uint64, data []byte) error {
+				switch field {
+				case 2:
+					if wire == 2 {
+						return walkPacked(data, func(v uint64) {
+							s.values = append(s.values, int64(v))
+						})
+					}
+
+					s.values = append(s.values, int64(value))
+
+				case 3:
+					var key, str int64
+
+					err := walkProto(data, func(field int, wire int, value uint64, data []byte) error {
+						switch field {
+						case 1:
+							key = int64(value)
+						case 2:
+							str = int64(value)
+						}
+
+						return nil
+					})
+					if err != nil {
+						return err
+					}
+
+					s.labels[key] = str
+				}
+
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			samples = append(samples, s)
+
+		case 6:
+			stringTable = append(stringTable, string(data))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	idKey := int64(-1)
+	for i, s := range stringTable {
+		if s == ProcessorIDLabel {
+			idKey = int64(i)
+		}
+	}
+
+	if idKey < 0 {
+		return result, nil
+	}
+
+	for _, s := range samples {
+		str, ok := s.labels[idKey]
+		if !ok || str < 0 || str >= int64(len(stringTable)) || len(s.values) < 2 {
+			continue
+		}
+
+		// CPU profiles record [samples/count, cpu/nanoseconds]
+		result[stringTable[str]] += time.Duration(s.values[1])
+	}
+
+	return result, nil
+}
+
+func walkProto(data []byte, fn func(field int, wire int, value uint64, data []byte) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return ErrInvalidProfile
+		}
+		data = data[n:]
+
+		field := int(key >> 3)
+		wire := int(key & 7)
+
+		var value uint64
+		var payload []byte
+
+		switch wire {
+		case 0:
+			value, n = binary.Uvarint(data)
+			if n <= 0 {
+				return ErrInvalidProfile
+			}
+			data = data[n:]
+
+		case 1:
+			if len(data) < 8 {
+				return ErrInvalidProfile
+			}
+			value = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+
+		case 2:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return ErrInvalidProfile
+			}
+			payload = data[n : n+int(length)]
+			data = data[n+int(length):]
+
+		case 5:
+			if len(data) < 4 {
+				return ErrInvalidProfile
+			}
+			value = uint64(binary.LittleEndian.Uint32(data))
+			data = data[4:]
+
+		default:
+			return ErrInvalidProfile
+		}
+
+		if err := fn(field, wire, value, payload); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func walkPacked(data []byte, fn func(uint64)) error {
+	for len(data) > 0 {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return ErrInvalidProfile
+		}
+
+		fn(v)
+		data = data[n:]
+	}
+
+	return nil
+}
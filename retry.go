@@ -0,0 +1,210 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+var ErrNotItemProcessor = fmt.Errorf("processor does not handle single items")
+
+const EventItemRetried = "item_retried"
+
+const (
+	DefaultRetryAttempts   = 3
+	DefaultRetryBackoff    = 100 * time.Millisecond
+	DefaultRetryMultiplier = 2
+)
+
+/*
+	A RetryPolicy tells how many times an item is tried, MaxAttempts, and how
+	long to wait before trying it again: Backoff after the first attempt,
+	multiplied by Multiplier after every other one, up to MaxBackoff when it
+	is set. Every wait is shortened or lengthened randomly by up to Jitter,
+	a fraction of it, so items failing together are not retried together.
+*/
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	Multiplier  float64
+	Jitter      float64
+}
+
+func (p RetryPolicy) attempts() int {
+	if p.MaxAttempts <= 0 {
+		return DefaultRetryAttempts
+	}
+
+	return p.MaxAttempts
+}
+
+// delay returns the wait after the given attempt, counted from 1
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	backoff, multiplier := p.Backoff, p.Multiplier
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+
+	if multiplier < 1 {
+		multiplier = DefaultRetryMultiplier
+	}
+
+	d := float64(backoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+
+	if d < 0 {
+		return 0
+	}
+
+	return time.Duration(d)
+}
+
+/*
+	The Retry processor has:
+
+	- One input
+	- One processor, which must be an ItemProcessor
+	- One output
+
+	Every item goes through ProcessItem of the processor, and is tried again
+	as told by Policy while it fails, unless its error is permanent. Every
+	attempt is counted in the stats of the processor.
+
+	Items still failing after their last attempt are counted as failures of
+	the Retry, their error is reported, and they are handed to DeadLetter when
+	it is set, or to the dead letter handlers of the context otherwise.
+*/
+type Retry[E Traceable] struct {
+	ChainName string
+	Display   string
+	Tags      map[string]string
+
+	Processor Processor[E]
+	Policy    RetryPolicy
+
+	DeadLetter func(ctx context.Context, item E, err error)
+
+	executions executions
+}
+
+func (retry *Retry[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	retry.executions.begin()
+	defer retry.executions.end()
+
+	Log[E](ctx, retry, "starting")
+	TrackStarted[E](ctx, retry)
+	ctx = withErrorScope[E](ctx, retry)
+
+	if retry.Processor == nil {
+		close(output)
+		return
+	}
+
+	proc, ok := retry.Processor.(ItemProcessor[E])
+	if !ok {
+		ReportError(ctx, retry, fmt.Errorf("%s: %w: %w", retry.Processor.Name(), ErrNotItemProcessor, ErrFatal))
+		drain(input)
+		close(output)
+		return
+	}
+
+	if statDB, ok := ctx.Value(PipelineStatDB).(*StatDB[E]); ok {
+		statDB.register(proc)
+	}
+
+	setErrorReporter(ctx, proc)
+
+	for {
+		msg, ok := receive(ctx, input)
+		if !ok {
+			break
+		}
+
+		TrackItemInput[E](ctx, retry, msg)
+
+		result, err := retry.process(ctx, proc, msg)
+		if errors.Is(err, ErrDropped) {
+			Ack(msg)
+			continue
+		}
+
+		if err != nil {
+			Log[E](ctx, retry, "failed: %s", err)
+			TrackFailure[E](ctx, retry)
+			ReportError(ctx, retry, err)
+
+			if retry.DeadLetter != nil {
+				retry.DeadLetter(ctx, msg, err)
+			} else {
+				SendToDLQ(ctx, retry, msg, err)
+			}
+
+			continue
+		}
+
+		TrackOutput[E](ctx, retry, result)
+		send(ctx, output, result)
+	}
+
+	inputClosed[E](ctx, retry)
+	TrackFinished[E](ctx, retry)
+	close(output)
+}
+
+func (retry *Retry[E]) process(ctx context.Context, proc ItemProcessor[E], item E) (E, error) {
+	attempts := retry.Policy.attempts()
+
+	for attempt := 1; ; attempt++ {
+		TrackItemInput[E](ctx, proc, item)
+
+		result, err := proc.ProcessItem(ctx, item)
+		if err == nil {
+			TrackOutput[E](ctx, proc, result)
+			return result, nil
+		}
+
+		if errors.Is(err, ErrDropped) {
+			return result, err
+		}
+
+		TrackFailure[E](ctx, proc)
+
+		if errors.Is(err, ErrPermanent) || errors.Is(err, ErrFatal) {
+			return result, fmt.Errorf("%s: %w", proc.Name(), err)
+		}
+
+		if attempt >= attempts {
+			return result, fmt.Errorf("%s: %d attempts: %w", proc.Name(), attempt, err)
+		}
+
+		delay := retry.Policy.delay(attempt)
+		Emit[E](ctx, retry, EventItemRetried, "attempt %d failed, retrying in %s: %s", attempt, delay, err)
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return result, fmt.Errorf("%s: %w", proc.Name(), err)
+		}
+	}
+}
+
+func (retry *Retry[E]) Name() string {
+	return fmt.Sprintf("Retry/%s", retry.ChainName)
+}
+
+func (retry *Retry[E]) Children() []Processor[E] {
+	return nonNil([]Processor[E]{retry.Processor})
+}
@@ -0,0 +1,216 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+var ErrUnknownPredicate = fmt.Errorf("unknown predicate")
+
+/*
+	A Route sends the items matching its predicate to its processor. Its Name
+	identifies the predicate when the Router is serialized, so predicates of
+	serialized pipelines must be registered with RegisterPredicate.
+*/
+type Route[E Traceable] struct {
+	Name      string
+	Match     func(E) bool
+	Processor Processor[E]
+}
+
+/*
+	The Router processor has:
+
+	- One input
+	- X routes, each with a predicate and a processor
+	- An optional default processor
+	- One output
+
+	Each item is forwarded to the first route whose predicate matches it, or
+	to all of the matching routes when All is set. Items matching no route go
+	to the Default processor, and are discarded and acked when there is none.
+
+	The output of every processor is collected and forwarded to the Router
+	output. When All is set, an item matching several routes is shared by
+	them, which is only safe if processors do not modify it.
+*/
+type Router[E Traceable] struct {
+	ChainName string
+	Display   string
+	Tags      map[string]string
+
+	Routes  []Route[E]
+	Default Processor[E]
+	All     bool
+
+	executions executions
+}
+
+func (router *Router[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	router.executions.begin()
+	defer router.executions.end()
+
+	Log[E](ctx, router, "starting")
+	TrackStarted[E](ctx, router)
+	ctx = withErrorScope[E](ctx, router)
+
+	if len(router.Children()) == 0 {
+		close(output)
+		return
+	}
+
+	wg := sync.WaitGroup{}
+	collectorWg := sync.WaitGroup{}
+
+	collector := make(chan E)
+
+	collectorWg.Add(1)
+	go func() {
+		for m := range collector {
+			if ctx.Err() != nil {
+				abandon(ctx, m)
+				continue
+			}
+
+			TrackOutput[E](ctx, router, m)
+			send(ctx, output, m)
+		}
+		collectorWg.Done()
+	}()
+
+	start := func(proc Processor[E]) chan E {
+		procInput := make(chan E)
+		procOutput := make(chan E)
+
+		wg.Add(1)
+		go func() {
+			runProcessor[E](ctx, proc, procInput, procOutput)
+			wg.Done()
+		}()
+
+		wg.Add(1)
+		go func() {
+			for m := range procOutput {
+				collector <- m
+			}
+			wg.Done()
+		}()
+
+		return procInput
+	}
+
+	routeInputs := make([]chan E, len(router.Routes))
+	for i, route := range router.Routes {
+		if route.Processor != nil {
+			routeInputs[i] = start(route.Processor)
+		}
+	}
+
+	var defaultInput chan E
+	if router.Default != nil {
+		defaultInput = start(router.Default)
+	}
+
+	for {
+		msg, ok := receive(ctx, input)
+		if !ok {
+			break
+		}
+
+		TrackItemInput[E](ctx, router, msg)
+
+		if router.route(ctx, routeInputs, msg) {
+			continue
+		}
+
+		if defaultInput != nil {
+			send(ctx, defaultInput, msg)
+			continue
+		}
+
+		Ack(msg)
+	}
+
+	inputClosed[E](ctx, router)
+
+	for _, routeInput := range routeInputs {
+		if routeInput != nil {
+			close(routeInput)
+		}
+	}
+
+	if defaultInput != nil {
+		close(defaultInput)
+	}
+
+	wg.Wait()
+
+	close(collector)
+	collectorWg.Wait()
+
+	TrackFinished[E](ctx, router)
+	close(output)
+}
+
+// route sends msg to the matching routes, and returns whether any matched
+func (router *Router[E]) route(ctx context.Context, routeInputs []chan E, msg E) bool {
+	matched := false
+
+	for i, route := range router.Routes {
+		if routeInputs[i] == nil || route.Match == nil || !route.Match(msg) {
+			continue
+		}
+
+		matched = true
+		send(ctx, routeInputs[i], msg)
+
+		if !router.All {
+			break
+		}
+	}
+
+	return matched
+}
+
+func (router *Router[E]) Name() string {
+	return fmt.Sprintf("Router/%s", router.ChainName)
+}
+
+func (router *Router[E]) Children() []Processor[E] {
+	children := make([]Processor[E], 0, len(router.Routes)+1)
+
+	for _, route := range router.Routes {
+		children = append(children, route.Processor)
+	}
+
+	return nonNil(append(children, router.Default))
+}
+
+var predicates = struct {
+	lock   sync.RWMutex
+	byName map[string]interface{}
+}{byName: make(map[string]interface{})}
+
+/*
+	RegisterPredicate makes predicate available to the routes named name of
+	deserialized Routers. Registering a name again replaces its predicate.
+*/
+func RegisterPredicate[E Traceable](name string, predicate func(E) bool) {
+	predicates.lock.Lock()
+	defer predicates.lock.Unlock()
+
+	predicates.byName[name] = predicate
+}
+
+func lookupPredicate[E Traceable](name string) (func(E) bool, error) {
+	predicates.lock.RLock()
+	defer predicates.lock.RUnlock()
+
+	predicate, ok := predicates.byName[name].(func(E) bool)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, ErrUnknownPredicate)
+	}
+
+	return predicate, nil
+}
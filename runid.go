@@ -0,0 +1,34 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+var PipelineRunID PipelineContextKey = "pipeline_run_id"
+
+// NewRunID returns a unique, time ordered, run identifier
+func NewRunID() string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+
+	return fmt.Sprintf("%s-%s", time.Now().UTC().Format("20060102T150405"), hex.EncodeToString(suffix))
+}
+
+/*
+	WithRunID identifies a pipeline run. The run ID is added to logs, stats,
+	events and to the headers propagated across remote edges, so artifacts of
+	the same execution can be correlated.
+*/
+func WithRunID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, PipelineRunID, id)
+}
+
+// RunID returns the run ID of the context, or an empty string
+func RunID(ctx context.Context) string {
+	id, _ := ctx.Value(PipelineRunID).(string)
+	return id
+}
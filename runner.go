@@ -0,0 +1,699 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+type RunMode int
+
+const (
+	BatchMode RunMode = iota
+	StreamingMode
+)
+
+func (m RunMode) String() string {
+	switch m {
+	case BatchMode:
+		return "batch"
+	case StreamingMode:
+		return "streaming"
+	default:
+		return fmt.Sprintf("RunMode(%d)", int(m))
+	}
+}
+
+func (m RunMode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+const (
+	EventFeedFailed    = "feed_failed"
+	EventFeedRestarted = "feed_restarted"
+)
+
+const defaultRestartDelay = time.Second
+
+// A StopReason tells why a run stopped its intake before its feed was over
+type StopReason string
+
+const (
+	StopTimeLimit   StopReason = "time_limit"
+	StopMaxItems    StopReason = "max_items"
+	StopMaxBytes    StopReason = "max_bytes"
+	StopMaxFailures StopReason = "max_failures"
+	StopFailureRate StopReason = "failure_rate"
+)
+
+// failures are checked for MaxFailures at this interval
+const failureCheckInterval = 100 * time.Millisecond
+
+// runStopped is the cause of the context of a run stopped by the Runner
+type runStopped struct {
+	reason StopReason
+}
+
+func (s *runStopped) Error() string {
+	return fmt.Sprintf("run stopped: %s", s.reason)
+}
+
+/*
+	A Runner executes a pipeline fed by Feed, which sends items to the input
+	of the pipeline until it returns. Feed must return once its context is
+	done.
+
+	In BatchMode the input is finite: the input of the pipeline is closed when
+	Feed returns, and Run returns once everything has drained, with a report
+	of the whole run. In StreamingMode the input is infinite: Feed is
+	supervised and restarted after RestartDelay, one second by default,
+	whenever it returns or panics, until the context of Run is done. The
+	report of a running stream is available with Report.
+
+	Cancelling the context of Run stops the intake. The pipeline then has
+	DrainTimeout to finish the items it holds before its own context is
+	cancelled, or as long as it needs when DrainTimeout is zero. Start runs
+	the pipeline in the background instead, until Drain or Stop.
+
+	The intake is stopped the same way once MaxItems items or MaxBytes bytes
+	were fed, or MaxFailures items failed in the pipeline, when they are set.
+	Items are sized by their Size method when they implement Sizer, or by
+	their raw data when they are RawCarriers, and count for nothing otherwise.
+	A Guard can also stop or pause the intake on the failure rate of a stage.
+
+	With a Recovery, the items left undelivered by a pipeline cancelled
+	before it drained are written to a recovery file, and fed again by the
+	next run. An Admission controller is run along with the pipeline, for
+	Feed to push back on its sources when the pipeline falls behind.
+
+	The SLOs of the pipeline are tracked while it runs, their error budgets
+	are kept in the report, and their burn rate alerts are emitted as events.
+	So are the regressions of its stages against the Baseline of a
+	calibration run, taken with TakeBaseline, when one is set.
+
+	Items which are AckableTraceable are acked once they were handed to
+	Output. The lifecycle of the pipeline is tracked by State, and its
+	intake can be held with Pause. Hooks registered with RegisterOnStart
+	and RegisterOnStop run before the intake opens, and once the pipeline
+	has drained.
+*/
+type Runner[E Traceable] struct {
+	Pipeline Processor[E]
+	Feed     func(ctx context.Context, input chan<- E) error
+	Mode     RunMode
+
+	RestartDelay time.Duration
+	DrainTimeout time.Duration
+
+	MaxItems    int64
+	MaxBytes    int64
+	MaxFailures int64
+
+	Guard     *FailureGuard[E]
+	Health    *Health
+	SLOs      []SLO
+	Recovery  *Recovery
+	Admission *AdmissionController[E]
+
+	Baseline *BaselineComparator
+
+	// DependsOn lists the stages each stage needs to be ready before it is
+	// initialized, by Walk path or name
+	DependsOn map[string][]string
+
+	// Output receives every item produced. Items are discarded when nil.
+	Output func(item E)
+
+	lock    sync.Mutex
+	onStart []runnerHook
+	onStop  []runnerHook
+	state   stateMachine
+	pauses  pauser
+	events  context.Context
+	built   bool
+	startup []StageStartup
+	report  RunReport
+	statDB  *StatDB[E]
+	slos    []*sloTracker
+	input   atomic.Int64
+	bytes   atomic.Int64
+	output  atomic.Int64
+
+	// runs started with Start
+	stopIntake     context.CancelCauseFunc
+	cancelPipeline context.CancelFunc
+	done           chan struct{}
+	result         *RunReport
+	resultErr      error
+}
+
+type RunReport struct {
+	Mode     RunMode   `json:"mode"`
+	RunID    string    `json:"run_id,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished,omitempty"`
+
+	Duration   time.Duration `json:"duration"`
+	Input      int64         `json:"input"`
+	Bytes      int64         `json:"bytes"`
+	Output     int64         `json:"output"`
+	Failures   int64         `json:"failures"`
+	Throughput float64       `json:"throughput"`
+
+	// Batches are complete when all of their input was processed. Streams
+	// count the restarts of their feed instead.
+	Completed bool `json:"completed"`
+	Restarts  int  `json:"restarts"`
+
+	State   PipelineState `json:"state"`
+	Stopped StopReason    `json:"stopped,omitempty"`
+	Trips   []GuardTrip   `json:"guard_trips,omitempty"`
+	SLOs    []SLOStatus   `json:"slos,omitempty"`
+
+	Regressions []Regression `json:"regressions,omitempty"`
+
+	// Recovered counts the items fed again from the recovery file, and
+	// Shutdown tells those the run did not deliver
+	Recovered int             `json:"recovered,omitempty"`
+	Shutdown  *ShutdownReport `json:"shutdown,omitempty"`
+
+	Tags    map[string]string `json:"tags,omitempty"`
+	Err     string            `json:"error,omitempty"`
+	Startup []StageStartup    `json:"startup,omitempty"`
+	Stats   json.RawMessage   `json:"stats,omitempty"`
+}
+
+/*
+	Run executes the pipeline, and returns its report once it has finished.
+	The pipeline is built first unless Build was already called, and Run fails
+	without starting the intake if that fails.
+*/
+func (r *Runner[E]) Run(ctx context.Context) (*RunReport, error) {
+	r.lock.Lock()
+	built := r.built
+	r.lock.Unlock()
+
+	if !built {
+		if err := r.Build(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return r.Open(ctx)
+}
+
+func (r *Runner[E]) run(ctx context.Context) (*RunReport, error) {
+	if RunID(ctx) == "" {
+		ctx = WithRunID(ctx, NewRunID())
+	}
+
+	statDB, ok := ctx.Value(PipelineStatDB).(*StatDB[E])
+	if !ok {
+		statDB = NewStatDB[E]()
+		ctx = WithStats(ctx, statDB)
+	}
+
+	var recovered []E
+	if r.Recovery != nil {
+		items, err := recoveredItems[E](r.Recovery.Path)
+		if err != nil {
+			err = fmt.Errorf("recovering undelivered items: %w", err)
+			r.setState(ctx, StateFailed, err.Error())
+			return nil, err
+		}
+
+		recovered = items
+	}
+
+	r.lock.Lock()
+	r.statDB = statDB
+	r.events = ctx
+	r.report = RunReport{Mode: r.Mode, RunID: RunID(ctx), Started: time.Now(), Startup: r.startup, Tags: processorTags(r.Pipeline), Recovered: len(recovered)}
+	r.input.Store(0)
+	r.bytes.Store(0)
+	r.output.Store(0)
+
+	r.slos = nil
+	for _, slo := range r.SLOs {
+		r.slos = append(r.slos, newSLOTracker(slo))
+	}
+	slos := r.slos
+	r.lock.Unlock()
+
+	ctx, stop := context.WithCancelCause(ctx)
+	defer stop(nil)
+
+	pipelineCtx, cancelPipeline := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelPipeline()
+
+	r.lock.Lock()
+	r.cancelPipeline = cancelPipeline
+	r.lock.Unlock()
+
+	var lost *undelivered[E]
+	if r.Recovery != nil {
+		lost = newUndelivered[E]()
+		cancelled := pipelineCtx
+
+		pipelineCtx = context.WithValue(pipelineCtx, PipelineRecovery, lost)
+		pipelineCtx = WithDeadLetters(pipelineCtx, func(letter *DeadLetter[E]) {
+			if cancelled.Err() != nil {
+				lost.add(letter.Item, RecoveredDeadLetter, letter.Processor)
+			}
+		})
+	}
+
+	if err := r.runStartHooks(ctx); err != nil {
+		err = errors.Join(err, r.runStopHooks(ctx))
+		r.setState(ctx, StateFailed, err.Error())
+		return nil, err
+	}
+
+	input := make(chan E)
+	output := make(chan E)
+
+	go runProcessor[E](pipelineCtx, r.Pipeline, input, output)
+
+	r.setState(ctx, StateRunning, "")
+
+	fed := make(chan error, 1)
+	go func() {
+		err := r.feed(ctx, pipelineCtx, input, stop, recovered)
+		close(input)
+
+		r.setState(ctx, StateDraining, "intake over")
+		fed <- err
+	}()
+
+	if r.MaxFailures > 0 {
+		go r.watchFailures(ctx, stop)
+	}
+
+	if r.Guard != nil {
+		go r.watchGuard(ctx, stop)
+	}
+
+	if len(slos) > 0 {
+		go r.watchSLOs(pipelineCtx, slos)
+	}
+
+	if r.Baseline != nil {
+		go r.watchBaseline(pipelineCtx, statDB)
+	}
+
+	if r.Admission != nil {
+		go r.Admission.Run(pipelineCtx)
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-pipelineCtx.Done():
+			return
+		}
+
+		if r.DrainTimeout <= 0 {
+			return
+		}
+
+		timer := time.NewTimer(r.DrainTimeout)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+			Log[E](ctx, r.Pipeline, "not drained after %s, cancelling", r.DrainTimeout)
+			cancelPipeline()
+		case <-pipelineCtx.Done():
+		}
+	}()
+
+	for m := range output {
+		r.output.Inc()
+
+		now := time.Now()
+		for _, t := range slos {
+			t.observe(ctx, m, now)
+		}
+
+		if r.Output != nil {
+			r.Output(m)
+		}
+
+		Ack(m)
+	}
+
+	err := <-fed
+
+	r.lock.Lock()
+	failed := r.failures()
+	r.lock.Unlock()
+
+	for _, t := range slos {
+		t.sample(time.Now(), r.input.Load(), failed)
+	}
+
+	var shutdown *ShutdownReport
+	if lost != nil {
+		lost.collectBuffered(r.Pipeline)
+
+		shutdown = lost.export(r.Recovery, RunID(ctx))
+
+		switch {
+		case shutdown != nil && shutdown.Err != "":
+			Log[E](ctx, r.Pipeline, "writing undelivered items to %s failed: %s", r.Recovery.Path, shutdown.Err)
+		case shutdown != nil:
+			Log[E](ctx, r.Pipeline, "undelivered items written to %s", r.Recovery.Path)
+		}
+	}
+
+	if r.Mode == BatchMode && err == nil {
+		err = ctx.Err()
+	}
+
+	var stopped *runStopped
+	if errors.As(context.Cause(ctx), &stopped) && errors.Is(err, ctx.Err()) {
+		err = nil
+	}
+
+	if hookErr := r.runStopHooks(ctx); hookErr != nil {
+		err = errors.Join(err, hookErr)
+	}
+
+	switch {
+	case err != nil:
+		r.setState(ctx, StateFailed, err.Error())
+	case stopped != nil:
+		r.setState(ctx, StateStopped, string(stopped.reason))
+	default:
+		r.setState(ctx, StateStopped, "")
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if stopped != nil {
+		r.report.Stopped = stopped.reason
+	}
+
+	r.report.Shutdown = shutdown
+
+	r.report.Finished = time.Now()
+	r.report.Completed = r.Mode == BatchMode && err == nil && stopped == nil && pipelineCtx.Err() == nil
+
+	if err != nil {
+		r.report.Err = err.Error()
+	}
+
+	report := r.snapshot()
+
+	return &report, err
+}
+
+/*
+	RunFor runs the pipeline for at most d: the intake is then stopped, and
+	the pipeline drained as when the context is cancelled. Reaching the time
+	limit is not an error, and is recorded in the report.
+*/
+func (r *Runner[E]) RunFor(ctx context.Context, d time.Duration) (*RunReport, error) {
+	ctx, cancel := context.WithTimeoutCause(ctx, d, &runStopped{reason: StopTimeLimit})
+	defer cancel()
+
+	return r.Run(ctx)
+}
+
+// Report returns the report of the current run, or of the last one
+func (r *Runner[E]) Report() RunReport {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.snapshot()
+}
+
+func (r *Runner[E]) snapshot() RunReport {
+	report := r.report
+
+	report.Input = r.input.Load()
+	report.Bytes = r.bytes.Load()
+	report.Output = r.output.Load()
+	report.Failures = r.failures()
+	report.State = r.state.get().State
+	report.SLOs = r.sloStatus()
+	report.Regressions = CompareBaseline(r.Baseline, r.statDB, r.Pipeline)
+
+	end := report.Finished
+	if end.IsZero() {
+		end = time.Now()
+	}
+
+	if !report.Started.IsZero() {
+		report.Duration = end.Sub(report.Started)
+	}
+
+	if report.Duration > 0 {
+		report.Throughput = float64(report.Output) / report.Duration.Seconds()
+	}
+
+	if r.statDB != nil {
+		report.Stats, _ = json.Marshal(r.statDB)
+	}
+
+	return report
+}
+
+// failures returns the items failed in the pipeline, as tracked in its stats
+func (r *Runner[E]) failures() int64 {
+	if r.statDB == nil || r.Pipeline == nil {
+		return 0
+	}
+
+	var failed int64
+
+	Walk(r.Pipeline, func(path string, p Processor[E]) {
+		if stats, ok := r.statDB.statsOf(p); ok {
+			failed += stats.Failed.Load()
+		}
+	})
+
+	return failed
+}
+
+func (r *Runner[E]) watchFailures(ctx context.Context, stop context.CancelCauseFunc) {
+	ticker := time.NewTicker(failureCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		r.lock.Lock()
+		failed := r.failures()
+		r.lock.Unlock()
+
+		if failed >= r.MaxFailures {
+			Log[E](ctx, r.Pipeline, "%d failures, stopping", failed)
+			stop(&runStopped{reason: StopMaxFailures})
+			return
+		}
+	}
+}
+
+func (r *Runner[E]) watchGuard(ctx context.Context, stop context.CancelCauseFunc) {
+	ticker := time.NewTicker(r.Guard.interval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		trip := r.Guard.check(r.statDB, r.Pipeline)
+		if trip == nil {
+			continue
+		}
+
+		r.lock.Lock()
+		r.report.Trips = append(r.report.Trips, *trip)
+		r.lock.Unlock()
+
+		Emit[E](ctx, r.Pipeline, EventGuardTripped, "%s failed %d of %d items in %s", trip.Path, trip.Failed, trip.Input, r.Guard.Window)
+
+		if r.Guard.Action == GuardAbort {
+			stop(&runStopped{reason: StopFailureRate})
+			return
+		}
+
+		r.setState(ctx, StatePaused, fmt.Sprintf("guard tripped on %s", trip.Path))
+
+		go func() {
+			if r.Guard.wait(ctx) {
+				Emit[E](ctx, r.Pipeline, EventGuardResumed, "intake resumed")
+
+				if !r.pauses.paused() {
+					r.setState(ctx, StateRunning, "guard resumed")
+				}
+			}
+		}()
+	}
+}
+
+// limit returns why the intake must stop after the items and bytes fed so
+// far, if it must
+func (r *Runner[E]) limit(items, bytes int64) StopReason {
+	switch {
+	case r.MaxItems > 0 && items >= r.MaxItems:
+		return StopMaxItems
+	case r.MaxBytes > 0 && bytes >= r.MaxBytes:
+		return StopMaxBytes
+	default:
+		return ""
+	}
+}
+
+func itemSize(item interface{}) int64 {
+	switch item := item.(type) {
+	case Sizer:
+		return int64(item.Size())
+	case RawCarrier:
+		return int64(len(item.RawBytes()))
+	default:
+		return 0
+	}
+}
+
+/*
+	feed runs Feed, once in batch mode and until ctx is done in streaming
+	mode, after feeding the recovered items. Items go through a relay
+	counting them, which gives up when the pipeline is cancelled, and stops
+	the intake once a limit is reached.
+*/
+func (r *Runner[E]) feed(ctx context.Context, pipelineCtx context.Context, input chan E, stop context.CancelCauseFunc, recovered []E) error {
+	feedInput := make(chan E)
+	relayed := make(chan struct{})
+
+	// relay returns false once the intake stops
+	relay := func(m E) bool {
+		if r.Guard != nil && !r.Guard.wait(ctx) {
+			abandon(pipelineCtx, m)
+			return false
+		}
+
+		if !r.pauses.wait(ctx) {
+			abandon(pipelineCtx, m)
+			return false
+		}
+
+		items := r.input.Inc()
+		bytes := r.bytes.Add(itemSize(m))
+
+		if timer, ok := any(m).(IngestTimer); ok && timer.IngestTime().IsZero() {
+			timer.SetIngestTime(time.Now())
+		}
+
+		if !send(pipelineCtx, input, m) {
+			return false
+		}
+
+		if reason := r.limit(items, bytes); reason != "" {
+			Log[E](ctx, r.Pipeline, "%s reached, stopping", reason)
+			stop(&runStopped{reason: reason})
+			return false
+		}
+
+		return true
+	}
+
+	go func() {
+		defer close(relayed)
+
+		for i, m := range recovered {
+			if relay(m) {
+				continue
+			}
+
+			// kept for the next run
+			for _, rest := range recovered[i+1:] {
+				abandon(pipelineCtx, rest)
+			}
+
+			go drain(feedInput)
+			return
+		}
+
+		if len(recovered) > 0 {
+			if err := os.Remove(r.Recovery.Path); err != nil && !os.IsNotExist(err) {
+				Log[E](ctx, r.Pipeline, "removing %s failed: %s", r.Recovery.Path, err)
+			}
+		}
+
+		for m := range feedInput {
+			if !relay(m) {
+				go drain(feedInput)
+				break
+			}
+		}
+	}()
+
+	defer func() {
+		close(feedInput)
+		<-relayed
+	}()
+
+	delay := r.RestartDelay
+	if delay <= 0 {
+		delay = defaultRestartDelay
+	}
+
+	for {
+		err := r.runFeed(ctx, feedInput)
+
+		if r.Mode == BatchMode {
+			return err
+		}
+
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err != nil {
+			Emit[E](ctx, r.Pipeline, EventFeedFailed, "%s", err)
+
+			r.lock.Lock()
+			r.report.Err = err.Error()
+			r.lock.Unlock()
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil
+		}
+
+		r.lock.Lock()
+		r.report.Restarts++
+		restarts := r.report.Restarts
+		r.lock.Unlock()
+
+		Emit[E](ctx, r.Pipeline, EventFeedRestarted, "feed restarted, %d restarts", restarts)
+	}
+}
+
+func (r *Runner[E]) runFeed(ctx context.Context, input chan<- E) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("feed panicked: %v", rec)
+		}
+	}()
+
+	return r.Feed(ctx, input)
+}
@@ -0,0 +1,368 @@
+/*
+	Package script runs per item logic written in Starlark in pipelines.
+	Register makes its processor available to definitions, as the "script"
+	type.
+*/
+package script
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/ca0s/pipeline"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+var ErrScriptFailed = fmt.Errorf("script failed")
+
+// steps an item may take when the script sets no MaxSteps
+const DefaultScriptMaxSteps = 1000000
+
+/*
+	The Script processor runs per item logic written in Starlark, a Python
+	dialect made to be embedded, in the definition of the pipeline, as the
+	source of a "script" definition.
+
+	Scripts define a process function, called with a dict of the fields of
+	every item, named after Tag as for pipeline.Fanout. It changes the
+	fields in place, or returns a dict of fields to set instead, and returns
+	False to drop the item; fail(msg) fails it. pipeline.FieldSetter items
+	get the values converted as told by Types.
+
+	The program is compiled once, and its top level run once, frozen, so
+	items do not share a state. Scripts load no modules and have no access
+	to the host, beyond print, which logs in the name of the processor.
+	Items taking more than MaxSteps steps of the interpreter, a million by
+	default, or Timeout, one second by default, fail. Compiled is the
+	program as written by CompileScript, when it is not compiled from
+	Source.
+*/
+type Script[E pipeline.Traceable] struct {
+	ChainName string
+	Source    string
+	Compiled  []byte `json:"-"`
+
+	Tag   string
+	Types map[string]string
+
+	MaxSteps uint64
+	Timeout  time.Duration
+
+	lock    sync.Mutex
+	process starlark.Callable
+}
+
+func NewScript[E pipeline.Traceable](name string, source string) *Script[E] {
+	return &Script[E]{
+		ChainName: name,
+		Source:    source,
+	}
+}
+
+// CompileScript compiles source, for Scripts to be given as Compiled
+func CompileScript(name string, source string) ([]byte, error) {
+	program, err := compileScript(name, source)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %w", name, err, ErrScriptFailed)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := program.Write(buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// compileScript compiles source without predeclared names but the builtins
+func compileScript(name string, source string) (*starlark.Program, error) {
+	_, program, err := starlark.SourceProgramOptions(&syntax.FileOptions{}, name, source, func(string) bool { return false })
+	return program, err
+}
+
+// Init compiles the program and runs its top level
+func (s *Script[E]) Init(ctx context.Context) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.init(ctx)
+}
+
+func (s *Script[E]) init(ctx context.Context) error {
+	if s.process != nil {
+		return nil
+	}
+
+	var program *starlark.Program
+	var err error
+
+	if len(s.Compiled) > 0 {
+		program, err = starlark.CompiledProgram(bytes.NewReader(s.Compiled))
+	} else {
+		program, err = compileScript(s.ChainName, s.Source)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s: %w: %w", s.Name(), err, ErrScriptFailed)
+	}
+
+	thread := s.thread(ctx)
+	thread.SetMaxExecutionSteps(s.maxSteps())
+
+	globals, err := program.Init(thread, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w: %w", s.Name(), err, ErrScriptFailed)
+	}
+
+	globals.Freeze()
+
+	process, ok := globals["process"].(starlark.Callable)
+	if !ok {
+		return fmt.Errorf("%s: script defines no process function: %w", s.Name(), ErrScriptFailed)
+	}
+
+	s.process = process
+
+	return nil
+}
+
+func (s *Script[E]) maxSteps() uint64 {
+	if s.MaxSteps == 0 {
+		return DefaultScriptMaxSteps
+	}
+
+	return s.MaxSteps
+}
+
+func (s *Script[E]) thread(ctx context.Context) *starlark.Thread {
+	return &starlark.Thread{
+		Name: s.Name(),
+		Print: func(_ *starlark.Thread, msg string) {
+			pipeline.Log[E](ctx, s, "%s", msg)
+		},
+	}
+}
+
+func (s *Script[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	err := s.Init(ctx)
+	if err != nil {
+		pipeline.Log[E](ctx, s, "%s", err)
+		pipeline.ReportError(ctx, s, fmt.Errorf("%w: %w", err, pipeline.ErrFatal))
+	}
+
+	pipeline.ExecuteItems[E](ctx, s, input, output, func(item E) (E, error) {
+		if err != nil {
+			return item, err
+		}
+
+		return s.ProcessItem(ctx, item)
+	})
+}
+
+func (s *Script[E]) Name() string {
+	return fmt.Sprintf("Script/%s", s.ChainName)
+}
+
+// ProcessItem runs the script for item, failing with pipeline.ErrDropped for
+// the items it drops
+func (s *Script[E]) ProcessItem(ctx context.Context, item E) (E, error) {
+	s.lock.Lock()
+	err := s.init(ctx)
+	process := s.process
+	s.lock.Unlock()
+
+	if err != nil {
+		return item, fmt.Errorf("%w: %w", err, pipeline.ErrFatal)
+	}
+
+	fields, _ := pipeline.ItemFields(item, s.Tag)
+
+	dict := starlark.NewDict(len(fields))
+	original := make(map[string]starlark.Value, len(fields))
+
+	for name, value := range fields {
+		original[name] = starlarkValue(value)
+		dict.SetKey(starlark.String(name), original[name])
+	}
+
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	thread := s.thread(ctx)
+	thread.SetMaxExecutionSteps(s.maxSteps())
+
+	stop := context.AfterFunc(callCtx, func() {
+		thread.Cancel(callCtx.Err().Error())
+	})
+	defer stop()
+
+	result, err := starlark.Call(thread, process, starlark.Tuple{dict}, nil)
+	if err != nil {
+		return item, fmt.Errorf("%s: %w: %w", s.Name(), err, ErrScriptFailed)
+	}
+
+	if result == starlark.False {
+		return item, fmt.Errorf("%s: %w", s.Name(), pipeline.ErrDropped)
+	}
+
+	if returned, ok := result.(*starlark.Dict); ok {
+		dict = returned
+	}
+
+	updates := make(map[string]string)
+
+	for _, entry := range dict.Items() {
+		name, ok := starlark.AsString(entry[0])
+		if !ok {
+			return item, fmt.Errorf("%s: field %s is not named by a string: %w", s.Name(), entry[0], ErrScriptFailed)
+		}
+
+		if before, found := original[name]; found {
+			if same, err := starlark.Equal(before, entry[1]); err == nil && same {
+				continue
+			}
+		}
+
+		updates[name] = starlarkString(entry[1])
+	}
+
+	if len(updates) > 0 {
+		if err := pipeline.SetItemFields(item, s.Tag, updates, s.Types); err != nil {
+			return item, err
+		}
+	}
+
+	return item, nil
+}
+
+// starlarkValue converts a field to its Starlark equivalent, or its text
+// representation for the types Starlark does not have
+func starlarkValue(value interface{}) starlark.Value {
+	if value == nil {
+		return starlark.None
+	}
+
+	switch v := value.(type) {
+	case string:
+		return starlark.String(v)
+	case bool:
+		return starlark.Bool(v)
+	case time.Time, time.Duration:
+		return starlark.String(pipeline.FormatValue(v))
+	}
+
+	switch rv := reflect.ValueOf(value); rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return starlark.MakeInt64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return starlark.MakeUint64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return starlark.Float(rv.Float())
+	}
+
+	return starlark.String(pipeline.FormatValue(value))
+}
+
+// starlarkString returns the text representation of a value, as fields are
+// set from
+func starlarkString(value starlark.Value) string {
+	switch v := value.(type) {
+	case starlark.String:
+		return string(v)
+	case starlark.Bool:
+		if v {
+			return "true"
+		}
+
+		return "false"
+	case starlark.NoneType:
+		return ""
+	}
+
+	return value.String()
+}
+
+/*
+	Register registers the Script processor as the "script" processor type
+	of definitions, configured with source, tag, types, max_steps and
+	timeout. Scripts are compiled when they are built, so broken ones fail
+	their definition.
+*/
+func Register[E pipeline.Traceable]() {
+	pipeline.RegisterProcessorType[E, *Script[E]]("script", newScript[E], marshalScript[E])
+}
+
+func newScript[E pipeline.Traceable](name string, cfg map[string]interface{}) (*Script[E], error) {
+	script := &Script[E]{ChainName: name}
+
+	script.Source, _ = cfg["source"].(string)
+	script.Tag, _ = cfg["tag"].(string)
+
+	if types, ok := cfg["types"].(map[string]interface{}); ok {
+		script.Types = make(map[string]string, len(types))
+
+		for name, typename := range types {
+			if typename, ok := typename.(string); ok {
+				script.Types[name] = typename
+			}
+		}
+	}
+
+	if steps, ok := cfg["max_steps"].(float64); ok {
+		if steps < 0 || steps != math.Trunc(steps) {
+			return nil, fmt.Errorf("invalid max_steps %v: %w", steps, pipeline.ErrInvalidConfig)
+		}
+
+		script.MaxSteps = uint64(steps)
+	}
+
+	if timeout, ok := cfg["timeout"].(string); ok {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %w: %w", err, pipeline.ErrInvalidConfig)
+		}
+
+		script.Timeout = d
+	}
+
+	if err := script.Init(context.Background()); err != nil {
+		return nil, fmt.Errorf("%w: %w", err, pipeline.ErrInvalidConfig)
+	}
+
+	return script, nil
+}
+
+func marshalScript[E pipeline.Traceable](script *Script[E]) (string, map[string]interface{}, error) {
+	cfg := map[string]interface{}{
+		"source": script.Source,
+	}
+
+	if script.Tag != "" {
+		cfg["tag"] = script.Tag
+	}
+
+	if len(script.Types) > 0 {
+		cfg["types"] = script.Types
+	}
+
+	if script.MaxSteps > 0 {
+		cfg["max_steps"] = script.MaxSteps
+	}
+
+	if script.Timeout > 0 {
+		cfg["timeout"] = script.Timeout.String()
+	}
+
+	return script.ChainName, cfg, nil
+}
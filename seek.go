@@ -0,0 +1,85 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrNotSeekable = fmt.Errorf("no seekable source")
+
+/*
+	A Seeker is a checkpoint-aware source able to start from an arbitrary
+	position instead of its last checkpoint, either an opaque offset (a Kafka
+	offset, a byte position in a file...) or the first item at or after a
+	timestamp. Seeking happens before the source is started.
+*/
+type Seeker interface {
+	SeekOffset(ctx context.Context, offset string) error
+	SeekTime(ctx context.Context, t time.Time) error
+}
+
+/*
+	A SeekRequest selects where to reprocess from. Time takes precedence over
+	Offset when both are set.
+*/
+type SeekRequest struct {
+	Offset string    `json:"offset,omitempty"`
+	Time   time.Time `json:"time,omitempty"`
+}
+
+/*
+	ParseSeekRequest understands the forms a user would type to request a
+	reprocessing job: "2024-01-01", an RFC 3339 timestamp such as
+	"2024-01-01T10:00:00Z", or anything else as a raw offset.
+*/
+func ParseSeekRequest(s string) SeekRequest {
+	s = strings.TrimSpace(s)
+
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", time.DateOnly} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return SeekRequest{Time: t}
+		}
+	}
+
+	return SeekRequest{Offset: s}
+}
+
+func (req SeekRequest) Apply(ctx context.Context, s Seeker) error {
+	if !req.Time.IsZero() {
+		return s.SeekTime(ctx, req.Time)
+	}
+
+	return s.SeekOffset(ctx, req.Offset)
+}
+
+// SeekTree applies the request to every Seeker of the tree
+func SeekTree[E Traceable](ctx context.Context, root Processor[E], req SeekRequest) error {
+	found := false
+
+	var err error
+
+	Walk(root, func(path string, p Processor[E]) {
+		s, ok := p.(Seeker)
+		if !ok || err != nil {
+			return
+		}
+
+		found = true
+
+		if seekErr := req.Apply(ctx, s); seekErr != nil {
+			err = fmt.Errorf("%s: %w", path, seekErr)
+		}
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return ErrNotSeekable
+	}
+
+	return nil
+}
@@ -4,32 +4,128 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
+/*
+	A SerializedPipeline is the definition of a pipeline, read from JSON or
+	YAML, with the same fields in both. Pipeline builds it.
+*/
 type SerializedPipeline[E Traceable] struct {
-	Type       string                  `json:"type"`
-	Name       string                  `json:"name"`
-	Config     map[string]interface{}  `json:"cfg"`
-	Processors []SerializedPipeline[E] `json:"processors"`
+	Type       string                  `json:"type" yaml:"type"`
+	Name       string                  `json:"name" yaml:"name"`
+	Display    string                  `json:"display,omitempty" yaml:"display,omitempty"`
+	Tags       map[string]string       `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Config     map[string]interface{}  `json:"cfg" yaml:"cfg"`
+	Processors []SerializedPipeline[E] `json:"processors" yaml:"processors"`
 
 	processorFactory ProcessorFactory[E]
+	limits           DefinitionLimits
 }
 
 type ProcessorFactory[E Traceable] func(name string, cfg map[string]interface{}) (Processor[E], error)
 
 var ErrInvalidType = fmt.Errorf("invalid pipeline type")
+var ErrInvalidConfig = fmt.Errorf("invalid pipeline config")
+var ErrNoProcessorFactory = fmt.Errorf("no processor factory")
+var ErrNilProcessor = fmt.Errorf("nil processor")
+var ErrMissingField = fmt.Errorf("missing required field")
+
+/*
+	A DefinitionError is returned for a definition Pipeline can not build.
+	Path is the names of the definitions from the root down to the faulty
+	one, joined by slashes, and Err why it can not be built.
+*/
+type DefinitionError struct {
+	Path string
+	Err  error
+}
+
+func (e *DefinitionError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Err)
+}
+
+func (e *DefinitionError) Unwrap() error {
+	return e.Err
+}
 
+/*
+	Pipeline builds the processor tree of the definition. Tags are given to
+	the processors implementing TagSetter, as composites do, and ignored for
+	the others.
+
+	Malformed definitions, including those making the processor factory
+	panic, and those exceeding the limits set with SetLimits, return a
+	DefinitionError.
+*/
 func (sp *SerializedPipeline[E]) Pipeline() (Processor[E], error) {
+	return sp.pipeline(&definitionBudget{limits: sp.limits.orDefault()}, 1)
+}
+
+func (sp *SerializedPipeline[E]) pipeline(budget *definitionBudget, depth int) (Processor[E], error) {
+	if err := budget.check(depth, len(sp.Processors)); err != nil {
+		return nil, sp.definitionError(err)
+	}
+
+	p, err := sp.build(budget, depth)
+	if err != nil {
+		return nil, sp.definitionError(err)
+	}
+
+	if setter, ok := p.(TagSetter); ok && len(sp.Tags) > 0 {
+		setter.SetTags(sp.Tags)
+	}
+
+	return p, nil
+}
+
+// definitionError prefixes the path of the DefinitionError of a child with
+// the name of sp
+func (sp *SerializedPipeline[E]) definitionError(err error) error {
+	name := sp.pathName()
+
+	if child, ok := err.(*DefinitionError); ok {
+		return &DefinitionError{Path: name + "/" + child.Path, Err: child.Err}
+	}
+
+	return &DefinitionError{Path: name, Err: err}
+}
+
+// pathName is the name of sp in the paths of DefinitionErrors
+func (sp *SerializedPipeline[E]) pathName() string {
+	if sp.Name != "" {
+		return sp.Name
+	}
+
+	if sp.Type != "" {
+		return sp.Type
+	}
+
+	return "unnamed"
+}
+
+// buildChild builds a child definition with the factory of sp
+func (sp *SerializedPipeline[E]) buildChild(child SerializedPipeline[E], budget *definitionBudget, depth int) (Processor[E], error) {
+	child.processorFactory = sp.processorFactory
+	return child.pipeline(budget, depth+1)
+}
+
+func (sp *SerializedPipeline[E]) build(budget *definitionBudget, depth int) (p Processor[E], err error) {
 	switch sp.Type {
 	case "fanout":
 		fanout := &Fanout[E]{
 			ChainName: sp.Name,
+			Display:   sp.Display,
 		}
 
 		for _, proc := range sp.Processors {
-			proc.processorFactory = sp.processorFactory
-
-			builtProc, err := proc.Pipeline()
+			builtProc, err := sp.buildChild(proc, budget, depth)
 			if err != nil {
 				return nil, err
 			}
@@ -37,17 +133,41 @@ func (sp *SerializedPipeline[E]) Pipeline() (Processor[E], error) {
 			fanout.Processors = append(fanout.Processors, builtProc)
 		}
 
+		if timeout, ok := sp.Config["close_timeout"].(string); ok {
+			d, err := time.ParseDuration(timeout)
+			if err != nil {
+				return nil, fmt.Errorf("invalid close_timeout: %w: %w", err, ErrInvalidConfig)
+			}
+
+			fanout.CloseTimeout = d
+		}
+
+		if names, ok := sp.Config["non_critical"].([]interface{}); ok {
+			for _, name := range names {
+				if name, ok := name.(string); ok {
+					fanout.NonCritical = append(fanout.NonCritical, name)
+				}
+			}
+		}
+
+		if fanout.BufferSize, err = bufferSize(sp.Config); err != nil {
+			return nil, err
+		}
+
+		if fanout.Overflow, fanout.SpillDir, err = bufferOverflow(sp.Config); err != nil {
+			return nil, err
+		}
+
 		return fanout, nil
 
 	case "parallel":
 		parallel := &Parallel[E]{
 			ChainName: sp.Name,
+			Display:   sp.Display,
 		}
 
 		for _, proc := range sp.Processors {
-			proc.processorFactory = sp.processorFactory
-
-			builtProc, err := proc.Pipeline()
+			builtProc, err := sp.buildChild(proc, budget, depth)
 			if err != nil {
 				return nil, err
 			}
@@ -55,17 +175,24 @@ func (sp *SerializedPipeline[E]) Pipeline() (Processor[E], error) {
 			parallel.Processors = append(parallel.Processors, builtProc)
 		}
 
+		if parallel.BufferSize, err = bufferSize(sp.Config); err != nil {
+			return nil, err
+		}
+
+		if parallel.Overflow, parallel.SpillDir, err = bufferOverflow(sp.Config); err != nil {
+			return nil, err
+		}
+
 		return parallel, nil
 
 	case "sequential":
 		sequential := &Sequential[E]{
 			ChainName: sp.Name,
+			Display:   sp.Display,
 		}
 
 		for _, proc := range sp.Processors {
-			proc.processorFactory = sp.processorFactory
-
-			builtProc, err := proc.Pipeline()
+			builtProc, err := sp.buildChild(proc, budget, depth)
 			if err != nil {
 				return nil, err
 			}
@@ -73,93 +200,910 @@ func (sp *SerializedPipeline[E]) Pipeline() (Processor[E], error) {
 			sequential.Processors = append(sequential.Processors, builtProc)
 		}
 
+		if sequential.BufferSize, err = bufferSize(sp.Config); err != nil {
+			return nil, err
+		}
+
+		if sequential.Overflow, sequential.SpillDir, err = bufferOverflow(sp.Config); err != nil {
+			return nil, err
+		}
+
 		return sequential, nil
 
-	case "processor":
-		proc, err := sp.processorFactory(sp.Name, sp.Config)
+	case "shadow":
+		if len(sp.Processors) != 2 {
+			return nil, fmt.Errorf("shadow needs a primary and a candidate processor: %w", ErrInvalidType)
+		}
+
+		shadow := &Shadow[E]{
+			ChainName:  sp.Name,
+			Display:    sp.Display,
+			SampleRate: 1,
+		}
+
+		if rate, ok := sp.Config["sample_rate"].(float64); ok {
+			shadow.SampleRate = rate
+		}
+
+		built := make([]Processor[E], 0, len(sp.Processors))
+
+		for _, proc := range sp.Processors {
+			builtProc, err := sp.buildChild(proc, budget, depth)
+			if err != nil {
+				return nil, err
+			}
+
+			built = append(built, builtProc)
+		}
+
+		shadow.Primary = built[0]
+		shadow.Candidate = built[1]
+
+		return shadow, nil
+
+	case "bluegreen":
+		if len(sp.Processors) != 2 {
+			return nil, fmt.Errorf("bluegreen needs a blue and a green processor: %w", ErrInvalidType)
+		}
+
+		bg := &BlueGreen[E]{
+			ChainName: sp.Name,
+			Display:   sp.Display,
+		}
+
+		if split, ok := sp.Config["split"].(float64); ok {
+			bg.SetSplit(split)
+		}
+
+		built := make([]Processor[E], 0, len(sp.Processors))
+
+		for _, proc := range sp.Processors {
+			builtProc, err := sp.buildChild(proc, budget, depth)
+			if err != nil {
+				return nil, err
+			}
+
+			built = append(built, builtProc)
+		}
+
+		bg.Blue = built[0]
+		bg.Green = built[1]
+
+		return bg, nil
+
+	case "flagged":
+		if len(sp.Processors) != 1 {
+			return nil, fmt.Errorf("flagged needs exactly one processor: %w", ErrInvalidType)
+		}
+
+		flagged := &Flagged[E]{
+			Flag: sp.Name,
+		}
+
+		if def, ok := sp.Config["default"].(bool); ok {
+			flagged.Default = def
+		}
+
+		if interval, ok := sp.Config["interval"].(string); ok {
+			d, err := time.ParseDuration(interval)
+			if err != nil {
+				return nil, fmt.Errorf("invalid interval: %w: %w", err, ErrInvalidConfig)
+			}
+
+			flagged.Interval = d
+		}
+
+		proc := sp.Processors[0]
+		builtProc, err := sp.buildChild(proc, budget, depth)
 		if err != nil {
-			return nil, fmt.Errorf("%s: %v", sp.Name, ErrInvalidType)
+			return nil, err
 		}
 
-		return proc, nil
+		flagged.Processor = builtProc
+
+		return flagged, nil
+
+	case "pool":
+		if len(sp.Processors) != 1 {
+			return nil, fmt.Errorf("pool needs exactly one processor: %w", ErrInvalidType)
+		}
+
+		pool := &Pool[E]{
+			ChainName: sp.Name,
+			Display:   sp.Display,
+			Replicas:  1,
+		}
+
+		if replicas, ok, err := configInt(sp.Config, "replicas", 1); err != nil {
+			return nil, err
+		} else if ok {
+			pool.Replicas = replicas
+		}
+
+		if err := budget.checkWidth(pool.Replicas); err != nil {
+			return nil, err
+		}
+
+		built := make(map[string]Processor[E], pool.Replicas)
+
+		// replicas are built from the definition as it is, its name being
+		// the factory of leaves, and told apart by their ChainName#i
+		for i := 0; i < pool.Replicas; i++ {
+			builtProc, err := sp.buildChild(sp.Processors[0], budget, depth)
+			if err != nil {
+				return nil, err
+			}
+
+			built[fmt.Sprintf("%s#%d", sp.Name, i)] = builtProc
+		}
+
+		pool.New = func(name string) Processor[E] {
+			return built[name]
+		}
+
+		return pool, nil
+
+	case "tenants":
+		if len(sp.Processors) != 1 {
+			return nil, fmt.Errorf("tenants needs exactly one processor: %w", ErrInvalidType)
+		}
+
+		tenants := &TenantPool[E]{
+			ChainName: sp.Name,
+			Display:   sp.Display,
+			Factory:   sp.processorFactory,
+		}
+
+		tenants.TenantField, _ = sp.Config["tenant_field"].(string)
+		if tenants.TenantField == "" {
+			return nil, fmt.Errorf("tenants needs the field of the tenant of items: %w", ErrInvalidConfig)
+		}
+
+		tenants.Tag, _ = sp.Config["tag"].(string)
+
+		if tenants.MaxTenants, _, err = configInt(sp.Config, "max_tenants", 0); err != nil {
+			return nil, err
+		}
+
+		if tenants.BufferSize, err = bufferSize(sp.Config); err != nil {
+			return nil, err
+		}
+
+		if tenants.Template, err = sp.buildChild(sp.Processors[0], budget, depth); err != nil {
+			return nil, err
+		}
+
+		return tenants, nil
+
+	case "router":
+		router := &Router[E]{
+			ChainName: sp.Name,
+			Display:   sp.Display,
+		}
+
+		if all, ok := sp.Config["all"].(bool); ok {
+			router.All = all
+		}
+
+		var names []string
+		if routes, ok := sp.Config["routes"].([]interface{}); ok {
+			for _, name := range routes {
+				if name, ok := name.(string); ok {
+					names = append(names, name)
+				}
+			}
+		}
+
+		hasDefault, _ := sp.Config["default"].(bool)
+
+		expected := len(names)
+		if hasDefault {
+			expected++
+		}
+
+		if len(sp.Processors) != expected {
+			return nil, fmt.Errorf("router needs a processor for every route and its default: %w", ErrInvalidType)
+		}
+
+		built := make([]Processor[E], 0, len(sp.Processors))
+
+		for _, proc := range sp.Processors {
+			builtProc, err := sp.buildChild(proc, budget, depth)
+			if err != nil {
+				return nil, err
+			}
+
+			built = append(built, builtProc)
+		}
+
+		for i, name := range names {
+			match, err := lookupPredicate[E](name)
+			if err != nil {
+				return nil, err
+			}
+
+			router.Routes = append(router.Routes, Route[E]{
+				Name:      name,
+				Match:     match,
+				Processor: built[i],
+			})
+		}
+
+		if hasDefault {
+			router.Default = built[len(built)-1]
+		}
+
+		return router, nil
+
+	case "retry":
+		if len(sp.Processors) != 1 {
+			return nil, fmt.Errorf("retry needs exactly one processor: %w", ErrInvalidType)
+		}
+
+		retry := &Retry[E]{
+			ChainName: sp.Name,
+			Display:   sp.Display,
+		}
+
+		if attempts, ok, err := configInt(sp.Config, "max_attempts", 0); err != nil {
+			return nil, err
+		} else if ok {
+			retry.Policy.MaxAttempts = attempts
+		}
+
+		if multiplier, ok := sp.Config["multiplier"].(float64); ok {
+			retry.Policy.Multiplier = multiplier
+		}
+
+		if jitter, ok := sp.Config["jitter"].(float64); ok {
+			retry.Policy.Jitter = jitter
+		}
+
+		for key, d := range map[string]*time.Duration{"backoff": &retry.Policy.Backoff, "max_backoff": &retry.Policy.MaxBackoff} {
+			value, ok := sp.Config[key].(string)
+			if !ok {
+				continue
+			}
+
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w: %w", key, err, ErrInvalidConfig)
+			}
+
+			*d = parsed
+		}
+
+		proc := sp.Processors[0]
+		builtProc, err := sp.buildChild(proc, budget, depth)
+		if err != nil {
+			return nil, err
+		}
+
+		retry.Processor = builtProc
+
+		return retry, nil
+
+	case "deadline":
+		if len(sp.Processors) != 1 {
+			return nil, fmt.Errorf("deadline needs exactly one processor: %w", ErrInvalidType)
+		}
+
+		deadline := &Deadline[E]{
+			ChainName: sp.Name,
+			Display:   sp.Display,
+		}
+
+		if share, ok := sp.Config["share"].(float64); ok {
+			if share <= 0 || share > 1 {
+				return nil, fmt.Errorf("invalid share %v: %w", share, ErrInvalidConfig)
+			}
+
+			deadline.Share = share
+		}
+
+		if budget, ok := sp.Config["min_budget"].(string); ok {
+			d, err := time.ParseDuration(budget)
+			if err != nil {
+				return nil, fmt.Errorf("invalid min_budget: %w: %w", err, ErrInvalidConfig)
+			}
+
+			deadline.MinBudget = d
+		}
+
+		deadline.Optional, _ = sp.Config["optional"].(bool)
+
+		builtProc, err := sp.buildChild(sp.Processors[0], budget, depth)
+		if err != nil {
+			return nil, err
+		}
+
+		deadline.Processor = builtProc
+
+		return deadline, nil
+
+	case "processor":
+		factory, registered := lookupProcessor[E](sp.Name)
+		if !registered {
+			factory = sp.processorFactory
+		}
+
+		return sp.newProcessor(factory)
 
 	default:
-		return nil, ErrInvalidType
+		if t, ok := lookupProcessorType[E](sp.Type); ok {
+			return sp.newProcessor(t.factory)
+		}
+
+		return nil, fmt.Errorf("unknown type %q, known types are %s: %w", sp.Type, strings.Join(processorTypeNames[E](), ", "), ErrInvalidType)
+	}
+}
+
+// newProcessor builds a leaf with factory, which may fail in any way
+func (sp *SerializedPipeline[E]) newProcessor(factory ProcessorFactory[E]) (proc Processor[E], err error) {
+	if factory == nil {
+		return nil, ErrNoProcessorFactory
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			proc, err = nil, fmt.Errorf("processor factory panicked: %v: %w", r, ErrInvalidConfig)
+		}
+	}()
+
+	proc, err = factory(sp.Name, sp.Config)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", err, ErrInvalidType)
+	}
+
+	if isNil(proc) {
+		return nil, fmt.Errorf("no processor named %q: %w", sp.Name, ErrInvalidType)
+	}
+
+	return proc, nil
+}
+
+func bufferSize(cfg map[string]interface{}) (int, error) {
+	size, _, err := configInt(cfg, "buffer_size", 0)
+	return size, err
+}
+
+// bufferOverflow returns the overflow policy of the buffers of a composite,
+// and the directory they spill to
+func bufferOverflow(cfg map[string]interface{}) (BufferPolicy, string, error) {
+	name, _ := cfg["overflow"].(string)
+
+	policy, ok := bufferPolicy(name)
+	if !ok {
+		return BufferBlock, "", fmt.Errorf("invalid overflow %q: %w", name, ErrInvalidConfig)
 	}
+
+	dir, _ := cfg["spill_dir"].(string)
+
+	return policy, dir, nil
+}
+
+// configInt returns the integer cfg[key], which must not be less than min
+func configInt(cfg map[string]interface{}, key string, min int) (int, bool, error) {
+	value, ok := cfg[key].(float64)
+	if !ok {
+		return 0, false, nil
+	}
+
+	if value != math.Trunc(value) || value < float64(min) || value > math.MaxInt32 {
+		return 0, false, fmt.Errorf("invalid %s %v: %w", key, value, ErrInvalidConfig)
+	}
+
+	return int(value), true, nil
+}
+
+// configDuration returns the duration at key, written as "1.5s", zero when
+// it is not set
+func configDuration(cfg map[string]interface{}, key string) (time.Duration, error) {
+	value, ok := cfg[key].(string)
+	if !ok {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s %q: %w", key, value, ErrInvalidConfig)
+	}
+
+	return d, nil
 }
 
 func (sp *SerializedPipeline[E]) SetProcessorFactory(f ProcessorFactory[E]) {
 	sp.processorFactory = f
 }
 
+// SetLimits sets the limits Pipeline enforces on the definition, instead of
+// DefaultDefinitionLimits
+func (sp *SerializedPipeline[E]) SetLimits(limits DefinitionLimits) {
+	sp.limits = limits
+}
+
+/*
+	UnmarshalYAML reads the definition as its JSON equivalent, so the
+	configuration of the processors holds the same types, such as float64
+	numbers, whichever format it was written in.
+*/
+func (sp *SerializedPipeline[E]) UnmarshalYAML(node *yaml.Node) error {
+	data, err := yamlToJSON(node)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, sp)
+}
+
+func yamlToJSON(node *yaml.Node) ([]byte, error) {
+	var doc interface{}
+	if err := node.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("definition has no JSON equivalent: %w", err)
+	}
+
+	return data, nil
+}
+
+/*
+	UnmarshalPipeline reads a definition in the given format: JSON, YAML,
+	or a mermaid or dot graph imported as with Import. Unknown fields and
+	definitions missing their type, or the name of their leaves and
+	flagged processors, are refused.
+*/
+func UnmarshalPipeline[E Traceable](data []byte, format ExportFormat) (*SerializedPipeline[E], error) {
+	var sp *SerializedPipeline[E]
+	var err error
+
+	switch format {
+	case ExportJSON:
+		sp, err = unmarshalDefinition[E](data)
+
+	case ExportYAML:
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+
+		sp, err = unmarshalYAMLDefinition[E](&doc)
+
+	case ExportMermaid, ExportDOT:
+		sp, err = Import[E](bytes.NewReader(data), format)
+
+	default:
+		return nil, fmt.Errorf("%s: %w", format, ErrUnknownFormat)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sp.checkRequired(); err != nil {
+		return nil, err
+	}
+
+	return sp, nil
+}
+
+func unmarshalYAMLDefinition[E Traceable](node *yaml.Node) (*SerializedPipeline[E], error) {
+	data, err := yamlToJSON(node)
+	if err != nil {
+		return nil, err
+	}
+
+	return unmarshalDefinition[E](data)
+}
+
+// unmarshalDefinition reads a JSON definition without unknown fields
+func unmarshalDefinition[E Traceable](data []byte) (*SerializedPipeline[E], error) {
+	sp := &SerializedPipeline[E]{}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(sp); err != nil {
+		return nil, err
+	}
+
+	return sp, nil
+}
+
+/*
+	LoadPipelineFromFile reads the definition at path, in the format given
+	by its extension as for ExportFile: .json, .yaml or .yml, .mmd or
+	.mermaid, and .dot or .gv.
+*/
+func LoadPipelineFromFile[E Traceable](path string) (*SerializedPipeline[E], error) {
+	format, err := FormatForPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sp, err := UnmarshalPipeline[E](data, format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return sp, nil
+}
+
+// checkRequired returns a DefinitionError for the first definition missing
+// a field Pipeline needs
+func (sp *SerializedPipeline[E]) checkRequired() error {
+	if err := sp.checkFields(); err != nil {
+		return sp.definitionError(err)
+	}
+
+	for i := range sp.Processors {
+		if err := sp.Processors[i].checkRequired(); err != nil {
+			return sp.definitionError(err)
+		}
+	}
+
+	return nil
+}
+
+// checkFields checks the required fields of sp alone
+func (sp *SerializedPipeline[E]) checkFields() error {
+	if sp.Type == "" {
+		return fmt.Errorf("type: %w", ErrMissingField)
+	}
+
+	if sp.Name == "" && (sp.Type == "processor" || sp.Type == "flagged") {
+		return fmt.Errorf("name: %w", ErrMissingField)
+	}
+
+	return nil
+}
+
 func (item *Sequential[E]) MarshalJSON() ([]byte, error) {
-	return marshalPipelineComponent(item.ChainName, "sequential", item.Processors)
+	return marshalPipelineComponent(item.ChainName, item.Display, "sequential", item.Processors, item.config(), item.Tags)
 }
 
 func (item *Fanout[E]) MarshalJSON() ([]byte, error) {
-	return marshalPipelineComponent(item.ChainName, "fanout", item.Processors)
+	return marshalPipelineComponent(item.ChainName, item.Display, "fanout", item.Processors, item.config(), item.Tags)
 }
 
 func (item *Parallel[E]) MarshalJSON() ([]byte, error) {
-	return marshalPipelineComponent(item.ChainName, "parallel", item.Processors)
+	return marshalPipelineComponent(item.ChainName, item.Display, "parallel", item.Processors, item.config(), item.Tags)
 }
 
-func marshalPipelineComponent[E Traceable](name, typename string, processors []Processor[E]) ([]byte, error) {
-	writer := bytes.NewBufferString("")
+func (item *Shadow[E]) MarshalJSON() ([]byte, error) {
+	return marshalPipelineComponent(item.ChainName, item.Display, "shadow", []Processor[E]{item.Primary, item.Candidate}, item.config(), item.Tags)
+}
 
-	writer.WriteString("{")
+func (item *BlueGreen[E]) MarshalJSON() ([]byte, error) {
+	return marshalPipelineComponent(item.ChainName, item.Display, "bluegreen", []Processor[E]{item.Blue, item.Green}, item.config(), item.Tags)
+}
 
-	writer.WriteString(fmt.Sprintf(`"name": "%s",`, name))
-	writer.WriteString(fmt.Sprintf(`"type": "%s",`, typename))
+func (item *Flagged[E]) MarshalJSON() ([]byte, error) {
+	return marshalPipelineComponent(item.Flag, "", "flagged", []Processor[E]{item.Processor}, item.config(), item.Tags)
+}
 
-	writer.WriteString(`"processors": [`)
+// replicas are alike, the first one stands for all of them
+func (item *Pool[E]) MarshalJSON() ([]byte, error) {
+	replicas := item.Children()
+	if len(replicas) > 1 {
+		replicas = replicas[:1]
+	}
 
-	for pos, processor := range processors {
-		var enc []byte
-		var err error
+	return marshalPipelineComponent(item.ChainName, item.Display, "pool", replicas, item.config(), item.Tags)
+}
 
-		switch processor.(type) {
-		case *Parallel[E]:
-			enc, err = processor.(*Parallel[E]).MarshalJSON()
-		case *Sequential[E]:
-			enc, err = processor.(*Sequential[E]).MarshalJSON()
-		case *Fanout[E]:
-			enc, err = processor.(*Fanout[E]).MarshalJSON()
-		default:
-			procBuf := bytes.NewBuffer(nil)
-			procBuf.WriteString("{")
+func (item *TenantPool[E]) MarshalJSON() ([]byte, error) {
+	return marshalPipelineComponent(item.ChainName, item.Display, "tenants", nonNil([]Processor[E]{item.Template}), item.config(), item.Tags)
+}
 
-			procBuf.WriteString(fmt.Sprintf(`"name": "%s", "type": "processor", "cfg": `, processor.Name()))
+func (item *Router[E]) MarshalJSON() ([]byte, error) {
+	return marshalPipelineComponent(item.ChainName, item.Display, "router", item.Children(), item.config(), item.Tags)
+}
 
-			cfg, err := json.Marshal(processor)
-			if err != nil {
-				return nil, err
-			}
+func (item *Retry[E]) MarshalJSON() ([]byte, error) {
+	return marshalPipelineComponent(item.ChainName, item.Display, "retry", item.Children(), item.config(), item.Tags)
+}
 
-			if len(cfg) > 0 {
-				procBuf.Write(cfg)
-			} else {
-				procBuf.WriteString("null")
-			}
+func (item *Deadline[E]) MarshalJSON() ([]byte, error) {
+	return marshalPipelineComponent(item.ChainName, item.Display, "deadline", item.Children(), item.config(), item.Tags)
+}
+
+// config returns the serialized cfg of composites, nil when they have none
+func (item *Sequential[E]) config() map[string]interface{} {
+	return bufferConfig(nil, item.BufferSize, item.Overflow, item.SpillDir)
+}
+
+func (item *Fanout[E]) config() map[string]interface{} {
+	cfg := map[string]interface{}{}
+
+	if item.CloseTimeout > 0 {
+		cfg["close_timeout"] = item.CloseTimeout.String()
+	}
+
+	if len(item.NonCritical) > 0 {
+		cfg["non_critical"] = item.NonCritical
+	}
+
+	return bufferConfig(cfg, item.BufferSize, item.Overflow, item.SpillDir)
+}
+
+func (item *Parallel[E]) config() map[string]interface{} {
+	return bufferConfig(nil, item.BufferSize, item.Overflow, item.SpillDir)
+}
+
+// bufferConfig adds the buffer settings of a composite to cfg, and returns
+// nil when it is left empty
+func bufferConfig(cfg map[string]interface{}, size int, overflow BufferPolicy, spillDir string) map[string]interface{} {
+	if cfg == nil {
+		cfg = map[string]interface{}{}
+	}
+
+	if size > 0 {
+		cfg["buffer_size"] = size
+	}
+
+	if overflow != BufferBlock {
+		cfg["overflow"] = overflow.String()
+	}
+
+	if spillDir != "" {
+		cfg["spill_dir"] = spillDir
+	}
+
+	if len(cfg) == 0 {
+		return nil
+	}
+
+	return cfg
+}
+
+func (item *Shadow[E]) config() map[string]interface{} {
+	return map[string]interface{}{
+		"sample_rate": item.SampleRate,
+	}
+}
 
-			procBuf.WriteString("}")
-			enc = procBuf.Bytes()
+func (item *BlueGreen[E]) config() map[string]interface{} {
+	return map[string]interface{}{
+		"split": item.Split(),
+	}
+}
+
+func (item *Flagged[E]) config() map[string]interface{} {
+	cfg := map[string]interface{}{
+		"default": item.Default,
+	}
+
+	if item.Interval > 0 {
+		cfg["interval"] = item.Interval.String()
+	}
+
+	return cfg
+}
+
+func (item *Pool[E]) config() map[string]interface{} {
+	return map[string]interface{}{
+		"replicas": item.Replicas,
+	}
+}
+
+func (item *TenantPool[E]) config() map[string]interface{} {
+	cfg := map[string]interface{}{
+		"tenant_field": item.TenantField,
+	}
+
+	if item.Tag != "" {
+		cfg["tag"] = item.Tag
+	}
+
+	if item.MaxTenants > 0 {
+		cfg["max_tenants"] = item.MaxTenants
+	}
+
+	if item.BufferSize > 0 {
+		cfg["buffer_size"] = item.BufferSize
+	}
+
+	return cfg
+}
+
+// routes without a processor never match, so they are left out
+func (item *Router[E]) config() map[string]interface{} {
+	routes := []string{}
+
+	for _, route := range item.Routes {
+		if route.Processor != nil {
+			routes = append(routes, route.Name)
 		}
+	}
+
+	return map[string]interface{}{
+		"routes":  routes,
+		"all":     item.All,
+		"default": item.Default != nil,
+	}
+}
 
+func (item *Retry[E]) config() map[string]interface{} {
+	cfg := map[string]interface{}{
+		"max_attempts": item.Policy.attempts(),
+	}
+
+	if item.Policy.Backoff > 0 {
+		cfg["backoff"] = item.Policy.Backoff.String()
+	}
+
+	if item.Policy.MaxBackoff > 0 {
+		cfg["max_backoff"] = item.Policy.MaxBackoff.String()
+	}
+
+	if item.Policy.Multiplier > 0 {
+		cfg["multiplier"] = item.Policy.Multiplier
+	}
+
+	if item.Policy.Jitter > 0 {
+		cfg["jitter"] = item.Policy.Jitter
+	}
+
+	return cfg
+}
+
+func (item *Deadline[E]) config() map[string]interface{} {
+	cfg := make(map[string]interface{})
+
+	if item.Share > 0 && item.Share < 1 {
+		cfg["share"] = item.Share
+	}
+
+	if item.MinBudget > 0 {
+		cfg["min_budget"] = item.MinBudget.String()
+	}
+
+	if item.Optional {
+		cfg["optional"] = true
+	}
+
+	if len(cfg) == 0 {
+		return nil
+	}
+
+	return cfg
+}
+
+/*
+	serializedComponent is the serialized form of a processor, as read back
+	into a SerializedPipeline. Its children and configuration are marshaled
+	beforehand, so custom processors marshal themselves as they please.
+*/
+type serializedComponent struct {
+	Name       string            `json:"name"`
+	Type       string            `json:"type"`
+	Display    string            `json:"display,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	Config     json.RawMessage   `json:"cfg,omitempty"`
+	Processors []json.RawMessage `json:"processors,omitempty"`
+}
+
+func marshalPipelineComponent[E Traceable](name, display, typename string, processors []Processor[E], cfg map[string]interface{}, tags map[string]string) ([]byte, error) {
+	component := serializedComponent{
+		Name:    name,
+		Type:    typename,
+		Display: display,
+		Tags:    tags,
+	}
+
+	if cfg != nil {
+		enc, err := json.Marshal(cfg)
 		if err != nil {
 			return nil, err
 		}
 
-		writer.Write(enc)
+		component.Config = enc
+	}
 
-		if pos < len(processors)-1 {
-			writer.WriteString(",")
+	for _, processor := range processors {
+		enc, err := marshalProcessor(processor)
+		if err != nil {
+			return nil, err
 		}
+
+		component.Processors = append(component.Processors, enc)
+	}
+
+	return json.Marshal(component)
+}
+
+/*
+	MarshalPipeline serializes the definition of the tree of root, as Pipeline
+	builds it back, whether root is a composite or a leaf.
+*/
+func MarshalPipeline[E Traceable](root Processor[E]) ([]byte, error) {
+	return marshalProcessor(root)
+}
+
+func marshalProcessor[E Traceable](processor Processor[E]) ([]byte, error) {
+	if isNil(processor) {
+		return nil, ErrNilProcessor
 	}
 
-	writer.WriteString("]")
+	switch processor := processor.(type) {
+	case *Parallel[E]:
+		return processor.MarshalJSON()
+	case *Sequential[E]:
+		return processor.MarshalJSON()
+	case *Fanout[E]:
+		return processor.MarshalJSON()
+	case *Shadow[E]:
+		return processor.MarshalJSON()
+	case *BlueGreen[E]:
+		return processor.MarshalJSON()
+	case *Flagged[E]:
+		return processor.MarshalJSON()
+	case *Router[E]:
+		return processor.MarshalJSON()
+	case *Pool[E]:
+		return processor.MarshalJSON()
+	case *TenantPool[E]:
+		return processor.MarshalJSON()
+	case *Retry[E]:
+		return processor.MarshalJSON()
+	case *Deadline[E]:
+		return processor.MarshalJSON()
+	case *PipelineManager[E]:
+		return marshalProcessor(processor.Current())
+	}
 
-	writer.WriteString("}")
+	return marshalLeaf(processor)
+}
+
+/*
+	marshalLeaf serializes a processor which is not a composite, with the
+	type and marshaller registered for its Go type when there are some.
+	Otherwise it is named after Name, and configured with its own JSON
+	encoding, which processors implementing json.Marshaler choose.
+*/
+func marshalLeaf[E Traceable](processor Processor[E]) ([]byte, error) {
+	component := serializedComponent{
+		Name: processor.Name(),
+		Type: "processor",
+		Tags: processorTags(processor),
+	}
+
+	var cfg interface{} = processor
+
+	if t, ok := lookupProcessorMarshaller[E](processor); ok {
+		name, config, err := t.marshal(processor)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", processor.Name(), err)
+		}
+
+		component.Name, component.Type, cfg = name, t.name, config
+	}
+
+	enc, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	component.Config = enc
+
+	return json.Marshal(component)
+}
+
+// isNil tells whether p is nil, or a nil pointer in an interface
+func isNil(p interface{}) bool {
+	if p == nil {
+		return true
+	}
 
-	return writer.Bytes(), nil
+	v := reflect.ValueOf(p)
+	return v.Kind() == reflect.Pointer && v.IsNil()
 }
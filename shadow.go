@@ -0,0 +1,162 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+)
+
+/*
+	The Shadow processor has:
+
+	- One input
+	- A primary processor
+	- A candidate processor
+	- One output
+
+	Every item is forwarded to the primary processor, whose output is sent to
+	the Shadow output.
+
+	A sampled copy of the input is also sent to the candidate. Its output is
+	never sent downstream: it is discarded, or handed to Compare when set. The
+	candidate is tracked under its own stats entry, so a new version of a stage
+	can be evaluated on live traffic before switching it into the main path.
+
+	The candidate never slows down the primary path: when it can not keep up,
+	copies are dropped and counted as failures on the candidate stats.
+*/
+type Shadow[E Traceable] struct {
+	ChainName string
+	Display   string
+	Tags      map[string]string
+
+	Primary   Processor[E]
+	Candidate Processor[E]
+
+	// Fraction of the input copied to the candidate, from 0 to 1
+	SampleRate float64
+
+	// Copy builds the item sent to the candidate. When nil the same item is
+	// shared by both paths, which is only safe if processors do not modify it.
+	Copy func(E) E
+
+	// Compare receives every item produced by the candidate
+	Compare func(E)
+
+	executions executions
+}
+
+func (shadow *Shadow[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	shadow.executions.begin()
+	defer shadow.executions.end()
+
+	Log[E](ctx, shadow, "starting")
+	TrackStarted[E](ctx, shadow)
+	ctx = withErrorScope[E](ctx, shadow)
+
+	if shadow.Primary == nil {
+		close(output)
+		return
+	}
+
+	wg := sync.WaitGroup{}
+
+	primaryInput := make(chan E)
+	primaryOutput := make(chan E)
+
+	wg.Add(1)
+	go func() {
+		runProcessor[E](ctx, shadow.Primary, primaryInput, primaryOutput)
+		wg.Done()
+	}()
+
+	wg.Add(1)
+	go func() {
+		for m := range primaryOutput {
+			if ctx.Err() != nil {
+				abandon(ctx, m)
+				continue
+			}
+
+			TrackOutput[E](ctx, shadow, m)
+			send(ctx, output, m)
+		}
+		wg.Done()
+	}()
+
+	var candidateInput *stageBuffer[E]
+
+	if shadow.Candidate != nil {
+		candidateInput = newStageBuffer[E](ctx, shadow, 200)
+		candidateOutput := make(chan E)
+
+		wg.Add(1)
+		go func() {
+			runProcessor[E](ctx, shadow.Candidate, candidateInput.output, candidateOutput)
+			wg.Done()
+		}()
+
+		wg.Add(1)
+		go func() {
+			for m := range candidateOutput {
+				TrackOutput[E](ctx, shadow.Candidate, m)
+
+				if shadow.Compare != nil {
+					shadow.Compare(m)
+				}
+			}
+			wg.Done()
+		}()
+	}
+
+	for {
+		msg, ok := receive(ctx, input)
+		if !ok {
+			break
+		}
+
+		TrackItemInput[E](ctx, shadow, msg)
+
+		if candidateInput != nil && shadow.sampled() {
+			shadowMsg := msg
+			if shadow.Copy != nil {
+				shadowMsg = shadow.Copy(msg)
+			}
+
+			if candidateInput.trySend(shadowMsg) {
+				TrackItemInput[E](ctx, shadow.Candidate, shadowMsg)
+			} else {
+				TrackFailure[E](ctx, shadow.Candidate)
+			}
+		}
+
+		if !send(ctx, primaryInput, msg) {
+			break
+		}
+	}
+
+	inputClosed[E](ctx, shadow)
+	close(primaryInput)
+
+	if candidateInput != nil {
+		candidateInput.close()
+	}
+
+	wg.Wait()
+
+	TrackFinished[E](ctx, shadow)
+	close(output)
+}
+
+func (shadow *Shadow[E]) Name() string {
+	return fmt.Sprintf("Shadow/%s", shadow.ChainName)
+}
+
+func (shadow *Shadow[E]) sampled() bool {
+	if shadow.SampleRate >= 1 {
+		return true
+	}
+
+	return rand.Float64() < shadow.SampleRate
+}
@@ -0,0 +1,63 @@
+package pipeline
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestShadowDiscardsCandidateOutput(t *testing.T) {
+	lock := sync.Mutex{}
+	compared := []string{}
+
+	shadow := &Shadow[*testItem]{
+		ChainName: "canary",
+		Primary:   upper("primary"),
+		Candidate: NewMap("candidate", func(item *testItem) *testItem {
+			item.Value += "!"
+			return item
+		}),
+		SampleRate: 1,
+		Copy: func(item *testItem) *testItem {
+			return &testItem{Value: item.Value}
+		},
+		Compare: func(item *testItem) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			compared = append(compared, item.Value)
+		},
+	}
+
+	got := itemValues(runItems(t, context.Background(), shadow, newItems("a", "b")))
+	if !slices.Equal(got, []string{"A", "B"}) {
+		t.Fatalf("got %v, want the primary output A B", got)
+	}
+
+	// the output is closed once the candidate is done too
+	lock.Lock()
+	defer lock.Unlock()
+
+	sort.Strings(compared)
+	if !slices.Equal(compared, []string{"a!", "b!"}) {
+		t.Fatalf("compared %v, want the candidate output a! b!", compared)
+	}
+}
+
+func TestShadowSamplesNothingAtZeroRate(t *testing.T) {
+	shadow := &Shadow[*testItem]{
+		ChainName: "canary",
+		Primary:   upper("primary"),
+		Candidate: NewMap("candidate", func(item *testItem) *testItem {
+			t.Error("candidate ran with a zero sample rate")
+			return item
+		}),
+	}
+
+	got := itemValues(runItems(t, context.Background(), shadow, newItems("a")))
+	if !slices.Equal(got, []string{"A"}) {
+		t.Fatalf("got %v, want A", got)
+	}
+}
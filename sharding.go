@@ -0,0 +1,176 @@
+package pipeline
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+const EventShardsRebalanced = "shards_rebalanced"
+
+/*
+	Membership tracks the process instances running the same pipeline
+	definition. Members returns the current members, and a channel closed when
+	membership changes.
+*/
+type Membership interface {
+	Members(ctx context.Context) ([]string, <-chan struct{}, error)
+}
+
+/*
+	The ShardCoordinator spreads the shards of a source (Kafka partitions, file
+	ranges...) across every instance of a deployment.
+
+	Each instance runs a coordinator with its own Self id. Shards are assigned
+	with rendezvous hashing, so when membership changes only the shards of the
+	instances joining or leaving move.
+
+	Run calls Consume with the shards owned by this instance. On rebalance, the
+	context given to Consume is cancelled and, once it has returned, Consume is
+	called again with the new assignment.
+*/
+type ShardCoordinator struct {
+	Self       string
+	Shards     []string
+	Membership Membership
+
+	Consume func(ctx context.Context, shards []string)
+}
+
+func (c *ShardCoordinator) Run(ctx context.Context) error {
+	var stop context.CancelFunc
+	var consuming sync.WaitGroup
+
+	rebalance := func(shards []string) {
+		if stop != nil {
+			stop()
+			consuming.Wait()
+		}
+
+		var consumeCtx context.Context
+		consumeCtx, stop = context.WithCancel(ctx)
+
+		consuming.Add(1)
+		go func() {
+			c.Consume(consumeCtx, shards)
+			consuming.Done()
+		}()
+	}
+
+	defer func() {
+		if stop != nil {
+			stop()
+			consuming.Wait()
+		}
+	}()
+
+	for {
+		members, changed, err := c.Membership.Members(ctx)
+		if err != nil {
+			return err
+		}
+
+		shards := AssignShards(members, c.Shards)[c.Self]
+		Emit[Traceable](ctx, nil, EventShardsRebalanced, "%s owns %d of %d shards across %d members", c.Self, len(shards), len(c.Shards), len(members))
+
+		rebalance(shards)
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// AssignShards maps every shard to its owner using rendezvous hashing
+func AssignShards(members []string, shards []string) map[string][]string {
+	assignment := make(map[string][]string)
+
+	if len(members) == 0 {
+		return assignment
+	}
+
+	for _, shard := range shards {
+		var owner string
+		var best uint64
+
+		for _, member := range members {
+			h := fnv.New64a()
+			h.Write([]byte(member))
+			h.Write([]byte{0})
+			h.Write([]byte(shard))
+
+			score := h.Sum64()
+			if owner == "" || score > best || (score == best && member < owner) {
+				owner = member
+				best = score
+			}
+		}
+
+		assignment[owner] = append(assignment[owner], shard)
+	}
+
+	return assignment
+}
+
+/*
+	LocalMembership is a Membership managed in process by calling Join and
+	Leave. It is useful for tests or when membership is driven by an external
+	system notifying changes.
+*/
+type LocalMembership struct {
+	lock    sync.Mutex
+	members map[string]struct{}
+	changed chan struct{}
+}
+
+func NewLocalMembership(members ...string) *LocalMembership {
+	m := &LocalMembership{
+		members: make(map[string]struct{}),
+		changed: make(chan struct{}),
+	}
+
+	for _, member := range members {
+		m.members[member] = struct{}{}
+	}
+
+	return m
+}
+
+func (m *LocalMembership) Members(ctx context.Context) ([]string, <-chan struct{}, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	members := make([]string, 0, len(m.members))
+	for member := range m.members {
+		members = append(members, member)
+	}
+
+	sort.Strings(members)
+
+	return members, m.changed, nil
+}
+
+func (m *LocalMembership) Join(member string) {
+	m.update(func() {
+		m.members[member] = struct{}{}
+	})
+}
+
+func (m *LocalMembership) Leave(member string) {
+	m.update(func() {
+		delete(m.members, member)
+	})
+}
+
+func (m *LocalMembership) update(fn func()) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	fn()
+
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
@@ -0,0 +1,452 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+var ErrSimulated = fmt.Errorf("simulated failure")
+
+/*
+	A StageModel describes how a simulated stage behaves: how long it takes
+	for every item, with a random jitter of up to Jitter either way, how often
+	it fails, and how many items it works on at once.
+
+	Items wait in a queue of QueueSize in front of the workers, so the effect
+	of buffer sizes on backpressure can be observed before any processor is
+	written.
+*/
+type StageModel struct {
+	Latency   time.Duration `json:"latency"`
+	Jitter    time.Duration `json:"jitter"`
+	ErrorRate float64       `json:"error_rate"`
+	Workers   int           `json:"workers"`
+	QueueSize int           `json:"queue_size"`
+}
+
+func (m StageModel) delay() time.Duration {
+	d := m.Latency
+
+	if m.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(2*m.Jitter))) - m.Jitter
+	}
+
+	if d < 0 {
+		return 0
+	}
+
+	return d
+}
+
+func (m StageModel) workers() int {
+	if m.Workers <= 0 {
+		return 1
+	}
+
+	return m.Workers
+}
+
+/*
+	A SimulatedStage stands for a leaf processor in a simulation. Items are
+	held for the delay of the model and then sent on, or failed and dropped.
+*/
+type SimulatedStage[E Traceable] struct {
+	ChainName string
+	Model     StageModel
+
+	busy     atomic.Duration
+	blocked  atomic.Duration
+	maxQueue atomic.Int64
+
+	// stats are tracked by one worker at a time
+	trackLock sync.Mutex
+}
+
+func (s *SimulatedStage[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	queue := make(chan E, s.Model.QueueSize)
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < s.Model.workers(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for m := range queue {
+				d := s.Model.delay()
+				time.Sleep(d)
+				s.busy.Add(d)
+
+				failed := s.Model.ErrorRate > 0 && rand.Float64() < s.Model.ErrorRate
+
+				s.trackLock.Lock()
+				if failed {
+					TrackFailure[E](ctx, s)
+					ReportError(ctx, s, ErrSimulated)
+				} else {
+					TrackOutput[E](ctx, s, m)
+				}
+				s.trackLock.Unlock()
+
+				if failed {
+					continue
+				}
+
+				start := time.Now()
+				output <- m
+				s.blocked.Add(time.Since(start))
+			}
+		}()
+	}
+
+	for m := range input {
+		s.trackLock.Lock()
+		TrackItemInput[E](ctx, s, m)
+		s.trackLock.Unlock()
+
+		queue <- m
+
+		if depth := int64(len(queue)); depth > s.maxQueue.Load() {
+			s.maxQueue.Store(depth)
+		}
+	}
+
+	close(queue)
+	wg.Wait()
+
+	close(output)
+}
+
+// ProcessItem holds the item for the delay of the model, without the queue
+// and workers, so simulated stages can be wrapped in a Retry
+func (s *SimulatedStage[E]) ProcessItem(ctx context.Context, item E) (E, error) {
+	d := s.Model.delay()
+	time.Sleep(d)
+	s.busy.Add(d)
+
+	if s.Model.ErrorRate > 0 && rand.Float64() < s.Model.ErrorRate {
+		return item, ErrSimulated
+	}
+
+	return item, nil
+}
+
+func (s *SimulatedStage[E]) Name() string {
+	return s.ChainName
+}
+
+/*
+	A Simulation runs a topology with every leaf replaced by a SimulatedStage.
+	Models are looked up in Stages by the Walk path of the leaf, then by its
+	name, and Default is used for leaves without one.
+*/
+type Simulation[E Traceable] struct {
+	Default StageModel
+	Stages  map[string]StageModel
+}
+
+type SimulationReport struct {
+	Duration      time.Duration          `json:"duration"`
+	Input         int                    `json:"input"`
+	Output        int                    `json:"output"`
+	Throughput    float64                `json:"throughput"`
+	SourceBlocked time.Duration          `json:"source_blocked"`
+	Stages        []SimulatedStageReport `json:"stages"`
+}
+
+type SimulatedStageReport struct {
+	Path   string `json:"path"`
+	Name   string `json:"name"`
+	Input  int64  `json:"input"`
+	Output int64  `json:"output"`
+	Failed int64  `json:"failed"`
+
+	// Time spent by the workers on items, and waiting to hand them over to
+	// the next stage
+	Busy    time.Duration `json:"busy"`
+	Blocked time.Duration `json:"blocked"`
+
+	Utilization float64 `json:"utilization"`
+	MaxQueue    int64   `json:"max_queue"`
+}
+
+func (s *Simulation[E]) model(path, name string) StageModel {
+	if m, ok := s.Stages[path]; ok {
+		return m
+	}
+
+	if m, ok := s.Stages[name]; ok {
+		return m
+	}
+
+	return s.Default
+}
+
+// Topology returns a copy of root where leaves are replaced by simulated stages
+// with the same names
+func (s *Simulation[E]) Topology(root Processor[E]) Processor[E] {
+	paths := make(map[Processor[E]]string)
+
+	Walk(root, func(path string, p Processor[E]) {
+		paths[p] = path
+	})
+
+	return s.simulate(root, paths)
+}
+
+func (s *Simulation[E]) simulate(node Processor[E], paths map[Processor[E]]string) Processor[E] {
+	if node == nil {
+		return nil
+	}
+
+	children := func(processors []Processor[E]) []Processor[E] {
+		result := make([]Processor[E], len(processors))
+		for i, p := range processors {
+			result[i] = s.simulate(p, paths)
+		}
+
+		return result
+	}
+
+	switch node.(type) {
+	case *Fanout[E]:
+		fanout := node.(*Fanout[E])
+
+		return &Fanout[E]{
+			ChainName:    fanout.ChainName,
+			Display:      fanout.Display,
+			Tags:         fanout.Tags,
+			Processors:   children(fanout.Processors),
+			BufferSize:   fanout.BufferSize,
+			Overflow:     fanout.Overflow,
+			SpillDir:     fanout.SpillDir,
+			CloseTimeout: fanout.CloseTimeout,
+			NonCritical:  fanout.NonCritical,
+		}
+
+	case *Sequential[E]:
+		seq := node.(*Sequential[E])
+
+		return &Sequential[E]{
+			ChainName:  seq.ChainName,
+			Display:    seq.Display,
+			Tags:       seq.Tags,
+			BufferSize: seq.BufferSize,
+			Overflow:   seq.Overflow,
+			SpillDir:   seq.SpillDir,
+			Processors: children(seq.Processors),
+		}
+
+	case *Parallel[E]:
+		parallel := node.(*Parallel[E])
+
+		return &Parallel[E]{
+			ChainName:  parallel.ChainName,
+			Display:    parallel.Display,
+			Tags:       parallel.Tags,
+			BufferSize: parallel.BufferSize,
+			Overflow:   parallel.Overflow,
+			SpillDir:   parallel.SpillDir,
+			Processors: children(parallel.Processors),
+		}
+
+	case *Shadow[E]:
+		shadow := node.(*Shadow[E])
+
+		return &Shadow[E]{
+			ChainName:  shadow.ChainName,
+			Display:    shadow.Display,
+			Tags:       shadow.Tags,
+			Primary:    s.simulate(shadow.Primary, paths),
+			Candidate:  s.simulate(shadow.Candidate, paths),
+			SampleRate: shadow.SampleRate,
+			Copy:       shadow.Copy,
+			Compare:    shadow.Compare,
+		}
+
+	case *BlueGreen[E]:
+		bg := node.(*BlueGreen[E])
+
+		simulated := &BlueGreen[E]{
+			ChainName: bg.ChainName,
+			Display:   bg.Display,
+			Tags:      bg.Tags,
+			Blue:      s.simulate(bg.Blue, paths),
+			Green:     s.simulate(bg.Green, paths),
+		}
+		simulated.SetSplit(bg.Split())
+
+		return simulated
+
+	case *Flagged[E]:
+		flagged := node.(*Flagged[E])
+
+		return &Flagged[E]{
+			Flag:      flagged.Flag,
+			Tags:      flagged.Tags,
+			Processor: s.simulate(flagged.Processor, paths),
+			Interval:  flagged.Interval,
+			Default:   flagged.Default,
+		}
+
+	case *Pool[E]:
+		pool := node.(*Pool[E])
+		replicas := children(pool.Children())
+
+		return &Pool[E]{
+			ChainName: pool.ChainName,
+			Display:   pool.Display,
+			Tags:      pool.Tags,
+			Replicas:  len(replicas),
+			New: func(name string) Processor[E] {
+				replica := replicas[0]
+				replicas = replicas[1:]
+
+				return replica
+			},
+		}
+
+	case *Router[E]:
+		router := node.(*Router[E])
+
+		simulated := &Router[E]{
+			ChainName: router.ChainName,
+			Display:   router.Display,
+			Tags:      router.Tags,
+			Default:   s.simulate(router.Default, paths),
+			All:       router.All,
+		}
+
+		for _, route := range router.Routes {
+			simulated.Routes = append(simulated.Routes, Route[E]{
+				Name:      route.Name,
+				Match:     route.Match,
+				Processor: s.simulate(route.Processor, paths),
+			})
+		}
+
+		return simulated
+
+	case *Retry[E]:
+		retry := node.(*Retry[E])
+
+		return &Retry[E]{
+			ChainName:  retry.ChainName,
+			Display:    retry.Display,
+			Tags:       retry.Tags,
+			Processor:  s.simulate(retry.Processor, paths),
+			Policy:     retry.Policy,
+			DeadLetter: retry.DeadLetter,
+		}
+
+	case *Deadline[E]:
+		deadline := node.(*Deadline[E])
+
+		return &Deadline[E]{
+			ChainName: deadline.ChainName,
+			Display:   deadline.Display,
+			Tags:      deadline.Tags,
+			Processor: s.simulate(deadline.Processor, paths),
+			Share:     deadline.Share,
+			MinBudget: deadline.MinBudget,
+			Optional:  deadline.Optional,
+		}
+
+	default:
+		return &SimulatedStage[E]{
+			ChainName: node.Name(),
+			Model:     s.model(paths[node], node.Name()),
+		}
+	}
+}
+
+/*
+	Run simulates root processing count items built by newItem, sent at rate
+	items per second, or as fast as the pipeline takes them when rate is 0.
+	The stats of the run are kept in the StatDB of ctx when there is one.
+*/
+func (s *Simulation[E]) Run(ctx context.Context, root Processor[E], count int, rate float64, newItem func(i int) E) *SimulationReport {
+	statDB, ok := ctx.Value(PipelineStatDB).(*StatDB[E])
+	if !ok {
+		statDB = NewStatDB[E]()
+		ctx = WithStats(ctx, statDB)
+	}
+
+	topology := s.Topology(root)
+	report := &SimulationReport{Input: count}
+
+	input := make(chan E)
+	output := make(chan E)
+
+	fed := make(chan time.Duration)
+	start := time.Now()
+
+	go topology.Execute(ctx, input, output)
+
+	go func() {
+		var blocked time.Duration
+
+		var interval time.Duration
+		if rate > 0 {
+			interval = time.Duration(float64(time.Second) / rate)
+		}
+
+		for i := 0; i < count; i++ {
+			if interval > 0 {
+				time.Sleep(time.Until(start.Add(time.Duration(i) * interval)))
+			}
+
+			sent := time.Now()
+			input <- newItem(i)
+			blocked += time.Since(sent)
+		}
+
+		close(input)
+		fed <- blocked
+	}()
+
+	for range output {
+		report.Output++
+	}
+
+	report.SourceBlocked = <-fed
+
+	report.Duration = time.Since(start)
+
+	if report.Duration > 0 {
+		report.Throughput = float64(report.Output) / report.Duration.Seconds()
+	}
+
+	Walk(topology, func(path string, p Processor[E]) {
+		stage, ok := p.(*SimulatedStage[E])
+		if !ok {
+			return
+		}
+
+		entry := SimulatedStageReport{
+			Path:     path,
+			Name:     stage.Name(),
+			Busy:     stage.busy.Load(),
+			Blocked:  stage.blocked.Load(),
+			MaxQueue: stage.maxQueue.Load(),
+		}
+
+		if stats, ok := statDB.statsOf(p); ok {
+			entry.Input = stats.Input.Load()
+			entry.Output = stats.Output.Load()
+			entry.Failed = stats.Failed.Load()
+		}
+
+		if report.Duration > 0 {
+			entry.Utilization = float64(entry.Busy) / float64(time.Duration(stage.Model.workers())*report.Duration)
+		}
+
+		report.Stages = append(report.Stages, entry)
+	})
+
+	return report
+}
@@ -0,0 +1,74 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+/*
+	executeSink implements the Execute of sinks writing items in batches to an
+	external system. Items are consumed: none is sent to the output, which is
+	closed once the input is. When write fails, every item of the batch is
+	counted as a failure and sent to the dead letter handlers, and the error
+	is reported. Items written are acked.
+
+	Batches are sized by controller when set, which observes every write, and
+	by size and linger otherwise.
+*/
+func executeSink[E Traceable](ctx context.Context, p Processor[E], input chan E, output chan E, size int, linger time.Duration, controller BatchController, write func(ctx context.Context, items []E) error) {
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+
+	if linger <= 0 {
+		linger = DefaultBatchLinger
+	}
+
+	limits := func() (int, time.Duration) {
+		if controller != nil {
+			return controller.BatchSize(), controller.BatchLinger()
+		}
+
+		return size, linger
+	}
+
+	collectBatches(input, limits, func(items []E) {
+		for _, m := range items {
+			TrackItemInput[E](ctx, p, m)
+		}
+
+		start := time.Now()
+		err := write(ctx, items)
+
+		if controller != nil {
+			controller.Observe(len(items), time.Since(start), err)
+		}
+
+		if err != nil {
+			Log[E](ctx, p, "failed writing %d items: %s", len(items), err)
+
+			for _, m := range items {
+				TrackFailure[E](ctx, p)
+				SendToDLQ(ctx, p, m, err)
+			}
+
+			ReportError(ctx, p, fmt.Errorf("writing %d items: %w", len(items), err))
+			return
+		}
+
+		for _, m := range items {
+			Ack(m)
+		}
+	})
+
+	close(output)
+}
+
+/*
+	ExecuteSink implements the Execute of sinks from other packages, writing
+	items in batches as the sinks of this package do.
+*/
+func ExecuteSink[E Traceable](ctx context.Context, p Processor[E], input chan E, output chan E, size int, linger time.Duration, controller BatchController, write func(ctx context.Context, items []E) error) {
+	executeSink[E](ctx, p, input, output, size, linger, controller, write)
+}
@@ -0,0 +1,272 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+const (
+	EventSLOBurning   = "slo_burning"
+	EventSLORecovered = "slo_recovered"
+)
+
+const (
+	DefaultLatencyTarget = 0.99
+	DefaultBurnRate      = 2
+	DefaultBurnWindow    = 5 * time.Minute
+)
+
+/*
+	IngestTimer is implemented by items keeping when they entered the
+	pipeline. The Runner sets it on the items it feeds, so their end to end
+	latency can be measured once they come out.
+*/
+type IngestTimer interface {
+	IngestTime() time.Time
+	SetIngestTime(t time.Time)
+}
+
+/*
+	An SLO declares the objectives of a pipeline run by a Runner: at least
+	LatencyTarget of the items, 99% by default, come out of the pipeline
+	within MaxLatency of being fed, and at most MaxFailureRate of the items
+	fed fail in it. Objectives left at zero are not tracked.
+
+	The latency of an item is measured from its IngestTime, or from its event
+	time when it is not an IngestTimer, and items having neither are left out.
+
+	The error budget of an objective is the fraction of bad items it allows,
+	and is spent as bad items come. The burn rate is how fast it is spent
+	over the last BurnWindow, five minutes by default: a burn rate of 1 spends
+	exactly the budget. Once it exceeds BurnRate, 2 by default, the SLO is
+	burning and an event is emitted, and another one once it recovers.
+*/
+type SLO struct {
+	Name string
+
+	MaxLatency    time.Duration
+	LatencyTarget float64
+
+	MaxFailureRate float64
+
+	BurnRate   float64
+	BurnWindow time.Duration
+}
+
+type SLOStatus struct {
+	Name     string     `json:"name"`
+	Latency  *SLOBudget `json:"latency,omitempty"`
+	Failures *SLOBudget `json:"failures,omitempty"`
+}
+
+/*
+	An SLOBudget is the state of an objective: Bad of Total items missed it,
+	leaving Remaining of the error budget, which is negative once overspent.
+*/
+type SLOBudget struct {
+	Allowed   float64 `json:"allowed"`
+	Total     int64   `json:"total"`
+	Bad       int64   `json:"bad"`
+	Remaining float64 `json:"remaining"`
+	BurnRate  float64 `json:"burn_rate"`
+	Burning   bool    `json:"burning"`
+}
+
+type sloSample struct {
+	at       time.Time
+	input    int64
+	failed   int64
+	measured int64
+	slow     int64
+}
+
+type sloTracker struct {
+	slo SLO
+
+	measured atomic.Int64
+	slow     atomic.Int64
+
+	lock     sync.Mutex
+	samples  []sloSample
+	latency  *SLOBudget
+	failures *SLOBudget
+}
+
+func newSLOTracker(slo SLO) *sloTracker {
+	if slo.LatencyTarget <= 0 || slo.LatencyTarget >= 1 {
+		slo.LatencyTarget = DefaultLatencyTarget
+	}
+
+	if slo.BurnRate <= 0 {
+		slo.BurnRate = DefaultBurnRate
+	}
+
+	if slo.BurnWindow <= 0 {
+		slo.BurnWindow = DefaultBurnWindow
+	}
+
+	t := &sloTracker{slo: slo}
+
+	if slo.MaxLatency > 0 {
+		t.latency = &SLOBudget{Allowed: 1 - slo.LatencyTarget, Remaining: 1}
+	}
+
+	if slo.MaxFailureRate > 0 {
+		t.failures = &SLOBudget{Allowed: slo.MaxFailureRate, Remaining: 1}
+	}
+
+	return t
+}
+
+// observe measures the latency of an item coming out of the pipeline
+func (t *sloTracker) observe(ctx context.Context, item Traceable, now time.Time) {
+	if t.latency == nil {
+		return
+	}
+
+	var started time.Time
+	if timer, ok := item.(IngestTimer); ok {
+		started = timer.IngestTime()
+	}
+
+	if started.IsZero() {
+		started = EventTime(ctx, item)
+	}
+
+	if started.IsZero() {
+		return
+	}
+
+	t.measured.Inc()
+
+	if now.Sub(started) > t.slo.MaxLatency {
+		t.slow.Inc()
+	}
+}
+
+/*
+	sample updates the budgets with the current counters of the run, and
+	returns the objectives which started or stopped burning.
+*/
+func (t *sloTracker) sample(now time.Time, input, failed int64) (burning, recovered []string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	current := sloSample{at: now, input: input, failed: failed, measured: t.measured.Load(), slow: t.slow.Load()}
+
+	t.samples = append(t.samples, current)
+	for len(t.samples) > 1 && now.Sub(t.samples[0].at) > t.slo.BurnWindow {
+		t.samples = t.samples[1:]
+	}
+
+	first := t.samples[0]
+
+	update := func(name string, budget *SLOBudget, total, bad, windowTotal, windowBad int64) {
+		if budget == nil {
+			return
+		}
+
+		budget.Total, budget.Bad = total, bad
+
+		if total > 0 {
+			budget.Remaining = 1 - float64(bad)/float64(total)/budget.Allowed
+		}
+
+		budget.BurnRate = 0
+		if windowTotal > 0 {
+			budget.BurnRate = float64(windowBad) / float64(windowTotal) / budget.Allowed
+		}
+
+		was := budget.Burning
+		budget.Burning = budget.BurnRate > t.slo.BurnRate
+
+		switch {
+		case budget.Burning && !was:
+			burning = append(burning, name)
+		case !budget.Burning && was:
+			recovered = append(recovered, name)
+		}
+	}
+
+	update("latency", t.latency, current.measured, current.slow, current.measured-first.measured, current.slow-first.slow)
+	update("failures", t.failures, current.input, current.failed, current.input-first.input, current.failed-first.failed)
+
+	return burning, recovered
+}
+
+func (t *sloTracker) status() SLOStatus {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	status := SLOStatus{Name: t.slo.Name}
+
+	if t.latency != nil {
+		latency := *t.latency
+		status.Latency = &latency
+	}
+
+	if t.failures != nil {
+		failures := *t.failures
+		status.Failures = &failures
+	}
+
+	return status
+}
+
+// sloStatus returns the status of every SLO of the run
+func (r *Runner[E]) sloStatus() []SLOStatus {
+	var statuses []SLOStatus
+
+	for _, t := range r.slos {
+		statuses = append(statuses, t.status())
+	}
+
+	return statuses
+}
+
+func (r *Runner[E]) watchSLOs(ctx context.Context, slos []*sloTracker) {
+	ticker := time.NewTicker(failureCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		r.lock.Lock()
+		failed := r.failures()
+		r.lock.Unlock()
+
+		input := r.input.Load()
+
+		for _, t := range slos {
+			burning, recovered := t.sample(time.Now(), input, failed)
+
+			for _, objective := range burning {
+				Emit[E](ctx, r.Pipeline, EventSLOBurning, "%s %s budget burning %.1f times too fast", t.slo.Name, objective, t.status().burnRate(objective))
+			}
+
+			for _, objective := range recovered {
+				Emit[E](ctx, r.Pipeline, EventSLORecovered, "%s %s budget burn rate back to %.1f", t.slo.Name, objective, t.status().burnRate(objective))
+			}
+		}
+	}
+}
+
+func (s SLOStatus) burnRate(objective string) float64 {
+	budget := s.Failures
+	if objective == "latency" {
+		budget = s.Latency
+	}
+
+	if budget == nil {
+		return 0
+	}
+
+	return budget.BurnRate
+}
@@ -0,0 +1,325 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var ErrSinkReturned = fmt.Errorf("sink returned before its input was closed")
+
+/*
+	A Source is the entry of a pipeline: Execute sends items to output until
+	it has no more, or ctx is done, and then closes output. Sources are not
+	given any input.
+*/
+type Source[E Traceable] interface {
+	Execute(ctx context.Context, output chan E)
+	Name() string
+}
+
+/*
+	A Sink is the exit of a pipeline: Execute consumes the items of input
+	until it is closed, and returns once every item was handled. Sinks ack
+	the items they have delivered.
+*/
+type Sink[E Traceable] interface {
+	Execute(ctx context.Context, input chan E)
+	Name() string
+}
+
+/*
+	FailingSource is implemented by sources which can stop on an error, such
+	as a failed read: Err returns it once Execute has returned.
+*/
+type FailingSource interface {
+	Err() error
+}
+
+/*
+	A Pipeline wires a Source, a Processor and a Sink together, so the entry
+	and exit of the pipeline are stages of its tree, with names, stats and
+	graph nodes, rather than channels managed by the caller.
+
+	The Source is the intake: it runs with the context of Execute, and
+	cancelling it stops the intake. The Processor and the Sink then have
+	DrainTimeout to finish the items they hold before their own context is
+	cancelled, or as long as they need when DrainTimeout is zero.
+
+	Without a Source, items come from the input of the Pipeline, and without
+	a Sink, they are sent to its output. Otherwise the input is discarded and
+	nothing is sent out, and the Pipeline is run on its own with Run.
+*/
+type Pipeline[E Traceable] struct {
+	ChainName string
+	Display   string
+	Tags      map[string]string
+
+	Source    Source[E]
+	Processor Processor[E]
+	Sink      Sink[E]
+
+	DrainTimeout time.Duration
+
+	lock   sync.Mutex
+	source *SourceStage[E]
+	sink   *SinkStage[E]
+
+	executions executions
+}
+
+/*
+	Run builds the pipeline and executes it until its Source is done, or ctx
+	is, and everything has drained. Items sent out by a Pipeline without a
+	Sink are discarded.
+*/
+func (p *Pipeline[E]) Run(ctx context.Context) error {
+	if err := Build[E](ctx, p); err != nil {
+		return err
+	}
+
+	input := make(chan E)
+	close(input)
+
+	output := make(chan E)
+	go runProcessor[E](ctx, p, input, output)
+
+	for m := range output {
+		Ack(m)
+	}
+
+	return nil
+}
+
+func (p *Pipeline[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	p.executions.begin()
+	defer p.executions.end()
+
+	Log[E](ctx, p, "starting")
+	TrackStarted[E](ctx, p)
+	ctx = withErrorScope[E](ctx, p)
+
+	source, sink := p.stages()
+
+	drainCtx := ctx
+	items := input
+
+	if source != nil {
+		var cancel context.CancelFunc
+		drainCtx, cancel = p.drainContext(ctx)
+		defer cancel()
+
+		items = make(chan E)
+		go runProcessor[E](ctx, source, input, items)
+	}
+
+	if p.Processor != nil {
+		processed := make(chan E)
+		go runProcessor[E](drainCtx, p.Processor, items, processed)
+		items = processed
+	}
+
+	if sink != nil {
+		consumed := make(chan E)
+		go runProcessor[E](drainCtx, sink, items, consumed)
+		items = consumed
+	}
+
+	for m := range items {
+		TrackOutput[E](ctx, p, m)
+		send(drainCtx, output, m)
+	}
+
+	inputClosed[E](ctx, p)
+	TrackFinished[E](ctx, p)
+	close(output)
+}
+
+func (p *Pipeline[E]) Name() string {
+	return fmt.Sprintf("Pipeline/%s", p.ChainName)
+}
+
+func (p *Pipeline[E]) Children() []Processor[E] {
+	source, sink := p.stages()
+
+	var children []Processor[E]
+
+	if source != nil {
+		children = append(children, source)
+	}
+
+	if p.Processor != nil {
+		children = append(children, p.Processor)
+	}
+
+	if sink != nil {
+		children = append(children, sink)
+	}
+
+	return children
+}
+
+// stages returns the stages of Source and Sink, which keep their identity
+// as long as those are not replaced, so their stats do
+func (p *Pipeline[E]) stages() (*SourceStage[E], *SinkStage[E]) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	switch {
+	case p.Source == nil:
+		p.source = nil
+	case p.source == nil || p.source.Source != p.Source:
+		p.source = &SourceStage[E]{Source: p.Source}
+	}
+
+	switch {
+	case p.Sink == nil:
+		p.sink = nil
+	case p.sink == nil || p.sink.Sink != p.Sink:
+		p.sink = &SinkStage[E]{Sink: p.Sink}
+	}
+
+	return p.source, p.sink
+}
+
+// drainContext returns the context of the stages after the Source, cancelled
+// DrainTimeout after ctx is done
+func (p *Pipeline[E]) drainContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	drainCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-drainCtx.Done():
+			return
+		}
+
+		if p.DrainTimeout <= 0 {
+			return
+		}
+
+		timer := time.NewTimer(p.DrainTimeout)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+			Log[E](ctx, p, "not drained after %s, cancelling", p.DrainTimeout)
+			cancel()
+		case <-drainCtx.Done():
+		}
+	}()
+
+	return drainCtx, cancel
+}
+
+/*
+	A SourceStage runs a Source as a processor of the tree, tracking the
+	items it produces as its output, and reporting the error it stopped on
+	when it is a FailingSource. Its input is discarded.
+*/
+type SourceStage[E Traceable] struct {
+	Source Source[E]
+}
+
+func (s *SourceStage[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	go drain(input)
+
+	TrackStarted[E](ctx, s)
+
+	produced := make(chan E)
+	go s.Source.Execute(ctx, produced)
+
+	for m := range produced {
+		TrackOutput[E](ctx, s, m)
+		output <- m
+	}
+
+	if failing, ok := s.Source.(FailingSource); ok {
+		if err := failing.Err(); err != nil {
+			Log[E](ctx, s, "failed: %s", err)
+			ReportError(ctx, s, err)
+		}
+	}
+
+	TrackFinished[E](ctx, s)
+	close(output)
+}
+
+func (s *SourceStage[E]) Name() string {
+	return s.Source.Name()
+}
+
+func (s *SourceStage[E]) Init(ctx context.Context) error {
+	if init, ok := s.Source.(Initializer); ok {
+		return init.Init(ctx)
+	}
+
+	return nil
+}
+
+func (s *SourceStage[E]) HealthCheck(ctx context.Context) error {
+	if checker, ok := s.Source.(HealthChecker); ok {
+		return checker.HealthCheck(ctx)
+	}
+
+	return nil
+}
+
+/*
+	A SinkStage runs a Sink as a processor of the tree, tracking the items
+	it is given as its input. Nothing is sent to its output. Items left once
+	the Sink has returned are failed and sent to the dead letter handlers.
+*/
+type SinkStage[E Traceable] struct {
+	Sink Sink[E]
+}
+
+func (s *SinkStage[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	TrackStarted[E](ctx, s)
+
+	consumed := make(chan E)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		s.Sink.Execute(ctx, consumed)
+	}()
+
+	for m := range input {
+		TrackItemInput[E](ctx, s, m)
+
+		select {
+		case consumed <- m:
+		case <-done:
+			TrackFailure[E](ctx, s)
+			SendToDLQ(ctx, s, m, ErrSinkReturned)
+		}
+	}
+
+	close(consumed)
+	<-done
+
+	TrackFinished[E](ctx, s)
+	close(output)
+}
+
+func (s *SinkStage[E]) Name() string {
+	return s.Sink.Name()
+}
+
+func (s *SinkStage[E]) Init(ctx context.Context) error {
+	if init, ok := s.Sink.(Initializer); ok {
+		return init.Init(ctx)
+	}
+
+	return nil
+}
+
+func (s *SinkStage[E]) HealthCheck(ctx context.Context) error {
+	if checker, ok := s.Sink.(HealthChecker); ok {
+		return checker.HealthCheck(ctx)
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+/*
+	The SliceSource sends the items of Items, in order
+*/
+type SliceSource[E Traceable] struct {
+	ChainName string
+	Items     []E `json:"-"`
+}
+
+func NewSliceSource[E Traceable](name string, items []E) *SliceSource[E] {
+	return &SliceSource[E]{
+		ChainName: name,
+		Items:     items,
+	}
+}
+
+func (s *SliceSource[E]) Execute(ctx context.Context, output chan E) {
+	defer close(output)
+
+	for _, m := range s.Items {
+		if !send(ctx, output, m) {
+			return
+		}
+	}
+}
+
+func (s *SliceSource[E]) Name() string {
+	return fmt.Sprintf("SliceSource/%s", s.ChainName)
+}
+
+/*
+	The ChannelSource sends the items received from Channel, until it is
+	closed. Channel is not closed by the source.
+*/
+type ChannelSource[E Traceable] struct {
+	ChainName string
+	Channel   <-chan E `json:"-"`
+}
+
+func NewChannelSource[E Traceable](name string, channel <-chan E) *ChannelSource[E] {
+	return &ChannelSource[E]{
+		ChainName: name,
+		Channel:   channel,
+	}
+}
+
+func (s *ChannelSource[E]) Execute(ctx context.Context, output chan E) {
+	defer close(output)
+
+	for {
+		select {
+		case m, ok := <-s.Channel:
+			if !ok || !send(ctx, output, m) {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *ChannelSource[E]) Name() string {
+	return fmt.Sprintf("ChannelSource/%s", s.ChainName)
+}
+
+/*
+	The LineReaderSource sends an item for every line read from Reader, made
+	by Convert from the line, without its line ending. Reading stops at the
+	end of Reader, or on the first error, returned by Err.
+*/
+type LineReaderSource[E Traceable] struct {
+	ChainName string
+	Reader    io.Reader           `json:"-"`
+	Convert   func(line string) E `json:"-"`
+
+	// MaxLineSize is the longest line read, bufio.MaxScanTokenSize when zero
+	MaxLineSize int
+
+	err error
+}
+
+func NewLineReaderSource[E Traceable](name string, reader io.Reader, convert func(line string) E) *LineReaderSource[E] {
+	return &LineReaderSource[E]{
+		ChainName: name,
+		Reader:    reader,
+		Convert:   convert,
+	}
+}
+
+func (s *LineReaderSource[E]) Execute(ctx context.Context, output chan E) {
+	defer close(output)
+
+	scanner := bufio.NewScanner(s.Reader)
+	if s.MaxLineSize > 0 {
+		scanner.Buffer(make([]byte, 0, min(s.MaxLineSize, bufio.MaxScanTokenSize)), s.MaxLineSize)
+	}
+
+	s.err = nil
+
+	for scanner.Scan() {
+		if !send(ctx, output, s.Convert(scanner.Text())) {
+			return
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		s.err = fmt.Errorf("reading lines: %w", err)
+	}
+}
+
+func (s *LineReaderSource[E]) Name() string {
+	return fmt.Sprintf("LineReaderSource/%s", s.ChainName)
+}
+
+// Err returns the error reading stopped on, if any
+func (s *LineReaderSource[E]) Err() error {
+	return s.err
+}
+
+/*
+	The TickerSource sends a synthetic item every Interval, made by Make from
+	the number of items sent before it. It stops after Count items, or runs
+	until its context is done when Count is zero. It is meant to feed
+	pipelines under test.
+*/
+type TickerSource[E Traceable] struct {
+	ChainName string
+	Interval  time.Duration
+	Count     int
+	Make      func(n int) E `json:"-"`
+}
+
+func NewTickerSource[E Traceable](name string, interval time.Duration, fn func(n int) E) *TickerSource[E] {
+	return &TickerSource[E]{
+		ChainName: name,
+		Interval:  interval,
+		Make:      fn,
+	}
+}
+
+func (s *TickerSource[E]) Execute(ctx context.Context, output chan E) {
+	defer close(output)
+
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for n := 0; s.Count <= 0 || n < s.Count; n++ {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		if !send(ctx, output, s.Make(n)) {
+			return
+		}
+	}
+}
+
+func (s *TickerSource[E]) Name() string {
+	return fmt.Sprintf("TickerSource/%s", s.ChainName)
+}
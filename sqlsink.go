@@ -0,0 +1,218 @@
+package pipeline
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrBindFailed = fmt.Errorf("binding item failed")
+
+/*
+	The SQLSink inserts items as rows of Table, with the values Bind returns
+	for Columns, in order.
+
+	Batches are of BatchSize items, or those received within Linger, unless a
+	Controller is set to tune both from the observed writes. Every batch is
+	inserted with multi-row INSERT statements of at most RowsPerStatement
+	rows, all of them by default, in a transaction started with TxOptions,
+	so batches are written entirely or not at all. With NoTransaction,
+	statements run on their own instead: the rows of the statements run
+	before one failing stay written, and are written again if their items
+	are replayed from the dead letter handlers.
+
+	With a HeadersColumn, every row also stores in that column the values
+	propagated from the context and the lineage of its item, as a JSON
+	object of Headers, for the readers of the table to restore them.
+
+	Batches failing are rolled back, failed, and sent to the dead letter
+	handlers. Items written are acked.
+*/
+type SQLSink[E Traceable] struct {
+	ChainName string
+
+	DB      *sql.DB `json:"-"`
+	Table   string
+	Columns []string
+	Bind    func(item E) ([]interface{}, error) `json:"-"`
+
+	HeadersColumn string
+
+	// Placeholder returns the nth (starting at 1) query parameter placeholder
+	// of the SQL dialect, "?" when nil. For PostgreSQL use PostgresPlaceholder.
+	Placeholder func(n int) string `json:"-"`
+
+	BatchSize  int
+	Linger     time.Duration
+	Controller BatchController
+
+	RowsPerStatement int
+	TxOptions        *sql.TxOptions
+	NoTransaction    bool
+}
+
+func NewSQLSink[E Traceable](name string, db *sql.DB, table string, columns []string, bind func(item E) ([]interface{}, error)) *SQLSink[E] {
+	return &SQLSink[E]{
+		ChainName: name,
+		DB:        db,
+		Table:     table,
+		Columns:   columns,
+		Bind:      bind,
+	}
+}
+
+func (s *SQLSink[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	executeSink[E](ctx, s, input, output, s.BatchSize, s.Linger, s.Controller, s.write)
+}
+
+func (s *SQLSink[E]) Name() string {
+	return fmt.Sprintf("SQLSink/%s", s.ChainName)
+}
+
+// Init checks the sink is configured and its database reachable, so Build
+// fails otherwise
+func (s *SQLSink[E]) Init(ctx context.Context) error {
+	if s.DB == nil || s.Table == "" || len(s.Columns) == 0 || s.Bind == nil {
+		return fmt.Errorf("%s needs a database, a table, columns and a binder: %w", s.Name(), ErrInvalidConfig)
+	}
+
+	return s.DB.PingContext(ctx)
+}
+
+func (s *SQLSink[E]) HealthCheck(ctx context.Context) error {
+	return s.DB.PingContext(ctx)
+}
+
+func (s *SQLSink[E]) write(ctx context.Context, items []E) error {
+	rows := make([][]interface{}, len(items))
+
+	for i, m := range items {
+		values, err := s.Bind(m)
+		if err != nil {
+			return fmt.Errorf("%w: %w", err, ErrBindFailed)
+		}
+
+		if len(values) != len(s.Columns) {
+			return fmt.Errorf("%d values for %d columns: %w", len(values), len(s.Columns), ErrBindFailed)
+		}
+
+		if s.HeadersColumn != "" {
+			headers, err := InjectItem(ctx, m)
+			if err != nil {
+				return fmt.Errorf("%w: %w", err, ErrBindFailed)
+			}
+
+			encoded, err := json.Marshal(headers)
+			ReleaseHeaders(headers)
+
+			if err != nil {
+				return fmt.Errorf("%w: %w", err, ErrBindFailed)
+			}
+
+			values = append(slices.Clip(values), string(encoded))
+		}
+
+		rows[i] = values
+	}
+
+	if err := s.insertBatch(ctx, rows); err != nil {
+		return err
+	}
+
+	for _, m := range items {
+		TrackOutput[E](ctx, s, m)
+	}
+
+	return nil
+}
+
+// insertBatch inserts rows in a transaction, unless NoTransaction is set
+func (s *SQLSink[E]) insertBatch(ctx context.Context, rows [][]interface{}) error {
+	if s.NoTransaction {
+		return s.insert(ctx, s.DB, rows)
+	}
+
+	tx, err := s.DB.BeginTx(ctx, s.TxOptions)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+
+	if err := s.insert(ctx, tx, rows); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return errors.Join(err, fmt.Errorf("rolling back: %w", rbErr))
+		}
+
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing: %w", err)
+	}
+
+	return nil
+}
+
+// insert runs the INSERT statements of rows with db
+func (s *SQLSink[E]) insert(ctx context.Context, db interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}, rows [][]interface{}) error {
+	size := s.RowsPerStatement
+	if size <= 0 {
+		size = len(rows)
+	}
+
+	for start := 0; start < len(rows); start += size {
+		chunk := rows[start:min(start+size, len(rows))]
+
+		args := make([]interface{}, 0, len(chunk)*len(s.columns()))
+		for _, row := range chunk {
+			args = append(args, row...)
+		}
+
+		if _, err := db.ExecContext(ctx, s.query(len(chunk)), args...); err != nil {
+			return fmt.Errorf("inserting %d rows into %s: %w", len(chunk), s.Table, err)
+		}
+	}
+
+	return nil
+}
+
+// query is the INSERT statement of n rows
+func (s *SQLSink[E]) query(n int) string {
+	columns := s.columns()
+	values := make([]string, n)
+
+	for row := range values {
+		placeholders := make([]string, len(columns))
+
+		for col := range placeholders {
+			placeholders[col] = s.placeholder(row*len(columns) + col + 1)
+		}
+
+		values[row] = "(" + strings.Join(placeholders, ", ") + ")"
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", s.Table, strings.Join(columns, ", "), strings.Join(values, ", "))
+}
+
+// columns are the columns inserted, HeadersColumn included
+func (s *SQLSink[E]) columns() []string {
+	if s.HeadersColumn == "" {
+		return s.Columns
+	}
+
+	return append(slices.Clip(s.Columns), s.HeadersColumn)
+}
+
+func (s *SQLSink[E]) placeholder(n int) string {
+	if s.Placeholder == nil {
+		return "?"
+	}
+
+	return s.Placeholder(n)
+}
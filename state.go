@@ -0,0 +1,247 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	EventStateSaved    = "state_saved"
+	EventStateRestored = "state_restored"
+	EventStateFailed   = "state_failed"
+)
+
+/*
+	A StateSnapshotter is a processor holding state worth handing over to a
+	standby instance, such as dedupe sets or aggregation state.
+*/
+type StateSnapshotter interface {
+	SnapshotState() ([]byte, error)
+	RestoreState([]byte) error
+}
+
+/*
+	A StateStore keeps the latest snapshot of a pipeline, the state of each
+	snapshotter keyed by its Walk path.
+*/
+type StateStore interface {
+	SaveState(ctx context.Context, snapshot map[string][]byte) error
+	LoadState(ctx context.Context) (map[string][]byte, error)
+}
+
+// SnapshotState collects the state of every snapshotter of the tree
+func SnapshotState[E Traceable](root Processor[E]) (map[string][]byte, error) {
+	snapshot := make(map[string][]byte)
+
+	var err error
+
+	Walk(root, func(path string, p Processor[E]) {
+		s, ok := p.(StateSnapshotter)
+		if !ok || err != nil {
+			return
+		}
+
+		snapshot[path], err = s.SnapshotState()
+	})
+
+	return snapshot, err
+}
+
+// RestoreState hands each snapshotter of the tree its state from the snapshot
+func RestoreState[E Traceable](root Processor[E], snapshot map[string][]byte) error {
+	var err error
+
+	Walk(root, func(path string, p Processor[E]) {
+		s, ok := p.(StateSnapshotter)
+		if !ok || err != nil {
+			return
+		}
+
+		if state, found := snapshot[path]; found {
+			err = s.RestoreState(state)
+		}
+	})
+
+	return err
+}
+
+/*
+	The StateReplicator saves a snapshot of the pipeline state to its store
+	every Interval, and a last one when its context is done.
+*/
+type StateReplicator[E Traceable] struct {
+	Root     Processor[E]
+	Store    StateStore
+	Interval time.Duration
+}
+
+func (r *StateReplicator[E]) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.save(ctx)
+		case <-ctx.Done():
+			r.save(context.Background())
+			return
+		}
+	}
+}
+
+func (r *StateReplicator[E]) save(ctx context.Context) {
+	snapshot, err := SnapshotState(r.Root)
+	if err == nil {
+		err = r.Store.SaveState(ctx, snapshot)
+	}
+
+	if err != nil {
+		Emit(ctx, r.Root, EventStateFailed, "saving state: %s", err)
+		return
+	}
+
+	Emit(ctx, r.Root, EventStateSaved, "saved state of %d processors", len(snapshot))
+}
+
+/*
+	RunWarmStandby runs a pipeline with a warm standby: every instance builds
+	its pipeline up front, and while standing by keeps restoring the state
+	saved by the leader every interval. When an instance is elected, it
+	restores the latest snapshot one last time, and runs the pipeline while
+	replicating its state to the store.
+*/
+func RunWarmStandby[E Traceable](ctx context.Context, elector LeaderElector, store StateStore, interval time.Duration, root Processor[E], run func(ctx context.Context, root Processor[E]) error) error {
+	restore := func() {
+		snapshot, err := store.LoadState(ctx)
+		if err == nil {
+			err = RestoreState(root, snapshot)
+		}
+
+		if err != nil {
+			Emit(ctx, root, EventStateFailed, "restoring state: %s", err)
+			return
+		}
+
+		Emit(ctx, root, EventStateRestored, "restored state of %d processors", len(snapshot))
+	}
+
+	for {
+		standbyCtx, stopStandby := context.WithCancel(ctx)
+		standingBy := make(chan struct{})
+
+		go func() {
+			defer close(standingBy)
+
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+					restore()
+				case <-standbyCtx.Done():
+					return
+				}
+			}
+		}()
+
+		lost, err := elector.Campaign(ctx)
+		stopStandby()
+		<-standingBy
+
+		if err != nil {
+			return err
+		}
+
+		Emit(ctx, root, EventLeaderElected, "acquired leadership")
+		restore()
+
+		leaderCtx, cancel := context.WithCancel(ctx)
+
+		replicator := &StateReplicator[E]{Root: root, Store: store, Interval: interval}
+		replicated := make(chan struct{})
+
+		go func() {
+			replicator.Run(leaderCtx)
+			close(replicated)
+		}()
+
+		go func() {
+			select {
+			case <-lost:
+				cancel()
+			case <-leaderCtx.Done():
+			}
+		}()
+
+		err = run(leaderCtx, root)
+
+		wasLost := false
+		select {
+		case <-lost:
+			wasLost = true
+		default:
+		}
+
+		cancel()
+		<-replicated
+
+		if !wasLost {
+			elector.Resign(context.Background())
+			return err
+		}
+
+		Emit(ctx, root, EventLeadershipLost, "lost leadership, standing by")
+	}
+}
+
+/*
+	FileStateStore keeps snapshots in a JSON file, replaced atomically on save
+*/
+type FileStateStore struct {
+	Path string
+}
+
+func (fs *FileStateStore) SaveState(ctx context.Context, snapshot map[string][]byte) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(fs.Path), filepath.Base(fs.Path)+".*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), fs.Path)
+}
+
+func (fs *FileStateStore) LoadState(ctx context.Context) (map[string][]byte, error) {
+	data, err := os.ReadFile(fs.Path)
+	if os.IsNotExist(err) {
+		return map[string][]byte{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := make(map[string][]byte)
+	err = json.Unmarshal(data, &snapshot)
+
+	return snapshot, err
+}
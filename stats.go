@@ -12,37 +12,148 @@ import (
 
 var PipelineStatDB = "pipeline_stats_db"
 
+/*
+	A StatDB keeps the stats of every processor, keyed by processor identity.
+
+	Pipelines rebuilt during a reload are new processors, so their stats would
+	start from zero. Calling NewGeneration with the new tree before running it
+	gives stable keys to the processors, their Walk paths. With KeyByPath, the
+	stats are then serialized under them instead of their identity, and with
+	CarryOver the cumulative counters of a processor are carried over from the
+	processor of the previous generation which had the same path.
+
+	The stats of a processor have its tags, including the ones it inherits
+	from the tree registered with NewGeneration.
+*/
 type StatDB[E Traceable] struct {
-	itemLock sync.RWMutex
-	items    map[Processor[E]]*Stats
+	KeyByPath bool
+	CarryOver bool
+
+	// ThroughputWindows are the windows of the rates of Snapshot,
+	// DefaultThroughputWindows when empty
+	ThroughputWindows []time.Duration
+
+	itemLock    sync.RWMutex
+	items       map[Processor[E]]*Stats
+	known       map[string]Processor[E]
+	labelLimits map[string]LabelLimit
+
+	generation int
+	paths      map[Processor[E]]string
+	tags       map[Processor[E]]map[string]string
+	previous   map[string]*Stats
 }
 
 func NewStatDB[E Traceable]() *StatDB[E] {
 	return &StatDB[E]{
-		items: make(map[Processor[E]]*Stats),
+		items:       make(map[Processor[E]]*Stats),
+		known:       make(map[string]Processor[E]),
+		labelLimits: make(map[string]LabelLimit),
+		paths:       make(map[Processor[E]]string),
+		tags:        make(map[Processor[E]]map[string]string),
+		previous:    make(map[string]*Stats),
 	}
 }
 
+/*
+	NewGeneration registers root as the current version of the pipeline and
+	returns the new generation number. The stats of processors no longer part
+	of the tree are kept for carry over, and dropped from the StatDB.
+*/
+func (d *StatDB[E]) NewGeneration(root Processor[E]) int {
+	paths := make(map[Processor[E]]string)
+
+	Walk(root, func(path string, p Processor[E]) {
+		paths[p] = path
+	})
+
+	tags := treeTags(root)
+
+	d.itemLock.Lock()
+	defer d.itemLock.Unlock()
+
+	d.generation++
+
+	for p, stats := range d.items {
+		if path, current := paths[p]; current {
+			stats.Path = path
+			stats.Tags = tags[p]
+			continue
+		}
+
+		if stats.Path != "" {
+			d.previous[stats.Path] = stats
+		}
+
+		delete(d.items, p)
+	}
+
+	d.paths = paths
+	d.tags = tags
+
+	return d.generation
+}
+
+func (d *StatDB[E]) Generation() int {
+	d.itemLock.RLock()
+	defer d.itemLock.RUnlock()
+
+	return d.generation
+}
+
 type Stats struct {
 	Input       atomic.Int64 `json:"input"`
 	Output      atomic.Int64 `json:"output"`
 	Passthrough atomic.Int64 `json:"passthrough"`
 	Failed      atomic.Int64 `json:"failed"`
+	Errors      atomic.Int64 `json:"errors"`
+	DeadLetters atomic.Int64 `json:"dead_letters"`
 
 	LastInput       time.Time `json:"last_input"`
 	LastOutput      time.Time `json:"last_output"`
 	LastPassthrough time.Time `json:"last_passthrough"`
 	LastFailure     time.Time `json:"last_failure"`
+	LastError       time.Time `json:"last_error"`
 
 	Started  time.Time `json:"started"`
 	Finished time.Time `json:"finished"`
 
-	Name string `json:"name"`
+	LastEventTime time.Time       `json:"last_event_time,omitempty"`
+	EventLag      atomic.Duration `json:"event_lag"`
+
+	// Loads of reference data, by RefData processors
+	Loads     atomic.Int64 `json:"loads"`
+	LastLoad  time.Time    `json:"last_load,omitempty"`
+	LoadError string       `json:"load_error,omitempty"`
+
+	CPUTime    atomic.Duration `json:"cpu_time"`
+	AllocBytes atomic.Int64    `json:"alloc_bytes"`
+
+	// Latency is the distribution of the time, in seconds, items took to
+	// come out of the processor
+	Latency *ExponentialHistogram `json:"latency"`
+	pending latencyTracker
+
+	inputRate  *rateCounter
+	outputRate *rateCounter
+
+	Labels *LabelCounters `json:"labels"`
+
+	Name        string            `json:"name"`
+	Path        string            `json:"path,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Generation  int               `json:"generation"`
+	DisplayName string            `json:"display_name,omitempty"`
+	Version     string            `json:"version,omitempty"`
+	ConfigHash  string            `json:"config_hash,omitempty"`
+	RunID       string            `json:"run_id,omitempty"`
 }
 
 func NewStats(name string) *Stats {
 	return &Stats{
-		Name: name,
+		Name:    name,
+		Labels:  newLabelCounters(),
+		Latency: NewExponentialHistogram(0),
 	}
 }
 
@@ -53,26 +164,39 @@ func (d *StatDB[E]) MarshalJSON() ([]byte, error) {
 	data := make(map[string]*Stats)
 
 	for p, stats := range d.items {
-		data[fmt.Sprintf("%s/%p", p.Name(), p)] = stats
+		data[d.key(p, stats)] = stats
 	}
 
 	return json.Marshal(data)
 }
 
+// key returns the key the stats of p are serialized under
+func (d *StatDB[E]) key(p Processor[E], stats *Stats) string {
+	if d.KeyByPath && stats.Path != "" {
+		return stats.Path
+	}
+
+	return fmt.Sprintf("%s/%p", stats.Name, p)
+}
+
 func WithStats[E Traceable](ctx context.Context, sdb *StatDB[E]) context.Context {
 	return context.WithValue(ctx, PipelineStatDB, sdb)
 }
 
 func TrackStarted[E Traceable](ctx context.Context, processor Processor[E]) {
+	trackTeardownStarted(ctx, processor)
+
 	statDB, ok := ctx.Value(PipelineStatDB).(*StatDB[E])
 	if !ok {
 		return
 	}
 
-	statDB.trackStarted(processor)
+	statDB.trackStarted(processor, RunID(ctx), Describe(ctx, processor))
 }
 
 func TrackFinished[E Traceable](ctx context.Context, processor Processor[E]) {
+	trackTeardownFinished(ctx, processor)
+
 	statDB, ok := ctx.Value(PipelineStatDB).(*StatDB[E])
 	if !ok {
 		return
@@ -90,26 +214,76 @@ func TrackInput[E Traceable](ctx context.Context, processor Processor[E]) {
 	statDB.trackInput(processor)
 }
 
+// TrackItemInput tracks an input like TrackInput, and logs the item when item
+// logging is enabled. The latency of the item is measured until it is tracked
+// as an output.
+func TrackItemInput[E Traceable](ctx context.Context, processor Processor[E], obj E) {
+	LogItem(ctx, processor, ItemLogInput, obj)
+	TrackInput(ctx, processor)
+	startItemSpan(ctx, processor, obj)
+
+	statDB, ok := ctx.Value(PipelineStatDB).(*StatDB[E])
+	if !ok {
+		return
+	}
+
+	statDB.trackItemStarted(processor, obj)
+
+	if t := EventTime(ctx, obj); !t.IsZero() {
+		statDB.trackEventTime(processor, t)
+	}
+}
+
 func TrackOutput[E Traceable](ctx context.Context, processor Processor[E], obj Traceable) {
 	if HasTracesEnabled(ctx) {
 		obj.AddTrace(processor.Name())
 	}
 
+	if item, ok := obj.(E); ok {
+		LogItem(ctx, processor, ItemLogOutput, item)
+	}
+
+	endItemSpan(ctx, processor, obj)
+
 	statDB, ok := ctx.Value(PipelineStatDB).(*StatDB[E])
 	if !ok {
 		return
 	}
 
+	statDB.trackItemDone(processor, obj, true)
 	statDB.trackOutput(processor)
 }
 
 func TrackPassthrough[E Traceable](ctx context.Context, processor Processor[E], obj Traceable) {
+	endItemSpan(ctx, processor, obj)
+
 	statDB, ok := ctx.Value(PipelineStatDB).(*StatDB[E])
 	if !ok {
 		return
 	}
 
-	statDB.trackOutput(processor)
+	statDB.trackItemDone(processor, obj, false)
+	statDB.trackPassthrough(processor)
+}
+
+func TrackFailure[E Traceable](ctx context.Context, processor Processor[E]) {
+	statDB, ok := ctx.Value(PipelineStatDB).(*StatDB[E])
+	if !ok {
+		return
+	}
+
+	statDB.trackFailure(processor)
+}
+
+// TrackLoad records a load of reference data by processor, loaded at at or
+// failed with err
+func TrackLoad[E Traceable](ctx context.Context, processor Processor[E], at time.Time, err error) {
+	statDB, ok := ctx.Value(PipelineStatDB).(*StatDB[E])
+	if !ok {
+		return
+	}
+
+	statDB.trackLoad(processor, at, err)
 }
 
 func (db *StatDB[E]) getStats(p Processor[E]) *Stats {
@@ -119,20 +293,75 @@ func (db *StatDB[E]) getStats(p Processor[E]) *Stats {
 	stats, ok := db.items[p]
 	if !ok {
 		stats = NewStats(p.Name())
+		if display := DisplayName(p); display != stats.Name {
+			stats.DisplayName = display
+		}
+
+		stats.Generation = db.generation
+		stats.Path = db.paths[p]
+
+		stats.inputRate = newRateCounter(db.rateSpan())
+		stats.outputRate = newRateCounter(db.rateSpan())
+
+		stats.Tags = db.tags[p]
+		if stats.Tags == nil {
+			stats.Tags = processorTags(p)
+		}
+
+		if previous, found := db.previous[stats.Path]; found && db.CarryOver && stats.Path != "" {
+			stats.carryOver(previous)
+			delete(db.previous, stats.Path)
+		}
+
 		db.items[p] = stats
+		db.known[processorID(p)] = p
 	}
 
 	return stats
 }
 
-func (db *StatDB[E]) trackStarted(p Processor[E]) {
+func (db *StatDB[E]) register(p Processor[E]) {
+	db.itemLock.Lock()
+	defer db.itemLock.Unlock()
+
+	db.known[processorID(p)] = p
+}
+
+func (db *StatDB[E]) lookup(id string) (Processor[E], bool) {
+	db.itemLock.RLock()
+	defer db.itemLock.RUnlock()
+
+	p, ok := db.known[id]
+	return p, ok
+}
+
+// StatsOf returns the stats of p, if it has any
+func (db *StatDB[E]) StatsOf(p Processor[E]) (*Stats, bool) {
+	return db.statsOf(p)
+}
+
+// statsOf returns the stats of p, without creating them
+func (db *StatDB[E]) statsOf(p Processor[E]) (*Stats, bool) {
+	db.itemLock.RLock()
+	defer db.itemLock.RUnlock()
+
+	stats, ok := db.items[p]
+	return stats, ok
+}
+
+func (db *StatDB[E]) trackStarted(p Processor[E], runID string, d Descriptor) {
 	stats := db.getStats(p)
+	stats.RunID = runID
+	stats.Name = d.Name
+	stats.Version = d.Version
+	stats.ConfigHash = d.ConfigHash
 	stats.TrackStarted()
 }
 
 func (db *StatDB[E]) trackFinished(p Processor[E]) {
 	stats := db.getStats(p)
 	stats.TrackFinished()
+	stats.pending.reset()
 }
 
 func (db *StatDB[E]) trackInput(p Processor[E]) {
@@ -140,6 +369,11 @@ func (db *StatDB[E]) trackInput(p Processor[E]) {
 	stats.TrackInput()
 }
 
+func (db *StatDB[E]) trackEventTime(p Processor[E], t time.Time) {
+	stats := db.getStats(p)
+	stats.TrackEventTime(t)
+}
+
 func (db *StatDB[E]) trackOutput(p Processor[E]) {
 	stats := db.getStats(p)
 	stats.TrackOutput()
@@ -150,6 +384,26 @@ func (db *StatDB[E]) trackPassthrough(p Processor[E]) {
 	stats.TrackPassthrough()
 }
 
+func (db *StatDB[E]) trackFailure(p Processor[E]) {
+	stats := db.getStats(p)
+	stats.TrackFailure()
+}
+
+func (db *StatDB[E]) trackError(p Processor[E]) {
+	stats := db.getStats(p)
+	stats.TrackError()
+}
+
+func (db *StatDB[E]) trackDeadLetter(p Processor[E]) {
+	stats := db.getStats(p)
+	stats.DeadLetters.Inc()
+}
+
+func (db *StatDB[E]) trackLoad(p Processor[E], at time.Time, err error) {
+	stats := db.getStats(p)
+	stats.TrackLoad(at, err)
+}
+
 func (s *Stats) TrackStarted() {
 	s.Started = time.Now()
 }
@@ -161,6 +415,7 @@ func (s *Stats) TrackFinished() {
 func (s *Stats) TrackOutput() {
 	s.LastOutput = time.Now()
 	s.Output.Inc()
+	s.outputRate.inc(s.LastOutput)
 }
 
 func (s *Stats) TrackPassthrough() {
@@ -171,9 +426,56 @@ func (s *Stats) TrackPassthrough() {
 func (s *Stats) TrackInput() {
 	s.LastInput = time.Now()
 	s.Input.Inc()
+	s.inputRate.inc(s.LastInput)
 }
 
 func (s *Stats) TrackFailure() {
 	s.LastFailure = time.Now()
 	s.Failed.Inc()
 }
+
+func (s *Stats) TrackError() {
+	s.LastError = time.Now()
+	s.Errors.Inc()
+}
+
+// TrackLoad keeps the time of the last successful load, and the error of the
+// last load if it failed
+func (s *Stats) TrackLoad(at time.Time, err error) {
+	if err != nil {
+		s.LoadError = err.Error()
+		return
+	}
+
+	s.LastLoad = at
+	s.LoadError = ""
+	s.Loads.Inc()
+}
+
+// TrackEventTime records the event time of an input item, and how far behind
+// processing time it is
+func (s *Stats) TrackEventTime(t time.Time) {
+	if t.After(s.LastEventTime) {
+		s.LastEventTime = t
+	}
+
+	s.EventLag.Store(time.Since(t))
+}
+
+// carryOver adds the cumulative counters of previous, the stats of the same
+// processor in an earlier generation
+func (s *Stats) carryOver(previous *Stats) {
+	s.Input.Add(previous.Input.Load())
+	s.Output.Add(previous.Output.Load())
+	s.Passthrough.Add(previous.Passthrough.Load())
+	s.Failed.Add(previous.Failed.Load())
+	s.Errors.Add(previous.Errors.Load())
+	s.DeadLetters.Add(previous.DeadLetters.Load())
+	s.Loads.Add(previous.Loads.Load())
+
+	s.CPUTime.Add(previous.CPUTime.Load())
+	s.AllocBytes.Add(previous.AllocBytes.Load())
+	s.Latency.Merge(previous.Latency)
+
+	s.Labels.carryOver(previous.Labels)
+}
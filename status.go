@@ -0,0 +1,203 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const EventStateChanged = "pipeline_state_changed"
+
+// A PipelineState is where a pipeline is in its lifecycle
+type PipelineState int
+
+const (
+	// The pipeline was not started yet
+	StateCreated PipelineState = iota
+	// The pipeline is being built
+	StateStarting
+	// The intake is open and items flow through the pipeline
+	StateRunning
+	// The intake is closed, and the pipeline finishes the items it holds
+	StateDraining
+	// The intake is held, and items wait upstream
+	StatePaused
+	// The pipeline has finished
+	StateStopped
+	// The pipeline could not be built, or its run ended on an error
+	StateFailed
+)
+
+func (s PipelineState) String() string {
+	switch s {
+	case StateCreated:
+		return "created"
+	case StateStarting:
+		return "starting"
+	case StateRunning:
+		return "running"
+	case StateDraining:
+		return "draining"
+	case StatePaused:
+		return "paused"
+	case StateStopped:
+		return "stopped"
+	case StateFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("PipelineState(%d)", int(s))
+	}
+}
+
+func (s PipelineState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// stateTransitions are the states each state can be left for
+var stateTransitions = map[PipelineState][]PipelineState{
+	StateCreated:  {StateStarting},
+	StateStarting: {StateRunning, StateStopped, StateFailed},
+	StateRunning:  {StateDraining, StatePaused, StateStopped, StateFailed},
+	StatePaused:   {StateRunning, StateDraining, StateStopped, StateFailed},
+	StateDraining: {StateStopped, StateFailed},
+	StateStopped:  {StateStarting},
+	StateFailed:   {StateStarting},
+}
+
+// CanTransition tells whether a pipeline in state s can move to state to
+func (s PipelineState) CanTransition(to PipelineState) bool {
+	for _, next := range stateTransitions[s] {
+		if next == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+	A PipelineStatus is the state of a pipeline, since when it holds, and
+	why it was entered, when that is known.
+*/
+type PipelineStatus struct {
+	State  PipelineState `json:"state"`
+	Since  time.Time     `json:"since"`
+	Reason string        `json:"reason,omitempty"`
+}
+
+/*
+	stateMachine tracks the state of a pipeline, refusing the transitions
+	stateTransitions does not allow. Its zero value is Created.
+*/
+type stateMachine struct {
+	lock   sync.Mutex
+	status PipelineStatus
+}
+
+func (m *stateMachine) get() PipelineStatus {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.status
+}
+
+// transition moves to state to, and returns the state left, and false when
+// the transition is not allowed
+func (m *stateMachine) transition(to PipelineState, reason string) (PipelineState, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	from := m.status.State
+	if !from.CanTransition(to) {
+		return from, false
+	}
+
+	m.status = PipelineStatus{State: to, Since: time.Now(), Reason: reason}
+
+	return from, true
+}
+
+// setState moves the runner to state to, emitting EventStateChanged, and
+// returns false when the transition is not allowed
+func (r *Runner[E]) setState(ctx context.Context, to PipelineState, reason string) bool {
+	from, ok := r.state.transition(to, reason)
+	if !ok {
+		return false
+	}
+
+	if reason != "" {
+		Emit[E](ctx, r.Pipeline, EventStateChanged, "%s, from %s: %s", to, from, reason)
+	} else {
+		Emit[E](ctx, r.Pipeline, EventStateChanged, "%s, from %s", to, from)
+	}
+
+	return true
+}
+
+/*
+	State returns where the pipeline of the Runner is in its lifecycle:
+	Starting while it is built, Running once its intake is open, Paused
+	while the intake is held by Pause or the Guard, Draining once the intake
+	is over, and Stopped or Failed once the run has finished. Every change
+	is emitted as EventStateChanged.
+*/
+func (r *Runner[E]) State() PipelineState {
+	return r.state.get().State
+}
+
+// Status returns the state of the pipeline of the Runner, since when it
+// holds and why
+func (r *Runner[E]) Status() PipelineStatus {
+	return r.state.get()
+}
+
+// Pause holds the intake of the running pipeline, whose feed then waits,
+// while the items already fed go on through the pipeline
+func (r *Runner[E]) Pause() {
+	r.pauses.pause()
+	r.setState(r.eventContext(), StatePaused, "paused")
+}
+
+// Resume lets the intake paused by Pause go on, unless the Guard holds it
+func (r *Runner[E]) Resume() {
+	r.pauses.resume()
+
+	if r.Guard == nil || r.Guard.Tripped() == nil {
+		r.setState(r.eventContext(), StateRunning, "resumed")
+	}
+}
+
+// eventContext returns the context of the current run, which holds its
+// event handlers
+func (r *Runner[E]) eventContext() context.Context {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.events == nil {
+		return context.Background()
+	}
+
+	return r.events
+}
+
+// setState moves the manager to state to, emitting EventStateChanged
+func (m *PipelineManager[E]) setState(ctx context.Context, to PipelineState, reason string) {
+	if from, ok := m.state.transition(to, reason); ok {
+		Emit[E](ctx, m, EventStateChanged, "%s, from %s", to, from)
+	}
+}
+
+/*
+	State returns where the manager is in its lifecycle: Running once it
+	executes its first pipeline, Draining once its input is over, and
+	Stopped once every pipeline it started has finished. Every change is
+	emitted as EventStateChanged.
+*/
+func (m *PipelineManager[E]) State() PipelineState {
+	return m.state.get().State
+}
+
+func (m *PipelineManager[E]) Status() PipelineStatus {
+	return m.state.get()
+}
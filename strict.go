@@ -0,0 +1,115 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var PipelineStrictMode PipelineContextKey = "pipeline_strict_mode"
+
+var ErrContractViolation = fmt.Errorf("processor contract violation")
+
+/*
+	WithStrictMode checks at runtime that every processor run by a composite
+	follows the Processor contract, and panics with an ErrContractViolation
+	describing the offending processor as soon as one does not:
+
+	- it reads all of its input
+	- it closes its output exactly once, at most shutdown after its input was
+		closed
+	- it sends nothing after closing its output
+	- it does not close its input
+
+	Each processor gets its own channels, relayed to the real ones, so strict
+	mode adds two hops per stage. It is meant for development and tests.
+	Violations happening in goroutines spawned by a processor still panic, but
+	with the runtime message.
+*/
+func WithStrictMode(ctx context.Context, shutdown time.Duration) context.Context {
+	return context.WithValue(ctx, PipelineStrictMode, shutdown)
+}
+
+func hasStrictMode(ctx context.Context) bool {
+	_, ok := ctx.Value(PipelineStrictMode).(time.Duration)
+	return ok
+}
+
+func contractViolation[E Traceable](p Processor[E], format string, args ...interface{}) error {
+	return fmt.Errorf("%s (%T) %s: %w", p.Name(), p, fmt.Sprintf(format, args...), ErrContractViolation)
+}
+
+func strictExecute[E Traceable](p Processor[E], execute func(ctx context.Context, input chan E, output chan E)) func(ctx context.Context, input chan E, output chan E) {
+	return func(ctx context.Context, input chan E, output chan E) {
+		shutdown := ctx.Value(PipelineStrictMode).(time.Duration)
+
+		procInput := make(chan E)
+		procOutput := make(chan E)
+
+		inputDone := make(chan struct{})
+		outputDone := make(chan struct{})
+
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					panic(contractViolation(p, "closed its input, which belongs to its caller"))
+				}
+			}()
+
+			for m := range input {
+				procInput <- m
+			}
+
+			close(procInput)
+			close(inputDone)
+		}()
+
+		go func() {
+			for m := range procOutput {
+				output <- m
+			}
+
+			close(outputDone)
+			close(output)
+		}()
+
+		go func() {
+			select {
+			case <-inputDone:
+				select {
+				case <-outputDone:
+				case <-time.After(shutdown):
+					panic(contractViolation(p, "did not close its output %s after its input was closed", shutdown))
+				}
+
+			case <-outputDone:
+				select {
+				case <-inputDone:
+				case <-time.After(shutdown):
+					panic(contractViolation(p, "closed its output without reading all of its input"))
+				}
+			}
+		}()
+
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+
+			msg := fmt.Sprint(r)
+
+			switch {
+			case strings.Contains(msg, "send on closed channel"):
+				panic(contractViolation(p, "sent an item after closing its output"))
+			case strings.Contains(msg, "close of closed channel"):
+				panic(contractViolation(p, "closed its output more than once"))
+			}
+
+			panic(r)
+		}()
+
+		execute(ctx, procInput, procOutput)
+	}
+}
@@ -0,0 +1,148 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	EventBranchFailed    = "branch_failed"
+	EventBranchStalled   = "branch_stalled"
+	EventBranchAbandoned = "branch_abandoned"
+)
+
+/*
+	branch supervises a processor run by a composite with its own input and
+	output channels.
+
+	Non critical branches are isolated: a panic in their Execute is recovered,
+	and they can be abandoned. An abandoned branch is drained, its items being
+	discarded and its output no longer forwarded, so it blocks no one while it
+	keeps running; goroutines it is stuck in are leaked.
+*/
+type branch[E Traceable] struct {
+	proc     Processor[E]
+	critical bool
+	input    chan E
+
+	abandoned chan struct{}
+	finished  chan struct{}
+	once      sync.Once
+}
+
+func newBranch[E Traceable](proc Processor[E], critical bool, input chan E) *branch[E] {
+	return &branch[E]{
+		proc:      proc,
+		critical:  critical,
+		input:     input,
+		abandoned: make(chan struct{}),
+		finished:  make(chan struct{}),
+	}
+}
+
+func (b *branch[E]) run(ctx context.Context, parent Processor[E], output chan E) {
+	if !b.critical {
+		defer func() {
+			if r := recover(); r != nil {
+				Log[E](ctx, parent, "branch %s panicked: %v", b.proc.Name(), r)
+				Emit[E](ctx, b.proc, EventBranchFailed, "panicked in %s: %v", parent.Name(), r)
+				TrackFailure[E](ctx, b.proc)
+				ReportError(ctx, b.proc, fmt.Errorf("panicked in %s: %v", parent.Name(), r))
+
+				b.abandon()
+			}
+		}()
+	}
+
+	runProcessor[E](ctx, b.proc, b.input, output)
+}
+
+// forward sends the output of the branch to the collector until it is
+// closed or the branch is abandoned
+func (b *branch[E]) forward(output chan E, collector chan E) {
+	defer close(b.finished)
+
+	for {
+		select {
+		case m, ok := <-output:
+			if !ok {
+				return
+			}
+
+			select {
+			case collector <- m:
+			case <-b.abandoned:
+				go drain(output)
+				return
+			}
+
+		case <-b.abandoned:
+			go drain(output)
+			return
+		}
+	}
+}
+
+func (b *branch[E]) abandon() {
+	b.once.Do(func() {
+		close(b.abandoned)
+		go drain(b.input)
+	})
+}
+
+func drain[E Traceable](c chan E) {
+	for range c {
+	}
+}
+
+/*
+	superviseClose waits for the branches of a composite to finish once their
+	input has been closed. Branches still running after timeout are reported,
+	and non critical ones abandoned.
+*/
+func superviseClose[E Traceable](ctx context.Context, parent Processor[E], branches []*branch[E], timeout time.Duration) {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		deadline = timer.C
+	}
+
+	for _, b := range branches {
+		select {
+		case <-b.finished:
+			continue
+		default:
+		}
+
+		select {
+		case <-b.finished:
+			continue
+		case <-deadline:
+			deadline = closedDeadline
+		}
+
+		if b.critical {
+			Emit[E](ctx, b.proc, EventBranchStalled, "still running %s after the input of %s was closed", timeout, parent.Name())
+			<-b.finished
+			continue
+		}
+
+		Emit[E](ctx, b.proc, EventBranchAbandoned, "abandoned by %s, still running %s after its input was closed", parent.Name(), timeout)
+		Log[E](ctx, parent, "abandoning branch %s", b.proc.Name())
+		TrackFailure[E](ctx, b.proc)
+		ReportError(ctx, b.proc, fmt.Errorf("abandoned by %s after %s", parent.Name(), timeout))
+
+		b.abandon()
+		<-b.finished
+	}
+}
+
+var closedDeadline = func() <-chan time.Time {
+	c := make(chan time.Time)
+	close(c)
+	return c
+}()
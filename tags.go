@@ -0,0 +1,233 @@
+package pipeline
+
+import (
+	"context"
+)
+
+/*
+	Tagger is implemented by processors carrying metadata tags, such as their
+	owner, team, environment or SLO, which are reported along with their
+	stats, errors and events. Composites carry the tags of their Tags field.
+
+	Tags are inherited: the tags of a composite apply to every processor it
+	contains, which can override them with tags of its own.
+*/
+type Tagger interface {
+	ProcessorTags() map[string]string
+}
+
+/*
+	TagSetter is implemented by processors accepting the tags given to them in
+	a pipeline definition.
+*/
+type TagSetter interface {
+	SetTags(tags map[string]string)
+}
+
+func processorTags(p interface{}) map[string]string {
+	if tagger, ok := p.(Tagger); ok {
+		return tagger.ProcessorTags()
+	}
+
+	return nil
+}
+
+// mergeTags returns the tags of parent overridden by own, without modifying
+// any of them
+func mergeTags(parent, own map[string]string) map[string]string {
+	if len(own) == 0 {
+		return parent
+	}
+
+	if len(parent) == 0 {
+		return own
+	}
+
+	merged := make(map[string]string, len(parent)+len(own))
+	for k, v := range parent {
+		merged[k] = v
+	}
+
+	for k, v := range own {
+		merged[k] = v
+	}
+
+	return merged
+}
+
+// MatchTags tells whether tags have every key of selector, with its value
+func MatchTags(tags, selector map[string]string) bool {
+	for k, v := range selector {
+		if value, ok := tags[k]; !ok || value != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// TagsByPath returns the tags of every processor of root, inherited ones
+// included, keyed by Walk path
+func TagsByPath[E Traceable](root Processor[E]) map[string]map[string]string {
+	tags := treeTags(root)
+	result := make(map[string]map[string]string)
+
+	Walk(root, func(path string, p Processor[E]) {
+		if len(tags[p]) > 0 {
+			result[path] = tags[p]
+		}
+	})
+
+	return result
+}
+
+func treeTags[E Traceable](root Processor[E]) map[Processor[E]]map[string]string {
+	tags := make(map[Processor[E]]map[string]string)
+
+	Walk(root, func(path string, p Processor[E]) {
+		tags[p] = mergeTags(tags[p], processorTags(p))
+
+		if composite, ok := p.(Composite[E]); ok {
+			for _, child := range composite.Children() {
+				tags[child] = tags[p]
+			}
+		}
+	})
+
+	return tags
+}
+
+// contextTags returns the tags of p, inheriting those of the composites of
+// its error scope
+func contextTags(ctx context.Context, p interface{}) map[string]string {
+	scope, _ := ctx.Value(PipelineErrorScope).(*errorScope)
+
+	var chain []*errorScope
+	for ; scope != nil; scope = scope.parent {
+		chain = append(chain, scope)
+	}
+
+	var tags map[string]string
+	for i := len(chain) - 1; i >= 0; i-- {
+		tags = mergeTags(tags, processorTags(chain[i].processor))
+	}
+
+	return mergeTags(tags, processorTags(p))
+}
+
+/*
+	Select returns the stats of the processors having the tags of selector,
+	keyed as they are serialized.
+*/
+func (d *StatDB[E]) Select(selector map[string]string) map[string]*Stats {
+	d.itemLock.RLock()
+	defer d.itemLock.RUnlock()
+
+	selected := make(map[string]*Stats)
+
+	for p, stats := range d.items {
+		if !MatchTags(stats.Tags, selector) {
+			continue
+		}
+
+		selected[d.key(p, stats)] = stats
+	}
+
+	return selected
+}
+
+func (fanout *Fanout[E]) ProcessorTags() map[string]string {
+	return fanout.Tags
+}
+
+func (sequential *Sequential[E]) ProcessorTags() map[string]string {
+	return sequential.Tags
+}
+
+func (parallel *Parallel[E]) ProcessorTags() map[string]string {
+	return parallel.Tags
+}
+
+func (shadow *Shadow[E]) ProcessorTags() map[string]string {
+	return shadow.Tags
+}
+
+func (bg *BlueGreen[E]) ProcessorTags() map[string]string {
+	return bg.Tags
+}
+
+func (flagged *Flagged[E]) ProcessorTags() map[string]string {
+	return flagged.Tags
+}
+
+func (pool *Pool[E]) ProcessorTags() map[string]string {
+	return pool.Tags
+}
+
+func (pool *TenantPool[E]) ProcessorTags() map[string]string {
+	return pool.Tags
+}
+
+func (router *Router[E]) ProcessorTags() map[string]string {
+	return router.Tags
+}
+
+func (retry *Retry[E]) ProcessorTags() map[string]string {
+	return retry.Tags
+}
+
+func (deadline *Deadline[E]) ProcessorTags() map[string]string {
+	return deadline.Tags
+}
+
+func (p *Pipeline[E]) ProcessorTags() map[string]string {
+	return p.Tags
+}
+
+func (fanout *Fanout[E]) SetTags(tags map[string]string) {
+	fanout.Tags = tags
+}
+
+func (sequential *Sequential[E]) SetTags(tags map[string]string) {
+	sequential.Tags = tags
+}
+
+func (parallel *Parallel[E]) SetTags(tags map[string]string) {
+	parallel.Tags = tags
+}
+
+func (shadow *Shadow[E]) SetTags(tags map[string]string) {
+	shadow.Tags = tags
+}
+
+func (bg *BlueGreen[E]) SetTags(tags map[string]string) {
+	bg.Tags = tags
+}
+
+func (flagged *Flagged[E]) SetTags(tags map[string]string) {
+	flagged.Tags = tags
+}
+
+func (pool *Pool[E]) SetTags(tags map[string]string) {
+	pool.Tags = tags
+}
+
+func (pool *TenantPool[E]) SetTags(tags map[string]string) {
+	pool.Tags = tags
+}
+
+func (router *Router[E]) SetTags(tags map[string]string) {
+	router.Tags = tags
+}
+
+func (retry *Retry[E]) SetTags(tags map[string]string) {
+	retry.Tags = tags
+}
+
+func (deadline *Deadline[E]) SetTags(tags map[string]string) {
+	deadline.Tags = tags
+}
+
+func (p *Pipeline[E]) SetTags(tags map[string]string) {
+	p.Tags = tags
+}
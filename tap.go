@@ -0,0 +1,145 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+/*
+	A Tap is a pure observer: it sees every item and sends it on unchanged.
+
+	Composites detect taps and call Observe from the goroutines already moving
+	items around instead of wiring the tap with its own channels, so
+	observability-only stages add no extra hop. Taps are still tracked under
+	stats of their own, as if they ran on their own. Observe must not modify
+	the item nor block for long.
+*/
+type Tap[E Traceable] interface {
+	Processor[E]
+	Observe(ctx context.Context, item E)
+}
+
+/*
+	TapFunc adapts a function to a Tap
+*/
+type TapFunc[E Traceable] struct {
+	ProcName string
+	Fn       func(ctx context.Context, item E)
+}
+
+func NewTapFunc[E Traceable](name string, fn func(ctx context.Context, item E)) *TapFunc[E] {
+	return &TapFunc[E]{
+		ProcName: name,
+		Fn:       fn,
+	}
+}
+
+func (t *TapFunc[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	executeItems[E](ctx, t, input, output, func(item E) (E, error) {
+		t.Observe(ctx, item)
+		return item, nil
+	})
+}
+
+func (t *TapFunc[E]) Name() string {
+	return t.ProcName
+}
+
+func (t *TapFunc[E]) Observe(ctx context.Context, item E) {
+	t.Fn(ctx, item)
+}
+
+/*
+	tapGroup runs consecutive taps of a Sequential in a single hop
+*/
+type tapGroup[E Traceable] struct {
+	taps []Tap[E]
+}
+
+func (g *tapGroup[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	startTaps(ctx, g.taps)
+
+	for m := range input {
+		observe(ctx, g.taps, m)
+		send(ctx, output, m)
+	}
+
+	finishTaps(ctx, g.taps)
+	close(output)
+}
+
+func (g *tapGroup[E]) Name() string {
+	names := make([]string, len(g.taps))
+	for i, t := range g.taps {
+		names[i] = t.Name()
+	}
+
+	return fmt.Sprintf("Taps/%s", strings.Join(names, "+"))
+}
+
+// observe hands item to every tap, tracking it as their input and output
+func observe[E Traceable](ctx context.Context, taps []Tap[E], item E) {
+	for _, t := range taps {
+		TrackItemInput[E](ctx, t, item)
+		t.Observe(ctx, item)
+		TrackOutput[E](ctx, t, item)
+	}
+}
+
+// startTaps registers the taps observed by a composite, and tracks them as
+// started
+func startTaps[E Traceable](ctx context.Context, taps []Tap[E]) {
+	statDB, tracked := ctx.Value(PipelineStatDB).(*StatDB[E])
+
+	for _, t := range taps {
+		if tracked {
+			statDB.register(t)
+		}
+
+		TrackStarted[E](ctx, t)
+	}
+}
+
+func finishTaps[E Traceable](ctx context.Context, taps []Tap[E]) {
+	for _, t := range taps {
+		TrackFinished[E](ctx, t)
+	}
+}
+
+/*
+	planTaps removes taps from a Sequential stage list. Taps in front of the
+	first stage are returned as entry taps, to be observed by the input
+	forwarder, and taps after the last one as exit taps, observed by the output
+	collector. Taps in between stages are grouped into a single hop.
+*/
+func planTaps[E Traceable](processors []Processor[E]) (stages []Processor[E], entry []Tap[E], exit []Tap[E]) {
+	var run []Tap[E]
+
+	for _, p := range processors {
+		if t, ok := p.(Tap[E]); ok {
+			run = append(run, t)
+			continue
+		}
+
+		if len(run) > 0 {
+			if len(stages) == 0 {
+				entry = run
+			} else {
+				stages = append(stages, &tapGroup[E]{taps: run})
+			}
+
+			run = nil
+		}
+
+		stages = append(stages, p)
+	}
+
+	if len(stages) == 0 {
+		entry = run
+	} else {
+		exit = run
+	}
+
+	return stages, entry, exit
+}
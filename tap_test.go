@@ -0,0 +1,62 @@
+package pipeline
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+)
+
+// notTap hides that a processor is a Tap, so it runs as a plain branch
+type notTap[E Traceable] struct {
+	Processor[E]
+}
+
+func TestFanoutTapsEmitLikePlainBranches(t *testing.T) {
+	observed := atomic.Int64{}
+
+	tap := func(name string) Processor[*testItem] {
+		return NewTapFunc[*testItem](name, func(ctx context.Context, item *testItem) {
+			observed.Add(1)
+		})
+	}
+
+	tapped := &Fanout[*testItem]{
+		ChainName:  "tapped",
+		Processors: []Processor[*testItem]{tap("a"), tap("b"), &Noop[*testItem]{ChainName: "noop"}},
+	}
+
+	plain := &Fanout[*testItem]{
+		ChainName:  "plain",
+		Processors: []Processor[*testItem]{notTap[*testItem]{tap("a")}, notTap[*testItem]{tap("b")}, &Noop[*testItem]{ChainName: "noop"}},
+	}
+
+	want := len(runItems(t, context.Background(), plain, newItems("x", "y")))
+	got := len(runItems(t, context.Background(), tapped, newItems("x", "y")))
+
+	if want != 6 || got != want {
+		t.Fatalf("fanout with taps emitted %d items, with plain branches %d, want 6", got, want)
+	}
+
+	if observed.Load() != 8 {
+		t.Fatalf("taps observed %d items, want 8", observed.Load())
+	}
+}
+
+func TestSequentialTapsKeepItems(t *testing.T) {
+	observed := atomic.Int64{}
+
+	chain := &Sequential[*testItem]{
+		ChainName: "chain",
+		Processors: []Processor[*testItem]{
+			NewTapFunc[*testItem]("tap", func(ctx context.Context, item *testItem) {
+				observed.Add(1)
+			}),
+			upper("upper"),
+		},
+	}
+
+	got := itemValues(runItems(t, context.Background(), chain, newItems("a", "b")))
+	if len(got) != 2 || got[0] != "A" || got[1] != "B" || observed.Load() != 2 {
+		t.Fatalf("got %v with %d observed, want A B with 2 observed", got, observed.Load())
+	}
+}
@@ -0,0 +1,162 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var PipelineTeardown PipelineContextKey = "pipeline_teardown"
+
+/*
+	WithTeardownDiagnostics helps finding why a pipeline does not finish.
+
+	Teardown starts when ctx is done or the input of any composite is closed.
+	If processors are still running timeout later, a report is written to w:
+	the processors not finished yet, the buffered channels of composites still
+	holding items, and the stacks of the goroutines owned by the pipeline,
+	those labelled by the composites running them.
+
+	A single report is written per context. To send it to a logger, use its
+	writer, such as log.Writer().
+*/
+func WithTeardownDiagnostics(ctx context.Context, timeout time.Duration, w io.Writer) context.Context {
+	td := &teardownDiagnostics{
+		timeout: timeout,
+		w:       w,
+		running: make(map[string]*runningProcessor),
+	}
+
+	go func() {
+		<-ctx.Done()
+		td.begin()
+	}()
+
+	return context.WithValue(ctx, PipelineTeardown, td)
+}
+
+type teardownDiagnostics struct {
+	timeout time.Duration
+	w       io.Writer
+
+	lock    sync.Mutex
+	running map[string]*runningProcessor
+
+	once sync.Once
+}
+
+type runningProcessor struct {
+	name     string
+	id       string
+	started  time.Time
+	channels func() []string
+}
+
+func trackTeardownStarted[E Traceable](ctx context.Context, p Processor[E]) {
+	td, ok := ctx.Value(PipelineTeardown).(*teardownDiagnostics)
+	if !ok {
+		return
+	}
+
+	td.lock.Lock()
+	defer td.lock.Unlock()
+
+	td.running[processorID(p)] = &runningProcessor{
+		name:    p.Name(),
+		id:      processorID(p),
+		started: time.Now(),
+		channels: func() []string {
+			return channelDepths(p)
+		},
+	}
+}
+
+func trackTeardownFinished[E Traceable](ctx context.Context, p Processor[E]) {
+	td, ok := ctx.Value(PipelineTeardown).(*teardownDiagnostics)
+	if !ok {
+		return
+	}
+
+	td.lock.Lock()
+	defer td.lock.Unlock()
+
+	delete(td.running, processorID(p))
+}
+
+// inputClosed is called by composites once they have read all their input
+func inputClosed[E Traceable](ctx context.Context, p Processor[E]) {
+	Log[E](ctx, p, "input closed")
+
+	if td, ok := ctx.Value(PipelineTeardown).(*teardownDiagnostics); ok {
+		td.begin()
+	}
+}
+
+func (td *teardownDiagnostics) begin() {
+	td.once.Do(func() {
+		time.AfterFunc(td.timeout, td.report)
+	})
+}
+
+func (td *teardownDiagnostics) report() {
+	td.lock.Lock()
+
+	running := make([]*runningProcessor, 0, len(td.running))
+	for _, rp := range td.running {
+		running = append(running, rp)
+	}
+
+	td.lock.Unlock()
+
+	if len(running) == 0 {
+		return
+	}
+
+	sort.Slice(running, func(i, j int) bool {
+		return running[i].started.Before(running[j].started)
+	})
+
+	owned := make(map[string]bool)
+
+	report := &strings.Builder{}
+	fmt.Fprintf(report, "pipeline teardown diagnostics: %d processor(s) not finished %s after teardown started\n", len(running), td.timeout)
+
+	for _, rp := range running {
+		fmt.Fprintf(report, "\n%s (%s), running for %s\n", rp.name, rp.id, time.Since(rp.started).Round(time.Millisecond))
+
+		for _, channel := range rp.channels() {
+			fmt.Fprintf(report, "\t%s\n", channel)
+		}
+
+		owned[rp.id] = true
+	}
+
+	fmt.Fprintf(report, "\ngoroutines:\n")
+
+	for _, group := range goroutineGroups() {
+		if group.processorID == "" {
+			continue
+		}
+
+		fmt.Fprintf(report, "\n%d goroutine(s) %s\n%s", group.count, group.labels, group.stack)
+	}
+
+	io.WriteString(td.w, report.String())
+}
+
+// channelDepths describes the channels of a composite holding items
+func channelDepths[E Traceable](p Processor[E]) []string {
+	var depths []string
+
+	for _, queue := range queueDepths(p) {
+		if queue.Depth > 0 {
+			depths = append(depths, fmt.Sprintf("%s: %d/%d items", queue.Channel, queue.Depth, queue.Capacity))
+		}
+	}
+
+	return depths
+}
@@ -0,0 +1,76 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"text/template"
+)
+
+/*
+	A TemplateExecutor renders data, as both text/template and html/template
+	templates do.
+*/
+type TemplateExecutor interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+/*
+	The Template processor renders every item through a template, the item
+	being the data of the template.
+
+	Rendered documents are written to Writer when set, each followed by
+	Separator, and items sent on unchanged. Otherwise each item carries its own
+	rendering, through Wrap or RawSetter.
+*/
+type Template[E Traceable] struct {
+	ChainName string
+
+	Template  TemplateExecutor
+	Separator string
+
+	Writer io.Writer
+	Wrap   func(item E, data []byte) E
+}
+
+// NewTemplate parses text as a text/template rendering items to w, one per
+// line
+func NewTemplate[E Traceable](name string, text string, w io.Writer) (*Template[E], error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Template[E]{
+		ChainName: name,
+		Template:  tmpl,
+		Separator: "\n",
+		Writer:    w,
+	}, nil
+}
+
+func (t *Template[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	buf := &bytes.Buffer{}
+
+	executeItems[E](ctx, t, input, output, func(item E) (E, error) {
+		buf.Reset()
+
+		if err := t.Template.Execute(buf, item); err != nil {
+			return item, err
+		}
+
+		if t.Writer != nil {
+			buf.WriteString(t.Separator)
+
+			_, err := t.Writer.Write(buf.Bytes())
+			return item, err
+		}
+
+		return withRaw(item, bytes.Clone(buf.Bytes()), t.Wrap)
+	})
+}
+
+func (t *Template[E]) Name() string {
+	return fmt.Sprintf("Template/%s", t.ChainName)
+}
@@ -0,0 +1,295 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	EventTenantStarted = "tenant_started"
+	EventTenantEvicted = "tenant_evicted"
+)
+
+/*
+	The TenantPool processor has:
+
+	- One input
+	- One instance of Template per tenant, cloned with Clone
+	- One output
+
+	Each item coming from the input is forwarded to the instance of its
+	tenant, told by TenantOf, or else by the field TenantField of the item,
+	named by Tag as for Fanout. Instances are cloned and built the first time
+	their tenant has an item, so tenants share no state, and their stats are
+	apart: each instance is tagged with its "tenant", and composite templates
+	are named ChainName@tenant.
+
+	At most MaxTenants instances run at once when it is set. Starting one
+	more evicts the least recently used tenant: the input of its instance is
+	closed, and it drains while the others go on. Its next item starts a new
+	instance. Each instance takes up to BufferSize items before holding back
+	the input, and with it every other tenant.
+
+	Leaves of type "processor" of Template are built by Factory when they are
+	not registered, as by Clone. Items whose instance can not be built are
+	failed.
+*/
+type TenantPool[E Traceable] struct {
+	ChainName string
+	Display   string
+	Tags      map[string]string
+
+	Template    Processor[E]
+	Factory     ProcessorFactory[E] `json:"-"`
+	TenantOf    func(item E) string `json:"-"`
+	TenantField string
+	Tag         string
+	MaxTenants  int
+	BufferSize  int
+
+	definition     []byte
+	definitionErr  error
+	definitionOnce sync.Once
+
+	lock    sync.Mutex
+	tenants map[string]*tenantInstance[E]
+	used    uint64
+
+	executions executions
+}
+
+type tenantInstance[E Traceable] struct {
+	tenant string
+	proc   Processor[E]
+	input  chan E
+	used   uint64
+}
+
+func (pool *TenantPool[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	pool.executions.begin()
+	defer pool.executions.end()
+
+	Log[E](ctx, pool, "starting")
+	TrackStarted[E](ctx, pool)
+	ctx = withErrorScope[E](ctx, pool)
+
+	wg := sync.WaitGroup{}
+	collectorWg := sync.WaitGroup{}
+
+	collector := make(chan E)
+
+	collectorWg.Add(1)
+	go func() {
+		for m := range collector {
+			if ctx.Err() != nil {
+				abandon(ctx, m)
+				continue
+			}
+
+			TrackOutput[E](ctx, pool, m)
+			send(ctx, output, m)
+		}
+		collectorWg.Done()
+	}()
+
+	for {
+		msg, ok := receive(ctx, input)
+		if !ok {
+			break
+		}
+
+		TrackItemInput[E](ctx, pool, msg)
+
+		instance, err := pool.instance(ctx, pool.tenantOf(msg), collector, &wg)
+		if err != nil {
+			Log[E](ctx, pool, "%s", err)
+			TrackFailure[E](ctx, pool)
+			ReportError(ctx, pool, err)
+			SendToDLQ(ctx, pool, msg, err)
+			continue
+		}
+
+		if !send(ctx, instance.input, msg) {
+			break
+		}
+	}
+
+	inputClosed[E](ctx, pool)
+
+	pool.lock.Lock()
+	for tenant, instance := range pool.tenants {
+		close(instance.input)
+		delete(pool.tenants, tenant)
+	}
+	pool.lock.Unlock()
+
+	wg.Wait()
+
+	close(collector)
+	collectorWg.Wait()
+
+	TrackFinished[E](ctx, pool)
+	close(output)
+}
+
+func (pool *TenantPool[E]) tenantOf(item E) string {
+	if pool.TenantOf != nil {
+		return pool.TenantOf(item)
+	}
+
+	fields, _ := itemFields(item, pool.Tag)
+
+	return formatValue(fields[pool.TenantField])
+}
+
+// instance returns the running instance of tenant, starting it, and evicting
+// the least recently used one to make room, when there is none
+func (pool *TenantPool[E]) instance(ctx context.Context, tenant string, collector chan E, wg *sync.WaitGroup) (*tenantInstance[E], error) {
+	pool.lock.Lock()
+	pool.used++
+	instance, ok := pool.tenants[tenant]
+	if ok {
+		instance.used = pool.used
+	}
+	pool.lock.Unlock()
+
+	if ok {
+		return instance, nil
+	}
+
+	proc, err := pool.clone(tenant)
+	if err == nil {
+		err = Build(ctx, proc)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("starting tenant %q: %w", tenant, err)
+	}
+
+	if pool.MaxTenants > 0 {
+		pool.evict(ctx, pool.MaxTenants-1)
+	}
+
+	instance = &tenantInstance[E]{
+		tenant: tenant,
+		proc:   proc,
+		input:  make(chan E, pool.BufferSize),
+	}
+
+	pool.lock.Lock()
+	if pool.tenants == nil {
+		pool.tenants = make(map[string]*tenantInstance[E])
+	}
+	pool.used++
+	instance.used = pool.used
+	pool.tenants[tenant] = instance
+	pool.lock.Unlock()
+
+	procOutput := make(chan E)
+
+	wg.Add(1)
+	go func() {
+		runProcessor[E](ctx, proc, instance.input, procOutput)
+		wg.Done()
+	}()
+
+	wg.Add(1)
+	go func() {
+		for m := range procOutput {
+			collector <- m
+		}
+		wg.Done()
+	}()
+
+	Emit[E](ctx, pool, EventTenantStarted, "started tenant %q", tenant)
+
+	return instance, nil
+}
+
+// evict closes the input of the least recently used instances until at most
+// keep are left running
+func (pool *TenantPool[E]) evict(ctx context.Context, keep int) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	for len(pool.tenants) > keep {
+		var oldest *tenantInstance[E]
+		for _, instance := range pool.tenants {
+			if oldest == nil || instance.used < oldest.used {
+				oldest = instance
+			}
+		}
+
+		close(oldest.input)
+		delete(pool.tenants, oldest.tenant)
+
+		Emit[E](ctx, pool, EventTenantEvicted, "evicted tenant %q, draining", oldest.tenant)
+	}
+}
+
+// clone builds a new instance of Template for tenant
+func (pool *TenantPool[E]) clone(tenant string) (Processor[E], error) {
+	pool.definitionOnce.Do(func() {
+		if pool.Template == nil {
+			pool.definitionErr = fmt.Errorf("%s has no template: %w", pool.Name(), ErrInvalidType)
+			return
+		}
+
+		pool.definition, pool.definitionErr = MarshalPipeline(pool.Template)
+	})
+
+	if pool.definitionErr != nil {
+		return nil, pool.definitionErr
+	}
+
+	_, composite := pool.Template.(Composite[E])
+
+	return cloneDefinition(pool.definition, pool.Factory, func(sp *SerializedPipeline[E]) {
+		if composite {
+			sp.Name = fmt.Sprintf("%s@%s", sp.Name, tenant)
+		}
+
+		sp.Tags = mergeTags(sp.Tags, map[string]string{"tenant": tenant})
+	})
+}
+
+func (pool *TenantPool[E]) Name() string {
+	return fmt.Sprintf("Tenants/%s", pool.ChainName)
+}
+
+// Children returns the running instances, by tenant
+func (pool *TenantPool[E]) Children() []Processor[E] {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	tenants := make([]string, 0, len(pool.tenants))
+	for tenant := range pool.tenants {
+		tenants = append(tenants, tenant)
+	}
+
+	sort.Strings(tenants)
+
+	children := make([]Processor[E], 0, len(tenants))
+	for _, tenant := range tenants {
+		children = append(children, pool.tenants[tenant].proc)
+	}
+
+	return children
+}
+
+// Tenants returns the tenants with a running instance
+func (pool *TenantPool[E]) Tenants() []string {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	tenants := make([]string, 0, len(pool.tenants))
+	for tenant := range pool.tenants {
+		tenants = append(tenants, tenant)
+	}
+
+	sort.Strings(tenants)
+
+	return tenants
+}
@@ -0,0 +1,285 @@
+package pipeline
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultThroughputWindows are the windows rates are computed over when the
+// StatDB has none
+var DefaultThroughputWindows = []time.Duration{10 * time.Second, time.Minute, 5 * time.Minute}
+
+/*
+	rateCounter counts events per second over its last len(counts) seconds,
+	each slot keeping the second it counts so stale slots are skipped.
+*/
+type rateCounter struct {
+	created time.Time
+
+	lock    sync.Mutex
+	seconds []int64
+	counts  []int64
+}
+
+func newRateCounter(span time.Duration) *rateCounter {
+	size := int(span / time.Second)
+	if size < 1 {
+		size = 1
+	}
+
+	return &rateCounter{
+		created: time.Now(),
+		seconds: make([]int64, size),
+		counts:  make([]int64, size),
+	}
+}
+
+func (c *rateCounter) inc(now time.Time) {
+	if c == nil {
+		return
+	}
+
+	second := now.Unix()
+	slot := int(second % int64(len(c.counts)))
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.seconds[slot] != second {
+		c.seconds[slot] = second
+		c.counts[slot] = 0
+	}
+
+	c.counts[slot]++
+}
+
+// rate returns the events per second over the window ending at now, or since
+// the counter was created when it is more recent
+func (c *rateCounter) rate(now time.Time, window time.Duration) float64 {
+	if c == nil {
+		return 0
+	}
+
+	if window > time.Duration(len(c.counts))*time.Second {
+		window = time.Duration(len(c.counts)) * time.Second
+	}
+
+	first := now.Add(-window).Unix()
+
+	if elapsed := now.Sub(c.created); elapsed < window {
+		window = elapsed
+		first = c.created.Unix() - 1
+	}
+
+	if window <= 0 {
+		return 0
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var total int64
+	for i, second := range c.seconds {
+		if second > first && second <= now.Unix() {
+			total += c.counts[i]
+		}
+	}
+
+	return float64(total) / window.Seconds()
+}
+
+/*
+	A Snapshot is a typed copy of the stats of every processor, taken at
+	once, with the rates and queue depths the serialized stats do not have.
+*/
+type Snapshot struct {
+	Taken      time.Time                    `json:"taken"`
+	Generation int                          `json:"generation"`
+	Processors map[string]ProcessorSnapshot `json:"processors"`
+}
+
+type ProcessorSnapshot struct {
+	Name string            `json:"name"`
+	Path string            `json:"path,omitempty"`
+	Tags map[string]string `json:"tags,omitempty"`
+
+	Input       int64 `json:"input"`
+	Output      int64 `json:"output"`
+	Passthrough int64 `json:"passthrough"`
+	Failed      int64 `json:"failed"`
+	Errors      int64 `json:"errors"`
+	DeadLetters int64 `json:"dead_letters"`
+
+	LastInput  time.Time `json:"last_input"`
+	LastOutput time.Time `json:"last_output"`
+	Started    time.Time `json:"started"`
+	Finished   time.Time `json:"finished"`
+
+	LatencyP50 time.Duration `json:"latency_p50"`
+	LatencyP99 time.Duration `json:"latency_p99"`
+
+	Throughput []Throughput `json:"throughput"`
+	Queues     []QueueDepth `json:"queues,omitempty"`
+}
+
+/*
+	Throughput is the items per second a processor received and sent on over
+	Window, or since it started when it has run for less.
+*/
+type Throughput struct {
+	Window time.Duration `json:"window"`
+	Input  float64       `json:"input"`
+	Output float64       `json:"output"`
+}
+
+/*
+	A QueueDepth is the number of items waiting in a channel of a composite,
+	between two of its stages. Full queues show the stage reading them can
+	not keep up.
+*/
+type QueueDepth struct {
+	Channel  string `json:"channel"`
+	Depth    int    `json:"depth"`
+	Capacity int    `json:"capacity"`
+}
+
+func (d *StatDB[E]) throughputWindows() []time.Duration {
+	if len(d.ThroughputWindows) == 0 {
+		return DefaultThroughputWindows
+	}
+
+	return d.ThroughputWindows
+}
+
+// rateSpan is how long the rate counters count, the longest window
+func (d *StatDB[E]) rateSpan() time.Duration {
+	var span time.Duration
+
+	for _, window := range d.throughputWindows() {
+		if window > span {
+			span = window
+		}
+	}
+
+	return span
+}
+
+func (d *StatDB[E]) Snapshot() Snapshot {
+	d.itemLock.RLock()
+	defer d.itemLock.RUnlock()
+
+	now := time.Now()
+
+	snapshot := Snapshot{
+		Taken:      now,
+		Generation: d.generation,
+		Processors: make(map[string]ProcessorSnapshot, len(d.items)),
+	}
+
+	for p, stats := range d.items {
+		ps := ProcessorSnapshot{
+			Name:        stats.Name,
+			Path:        stats.Path,
+			Tags:        stats.Tags,
+			Input:       stats.Input.Load(),
+			Output:      stats.Output.Load(),
+			Passthrough: stats.Passthrough.Load(),
+			Failed:      stats.Failed.Load(),
+			Errors:      stats.Errors.Load(),
+			DeadLetters: stats.DeadLetters.Load(),
+			LastInput:   stats.LastInput,
+			LastOutput:  stats.LastOutput,
+			Started:     stats.Started,
+			Finished:    stats.Finished,
+			LatencyP50:  stats.LatencyQuantile(0.5),
+			LatencyP99:  stats.LatencyQuantile(0.99),
+			Queues:      queueDepths(p),
+		}
+
+		end := now
+		if !stats.Finished.IsZero() && stats.Finished.After(stats.Started) {
+			end = stats.Finished
+		}
+
+		for _, window := range d.throughputWindows() {
+			ps.Throughput = append(ps.Throughput, Throughput{
+				Window: window,
+				Input:  stats.inputRate.rate(end, window),
+				Output: stats.outputRate.rate(end, window),
+			})
+		}
+
+		snapshot.Processors[d.key(p, stats)] = ps
+	}
+
+	return snapshot
+}
+
+// queueDepths returns the depths of the channels of a composite between its
+// stages
+func queueDepths[E Traceable](p Processor[E]) []QueueDepth {
+	var depths []QueueDepth
+
+	for _, queue := range stageQueues(p) {
+		depths = append(depths, QueueDepth{Channel: queue.name, Depth: len(queue.c), Capacity: cap(queue.c)})
+	}
+
+	return depths
+}
+
+type stageQueue[E Traceable] struct {
+	name string
+	c    chan E
+}
+
+// stageQueues returns the channels of a composite between its stages
+func stageQueues[E Traceable](p Processor[E]) []stageQueue[E] {
+	var queues []stageQueue[E]
+
+	describe := func(name string, c chan E) {
+		if c != nil {
+			queues = append(queues, stageQueue[E]{name: name, c: c})
+		}
+	}
+
+	switch p := p.(type) {
+	case *Fanout[E]:
+		p.queues.RLock()
+		defer p.queues.RUnlock()
+
+		for i, buf := range p.procInChans {
+			describe(fmt.Sprintf("branch %d input", i), buf.input)
+		}
+
+		for i, c := range p.procOutChans {
+			describe(fmt.Sprintf("branch %d output", i), c)
+		}
+
+	case *Sequential[E]:
+		p.queues.RLock()
+		defer p.queues.RUnlock()
+
+		for i, buf := range p.procInBufs {
+			describe(fmt.Sprintf("stage %d input", i), buf.input)
+		}
+
+		for i, c := range p.procOutChans {
+			describe(fmt.Sprintf("stage %d output", i), c)
+		}
+
+	case *Parallel[E]:
+		p.queues.RLock()
+		defer p.queues.RUnlock()
+
+		if p.procInBuf != nil {
+			describe("input", p.procInBuf.input)
+		}
+
+		for i, c := range p.procChans {
+			describe(fmt.Sprintf("processor %d output", i), c)
+		}
+	}
+
+	return queues
+}
@@ -0,0 +1,131 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/mssola/useragent"
+)
+
+/*
+	The UserAgent processor parses the user agent string in the field Field
+	of every item, named by Tag as for Fanout, and sets the fields browser,
+	browser_version, engine, engine_version, os, os_name, os_version,
+	platform, model and device, which is "bot", "mobile" or "desktop".
+	Fields are named with Prefix before them, and those the user agent does
+	not tell are left as they are.
+
+	Items without a user agent are sent on unchanged. Parsed ones are
+	counted under the "user_agent" label dimension of the processor stats,
+	by device, and the others as "missing".
+*/
+type UserAgent[E Traceable] struct {
+	ChainName string
+	Field     string
+	Tag       string
+	Prefix    string
+}
+
+func NewUserAgent[E Traceable](name string, field string) *UserAgent[E] {
+	return &UserAgent[E]{
+		ChainName: name,
+		Field:     field,
+	}
+}
+
+func (u *UserAgent[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	executeItems[E](ctx, u, input, output, func(item E) (E, error) {
+		result, device, err := u.parse(item)
+		if err == nil {
+			TrackLabel[E](ctx, u, "user_agent", device)
+		}
+
+		return result, err
+	})
+}
+
+func (u *UserAgent[E]) Name() string {
+	return fmt.Sprintf("UserAgent/%s", u.ChainName)
+}
+
+func (u *UserAgent[E]) ProcessItem(ctx context.Context, item E) (E, error) {
+	result, _, err := u.parse(item)
+	return result, err
+}
+
+// parse sets the fields told by the user agent of item, and returns its
+// device
+func (u *UserAgent[E]) parse(item E) (E, string, error) {
+	fields, _ := itemFields(item, u.Tag)
+
+	value, found := fields[u.Field]
+	text := strings.TrimSpace(formatValue(value))
+	if !found || value == nil || text == "" {
+		return item, "missing", nil
+	}
+
+	ua := useragent.New(text)
+
+	device := "desktop"
+	if ua.Bot() {
+		device = "bot"
+	} else if ua.Mobile() {
+		device = "mobile"
+	}
+
+	values := make(map[string]string)
+
+	set := func(name, value string) {
+		if value != "" {
+			values[u.Prefix+name] = value
+		}
+	}
+
+	browser, browserVersion := ua.Browser()
+	engine, engineVersion := ua.Engine()
+	os := ua.OSInfo()
+
+	set("browser", browser)
+	set("browser_version", browserVersion)
+	set("engine", engine)
+	set("engine_version", engineVersion)
+	set("os", os.FullName)
+	set("os_name", os.Name)
+	set("os_version", os.Version)
+	set("platform", ua.Platform())
+	set("model", ua.Model())
+	set("device", device)
+
+	if err := setItemFields(item, u.Tag, values, nil); err != nil {
+		return item, device, err
+	}
+
+	return item, device, nil
+}
+
+func newUserAgent[E Traceable](name string, cfg map[string]interface{}) (*UserAgent[E], error) {
+	useragent := &UserAgent[E]{ChainName: name}
+
+	useragent.Field, _ = cfg["field"].(string)
+	useragent.Tag, _ = cfg["tag"].(string)
+	useragent.Prefix, _ = cfg["prefix"].(string)
+
+	return useragent, nil
+}
+
+func marshalUserAgent[E Traceable](useragent *UserAgent[E]) (string, map[string]interface{}, error) {
+	cfg := map[string]interface{}{
+		"field": useragent.Field,
+	}
+
+	if useragent.Tag != "" {
+		cfg["tag"] = useragent.Tag
+	}
+
+	if useragent.Prefix != "" {
+		cfg["prefix"] = useragent.Prefix
+	}
+
+	return useragent.ChainName, cfg, nil
+}
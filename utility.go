@@ -0,0 +1,359 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+const EventCounterReached = "counter_reached"
+
+/*
+	The Noop processor sends its items on untouched, for smoke testing the
+	topology around it.
+*/
+type Noop[E Traceable] struct {
+	ChainName string
+}
+
+func (noop *Noop[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	executeItems[E](ctx, noop, input, output, func(item E) (E, error) {
+		return item, nil
+	})
+}
+
+func (noop *Noop[E]) Name() string {
+	return fmt.Sprintf("Noop/%s", noop.ChainName)
+}
+
+func (noop *Noop[E]) ProcessItem(ctx context.Context, item E) (E, error) {
+	return item, nil
+}
+
+/*
+	The FixedDelay processor holds every item for Delay before sending it on,
+	standing in for a stage of known latency.
+*/
+type FixedDelay[E Traceable] struct {
+	ChainName string
+	Delay     time.Duration
+}
+
+func (d *FixedDelay[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	executeItems[E](ctx, d, input, output, func(item E) (E, error) {
+		return d.ProcessItem(ctx, item)
+	})
+}
+
+func (d *FixedDelay[E]) Name() string {
+	return fmt.Sprintf("FixedDelay/%s", d.ChainName)
+}
+
+func (d *FixedDelay[E]) ProcessItem(ctx context.Context, item E) (E, error) {
+	return item, sleep(ctx, d.Delay)
+}
+
+/*
+	The RandomDelay processor holds every item for a time drawn uniformly
+	between Min and Max before sending it on, standing in for a stage of
+	varying latency.
+*/
+type RandomDelay[E Traceable] struct {
+	ChainName string
+	Min       time.Duration
+	Max       time.Duration
+}
+
+func (d *RandomDelay[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	executeItems[E](ctx, d, input, output, func(item E) (E, error) {
+		return d.ProcessItem(ctx, item)
+	})
+}
+
+func (d *RandomDelay[E]) Name() string {
+	return fmt.Sprintf("RandomDelay/%s", d.ChainName)
+}
+
+func (d *RandomDelay[E]) ProcessItem(ctx context.Context, item E) (E, error) {
+	delay := d.Min
+	if d.Max > d.Min {
+		delay += time.Duration(rand.Int63n(int64(d.Max - d.Min)))
+	}
+
+	return item, sleep(ctx, delay)
+}
+
+// sleep waits for d, and returns the error of ctx if it is done first
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+/*
+	The Generator processor sends its input on, along with Count synthetic
+	items, one every Interval, made by New from their sequence number. It
+	finishes once its input is closed and its items were all sent, or with
+	its input when Count is zero.
+
+	Generators built from definitions make new items of the pipeline type,
+	which must be a pointer to a struct or a FieldSetter, with Fields set
+	from their text, in which {n} is replaced by the sequence number. Fields
+	are named after Tag, as for Grok.
+*/
+type Generator[E Traceable] struct {
+	ChainName string
+	Count     int
+	Interval  time.Duration
+
+	Fields map[string]string
+	Tag    string
+
+	New func(n int) E `json:"-"`
+}
+
+func (g *Generator[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	generated := make(chan struct{})
+	stop := make(chan struct{})
+
+	go func() {
+		defer close(generated)
+		g.generate(ctx, output, stop)
+	}()
+
+	for m := range input {
+		TrackItemInput[E](ctx, g, m)
+		TrackOutput[E](ctx, g, m)
+		output <- m
+	}
+
+	if g.Count <= 0 {
+		close(stop)
+	}
+
+	<-generated
+	close(output)
+}
+
+func (g *Generator[E]) generate(ctx context.Context, output chan E, stop chan struct{}) {
+	interval := g.Interval
+	if interval <= 0 {
+		interval = time.Millisecond
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for n := 0; g.Count <= 0 || n < g.Count; n++ {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		}
+
+		item, err := g.item(n)
+		if err != nil {
+			Log[E](ctx, g, "failed generating item %d: %s", n, err)
+			ReportError(ctx, g, fmt.Errorf("generating item %d: %w: %w", n, err, ErrFatal))
+			return
+		}
+
+		TrackOutput[E](ctx, g, item)
+
+		select {
+		case output <- item:
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// item makes the item numbered n, with New when set
+func (g *Generator[E]) item(n int) (E, error) {
+	if g.New != nil {
+		return g.New(n), nil
+	}
+
+	item, err := NewItem[E]()
+	if err != nil {
+		return item, err
+	}
+
+	values := make(map[string]string, len(g.Fields))
+	for name, value := range g.Fields {
+		values[name] = strings.ReplaceAll(value, "{n}", strconv.Itoa(n))
+	}
+
+	return item, setItemFields(item, g.Tag, values, nil)
+}
+
+func (g *Generator[E]) Name() string {
+	return fmt.Sprintf("Generator/%s", g.ChainName)
+}
+
+/*
+	The Counter processor sends its items on, counting them, and emits
+	EventCounterReached every Every items when it is set.
+*/
+type Counter[E Traceable] struct {
+	ChainName string
+	Every     int64
+
+	count atomic.Int64
+}
+
+func (c *Counter[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	executeItems[E](ctx, c, input, output, func(item E) (E, error) {
+		if n := c.count.Inc(); c.Every > 0 && n%c.Every == 0 {
+			Emit[E](ctx, c, EventCounterReached, "%d items", n)
+		}
+
+		return item, nil
+	})
+}
+
+func (c *Counter[E]) Name() string {
+	return fmt.Sprintf("Counter/%s", c.ChainName)
+}
+
+// Count returns the items counted so far
+func (c *Counter[E]) Count() int64 {
+	return c.count.Load()
+}
+
+func newNoop[E Traceable](name string, cfg map[string]interface{}) (*Noop[E], error) {
+	return &Noop[E]{ChainName: name}, nil
+}
+
+func marshalNoop[E Traceable](noop *Noop[E]) (string, map[string]interface{}, error) {
+	return noop.ChainName, nil, nil
+}
+
+func newFixedDelay[E Traceable](name string, cfg map[string]interface{}) (*FixedDelay[E], error) {
+	delay, err := configDuration(cfg, "delay")
+	if err != nil {
+		return nil, err
+	}
+
+	return &FixedDelay[E]{ChainName: name, Delay: delay}, nil
+}
+
+func marshalFixedDelay[E Traceable](d *FixedDelay[E]) (string, map[string]interface{}, error) {
+	return d.ChainName, map[string]interface{}{
+		"delay": d.Delay.String(),
+	}, nil
+}
+
+func newRandomDelay[E Traceable](name string, cfg map[string]interface{}) (*RandomDelay[E], error) {
+	minDelay, err := configDuration(cfg, "min")
+	if err != nil {
+		return nil, err
+	}
+
+	maxDelay, err := configDuration(cfg, "max")
+	if err != nil {
+		return nil, err
+	}
+
+	if maxDelay < minDelay {
+		return nil, fmt.Errorf("max %s is under min %s: %w", maxDelay, minDelay, ErrInvalidConfig)
+	}
+
+	return &RandomDelay[E]{ChainName: name, Min: minDelay, Max: maxDelay}, nil
+}
+
+func marshalRandomDelay[E Traceable](d *RandomDelay[E]) (string, map[string]interface{}, error) {
+	return d.ChainName, map[string]interface{}{
+		"min": d.Min.String(),
+		"max": d.Max.String(),
+	}, nil
+}
+
+func newGenerator[E Traceable](name string, cfg map[string]interface{}) (*Generator[E], error) {
+	generator := &Generator[E]{ChainName: name}
+
+	var err error
+	if generator.Count, _, err = configInt(cfg, "count", 0); err != nil {
+		return nil, err
+	}
+
+	if generator.Interval, err = configDuration(cfg, "interval"); err != nil {
+		return nil, err
+	}
+
+	generator.Tag, _ = cfg["tag"].(string)
+
+	if fields, ok := cfg["fields"].(map[string]interface{}); ok {
+		generator.Fields = make(map[string]string, len(fields))
+
+		for name, value := range fields {
+			generator.Fields[name] = fmt.Sprint(value)
+		}
+	}
+
+	if _, err := generator.item(0); err != nil {
+		return nil, fmt.Errorf("%w: %w", err, ErrInvalidConfig)
+	}
+
+	return generator, nil
+}
+
+func marshalGenerator[E Traceable](g *Generator[E]) (string, map[string]interface{}, error) {
+	cfg := map[string]interface{}{}
+
+	if g.Count > 0 {
+		cfg["count"] = g.Count
+	}
+
+	if g.Interval > 0 {
+		cfg["interval"] = g.Interval.String()
+	}
+
+	if g.Tag != "" {
+		cfg["tag"] = g.Tag
+	}
+
+	if len(g.Fields) > 0 {
+		cfg["fields"] = g.Fields
+	}
+
+	return g.ChainName, cfg, nil
+}
+
+func newCounter[E Traceable](name string, cfg map[string]interface{}) (*Counter[E], error) {
+	every, _, err := configInt(cfg, "every", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Counter[E]{ChainName: name, Every: int64(every)}, nil
+}
+
+func marshalCounter[E Traceable](c *Counter[E]) (string, map[string]interface{}, error) {
+	if c.Every <= 0 {
+		return c.ChainName, nil, nil
+	}
+
+	return c.ChainName, map[string]interface{}{
+		"every": c.Every,
+	}, nil
+}
@@ -0,0 +1,142 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/*
+	ConfigValidator is implemented by the processors registered with
+	RegisterProcessorType checking the configuration they are built from,
+	for Validate.
+*/
+type ConfigValidator interface {
+	ValidateConfig(cfg map[string]interface{}) error
+}
+
+/*
+	Validate checks the definition before Pipeline builds it, and returns
+	every problem found as a DefinitionError, joined with errors.Join:
+	missing fields, unknown types, composites without processors, or not
+	having as many as their type needs, leaves having some, siblings with
+	the same name, leaves no factory can build, and configurations their
+	registered type refuses.
+
+	Definitions passing Validate can still fail to build, with the errors
+	only their factories find.
+*/
+func (sp *SerializedPipeline[E]) Validate() error {
+	return errors.Join(sp.validate(sp.pathName(), sp.processorFactory)...)
+}
+
+func (sp *SerializedPipeline[E]) validate(path string, factory ProcessorFactory[E]) []error {
+	var errs []error
+
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, &DefinitionError{Path: path, Err: fmt.Errorf(format, args...)})
+	}
+
+	if err := sp.checkFields(); err != nil {
+		fail("%w", err)
+	}
+
+	leaf := false
+
+	switch sp.Type {
+	case "":
+		// reported by checkFields
+
+	case "fanout", "parallel", "sequential":
+		if len(sp.Processors) == 0 {
+			fail("%s has no processors: %w", sp.Type, ErrInvalidType)
+		}
+
+	case "shadow", "bluegreen":
+		if len(sp.Processors) != 2 {
+			fail("%s needs exactly two processors, not %d: %w", sp.Type, len(sp.Processors), ErrInvalidType)
+		}
+
+	case "flagged", "pool", "tenants", "retry", "deadline":
+		if len(sp.Processors) != 1 {
+			fail("%s needs exactly one processor, not %d: %w", sp.Type, len(sp.Processors), ErrInvalidType)
+		}
+
+	case "router":
+		for _, err := range sp.validateRoutes() {
+			fail("%w", err)
+		}
+
+	case "processor":
+		leaf = true
+
+		if _, registered := lookupProcessor[E](sp.Name); !registered && factory == nil {
+			fail("%w", ErrNoProcessorFactory)
+		}
+
+	default:
+		t, registered := lookupProcessorType[E](sp.Type)
+		if !registered {
+			fail("unknown type %q, known types are %s: %w", sp.Type, strings.Join(processorTypeNames[E](), ", "), ErrInvalidType)
+			break
+		}
+
+		leaf = true
+
+		if t.validate != nil {
+			if err := t.validate(sp.Config); err != nil {
+				fail("%w: %w", err, ErrInvalidConfig)
+			}
+		}
+	}
+
+	if leaf && len(sp.Processors) > 0 {
+		fail("%s has processors, which its type does not have: %w", sp.Type, ErrInvalidType)
+	}
+
+	seen := make(map[string]bool, len(sp.Processors))
+
+	for i := range sp.Processors {
+		child := &sp.Processors[i]
+
+		if child.Name != "" && seen[child.Name] {
+			fail("more than one processor named %q: %w", child.Name, ErrInvalidConfig)
+		}
+
+		seen[child.Name] = true
+
+		errs = append(errs, child.validate(path+"/"+child.pathName(), factory)...)
+	}
+
+	return errs
+}
+
+// validateRoutes checks a router has a processor for each of its routes,
+// known predicates, and its default
+func (sp *SerializedPipeline[E]) validateRoutes() []error {
+	var errs []error
+
+	routes, _ := sp.Config["routes"].([]interface{})
+	for _, route := range routes {
+		name, ok := route.(string)
+		if !ok {
+			errs = append(errs, fmt.Errorf("route %v is not a predicate name: %w", route, ErrInvalidConfig))
+			continue
+		}
+
+		if _, err := lookupPredicate[E](name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	expected := len(routes)
+	if hasDefault, _ := sp.Config["default"].(bool); hasDefault {
+		expected++
+	}
+
+	if len(sp.Processors) != expected {
+		errs = append(errs, fmt.Errorf("router needs %d processors, for its routes and default, not %d: %w", expected, len(sp.Processors), ErrInvalidType))
+	}
+
+	return errs
+}
@@ -0,0 +1,81 @@
+package pipeline
+
+import (
+	"fmt"
+)
+
+/*
+	Composite is implemented by processors made of other processors. Walk uses
+	it to go through a processor tree.
+*/
+type Composite[E Traceable] interface {
+	Processor[E]
+	Children() []Processor[E]
+}
+
+func (fanout *Fanout[E]) Children() []Processor[E] {
+	return fanout.Processors
+}
+
+func (sequential *Sequential[E]) Children() []Processor[E] {
+	return sequential.Processors
+}
+
+func (parallel *Parallel[E]) Children() []Processor[E] {
+	return parallel.Processors
+}
+
+func (shadow *Shadow[E]) Children() []Processor[E] {
+	return nonNil([]Processor[E]{shadow.Primary, shadow.Candidate})
+}
+
+func (bg *BlueGreen[E]) Children() []Processor[E] {
+	return nonNil([]Processor[E]{bg.Blue, bg.Green})
+}
+
+func nonNil[E Traceable](processors []Processor[E]) []Processor[E] {
+	result := make([]Processor[E], 0, len(processors))
+
+	for _, p := range processors {
+		if p != nil {
+			result = append(result, p)
+		}
+	}
+
+	return result
+}
+
+/*
+	Walk calls fn for every processor of the tree, parents before their
+	children, with the path of the processor: the names from the root down to
+	it, joined by slashes. Siblings sharing a name get a #index suffix so paths
+	are unique.
+*/
+func Walk[E Traceable](root Processor[E], fn func(path string, p Processor[E])) {
+	walk(root, root.Name(), fn)
+}
+
+func walk[E Traceable](p Processor[E], path string, fn func(path string, p Processor[E])) {
+	fn(path, p)
+
+	composite, ok := p.(Composite[E])
+	if !ok {
+		return
+	}
+
+	children := composite.Children()
+
+	seen := make(map[string]int, len(children))
+	for _, child := range children {
+		seen[child.Name()]++
+	}
+
+	for i, child := range children {
+		childPath := path + "/" + child.Name()
+		if seen[child.Name()] > 1 {
+			childPath = fmt.Sprintf("%s#%d", childPath, i)
+		}
+
+		walk(child, childPath, fn)
+	}
+}
@@ -0,0 +1,411 @@
+/*
+	Package wasm runs per item logic compiled to WebAssembly in pipelines,
+	sandboxed in wazero. Register makes its processor available to
+	definitions, as the "wasm" type.
+*/
+package wasm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ca0s/pipeline"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+var ErrWASMFailed = fmt.Errorf("wasm processing failed")
+
+// memory of the modules, in 64KiB pages, when their processor sets none
+const DefaultWASMMemoryPages = 256
+
+// results of the process function of the modules
+const (
+	WASMKeep = 0
+	WASMDrop = 1
+)
+
+/*
+	The WASM processor runs per item logic compiled to WebAssembly, sandboxed
+	in wazero: modules only reach the fields of the item, through the host
+	functions of the "pipeline" module, with a bounded memory and time.
+
+	Modules export their memory and a process function, of no parameters,
+	called for every item. It returns WASMKeep to send the item on, WASMDrop
+	to drop it, and any other code to fail it. Host functions take strings as
+	a pointer and a length in the memory of the module:
+
+		field_get(name, name_len, buf, buf_cap) i32
+			writes the text representation of the field to buf, and returns
+			its length, -1 when the item has no such field; nothing is
+			written when the value does not fit in buf_cap bytes
+		field_set(name, name_len, value, value_len)
+			sets the field from its text representation, once process
+			returns WASMKeep
+		fail(msg, msg_len)
+			gives the error of a failed item
+		log(msg, msg_len)
+			logs a message in the name of the processor
+
+	Fields are named after their Tag, as for pipeline.Fanout, and
+	pipeline.FieldSetter items get the values converted as told by Types.
+	WASI modules, such as Go ones built for wasip1, have WASI without
+	filesystem, network or clock access beyond the monotonic one. Reactor
+	modules are initialized once, and keep their state from an item to the
+	next.
+
+	Module is the compiled module, or Path the file it is read from in Init.
+	Items taking more than Timeout, one second by default, fail.
+*/
+type WASM[E pipeline.Traceable] struct {
+	ChainName string
+	Path      string
+	Module    []byte `json:"-"`
+
+	Tag   string
+	Types map[string]string
+
+	MemoryPages uint32
+	Timeout     time.Duration
+
+	lock    sync.Mutex
+	runtime wazero.Runtime
+	process api.Function
+}
+
+type wasmCallKey struct{}
+
+// wasmCall is the item a module is processing, for the host functions
+type wasmCall struct {
+	log     func(msg string)
+	fields  map[string]interface{}
+	updates map[string]string
+	failure string
+}
+
+func NewWASM[E pipeline.Traceable](name string, module []byte) *WASM[E] {
+	return &WASM[E]{
+		ChainName: name,
+		Module:    module,
+	}
+}
+
+// Init compiles and instantiates the module, reading it from Path if needed
+func (w *WASM[E]) Init(ctx context.Context) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.init(ctx)
+}
+
+func (w *WASM[E]) init(ctx context.Context) error {
+	if w.process != nil {
+		return nil
+	}
+
+	module := w.Module
+	if len(module) == 0 {
+		if w.Path == "" {
+			return fmt.Errorf("%s: no module: %w", w.Name(), ErrWASMFailed)
+		}
+
+		data, err := os.ReadFile(w.Path)
+		if err != nil {
+			return err
+		}
+
+		module = data
+	}
+
+	pages := w.MemoryPages
+	if pages == 0 {
+		pages = DefaultWASMMemoryPages
+	}
+
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(pages).
+		WithCloseOnContextDone(true))
+
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		runtime.Close(ctx)
+		return err
+	}
+
+	_, err := runtime.NewHostModuleBuilder("pipeline").
+		NewFunctionBuilder().WithFunc(wasmFieldGet).Export("field_get").
+		NewFunctionBuilder().WithFunc(wasmFieldSet).Export("field_set").
+		NewFunctionBuilder().WithFunc(wasmFail).Export("fail").
+		NewFunctionBuilder().WithFunc(wasmLog).Export("log").
+		Instantiate(ctx)
+	if err != nil {
+		runtime.Close(ctx)
+		return err
+	}
+
+	compiled, err := runtime.CompileModule(ctx, module)
+	if err != nil {
+		runtime.Close(ctx)
+		return fmt.Errorf("%s: %w: %w", w.Name(), err, ErrWASMFailed)
+	}
+
+	instance, err := runtime.InstantiateModule(ctx, compiled, wazero.NewModuleConfig().
+		WithName(w.ChainName).
+		WithStartFunctions("_initialize"))
+	if err != nil {
+		runtime.Close(ctx)
+		return fmt.Errorf("%s: %w: %w", w.Name(), err, ErrWASMFailed)
+	}
+
+	process := instance.ExportedFunction("process")
+	if process == nil || instance.ExportedMemory("memory") == nil {
+		runtime.Close(ctx)
+		return fmt.Errorf("%s: module exports no process function or memory: %w", w.Name(), ErrWASMFailed)
+	}
+
+	w.runtime, w.process = runtime, process
+
+	return nil
+}
+
+func (w *WASM[E]) Execute(ctx context.Context, input chan E, output chan E) {
+	w.lock.Lock()
+	err := w.init(ctx)
+	w.lock.Unlock()
+
+	if err != nil {
+		pipeline.Log[E](ctx, w, "%s", err)
+		pipeline.ReportError(ctx, w, fmt.Errorf("%w: %w", err, pipeline.ErrFatal))
+	}
+
+	pipeline.ExecuteItems[E](ctx, w, input, output, func(item E) (E, error) {
+		if err != nil {
+			return item, err
+		}
+
+		return w.ProcessItem(ctx, item)
+	})
+
+	w.close()
+}
+
+func (w *WASM[E]) Name() string {
+	return fmt.Sprintf("WASM/%s", w.ChainName)
+}
+
+// ProcessItem runs the module for item, failing with pipeline.ErrDropped
+// for the items it drops
+func (w *WASM[E]) ProcessItem(ctx context.Context, item E) (E, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if err := w.init(ctx); err != nil {
+		return item, fmt.Errorf("%w: %w", err, pipeline.ErrFatal)
+	}
+
+	fields, _ := pipeline.ItemFields(item, w.Tag)
+
+	call := &wasmCall{
+		log:     func(msg string) { pipeline.Log[E](ctx, w, "%s", msg) },
+		fields:  fields,
+		updates: make(map[string]string),
+	}
+
+	timeout := w.Timeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+
+	callCtx, cancel := context.WithTimeout(context.WithValue(ctx, wasmCallKey{}, call), timeout)
+	defer cancel()
+
+	results, err := w.process.Call(callCtx)
+	if err != nil {
+		// the module is closed once its call is interrupted
+		w.reset()
+		return item, fmt.Errorf("%s: %w: %w", w.Name(), err, ErrWASMFailed)
+	}
+
+	code := int32(api.DecodeI32(results[0]))
+
+	switch code {
+	case WASMKeep:
+	case WASMDrop:
+		return item, fmt.Errorf("%s: %w", w.Name(), pipeline.ErrDropped)
+	default:
+		if call.failure == "" {
+			call.failure = fmt.Sprintf("code %d", code)
+		}
+
+		return item, fmt.Errorf("%s: %w", call.failure, ErrWASMFailed)
+	}
+
+	if len(call.updates) > 0 {
+		if err := pipeline.SetItemFields(item, w.Tag, call.updates, w.Types); err != nil {
+			return item, err
+		}
+	}
+
+	return item, nil
+}
+
+// reset drops the instance, which is instantiated again for the next item
+func (w *WASM[E]) reset() {
+	if w.runtime != nil {
+		w.runtime.Close(context.Background())
+	}
+
+	w.runtime, w.process = nil, nil
+}
+
+func (w *WASM[E]) close() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.reset()
+}
+
+func wasmCallOf(ctx context.Context) *wasmCall {
+	call, _ := ctx.Value(wasmCallKey{}).(*wasmCall)
+	return call
+}
+
+func wasmString(m api.Module, ptr, length uint32) (string, bool) {
+	data, ok := m.Memory().Read(ptr, length)
+	return string(data), ok
+}
+
+func wasmFieldGet(ctx context.Context, m api.Module, namePtr, nameLen, bufPtr, bufCap uint32) int32 {
+	call := wasmCallOf(ctx)
+	if call == nil {
+		return -1
+	}
+
+	name, ok := wasmString(m, namePtr, nameLen)
+	if !ok {
+		return -1
+	}
+
+	var value string
+	if updated, found := call.updates[name]; found {
+		value = updated
+	} else if field, found := call.fields[name]; found {
+		value = pipeline.FormatValue(field)
+	} else {
+		return -1
+	}
+
+	if uint32(len(value)) <= bufCap {
+		m.Memory().Write(bufPtr, []byte(value))
+	}
+
+	return int32(len(value))
+}
+
+func wasmFieldSet(ctx context.Context, m api.Module, namePtr, nameLen, valuePtr, valueLen uint32) {
+	call := wasmCallOf(ctx)
+	if call == nil {
+		return
+	}
+
+	name, ok := wasmString(m, namePtr, nameLen)
+	if !ok {
+		return
+	}
+
+	value, ok := wasmString(m, valuePtr, valueLen)
+	if !ok {
+		return
+	}
+
+	call.updates[name] = value
+}
+
+func wasmFail(ctx context.Context, m api.Module, msgPtr, msgLen uint32) {
+	if call := wasmCallOf(ctx); call != nil {
+		call.failure, _ = wasmString(m, msgPtr, msgLen)
+	}
+}
+
+func wasmLog(ctx context.Context, m api.Module, msgPtr, msgLen uint32) {
+	if call := wasmCallOf(ctx); call != nil {
+		msg, _ := wasmString(m, msgPtr, msgLen)
+		call.log(msg)
+	}
+}
+
+/*
+	Register registers the WASM processor as the "wasm" processor type of
+	definitions, configured with path, tag, types, memory_pages and timeout.
+	The module is read from path when the processor starts.
+*/
+func Register[E pipeline.Traceable]() {
+	pipeline.RegisterProcessorType[E, *WASM[E]]("wasm", newWASM[E], marshalWASM[E])
+}
+
+func newWASM[E pipeline.Traceable](name string, cfg map[string]interface{}) (*WASM[E], error) {
+	w := &WASM[E]{ChainName: name}
+
+	w.Path, _ = cfg["path"].(string)
+	if w.Path == "" {
+		return nil, fmt.Errorf("wasm needs the path of its module: %w", pipeline.ErrInvalidConfig)
+	}
+
+	w.Tag, _ = cfg["tag"].(string)
+
+	if types, ok := cfg["types"].(map[string]interface{}); ok {
+		w.Types = make(map[string]string, len(types))
+
+		for name, typename := range types {
+			if typename, ok := typename.(string); ok {
+				w.Types[name] = typename
+			}
+		}
+	}
+
+	if pages, ok := cfg["memory_pages"].(float64); ok {
+		if pages < 1 || pages > 65536 || pages != float64(uint32(pages)) {
+			return nil, fmt.Errorf("invalid memory_pages %v: %w", pages, pipeline.ErrInvalidConfig)
+		}
+
+		w.MemoryPages = uint32(pages)
+	}
+
+	if timeout, ok := cfg["timeout"].(string); ok {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %w: %w", err, pipeline.ErrInvalidConfig)
+		}
+
+		w.Timeout = d
+	}
+
+	return w, nil
+}
+
+func marshalWASM[E pipeline.Traceable](w *WASM[E]) (string, map[string]interface{}, error) {
+	cfg := map[string]interface{}{
+		"path": w.Path,
+	}
+
+	if w.Tag != "" {
+		cfg["tag"] = w.Tag
+	}
+
+	if len(w.Types) > 0 {
+		cfg["types"] = w.Types
+	}
+
+	if w.MemoryPages > 0 {
+		cfg["memory_pages"] = w.MemoryPages
+	}
+
+	if w.Timeout > 0 {
+		cfg["timeout"] = w.Timeout.String()
+	}
+
+	return w.ChainName, cfg, nil
+}
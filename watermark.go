@@ -0,0 +1,170 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var PipelineWatermarks PipelineContextKey = "pipeline_watermarks"
+
+/*
+	Watermarks hold the watermarks emitted by the processors of a pipeline: the
+	event time up to which a processor guarantees no more items will be sent,
+	save for late ones.
+
+	Sources emit watermarks with EmitWatermark, and the watermark at any point
+	of the tree is derived from them. It goes unchanged through stages not
+	emitting watermarks of their own, and composites sending the output of
+	several branches (Fanout, Parallel, BlueGreen...) hold the minimum across
+	them, so a window downstream never closes while a slower branch may still
+	send it items.
+*/
+type Watermarks struct {
+	lock  sync.RWMutex
+	marks map[string]time.Time
+}
+
+func NewWatermarks() *Watermarks {
+	return &Watermarks{
+		marks: make(map[string]time.Time),
+	}
+}
+
+func WithWatermarks(ctx context.Context, w *Watermarks) context.Context {
+	return context.WithValue(ctx, PipelineWatermarks, w)
+}
+
+// EmitWatermark advances the watermark of p. Watermarks never go backwards,
+// so older values are ignored.
+func EmitWatermark[E Traceable](ctx context.Context, p Processor[E], t time.Time) {
+	w, ok := ctx.Value(PipelineWatermarks).(*Watermarks)
+	if !ok {
+		return
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	id := processorID(p)
+	if t.After(w.marks[id]) {
+		w.marks[id] = t
+	}
+}
+
+// Watermark returns the watermark of the output of root, or the zero time if
+// it is not known yet
+func Watermark[E Traceable](ctx context.Context, root Processor[E]) time.Time {
+	w, ok := ctx.Value(PipelineWatermarks).(*Watermarks)
+	if !ok {
+		return time.Time{}
+	}
+
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+
+	out, _, _ := propagateWatermark(w.marks, root, nil, time.Time{})
+	return out
+}
+
+// InputWatermark returns the watermark of the items p receives, p being a
+// processor of the tree of root
+func InputWatermark[E Traceable](ctx context.Context, root Processor[E], p Processor[E]) time.Time {
+	w, ok := ctx.Value(PipelineWatermarks).(*Watermarks)
+	if !ok {
+		return time.Time{}
+	}
+
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+
+	_, in, _ := propagateWatermark(w.marks, root, p, time.Time{})
+	return in
+}
+
+/*
+	propagateWatermark returns the output watermark of node given the watermark
+	of its input, and the input watermark of target if it is found within node.
+*/
+func propagateWatermark[E Traceable](marks map[string]time.Time, node Processor[E], target Processor[E], in time.Time) (out time.Time, targetIn time.Time, found bool) {
+	if target != nil && processorID(node) == processorID(target) {
+		targetIn, found = in, true
+	}
+
+	composite, ok := node.(Composite[E])
+	if !ok {
+		return leafWatermark(marks, node, in), targetIn, found
+	}
+
+	children := composite.Children()
+	chained := false
+	bypass := false
+
+	switch node.(type) {
+	case *Sequential[E]:
+		chained = true
+	case *Shadow[E]:
+		children = nonNil([]Processor[E]{node.(*Shadow[E]).Primary})
+	case *Flagged[E]:
+		bypass = true
+	}
+
+	if len(children) == 0 {
+		return in, targetIn, found
+	}
+
+	if chained {
+		out = in
+	}
+
+	for i, child := range children {
+		childIn := in
+		if chained {
+			childIn = out
+		}
+
+		childOut, childTargetIn, childFound := propagateWatermark(marks, child, target, childIn)
+		if childFound {
+			targetIn, found = childTargetIn, true
+		}
+
+		if chained || i == 0 {
+			out = childOut
+		} else {
+			out = minWatermark(out, childOut)
+		}
+	}
+
+	if bypass {
+		out = minWatermark(out, in)
+	}
+
+	return out, targetIn, found
+}
+
+func leafWatermark[E Traceable](marks map[string]time.Time, node Processor[E], in time.Time) time.Time {
+	emitted, ok := marks[processorID(node)]
+	if !ok {
+		return in
+	}
+
+	if in.IsZero() {
+		return emitted
+	}
+
+	return minWatermark(in, emitted)
+}
+
+// minWatermark returns the earliest watermark, an unknown (zero) one being
+// the earliest of all
+func minWatermark(a, b time.Time) time.Time {
+	if a.IsZero() || b.IsZero() {
+		return time.Time{}
+	}
+
+	if a.Before(b) {
+		return a
+	}
+
+	return b
+}